@@ -6,10 +6,10 @@ import (
 	"sync"
 	"time"
 
-	"github.com/brickingsoft/brick/configs"
 	"github.com/brickingsoft/brick/endpoints"
-	"github.com/brickingsoft/brick/logs"
 	"github.com/brickingsoft/brick/pkg/whisper"
+	"github.com/brickingsoft/brick/rpc/configs"
+	"github.com/brickingsoft/brick/rpc/logs"
 	"github.com/brickingsoft/brick/transports"
 )
 
@@ -40,11 +40,17 @@ func New(options ...Option) (app *App, err error) {
 
 	// discovery
 
+	closeTimeout := opts.CloseTimeout
+	if closeTimeout <= 0 {
+		closeTimeout = DefaultCloseTimeout
+	}
+
 	app = &App{
-		locker:   new(sync.Mutex),
-		launched: true,
-		eps:      eps,
-		trs:      trs,
+		locker:       new(sync.Mutex),
+		launched:     true,
+		eps:          eps,
+		trs:          trs,
+		closeTimeout: closeTimeout,
 	}
 
 	return
@@ -110,28 +116,24 @@ func (app *App) Close() (err error) {
 	if !app.launched {
 		return
 	}
-	ctx := context.TODO()
-	if timeout := app.closeTimeout; timeout > 0 {
-		failed := make(chan error, 1)
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, timeout)
-		go func(ctx context.Context, failed chan<- error, app *App) {
-			if exitErr := app.exit(ctx); exitErr != nil {
-				failed <- exitErr
-			}
-			close(failed)
-		}(ctx, failed, app)
-		select {
-		case <-ctx.Done():
-			err = errors.New("timeout")
-			break
-		case err = <-failed:
-			break
-		}
-		cancel()
-		if err != nil {
-			err = errors.Join(errors.New("close app failed"), err)
+	failed := make(chan error, 1)
+	ctx, cancel := context.WithTimeout(context.TODO(), app.closeTimeout)
+	go func(ctx context.Context, failed chan<- error, app *App) {
+		if exitErr := app.exit(ctx); exitErr != nil {
+			failed <- exitErr
 		}
+		close(failed)
+	}(ctx, failed, app)
+	select {
+	case <-ctx.Done():
+		err = errors.New("timeout")
+		break
+	case err = <-failed:
+		break
+	}
+	cancel()
+	if err != nil {
+		err = errors.Join(errors.New("close app failed"), err)
 	}
 	return
 }
@@ -141,7 +143,18 @@ func (app *App) exit(ctx context.Context) (err error) {
 	// prepare
 	ctx = app.prepare(ctx)
 	// discovery
-	// transports
+	// transports, graceful ones drain requests until ctx is done
+	for _, tr := range app.trs {
+		if graceful, ok := tr.(transports.GracefulTransport); ok {
+			if shutdownErr := graceful.Shutdown(ctx); shutdownErr != nil {
+				errs = append(errs, shutdownErr)
+			}
+			continue
+		}
+		if closeErr := tr.Close(); closeErr != nil {
+			errs = append(errs, closeErr)
+		}
+	}
 	// endpoints
 	// logger
 
@@ -2,7 +2,9 @@ package cert
 
 import (
 	"context"
+	"errors"
 
+	"github.com/brickingsoft/brick/cmd/brickman/pkg/notes"
 	"github.com/urfave/cli/v3"
 )
 
@@ -41,6 +43,10 @@ var (
 			Name:  "ca_key",
 			Usage: "ca key file path",
 		},
+		&cli.StringSliceFlag{
+			Name:  "hosts",
+			Usage: "dns names or ip addresses of cert, the common name is used when it is not set",
+		},
 		&cli.StringFlag{
 			Name:  "out",
 			Usage: "output dir path",
@@ -49,6 +55,21 @@ var (
 )
 
 func Action(ctx context.Context, c *cli.Command) (err error) {
-
+	certFile, keyFile, genErr := Generate(Options{
+		Mode:   c.String("mode"),
+		Type:   c.String("type"),
+		Expire: c.Int("expire"),
+		CN:     c.String("cn"),
+		Hosts:  c.StringSlice("hosts"),
+		CACert: c.String("ca_cert"),
+		CAKey:  c.String("ca_key"),
+		Out:    c.String("out"),
+	})
+	if genErr != nil {
+		err = errors.Join(errors.New("create cert failed"), genErr)
+		return
+	}
+	notes.New(c)
+	notes.Info(ctx, "cert created", "cert", certFile, "key", keyFile)
 	return
 }
@@ -0,0 +1,171 @@
+package cert
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	CAMode   = "CA"
+	CertMode = "CERT"
+
+	ECDSAType   = "ECDSA"
+	RSAType     = "RSA"
+	ED25519Type = "ED25519"
+)
+
+type Options struct {
+	Mode   string
+	Type   string
+	Expire int
+	CN     string
+	Hosts  []string
+	CACert string
+	CAKey  string
+	Out    string
+}
+
+// Generate
+// CA mode makes a self-signed `ca.crt` and `ca.key`,
+// CERT mode makes `{cn}.crt` and `{cn}.key` signed by the ca, they are for both server and client auth.
+func Generate(options Options) (certFile string, keyFile string, err error) {
+	mode := strings.ToUpper(strings.TrimSpace(options.Mode))
+	cn := strings.TrimSpace(options.CN)
+	if cn == "" {
+		cn = "brick"
+	}
+	// the cn is the name of files in CERT mode, so it must not be a path.
+	if strings.ContainsAny(cn, `/\`) || cn == "." || cn == ".." || filepath.Base(cn) != cn {
+		err = errors.New("invalid cn " + options.CN + ", it must not contain path separators")
+		return
+	}
+	expire := options.Expire
+	if expire < 1 {
+		expire = 30
+	}
+	key, keyErr := generateKey(options.Type)
+	if keyErr != nil {
+		err = keyErr
+		return
+	}
+	serial, serialErr := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if serialErr != nil {
+		err = serialErr
+		return
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"brick"}},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.AddDate(0, 0, expire),
+	}
+
+	var parent *x509.Certificate
+	var signer crypto.Signer
+	switch mode {
+	case CAMode:
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature
+		parent, signer = template, key
+		certFile, keyFile = "ca.crt", "ca.key"
+		break
+	case CertMode:
+		ca, caErr := tls.LoadX509KeyPair(strings.TrimSpace(options.CACert), strings.TrimSpace(options.CAKey))
+		if caErr != nil {
+			err = errors.Join(errors.New("load ca failed"), caErr)
+			return
+		}
+		if parent, err = x509.ParseCertificate(ca.Certificate[0]); err != nil {
+			err = errors.Join(errors.New("load ca failed"), err)
+			return
+		}
+		var ok bool
+		if signer, ok = ca.PrivateKey.(crypto.Signer); !ok {
+			err = errors.New("load ca failed: invalid private key")
+			return
+		}
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		if _, isRSA := key.(*rsa.PrivateKey); isRSA {
+			template.KeyUsage |= x509.KeyUsageKeyEncipherment
+		}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		hosts := options.Hosts
+		if len(hosts) == 0 {
+			hosts = []string{cn}
+		}
+		for _, host := range hosts {
+			if host = strings.TrimSpace(host); host == "" {
+				continue
+			}
+			if ip := net.ParseIP(host); ip != nil {
+				template.IPAddresses = append(template.IPAddresses, ip)
+			} else {
+				template.DNSNames = append(template.DNSNames, host)
+			}
+		}
+		certFile, keyFile = cn+".crt", cn+".key"
+		break
+	default:
+		err = errors.New("invalid mode " + options.Mode)
+		return
+	}
+
+	der, derErr := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), signer)
+	if derErr != nil {
+		err = derErr
+		return
+	}
+	keyDER, keyDERErr := x509.MarshalPKCS8PrivateKey(key)
+	if keyDERErr != nil {
+		err = keyDERErr
+		return
+	}
+	out := strings.TrimSpace(options.Out)
+	if out == "" {
+		out = "."
+	}
+	if err = os.MkdirAll(out, 0755); err != nil {
+		return
+	}
+	certFile, keyFile = filepath.Join(out, certFile), filepath.Join(out, keyFile)
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)
+	return
+}
+
+func generateKey(typ string) (key crypto.Signer, err error) {
+	switch strings.ToUpper(strings.TrimSpace(typ)) {
+	case "", ECDSAType:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		break
+	case RSAType:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+		break
+	case ED25519Type:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+		break
+	default:
+		err = errors.New("invalid type " + typ)
+		break
+	}
+	return
+}
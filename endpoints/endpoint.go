@@ -4,9 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"sync"
 
-	"github.com/brickingsoft/brick/configs"
+	"github.com/brickingsoft/brick/rpc/configs"
 	"github.com/brickingsoft/brick/transports"
 )
 
@@ -113,6 +114,15 @@ func (e *Endpoints) Close() (err error) {
 			errs = append(errs, closeErr)
 		}
 	}
+	// the retriever may own resources, e.g. clients of remote endpoints.
+	if closer, ok := e.retriever.(io.Closer); ok {
+		if closeErr := closer.Close(); closeErr != nil {
+			if len(errs) == 0 {
+				errs = append(errs, errors.New("failed to close endpoints"))
+			}
+			errs = append(errs, closeErr)
+		}
+	}
 	if len(errs) > 0 {
 		err = errors.Join(errs...)
 	}
@@ -1,7 +1,10 @@
 package endpoints
 
 import (
+	"bytes"
 	"context"
+	"io"
+	"net"
 
 	"github.com/brickingsoft/brick/transports"
 )
@@ -15,6 +18,9 @@ type Request interface {
 	Function() string
 	Header() Header
 	Body() ([]byte, error)
+	// BodyReader
+	// read the body without buffering it when the transport supports, e.g. a large upload of a streaming function.
+	BodyReader() io.Reader
 }
 
 type Response interface {
@@ -123,6 +129,8 @@ type RequestCtx interface {
 	Response() ResponseWriter
 	Hijacked() bool
 	Hijack(handler HijackHandler) (err error)
+	RemoteAddr() net.Addr
+	ClientAddr() string
 }
 
 type requestCtx struct {
@@ -133,6 +141,25 @@ func (r *requestCtx) Header() Header {
 	return r.RequestCtx.Header()
 }
 
+func (r *requestCtx) BodyReader() io.Reader {
+	if sr, ok := r.RequestCtx.(transports.StreamingRequest); ok {
+		return sr.BodyReader()
+	}
+	body, err := r.RequestCtx.Body()
+	if err != nil {
+		return &errorReader{err: err}
+	}
+	return bytes.NewReader(body)
+}
+
+type errorReader struct {
+	err error
+}
+
+func (r *errorReader) Read(_ []byte) (int, error) {
+	return 0, r.err
+}
+
 func (r *requestCtx) Response() ResponseWriter {
 	return r
 }
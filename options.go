@@ -6,13 +6,19 @@ import (
 	"strings"
 	"time"
 
-	"github.com/brickingsoft/brick/configs"
 	"github.com/brickingsoft/brick/endpoints"
-	"github.com/brickingsoft/brick/logs"
 	"github.com/brickingsoft/brick/pkg/whisper"
+	"github.com/brickingsoft/brick/rpc/configs"
+	"github.com/brickingsoft/brick/rpc/logs"
 	"github.com/brickingsoft/brick/transports"
 )
 
+const (
+	// DefaultCloseTimeout
+	// the default timeout of closing app, graceful transports drain requests within it.
+	DefaultCloseTimeout = 30 * time.Second
+)
+
 type Options struct {
 	Active                      string
 	Version                     string
@@ -100,6 +106,8 @@ func WithGracefulShutdown(signals ...os.Signal) Option {
 	}
 }
 
+// WithCloseTimeout
+// the timeout of closing app, DefaultCloseTimeout is used when it is not positive.
 func WithCloseTimeout(timeout time.Duration) Option {
 	return func(o *Options) error {
 		if timeout < 0 {
@@ -1,19 +1,155 @@
 package avro
 
-func Marshal(v any) (b []byte, err error) {
+import (
+	"errors"
+	"io"
+	"reflect"
+)
 
+// Marshal
+// encode the value in the avro binary encoding, the schema is implied by the go type:
+// structs are records whose fields are named by `avro`, `json` then `yaml` tags, slices and arrays are arrays,
+// maps of string keys are maps, []byte is bytes, [N]byte is fixed, Enum types are enums,
+// pointers are unions of null and the element, and interfaces are unions (see RegisterUnion).
+// time.Time is timestamp-micros, time.Duration is time-millis, big.Rat is decimal and Duration is duration,
+// other logical types are set by tags of fields (see SchemaOf).
+// pointers of the root value are dereferenced, so the root value is never a union. the schema is returned by SchemaOf.
+func Marshal(v any) (b []byte, err error) {
+	e := &encoder{buf: make([]byte, 0, 64)}
+	if err = e.encode(rootValue(reflect.ValueOf(v)), nil, 0); err != nil {
+		return
+	}
+	b = e.buf
 	return
 }
 
+// Unmarshal
+// decode the value which is encoded by Marshal into v, v is untouched when it is nil or b is empty.
 func Unmarshal(b []byte, v any) (err error) {
+	if v == nil || len(b) == 0 {
+		return
+	}
+	rv, rvErr := targetValue(v)
+	if rvErr != nil {
+		err = rvErr
+		return
+	}
+	src := &sliceSource{data: b}
+	d := &decoder{src: src}
+	if err = d.decode(rv, nil, 0); err != nil {
+		return
+	}
+	if src.pos != len(src.data) {
+		err = ErrTrailingData
+	}
+	return
+}
 
+// EncodeTo
+// encode the value into w, e.g. a bytebuffers.Buffer.
+func EncodeTo(w io.Writer, v any) (err error) {
+	b, encodeErr := Marshal(v)
+	if encodeErr != nil {
+		err = encodeErr
+		return
+	}
+	if len(b) == 0 {
+		return
+	}
+	_, err = w.Write(b)
 	return
 }
 
+// DecodeFrom
+// decode one value from r, bytes after the value are not read, so values can be decoded one by one.
+// io.EOF is returned when r is ended before the value.
+func DecodeFrom(r io.Reader, v any) (err error) {
+	rv, rvErr := targetValue(v)
+	if rvErr != nil {
+		err = rvErr
+		return
+	}
+	src := &streamSource{r: r}
+	if br, ok := r.(io.ByteReader); ok {
+		src.br = br
+	}
+	d := &decoder{src: src}
+	if err = d.decode(rv, nil, 0); err != nil {
+		if errors.Is(err, io.EOF) && src.n > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	return
+}
+
+var (
+	ErrInvalidUnmarshal = errors.New("avro: unmarshal target must be a non-nil pointer")
+	ErrShortBuffer      = errors.New("avro: unexpected end of data")
+	ErrTrailingData     = errors.New("avro: trailing data after the value")
+	ErrMaxDepth         = errors.New("avro: exceeded max depth")
+)
+
+// MaxDepth
+// the max nesting depth of values, it stops cycles of encoding and hostile inputs of decoding.
+const MaxDepth = 1000
+
+// MaxItems
+// the max count of items of an array or a map, items may take no bytes, e.g. nulls, so the count is limited.
+const MaxItems = 1 << 24
+
+// Marshaler
+// the value encodes itself, b is written as avro bytes.
 type Marshaler interface {
-	MarshalAVRO(v any) (b []byte, err error)
+	MarshalAVRO() (b []byte, err error)
 }
 
+// Unmarshaler
+// the value decodes itself from the avro bytes which are written by Marshaler.
 type Unmarshaler interface {
-	UnmarshalAVRO(b []byte, v any) (err error)
+	UnmarshalAVRO(b []byte) (err error)
+}
+
+// Enum
+// an avro enum, a value of an integer type is the index of its symbol and a value of a string type is the symbol.
+// Symbols must be implemented by the value receiver.
+type Enum interface {
+	Symbols() []string
+}
+
+var (
+	marshalerType   = reflect.TypeFor[Marshaler]()
+	unmarshalerType = reflect.TypeFor[Unmarshaler]()
+	enumType        = reflect.TypeFor[Enum]()
+	tags            = []string{"avro", "json", "yaml"}
+)
+
+// rootValue
+// dereference pointers of the root value, a nil pointer is the zero value of its element.
+func rootValue(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v = reflect.Zero(v.Type().Elem())
+			continue
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// targetValue
+// the settable root value of v, pointers are allocated.
+func targetValue(v any) (rv reflect.Value, err error) {
+	rv = reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		err = ErrInvalidUnmarshal
+		return
+	}
+	rv = rv.Elem()
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			rv.Set(reflect.New(rv.Type().Elem()))
+		}
+		rv = rv.Elem()
+	}
+	return
 }
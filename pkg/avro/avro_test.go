@@ -0,0 +1,212 @@
+package avro_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/brickingsoft/brick/pkg/avro"
+	"github.com/brickingsoft/bytebuffers"
+)
+
+type Suit int
+
+func (s Suit) Symbols() []string { return []string{"SPADES", "HEARTS", "DIAMONDS", "CLUBS"} }
+
+type Color string
+
+func (c Color) Symbols() []string { return []string{"red", "green"} }
+
+type Upper string
+
+func (u Upper) MarshalAVRO() ([]byte, error) { return []byte(strings.ToUpper(string(u))), nil }
+
+func (u *Upper) UnmarshalAVRO(b []byte) error {
+	*u = Upper(strings.ToLower(string(b)))
+	return nil
+}
+
+type Shape interface {
+	Area() float64
+}
+
+type Circle struct {
+	R float64 `avro:"r"`
+}
+
+func (c Circle) Area() float64 { return 3 * c.R * c.R }
+
+type Square struct {
+	Side int32 `avro:"side"`
+}
+
+func (s *Square) Area() float64 { return float64(s.Side * s.Side) }
+
+type Meta struct {
+	Version int8 `json:"version"`
+}
+
+type Card struct {
+	Meta
+	Name    string            `avro:"name"`
+	Suit    Suit              `avro:"suit"`
+	Color   Color             `avro:"color"`
+	Rank    uint16            `yaml:"rank"`
+	Score   float32           `avro:"score"`
+	Weight  float64           `avro:"weight"`
+	Hidden  bool              `avro:"hidden"`
+	Raw     []byte            `avro:"raw"`
+	Digest  [4]byte           `avro:"digest"`
+	Tags    []string          `avro:"tags"`
+	Counts  map[string]int64  `avro:"counts"`
+	Next    *Card             `avro:"next"`
+	Label   Upper             `avro:"label"`
+	Shape   Shape             `avro:"shape"`
+	Extra   any               `avro:"extra"`
+	Nested  map[string][]*int `avro:"nested"`
+	Ignored string            `avro:"-"`
+}
+
+func TestMarshal(t *testing.T) {
+	avro.RegisterUnion[Shape](Circle{}, &Square{})
+	one := 1
+	in := Card{
+		Meta:   Meta{Version: -3},
+		Name:   "ace",
+		Suit:   2,
+		Color:  "green",
+		Rank:   14,
+		Score:  1.5,
+		Weight: -2.25,
+		Hidden: true,
+		Raw:    []byte{1, 2},
+		Digest: [4]byte{9, 8, 7, 6},
+		Tags:   []string{"a", "b"},
+		Counts: map[string]int64{"x": 1, "y": -1},
+		Next:   &Card{Name: "king", Color: "red", Shape: Circle{R: 2}, Tags: []string{}, Counts: map[string]int64{}, Nested: map[string][]*int{}},
+		Label:  "up",
+		Shape:  &Square{Side: 3},
+		Extra:  map[string]any{"n": int64(1), "l": []any{"s", true, nil, 1.5, []byte{1}}},
+		Nested: map[string][]*int{"k": {&one, nil}},
+	}
+	b, err := avro.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := Card{}
+	if err = avro.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("unexpected card\n%+v\n%+v", in, out)
+	}
+
+	// pointers of the root are dereferenced
+	var ptr *Card
+	if err = avro.Unmarshal(b, &ptr); err != nil || ptr == nil || ptr.Name != "ace" {
+		t.Fatal("unexpected root pointer", ptr, err)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	type Record struct {
+		A int64  `avro:"a"`
+		B string `avro:"b"`
+	}
+	cases := []struct {
+		v   any
+		hex string
+	}{
+		{int64(0), "00"},
+		{int64(-1), "01"},
+		{int64(1), "02"},
+		{int64(-64), "7f"},
+		{int64(64), "8001"},
+		{"foo", "06666f6f"},
+		{true, "01"},
+		{Record{A: 27, B: "foo"}, "3606666f6f"},
+		{[]int64{3, 27}, "04063600"},
+		{map[string]int64{"a": 1}, "020261" + "0200"},
+		{struct{ P *int64 }{}, "00"},
+		{Suit(3), "06"},
+		{[2]byte{0xab, 0xcd}, "abcd"},
+	}
+	for _, c := range cases {
+		b, err := avro.Marshal(c.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(b) != c.hex {
+			t.Fatal("unexpected encoding of", c.v, hex.EncodeToString(b))
+		}
+	}
+}
+
+func TestDecodeFrom(t *testing.T) {
+	buf := bytebuffers.NewBuffer()
+	for _, s := range []string{"a", "bc", ""} {
+		if err := avro.EncodeTo(buf, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var values []string
+	for {
+		var s string
+		if err := avro.DecodeFrom(buf, &s); err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.Fatal(err)
+			}
+			break
+		}
+		values = append(values, s)
+	}
+	if !reflect.DeepEqual(values, []string{"a", "bc", ""}) {
+		t.Fatal("unexpected values", values)
+	}
+
+	// readers without ReadByte are read byte by byte, so nothing after the value is consumed.
+	r := io.MultiReader(bytes.NewReader([]byte{0x04, 'h', 'i', 0x02}))
+	var s string
+	var n int64
+	if err := avro.DecodeFrom(r, &s); err != nil || s != "hi" {
+		t.Fatal("unexpected string", s, err)
+	}
+	if err := avro.DecodeFrom(r, &n); err != nil || n != 1 {
+		t.Fatal("unexpected long", n, err)
+	}
+	if err := avro.DecodeFrom(bytes.NewReader([]byte{0x06, 'a'}), &s); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatal("expected unexpected eof", err)
+	}
+}
+
+func TestUnmarshal_Invalid(t *testing.T) {
+	var s string
+	if err := avro.Unmarshal([]byte{0x06, 'a'}, &s); !errors.Is(err, avro.ErrShortBuffer) {
+		t.Fatal("expected short buffer", err)
+	}
+	if err := avro.Unmarshal([]byte{0x02, 0x02}, new(int64)); !errors.Is(err, avro.ErrTrailingData) {
+		t.Fatal("expected trailing data", err)
+	}
+	if err := avro.Unmarshal([]byte{0x80, 0x04}, new(int8)); err == nil {
+		t.Fatal("expected overflow")
+	}
+	if err := avro.Unmarshal([]byte{0xfe, 0xff, 0xff, 0xff, 0x0f}, &[]struct{}{}); err == nil {
+		t.Fatal("expected max items")
+	}
+	if err := avro.Unmarshal([]byte{0x04}, &struct{ P *int64 }{}); err == nil {
+		t.Fatal("expected invalid branch")
+	}
+	if err := avro.Unmarshal([]byte{0x08}, new(Suit)); err == nil {
+		t.Fatal("expected invalid symbol")
+	}
+	if _, err := avro.Marshal(map[int]int{1: 1}); err == nil {
+		t.Fatal("expected unsupported key")
+	}
+	if err := avro.Unmarshal([]byte{0x02}, s); !errors.Is(err, avro.ErrInvalidUnmarshal) {
+		t.Fatal("expected invalid unmarshal", err)
+	}
+}
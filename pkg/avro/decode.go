@@ -0,0 +1,490 @@
+package avro
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+
+	"github.com/brickingsoft/brick/pkg/internal/codec"
+)
+
+// source
+// the bytes of the decoder, bytes returned by next are only valid until the next call.
+type source interface {
+	io.ByteReader
+	next(n int) (b []byte, err error)
+}
+
+type sliceSource struct {
+	data []byte
+	pos  int
+}
+
+func (src *sliceSource) ReadByte() (b byte, err error) {
+	if src.pos >= len(src.data) {
+		err = ErrShortBuffer
+		return
+	}
+	b = src.data[src.pos]
+	src.pos++
+	return
+}
+
+func (src *sliceSource) next(n int) (b []byte, err error) {
+	if n < 0 || len(src.data)-src.pos < n {
+		err = ErrShortBuffer
+		return
+	}
+	b = src.data[src.pos : src.pos+n]
+	src.pos += n
+	return
+}
+
+// streamSource
+// read bytes of a value from a reader, n is the count of read bytes.
+type streamSource struct {
+	r   io.Reader
+	br  io.ByteReader
+	buf []byte
+	one [1]byte
+	n   int
+}
+
+func (src *streamSource) ReadByte() (b byte, err error) {
+	if src.br != nil {
+		if b, err = src.br.ReadByte(); err == nil {
+			src.n++
+		}
+		return
+	}
+	if _, err = io.ReadFull(src.r, src.one[:]); err != nil {
+		return
+	}
+	src.n++
+	b = src.one[0]
+	return
+}
+
+// next
+// the buffer grows while reading, so a hostile length can not allocate more than the reader has.
+func (src *streamSource) next(n int) (b []byte, err error) {
+	if n < 0 {
+		err = ErrShortBuffer
+		return
+	}
+	const chunk = 64 << 10
+	src.buf = src.buf[:0]
+	for len(src.buf) < n {
+		size := min(n-len(src.buf), chunk)
+		start := len(src.buf)
+		src.buf = append(src.buf, make([]byte, size)...)
+		read, readErr := io.ReadFull(src.r, src.buf[start:])
+		src.n += read
+		if readErr != nil {
+			err = readErr
+			if errors.Is(err, io.EOF) && src.n > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		}
+	}
+	b = src.buf
+	return
+}
+
+type decoder struct {
+	src source
+}
+
+func (d *decoder) readLong() (n int64, err error) {
+	u, readErr := binary.ReadUvarint(d.src)
+	if readErr != nil {
+		err = readErr
+		if errors.Is(readErr, io.ErrUnexpectedEOF) {
+			err = ErrShortBuffer
+		}
+		return
+	}
+	n = int64(u>>1) ^ -int64(u&1)
+	return
+}
+
+// readLength
+// the length of strings and bytes.
+func (d *decoder) readLength() (n int, err error) {
+	l, readErr := d.readLong()
+	if readErr != nil {
+		err = readErr
+		return
+	}
+	if l < 0 || l > math.MaxInt32 {
+		err = fmt.Errorf("avro: invalid length %d", l)
+		return
+	}
+	n = int(l)
+	return
+}
+
+func (d *decoder) readBytes() (b []byte, err error) {
+	n, lenErr := d.readLength()
+	if lenErr != nil {
+		err = lenErr
+		return
+	}
+	return d.src.next(n)
+}
+
+// readBlocks
+// read items of arrays and maps block by block until the empty block.
+func (d *decoder) readBlocks(item func() error) (err error) {
+	total := int64(0)
+	for {
+		count, countErr := d.readLong()
+		if countErr != nil {
+			err = countErr
+			return
+		}
+		if count == 0 {
+			return
+		}
+		if count < 0 {
+			// the block size follows the negative count.
+			if count == math.MinInt64 {
+				err = errors.New("avro: invalid block count")
+				return
+			}
+			count = -count
+			if _, err = d.readLong(); err != nil {
+				return
+			}
+		}
+		if total += count; total > MaxItems {
+			err = fmt.Errorf("avro: exceeded max items %d", MaxItems)
+			return
+		}
+		for i := int64(0); i < count; i++ {
+			if err = item(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (d *decoder) decode(v reflect.Value, hint *Schema, depth int) (err error) {
+	if depth > MaxDepth {
+		err = ErrMaxDepth
+		return
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		return d.decodeOptional(v, hint, depth)
+	case reflect.Interface:
+		return d.decodeUnion(v, depth)
+	default:
+		break
+	}
+	if schema := logicalOf(v.Type(), hint); schema != nil {
+		return d.decodeLogical(v, schema)
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(unmarshalerType) {
+		b, readErr := d.readBytes()
+		if readErr != nil {
+			err = readErr
+			return
+		}
+		err = v.Addr().Interface().(Unmarshaler).UnmarshalAVRO(append([]byte(nil), b...))
+		return
+	}
+	if v.Type().Implements(enumType) {
+		return d.decodeEnum(v)
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		b, readErr := d.src.ReadByte()
+		if readErr != nil {
+			err = readErr
+			return
+		}
+		v.SetBool(b != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, readErr := d.readLong()
+		if readErr != nil {
+			err = readErr
+			return
+		}
+		err = codec.SetInt(v, n)
+	case reflect.Float32:
+		b, readErr := d.src.next(4)
+		if readErr != nil {
+			err = readErr
+			return
+		}
+		v.SetFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))))
+	case reflect.Float64:
+		b, readErr := d.src.next(8)
+		if readErr != nil {
+			err = readErr
+			return
+		}
+		v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+	case reflect.String:
+		b, readErr := d.readBytes()
+		if readErr != nil {
+			err = readErr
+			return
+		}
+		v.SetString(string(b))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b, readErr := d.readBytes()
+			if readErr != nil {
+				err = readErr
+				return
+			}
+			v.SetBytes(append([]byte(nil), b...))
+			return
+		}
+		return d.decodeArray(v, hint, depth)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			// fixed
+			b, readErr := d.src.next(v.Len())
+			if readErr != nil {
+				err = readErr
+				return
+			}
+			reflect.Copy(v, reflect.ValueOf(b))
+			return
+		}
+		return d.decodeArray(v, hint, depth)
+	case reflect.Map:
+		return d.decodeMap(v, hint, depth)
+	case reflect.Struct:
+		return d.decodeRecord(v, depth)
+	default:
+		err = fmt.Errorf("avro: unsupported type %s", v.Type())
+	}
+	return
+}
+
+// readBranch
+// the index of the branch of a union.
+func (d *decoder) readBranch(n int) (index int, err error) {
+	i, readErr := d.readLong()
+	if readErr != nil {
+		err = readErr
+		return
+	}
+	if i < 0 || i >= int64(n) {
+		err = fmt.Errorf("avro: invalid union branch %d", i)
+		return
+	}
+	index = int(i)
+	return
+}
+
+func (d *decoder) decodeOptional(v reflect.Value, hint *Schema, depth int) (err error) {
+	index, branchErr := d.readBranch(2)
+	if branchErr != nil {
+		err = branchErr
+		return
+	}
+	if index == 0 {
+		codec.SetNil(v)
+		return
+	}
+	return d.decode(codec.Indirect(v), hint, depth+1)
+}
+
+func (d *decoder) decodeUnion(v reflect.Value, depth int) (err error) {
+	branches, registered := unionOf(v.Type())
+	if !registered {
+		if v.NumMethod() > 0 {
+			err = fmt.Errorf("avro: union %s is not registered", v.Type())
+			return
+		}
+		var value any
+		if value, err = d.decodeAny(depth); err != nil {
+			return
+		}
+		if value == nil {
+			codec.SetNil(v)
+			return
+		}
+		v.Set(reflect.ValueOf(value))
+		return
+	}
+	index, branchErr := d.readBranch(len(branches) + 1)
+	if branchErr != nil {
+		err = branchErr
+		return
+	}
+	if index == 0 {
+		codec.SetNil(v)
+		return
+	}
+	branch := branches[index-1]
+	value := reflect.New(branch).Elem()
+	if branch.Kind() == reflect.Pointer {
+		// the branch is the pointer but not a union of null.
+		value.Set(reflect.New(branch.Elem()))
+		err = d.decode(value.Elem(), nil, depth+1)
+	} else {
+		err = d.decode(value, nil, depth+1)
+	}
+	if err != nil {
+		return
+	}
+	v.Set(value)
+	return
+}
+
+// decodeAny
+// the value of an interface which is not registered.
+func (d *decoder) decodeAny(depth int) (value any, err error) {
+	if depth > MaxDepth {
+		err = ErrMaxDepth
+		return
+	}
+	index, branchErr := d.readBranch(anyMap + 1)
+	if branchErr != nil {
+		err = branchErr
+		return
+	}
+	switch index {
+	case anyBoolean:
+		b, readErr := d.src.ReadByte()
+		value, err = b != 0, readErr
+	case anyLong:
+		value, err = d.readLong()
+	case anyDouble:
+		b, readErr := d.src.next(8)
+		if readErr != nil {
+			err = readErr
+			return
+		}
+		value = math.Float64frombits(binary.LittleEndian.Uint64(b))
+	case anyBytes:
+		b, readErr := d.readBytes()
+		value, err = append([]byte(nil), b...), readErr
+	case anyString:
+		b, readErr := d.readBytes()
+		value, err = string(b), readErr
+	case anyArray:
+		values := make([]any, 0, 1)
+		err = d.readBlocks(func() (itemErr error) {
+			item, itemErr := d.decodeAny(depth + 1)
+			values = append(values, item)
+			return
+		})
+		value = values
+	case anyMap:
+		values := make(map[string]any)
+		err = d.readBlocks(func() (itemErr error) {
+			key, keyErr := d.readBytes()
+			if keyErr != nil {
+				return keyErr
+			}
+			name := string(key)
+			item, itemErr := d.decodeAny(depth + 1)
+			values[name] = item
+			return
+		})
+		value = values
+	default:
+		break
+	}
+	return
+}
+
+func (d *decoder) decodeEnum(v reflect.Value) (err error) {
+	symbols := reflect.Zero(v.Type()).Interface().(Enum).Symbols()
+	index, branchErr := d.readLong()
+	if branchErr != nil {
+		err = branchErr
+		return
+	}
+	if index < 0 || index >= int64(len(symbols)) {
+		err = fmt.Errorf("avro: invalid symbol %d of enum %s", index, v.Type())
+		return
+	}
+	if v.Kind() == reflect.String {
+		v.SetString(symbols[index])
+		return
+	}
+	return codec.SetInt(v, index)
+}
+
+func (d *decoder) decodeArray(v reflect.Value, hint *Schema, depth int) (err error) {
+	i := 0
+	if v.Kind() == reflect.Slice {
+		v.SetLen(0)
+		if v.IsNil() {
+			v.Set(reflect.MakeSlice(v.Type(), 0, 1))
+		}
+	}
+	err = d.readBlocks(func() error {
+		defer func() { i++ }()
+		if v.Kind() == reflect.Slice {
+			v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+			return d.decode(v.Index(i), hint, depth+1)
+		}
+		if i < v.Len() {
+			return d.decode(v.Index(i), hint, depth+1)
+		}
+		// items which are out of the array are dropped.
+		return d.decode(reflect.New(v.Type().Elem()).Elem(), hint, depth+1)
+	})
+	return
+}
+
+func (d *decoder) decodeMap(v reflect.Value, hint *Schema, depth int) (err error) {
+	if v.Type().Key().Kind() != reflect.String {
+		err = fmt.Errorf("avro: key of map %s must be string", v.Type())
+		return
+	}
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(v.Type()))
+	}
+	kt, vt := v.Type().Key(), v.Type().Elem()
+	err = d.readBlocks(func() error {
+		b, keyErr := d.readBytes()
+		if keyErr != nil {
+			return keyErr
+		}
+		key := reflect.New(kt).Elem()
+		key.SetString(string(b))
+		value := reflect.New(vt).Elem()
+		if valueErr := d.decode(value, hint, depth+1); valueErr != nil {
+			return valueErr
+		}
+		v.SetMapIndex(key, value)
+		return nil
+	})
+	return
+}
+
+func (d *decoder) decodeRecord(v reflect.Value, depth int) (err error) {
+	hints, hintsErr := hintsOf(v.Type())
+	if hintsErr != nil {
+		err = hintsErr
+		return
+	}
+	fields := codec.StructFields(v.Type(), tags...)
+	for i, field := range fields.List {
+		fv, ok := codec.FieldByIndex(v, field.Index, true)
+		if !ok {
+			// the field of an embedded pointer which can not be allocated is dropped.
+			fv = reflect.New(field.Type).Elem()
+		}
+		if err = d.decode(fv, hints[i], depth+1); err != nil {
+			err = fmt.Errorf("avro: field %s of %s: %w", field.Name, v.Type(), err)
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,203 @@
+package avro
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+
+	"github.com/brickingsoft/brick/pkg/internal/codec"
+)
+
+// setDefault
+// set the json default of the reader schema into v, bytes and fixed defaults are strings of code points 0-255.
+func setDefault(v reflect.Value, schema *Schema, def any, depth int) (err error) {
+	if depth > MaxDepth {
+		err = ErrMaxDepth
+		return
+	}
+	switch schema.Type {
+	case TypeNull, TypeUnion:
+		// defaults of unions are of the first branch which is null in go types.
+		if def != nil {
+			err = fmt.Errorf("default %v of %s must be null", def, schema.Type)
+			return
+		}
+		codec.SetNil(v)
+		return
+	case TypeRecord:
+		if schema.Name == AnyName && v.Kind() == reflect.Interface {
+			fields, _ := def.(map[string]any)
+			if value := fields["value"]; value != nil {
+				v.Set(reflect.ValueOf(value))
+				return
+			}
+			codec.SetNil(v)
+			return
+		}
+		return setRecordDefault(v, schema, def, depth)
+	default:
+		break
+	}
+	mismatch := func() error {
+		return fmt.Errorf("default %v is not %s", def, schema.Type)
+	}
+	if schema.LogicalType != "" {
+		// the default is of the underlying type.
+		underlying, ok := any(nil), false
+		switch schema.Type {
+		case TypeInt, TypeLong:
+			f, isNumber := def.(float64)
+			underlying, ok = int64(f), isNumber && f == math.Trunc(f) && math.Abs(f) <= 1<<63
+		case TypeBytes, TypeFixed:
+			s, isString := def.(string)
+			if isString {
+				underlying, ok = defaultBytes(s)
+			}
+		default:
+			underlying, ok = def, true
+		}
+		if !ok {
+			return mismatch()
+		}
+		return setLogical(v, logicalValue(schema, underlying))
+	}
+	switch schema.Type {
+	case TypeBoolean:
+		b, ok := def.(bool)
+		if !ok || v.Kind() != reflect.Bool {
+			return mismatch()
+		}
+		v.SetBool(b)
+	case TypeInt, TypeLong:
+		f, ok := def.(float64)
+		if !ok || f != math.Trunc(f) || math.Abs(f) > 1<<63 {
+			return mismatch()
+		}
+		err = codec.SetInt(v, int64(f))
+	case TypeFloat, TypeDouble:
+		f, ok := def.(float64)
+		if !ok {
+			return mismatch()
+		}
+		err = codec.SetFloat(v, f)
+	case TypeString:
+		s, ok := def.(string)
+		if !ok || v.Kind() != reflect.String {
+			return mismatch()
+		}
+		v.SetString(s)
+	case TypeBytes, TypeFixed:
+		s, ok := def.(string)
+		if !ok {
+			return mismatch()
+		}
+		b, ok := defaultBytes(s)
+		if !ok || (schema.Type == TypeFixed && len(b) != schema.Size) {
+			return mismatch()
+		}
+		if v.CanAddr() && v.Addr().Type().Implements(unmarshalerType) {
+			return v.Addr().Interface().(Unmarshaler).UnmarshalAVRO(b)
+		}
+		err = codec.SetBytes(v, b)
+	case TypeEnum:
+		s, ok := def.(string)
+		if !ok {
+			return mismatch()
+		}
+		err = setSymbol(v, schema, s)
+	case TypeArray:
+		items, ok := def.([]any)
+		if !ok {
+			return mismatch()
+		}
+		switch v.Kind() {
+		case reflect.Slice:
+			v.Set(reflect.MakeSlice(v.Type(), len(items), len(items)))
+		case reflect.Array:
+			items = items[:min(len(items), v.Len())]
+		default:
+			return mismatch()
+		}
+		for i, item := range items {
+			if err = setDefault(v.Index(i), schema.Items, item, depth+1); err != nil {
+				return
+			}
+		}
+	case TypeMap:
+		values, ok := def.(map[string]any)
+		if !ok || v.Kind() != reflect.Map {
+			return mismatch()
+		}
+		v.Set(reflect.MakeMapWithSize(v.Type(), len(values)))
+		for key, value := range values {
+			item := reflect.New(v.Type().Elem()).Elem()
+			if err = setDefault(item, schema.Values, value, depth+1); err != nil {
+				return
+			}
+			k := reflect.New(v.Type().Key()).Elem()
+			k.SetString(key)
+			v.SetMapIndex(k, item)
+		}
+	default:
+		return mismatch()
+	}
+	return
+}
+
+// defaultBytes
+// bytes of the default string whose code points are 0-255.
+func defaultBytes(s string) (b []byte, ok bool) {
+	b = make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xff {
+			return
+		}
+		b = append(b, byte(r))
+	}
+	ok = true
+	return
+}
+
+// setRecordDefault
+// fields which are missing in the default take their own defaults.
+func setRecordDefault(v reflect.Value, schema *Schema, def any, depth int) (err error) {
+	values, ok := def.(map[string]any)
+	if !ok || v.Kind() != reflect.Struct {
+		err = fmt.Errorf("default %v is not record %s", def, schema.Name)
+		return
+	}
+	fields := codec.StructFields(v.Type(), tags...)
+	for i, field := range schema.Fields {
+		value, has := values[field.Name]
+		if !has {
+			if !field.HasDefault {
+				continue
+			}
+			value = field.Default
+		}
+		fv, fvOk := codec.FieldByIndex(v, fields.List[i].Index, true)
+		if !fvOk {
+			continue
+		}
+		if err = setDefault(fv, field.Type, value, depth+1); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// setSymbol
+// set the symbol of the enum, an integer value is the index of the symbol in its symbols.
+func setSymbol(v reflect.Value, schema *Schema, symbol string) (err error) {
+	index := slices.Index(schema.Symbols, symbol)
+	if index < 0 {
+		err = fmt.Errorf("%s is not a symbol of enum %s", symbol, schema.Name)
+		return
+	}
+	if v.Kind() == reflect.String {
+		v.SetString(symbol)
+		return
+	}
+	return codec.SetInt(v, int64(index))
+}
@@ -0,0 +1,326 @@
+package avro
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/brickingsoft/brick/pkg/internal/codec"
+)
+
+// AnyName
+// the name of the record of interfaces which are not registered, its only field is the union of
+// null, boolean, long, double, bytes, string, array and map, so the encoding is the union itself.
+const AnyName = "avro.Any"
+
+// SchemaOf
+// the schema which is implied by the go type of v, it is the writer schema of Marshal.
+// pointers of the root value are dereferenced like Marshal.
+// defaults of fields are set by `default` tags in json, e.g. `default:"10"`, strings may be unquoted,
+// pointer fields default to null, and aliases of fields are set by `aliases` tags, e.g. `aliases:"old_name"`.
+// structs are records named by their package and type names.
+// logical types of fields are set by `logical` tags, e.g. `logical:"date"` of time.Time or `logical:"uuid"` of string and [16]byte,
+// and `decimal` tags of big.Rat, e.g. `decimal:"18,2"` is the precision and the scale.
+func SchemaOf(v any) (schema *Schema, err error) {
+	typ := reflect.TypeOf(v)
+	if typ == nil {
+		err = errors.New("avro: schema of nil")
+		return
+	}
+	return SchemaOfType(typ)
+}
+
+var schemas sync.Map // reflect.Type -> *Schema
+
+// SchemaOfType
+// the schema which is implied by the go type, see SchemaOf. schemas are cached and must not be modified.
+func SchemaOfType(typ reflect.Type) (schema *Schema, err error) {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if cached, ok := schemas.Load(typ); ok {
+		schema = cached.(*Schema)
+		return
+	}
+	d := &deriver{named: make(map[reflect.Type]*Schema), fixed: make(map[int]*Schema)}
+	if schema, err = d.derive(typ, nil, 0); err != nil {
+		return
+	}
+	schemas.Store(typ, schema)
+	return
+}
+
+type deriver struct {
+	named     map[reflect.Type]*Schema
+	fixed     map[int]*Schema
+	any       *Schema
+	anonymous int
+}
+
+func (d *deriver) derive(typ reflect.Type, hint *Schema, depth int) (schema *Schema, err error) {
+	if depth > MaxDepth {
+		err = ErrMaxDepth
+		return
+	}
+	if logical := logicalOf(typ, hint); logical != nil {
+		schema = logical
+		return
+	}
+	if named, ok := d.named[typ]; ok {
+		schema = named
+		return
+	}
+	switch typ.Kind() {
+	case reflect.Pointer:
+		for typ.Kind() == reflect.Pointer {
+			typ = typ.Elem()
+		}
+		if typ.Kind() == reflect.Interface {
+			err = fmt.Errorf("avro: pointer of interface %s is a union of union", typ)
+			return
+		}
+		elem, elemErr := d.derive(typ, hint, depth+1)
+		if elemErr != nil {
+			err = elemErr
+			return
+		}
+		schema = &Schema{Type: TypeUnion, Branches: []*Schema{{Type: TypeNull}, elem}}
+		return
+	case reflect.Interface:
+		return d.deriveUnion(typ, depth)
+	default:
+		break
+	}
+	if typ.Implements(marshalerType) || reflect.PointerTo(typ).Implements(marshalerType) {
+		schema = &Schema{Type: TypeBytes}
+		return
+	}
+	if typ.Implements(enumType) {
+		symbols := reflect.Zero(typ).Interface().(Enum).Symbols()
+		schema = &Schema{Type: TypeEnum, Name: typeName(typ), Symbols: append([]string(nil), symbols...)}
+		d.named[typ] = schema
+		return
+	}
+	switch typ.Kind() {
+	case reflect.Bool:
+		schema = &Schema{Type: TypeBoolean}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		schema = &Schema{Type: TypeInt}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		schema = &Schema{Type: TypeLong}
+	case reflect.Float32:
+		schema = &Schema{Type: TypeFloat}
+	case reflect.Float64:
+		schema = &Schema{Type: TypeDouble}
+	case reflect.String:
+		schema = &Schema{Type: TypeString}
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			schema = &Schema{Type: TypeBytes}
+			return
+		}
+		return d.deriveArray(typ, hint, depth)
+	case reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			return d.deriveFixed(typ)
+		}
+		return d.deriveArray(typ, hint, depth)
+	case reflect.Map:
+		if typ.Key().Kind() != reflect.String {
+			err = fmt.Errorf("avro: key of map %s must be string", typ)
+			return
+		}
+		values, valuesErr := d.derive(typ.Elem(), hint, depth+1)
+		if valuesErr != nil {
+			err = valuesErr
+			return
+		}
+		schema = &Schema{Type: TypeMap, Values: values}
+	case reflect.Struct:
+		return d.deriveRecord(typ, depth)
+	default:
+		err = fmt.Errorf("avro: unsupported type %s", typ)
+	}
+	return
+}
+
+func (d *deriver) deriveUnion(typ reflect.Type, depth int) (schema *Schema, err error) {
+	branches, registered := unionOf(typ)
+	if !registered {
+		schema = d.anySchema()
+		return
+	}
+	schema = &Schema{Type: TypeUnion, Branches: []*Schema{{Type: TypeNull}}}
+	for _, branch := range branches {
+		// a pointer branch is not a union of null.
+		for branch.Kind() == reflect.Pointer {
+			branch = branch.Elem()
+		}
+		s, branchErr := d.derive(branch, nil, depth+1)
+		if branchErr != nil {
+			err = branchErr
+			return
+		}
+		schema.Branches = append(schema.Branches, s)
+	}
+	return
+}
+
+// anySchema
+// the record of AnyName, it is defined once in a schema.
+func (d *deriver) anySchema() *Schema {
+	if d.any != nil {
+		return d.any
+	}
+	d.any = &Schema{Type: TypeRecord, Name: AnyName}
+	d.any.Fields = []*Field{{
+		Name: "value",
+		Type: &Schema{Type: TypeUnion, Branches: []*Schema{
+			{Type: TypeNull},
+			{Type: TypeBoolean},
+			{Type: TypeLong},
+			{Type: TypeDouble},
+			{Type: TypeBytes},
+			{Type: TypeString},
+			{Type: TypeArray, Items: d.any},
+			{Type: TypeMap, Values: d.any},
+		}},
+		HasDefault: true,
+	}}
+	return d.any
+}
+
+func (d *deriver) deriveArray(typ reflect.Type, hint *Schema, depth int) (schema *Schema, err error) {
+	items, itemsErr := d.derive(typ.Elem(), hint, depth+1)
+	if itemsErr != nil {
+		err = itemsErr
+		return
+	}
+	schema = &Schema{Type: TypeArray, Items: items}
+	return
+}
+
+// deriveFixed
+// fixed of unnamed arrays are shared by sizes, e.g. `avro.Fixed16`.
+func (d *deriver) deriveFixed(typ reflect.Type) (schema *Schema, err error) {
+	if typ.Name() == "" {
+		if fixed, ok := d.fixed[typ.Len()]; ok {
+			schema = fixed
+			return
+		}
+		schema = &Schema{Type: TypeFixed, Name: fmt.Sprintf("avro.Fixed%d", typ.Len()), Size: typ.Len()}
+		d.fixed[typ.Len()] = schema
+		return
+	}
+	schema = &Schema{Type: TypeFixed, Name: typeName(typ), Size: typ.Len()}
+	d.named[typ] = schema
+	return
+}
+
+func (d *deriver) deriveRecord(typ reflect.Type, depth int) (schema *Schema, err error) {
+	name := typeName(typ)
+	if name == "" {
+		d.anonymous++
+		name = fmt.Sprintf("avro.Record%d", d.anonymous)
+	}
+	schema = &Schema{Type: TypeRecord, Name: name}
+	// registered before fields, so recursive fields reference it.
+	d.named[typ] = schema
+	hints, hintsErr := hintsOf(typ)
+	if hintsErr != nil {
+		err = hintsErr
+		return
+	}
+	fields := codec.StructFields(typ, tags...)
+	schema.Fields = make([]*Field, 0, len(fields.List))
+	for i, f := range fields.List {
+		field := &Field{Name: f.Name}
+		if field.Type, err = d.derive(f.Type, hints[i], depth+1); err != nil {
+			err = fmt.Errorf("avro: field %s of %s: %w", f.Name, typ, err)
+			return
+		}
+		sf := typ.FieldByIndex(f.Index)
+		if aliases, has := sf.Tag.Lookup("aliases"); has && aliases != "" {
+			field.Aliases = strings.Split(aliases, ",")
+		}
+		if def, has := sf.Tag.Lookup("default"); has {
+			field.Default, field.HasDefault = parseDefault(def, field.Type), true
+			// the default is checked by setting it into a zero value.
+			if err = setDefault(reflect.New(f.Type).Elem(), field.Type, field.Default, 0); err != nil {
+				err = fmt.Errorf("avro: default of field %s of %s: %w", f.Name, typ, err)
+				return
+			}
+		} else if field.Type.Type == TypeUnion {
+			field.HasDefault = true
+		} else if field.Type == d.any {
+			field.Default, field.HasDefault = map[string]any{"value": nil}, true
+		}
+		schema.Fields = append(schema.Fields, field)
+	}
+	return
+}
+
+// parseDefault
+// the json of the tag, unquoted strings are taken as they are for strings, bytes, enums and fixed.
+func parseDefault(tag string, schema *Schema) (v any) {
+	decoder := json.NewDecoder(strings.NewReader(tag))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil || decoder.More() {
+		return tag
+	}
+	v = normalizeJSON(v)
+	if schema.LogicalType == LogicalDecimal {
+		// decimals are written in tags as numbers, e.g. `default:"1.50"`.
+		if r, ok := new(big.Rat).SetString(tag); ok {
+			if b, err := decimalBytes(r, schema.Precision, schema.Scale); err == nil {
+				runes := make([]rune, len(b))
+				for i, c := range b {
+					runes[i] = rune(c)
+				}
+				return string(runes)
+			}
+		}
+		return tag
+	}
+	switch schema.Type {
+	case TypeString, TypeBytes, TypeEnum, TypeFixed:
+		if _, ok := v.(string); !ok {
+			v = tag
+		}
+	default:
+		break
+	}
+	return
+}
+
+// typeName
+// the full name of the go type in avro, e.g. `brick.Card`, it is empty for unnamed types.
+func typeName(typ reflect.Type) string {
+	name := typ.Name()
+	if name == "" {
+		return ""
+	}
+	name = avroIdentifier(name)
+	if pkg := path.Base(typ.PkgPath()); pkg != "" && pkg != "." {
+		name = avroIdentifier(pkg) + "." + name
+	}
+	return name
+}
+
+// avroIdentifier
+// characters which are not valid in avro names are replaced by `_`, e.g. of generic types.
+func avroIdentifier(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		valid := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,298 @@
+package avro
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/brickingsoft/brick/pkg/internal/codec"
+)
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) encode(v reflect.Value, hint *Schema, depth int) (err error) {
+	if depth > MaxDepth {
+		err = ErrMaxDepth
+		return
+	}
+	if !v.IsValid() {
+		return
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		return e.encodeOptional(v, hint, depth)
+	case reflect.Interface:
+		return e.encodeUnion(v, depth)
+	default:
+		break
+	}
+	if schema := logicalOf(v.Type(), hint); schema != nil {
+		return e.encodeLogical(v, schema)
+	}
+	if m, ok := codec.Implemented(v, marshalerType); ok {
+		b, marshalErr := m.(Marshaler).MarshalAVRO()
+		if marshalErr != nil {
+			err = fmt.Errorf("avro: marshal %s failed: %w", v.Type(), marshalErr)
+			return
+		}
+		e.writeBytes(b)
+		return
+	}
+	if v.Type().Implements(enumType) {
+		return e.encodeEnum(v)
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, 1)
+		} else {
+			e.buf = append(e.buf, 0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.writeLong(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := v.Uint()
+		if n > math.MaxInt64 {
+			err = fmt.Errorf("avro: %d of %s overflows long", n, v.Type())
+			return
+		}
+		e.writeLong(int64(n))
+	case reflect.Float32:
+		e.buf = binary.LittleEndian.AppendUint32(e.buf, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.writeString(v.String())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.writeBytes(v.Bytes())
+			return
+		}
+		return e.encodeArray(v, hint, depth)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			// fixed
+			for i := 0; i < v.Len(); i++ {
+				e.buf = append(e.buf, byte(v.Index(i).Uint()))
+			}
+			return
+		}
+		return e.encodeArray(v, hint, depth)
+	case reflect.Map:
+		return e.encodeMap(v, hint, depth)
+	case reflect.Struct:
+		return e.encodeRecord(v, depth)
+	default:
+		err = fmt.Errorf("avro: unsupported type %s", v.Type())
+	}
+	return
+}
+
+// encodeOptional
+// a pointer is the union of null and its element, pointers of pointers are flattened.
+func (e *encoder) encodeOptional(v reflect.Value, hint *Schema, depth int) (err error) {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			e.writeLong(0)
+			return
+		}
+		v = v.Elem()
+	}
+	e.writeLong(1)
+	return e.encode(v, hint, depth+1)
+}
+
+func (e *encoder) encodeUnion(v reflect.Value, depth int) (err error) {
+	if v.IsNil() {
+		e.writeLong(0)
+		return
+	}
+	if branches, ok := unionOf(v.Type()); ok {
+		elem := v.Elem()
+		index := slices.Index(branches, elem.Type())
+		if index < 0 {
+			err = fmt.Errorf("avro: %s is not a branch of union %s", elem.Type(), v.Type())
+			return
+		}
+		e.writeLong(int64(index + 1))
+		// a pointer branch is not a union of null.
+		return e.encode(rootValue(elem), nil, depth+1)
+	}
+	return e.encodeAny(v.Elem(), depth)
+}
+
+// encodeAny
+// the value of an interface which is not registered.
+func (e *encoder) encodeAny(v reflect.Value, depth int) (err error) {
+	if depth > MaxDepth {
+		err = ErrMaxDepth
+		return
+	}
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			e.writeLong(anyNull)
+			return
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		e.writeLong(anyBoolean)
+		if v.Bool() {
+			e.buf = append(e.buf, 1)
+		} else {
+			e.buf = append(e.buf, 0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.writeLong(anyLong)
+		e.writeLong(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			err = fmt.Errorf("avro: %d of %s overflows long", v.Uint(), v.Type())
+			return
+		}
+		e.writeLong(anyLong)
+		e.writeLong(int64(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		e.writeLong(anyDouble)
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.writeLong(anyString)
+		e.writeString(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.writeLong(anyBytes)
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			e.writeBytes(b)
+			return
+		}
+		e.writeLong(anyArray)
+		return e.writeBlocks(v.Len(), func(i int) error {
+			return e.encodeAny(v.Index(i), depth+1)
+		})
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			err = fmt.Errorf("avro: key of map %s must be string", v.Type())
+			return
+		}
+		e.writeLong(anyMap)
+		keys := sortedKeys(v)
+		return e.writeBlocks(len(keys), func(i int) error {
+			e.writeString(keys[i].String())
+			return e.encodeAny(v.MapIndex(keys[i]), depth+1)
+		})
+	default:
+		err = fmt.Errorf("avro: %s is not a branch of unregistered union", v.Type())
+	}
+	return
+}
+
+func (e *encoder) encodeEnum(v reflect.Value) (err error) {
+	symbols := v.Interface().(Enum).Symbols()
+	index := -1
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n := v.Int(); n >= 0 && n < int64(len(symbols)) {
+			index = int(n)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n := v.Uint(); n < uint64(len(symbols)) {
+			index = int(n)
+		}
+	case reflect.String:
+		index = slices.Index(symbols, v.String())
+	default:
+		err = fmt.Errorf("avro: enum %s must be an integer or a string", v.Type())
+		return
+	}
+	if index < 0 {
+		err = fmt.Errorf("avro: %v is not a symbol of enum %s", v.Interface(), v.Type())
+		return
+	}
+	e.writeLong(int64(index))
+	return
+}
+
+func (e *encoder) encodeArray(v reflect.Value, hint *Schema, depth int) (err error) {
+	return e.writeBlocks(v.Len(), func(i int) error {
+		return e.encode(v.Index(i), hint, depth+1)
+	})
+}
+
+func (e *encoder) encodeMap(v reflect.Value, hint *Schema, depth int) (err error) {
+	if v.Type().Key().Kind() != reflect.String {
+		err = fmt.Errorf("avro: key of map %s must be string", v.Type())
+		return
+	}
+	keys := sortedKeys(v)
+	return e.writeBlocks(len(keys), func(i int) error {
+		e.writeString(keys[i].String())
+		return e.encode(v.MapIndex(keys[i]), hint, depth+1)
+	})
+}
+
+func (e *encoder) encodeRecord(v reflect.Value, depth int) (err error) {
+	hints, hintsErr := hintsOf(v.Type())
+	if hintsErr != nil {
+		err = hintsErr
+		return
+	}
+	fields := codec.StructFields(v.Type(), tags...)
+	for i, field := range fields.List {
+		fv, ok := codec.FieldByIndex(v, field.Index, false)
+		if !ok {
+			// the field of a nil embedded pointer.
+			fv = reflect.Zero(field.Type)
+		}
+		if err = e.encode(fv, hints[i], depth+1); err != nil {
+			err = fmt.Errorf("avro: field %s of %s: %w", field.Name, v.Type(), err)
+			return
+		}
+	}
+	return
+}
+
+// sortedKeys
+// keys of maps are sorted, so the output is deterministic.
+func sortedKeys(v reflect.Value) []reflect.Value {
+	keys := v.MapKeys()
+	slices.SortFunc(keys, func(a, b reflect.Value) int { return strings.Compare(a.String(), b.String()) })
+	return keys
+}
+
+// writeBlocks
+// items of arrays and maps are written in one block which is ended by an empty block.
+func (e *encoder) writeBlocks(n int, item func(i int) error) (err error) {
+	if n > 0 {
+		e.writeLong(int64(n))
+		for i := 0; i < n; i++ {
+			if err = item(i); err != nil {
+				return
+			}
+		}
+	}
+	e.writeLong(0)
+	return
+}
+
+// writeLong
+// the zig-zag variable length of int and long.
+func (e *encoder) writeLong(n int64) {
+	e.buf = binary.AppendUvarint(e.buf, uint64((n<<1)^(n>>63)))
+}
+
+func (e *encoder) writeString(s string) {
+	e.writeLong(int64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) writeBytes(b []byte) {
+	e.writeLong(int64(len(b)))
+	e.buf = append(e.buf, b...)
+}
@@ -0,0 +1,440 @@
+package avro
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brickingsoft/brick/pkg/internal/codec"
+)
+
+// logical types
+const (
+	LogicalDecimal         = "decimal"
+	LogicalUUID            = "uuid"
+	LogicalDate            = "date"
+	LogicalTimeMillis      = "time-millis"
+	LogicalTimeMicros      = "time-micros"
+	LogicalTimestampMillis = "timestamp-millis"
+	LogicalTimestampMicros = "timestamp-micros"
+	LogicalDuration        = "duration"
+)
+
+// the decimal of big.Rat values without `decimal` tags.
+const (
+	DefaultDecimalPrecision = 38
+	DefaultDecimalScale     = 9
+)
+
+// Duration
+// the avro duration, a fixed of 12 bytes which are little-endian months, days and milliseconds.
+type Duration struct {
+	Months       uint32
+	Days         uint32
+	Milliseconds uint32
+}
+
+var (
+	timeType         = reflect.TypeFor[time.Time]()
+	timeDurationType = reflect.TypeFor[time.Duration]()
+	ratType          = reflect.TypeFor[big.Rat]()
+	durationType     = reflect.TypeFor[Duration]()
+)
+
+// schemas of logical types, named ones are shared, so they are defined once in a schema.
+var (
+	dateSchema            = &Schema{Type: TypeInt, LogicalType: LogicalDate}
+	timeMillisSchema      = &Schema{Type: TypeInt, LogicalType: LogicalTimeMillis}
+	timeMicrosSchema      = &Schema{Type: TypeLong, LogicalType: LogicalTimeMicros}
+	timestampMillisSchema = &Schema{Type: TypeLong, LogicalType: LogicalTimestampMillis}
+	timestampMicrosSchema = &Schema{Type: TypeLong, LogicalType: LogicalTimestampMicros}
+	uuidStringSchema      = &Schema{Type: TypeString, LogicalType: LogicalUUID}
+	uuidFixedSchema       = &Schema{Type: TypeFixed, Name: "avro.UUID", Size: 16, LogicalType: LogicalUUID}
+	durationSchema        = &Schema{Type: TypeFixed, Name: "avro.Duration", Size: 12, LogicalType: LogicalDuration}
+	decimalSchema         = &Schema{Type: TypeBytes, LogicalType: LogicalDecimal, Precision: DefaultDecimalPrecision, Scale: DefaultDecimalScale}
+)
+
+// logicalOf
+// the schema of the logical go type, the hint is the schema of `logical` and `decimal` tags of the field.
+// time.Time is timestamp-micros, time.Duration is time-millis, big.Rat is decimal and Duration is duration by default,
+// strings and [16]byte are uuid by hints only.
+func logicalOf(typ reflect.Type, hint *Schema) *Schema {
+	name := ""
+	if hint != nil {
+		name = hint.LogicalType
+	}
+	switch typ {
+	case timeType:
+		switch name {
+		case LogicalDate:
+			return dateSchema
+		case LogicalTimestampMillis:
+			return timestampMillisSchema
+		default:
+			return timestampMicrosSchema
+		}
+	case timeDurationType:
+		if name == LogicalTimeMicros {
+			return timeMicrosSchema
+		}
+		return timeMillisSchema
+	case ratType:
+		if name == LogicalDecimal {
+			return hint
+		}
+		return decimalSchema
+	case durationType:
+		return durationSchema
+	default:
+		break
+	}
+	if name == LogicalUUID {
+		if typ.Kind() == reflect.String {
+			return uuidStringSchema
+		}
+		if typ.Kind() == reflect.Array && typ.Elem().Kind() == reflect.Uint8 && typ.Len() == 16 {
+			return uuidFixedSchema
+		}
+	}
+	return nil
+}
+
+type fieldHints struct {
+	hints []*Schema
+	err   error
+}
+
+var hintsCache sync.Map // reflect.Type -> *fieldHints
+
+// hintsOf
+// the hints of fields of the struct type in the order of codec.StructFields, e.g. `logical:"date"` or `decimal:"18,2"`.
+// a hint is applied to the first logical go type of the field through pointers, arrays, slices and maps.
+func hintsOf(typ reflect.Type) ([]*Schema, error) {
+	if cached, ok := hintsCache.Load(typ); ok {
+		h := cached.(*fieldHints)
+		return h.hints, h.err
+	}
+	fields := codec.StructFields(typ, tags...)
+	h := &fieldHints{hints: make([]*Schema, len(fields.List))}
+	for i, field := range fields.List {
+		sf := typ.FieldByIndex(field.Index)
+		hint, err := parseHint(sf)
+		if err != nil {
+			h.err = fmt.Errorf("avro: field %s of %s: %w", field.Name, typ, err)
+			break
+		}
+		if hint == nil {
+			continue
+		}
+		if !hintable(field.Type, hint) {
+			h.err = fmt.Errorf("avro: field %s of %s: %s is not applicable to %s", field.Name, typ, hint.LogicalType, field.Type)
+			break
+		}
+		h.hints[i] = hint
+	}
+	cached, _ := hintsCache.LoadOrStore(typ, h)
+	h = cached.(*fieldHints)
+	return h.hints, h.err
+}
+
+func parseHint(sf reflect.StructField) (hint *Schema, err error) {
+	if decimal, has := sf.Tag.Lookup("decimal"); has {
+		precision, scale, _ := strings.Cut(decimal, ",")
+		hint = &Schema{Type: TypeBytes, LogicalType: LogicalDecimal}
+		if hint.Precision, err = strconv.Atoi(precision); err != nil || hint.Precision <= 0 {
+			err = fmt.Errorf("invalid precision of decimal %q", decimal)
+			return
+		}
+		if scale != "" {
+			if hint.Scale, err = strconv.Atoi(scale); err != nil || hint.Scale < 0 || hint.Scale > hint.Precision {
+				err = fmt.Errorf("invalid scale of decimal %q", decimal)
+				return
+			}
+		}
+		return
+	}
+	name, has := sf.Tag.Lookup("logical")
+	if !has {
+		return
+	}
+	switch name {
+	case LogicalUUID, LogicalDate, LogicalTimeMillis, LogicalTimeMicros, LogicalTimestampMillis, LogicalTimestampMicros:
+		hint = &Schema{LogicalType: name}
+	default:
+		err = fmt.Errorf("unsupported logical type %q", name)
+	}
+	return
+}
+
+// hintable
+// whether the hint is applied to the type or its elements.
+func hintable(typ reflect.Type, hint *Schema) bool {
+	for {
+		if s := logicalOf(typ, hint); s != nil {
+			return s == hint || s.LogicalType == hint.LogicalType
+		}
+		switch typ.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+			typ = typ.Elem()
+		default:
+			return false
+		}
+	}
+}
+
+var epoch = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// logicalValue
+// convert the underlying value which is read by the schema into the go value of its logical type:
+// date and timestamps are time.Time in UTC, time-millis and time-micros are time.Duration, decimal is *big.Rat,
+// uuid is the string and duration is Duration. unknown logical types keep underlying values.
+func logicalValue(schema *Schema, underlying any) (value any) {
+	value = underlying
+	switch schema.LogicalType {
+	case LogicalDate, LogicalTimeMillis, LogicalTimeMicros, LogicalTimestampMillis, LogicalTimestampMicros:
+		n, ok := underlying.(int64)
+		if !ok {
+			return
+		}
+		switch schema.LogicalType {
+		case LogicalDate:
+			value = epoch.AddDate(0, 0, int(n))
+		case LogicalTimeMillis:
+			value = time.Duration(n) * time.Millisecond
+		case LogicalTimeMicros:
+			value = time.Duration(n) * time.Microsecond
+		case LogicalTimestampMillis:
+			value = time.UnixMilli(n).UTC()
+		default:
+			value = time.UnixMicro(n).UTC()
+		}
+	case LogicalDecimal:
+		b, ok := underlying.([]byte)
+		if !ok {
+			return
+		}
+		value = decimalRat(b, schema.Scale)
+	case LogicalUUID:
+		if b, ok := underlying.([]byte); ok && len(b) == 16 {
+			value = formatUUID([16]byte(b))
+		}
+	case LogicalDuration:
+		if b, ok := underlying.([]byte); ok && len(b) == 12 {
+			value = Duration{
+				Months:       binary.LittleEndian.Uint32(b),
+				Days:         binary.LittleEndian.Uint32(b[4:]),
+				Milliseconds: binary.LittleEndian.Uint32(b[8:]),
+			}
+		}
+	default:
+		break
+	}
+	return
+}
+
+// setLogical
+// set the value of logicalValue into v.
+func setLogical(v reflect.Value, value any) (err error) {
+	switch x := value.(type) {
+	case time.Time:
+		if v.Type() == timeType {
+			v.Set(reflect.ValueOf(x))
+			return
+		}
+	case time.Duration:
+		if v.Type() == timeDurationType {
+			v.SetInt(int64(x))
+			return
+		}
+	case *big.Rat:
+		if v.Type() == ratType {
+			v.Set(reflect.ValueOf(x).Elem())
+			return
+		}
+	case Duration:
+		if v.Type() == durationType {
+			v.Set(reflect.ValueOf(x))
+			return
+		}
+	case string:
+		if v.Kind() == reflect.String {
+			v.SetString(x)
+			return
+		}
+		if v.Kind() == reflect.Array && v.Len() == 16 {
+			id, parseErr := parseUUID(x)
+			if parseErr != nil {
+				err = parseErr
+				return
+			}
+			reflect.Copy(v, reflect.ValueOf(id[:]))
+			return
+		}
+	default:
+		break
+	}
+	if codec.IsAny(v) && value != nil {
+		v.Set(reflect.ValueOf(value))
+		return
+	}
+	err = &codec.TypeError{Value: fmt.Sprintf("%T", value), Type: v.Type()}
+	return
+}
+
+func (e *encoder) encodeLogical(v reflect.Value, schema *Schema) (err error) {
+	switch schema.LogicalType {
+	case LogicalDate:
+		y, m, d := v.Interface().(time.Time).Date()
+		e.writeLong(int64(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Sub(epoch) / (24 * time.Hour)))
+	case LogicalTimestampMillis:
+		e.writeLong(v.Interface().(time.Time).UnixMilli())
+	case LogicalTimestampMicros:
+		e.writeLong(v.Interface().(time.Time).UnixMicro())
+	case LogicalTimeMillis:
+		ms := time.Duration(v.Int()).Milliseconds()
+		if ms < math.MinInt32 || ms > math.MaxInt32 {
+			err = fmt.Errorf("avro: %s overflows time-millis", time.Duration(v.Int()))
+			return
+		}
+		e.writeLong(ms)
+	case LogicalTimeMicros:
+		e.writeLong(time.Duration(v.Int()).Microseconds())
+	case LogicalDecimal:
+		var r *big.Rat
+		if v.CanAddr() {
+			r = v.Addr().Interface().(*big.Rat)
+		} else {
+			x := v.Interface().(big.Rat)
+			r = &x
+		}
+		b, decimalErr := decimalBytes(r, schema.Precision, schema.Scale)
+		if decimalErr != nil {
+			err = decimalErr
+			return
+		}
+		e.writeBytes(b)
+	case LogicalUUID:
+		if v.Kind() == reflect.String {
+			if _, err = parseUUID(v.String()); err != nil {
+				return
+			}
+			e.writeString(v.String())
+			return
+		}
+		for i := 0; i < 16; i++ {
+			e.buf = append(e.buf, byte(v.Index(i).Uint()))
+		}
+	case LogicalDuration:
+		d := v.Interface().(Duration)
+		e.buf = binary.LittleEndian.AppendUint32(e.buf, d.Months)
+		e.buf = binary.LittleEndian.AppendUint32(e.buf, d.Days)
+		e.buf = binary.LittleEndian.AppendUint32(e.buf, d.Milliseconds)
+	default:
+		err = fmt.Errorf("avro: unsupported logical type %s", schema.LogicalType)
+	}
+	return
+}
+
+func (d *decoder) decodeLogical(v reflect.Value, schema *Schema) (err error) {
+	value, readErr := d.readGeneric(schema, 0)
+	if readErr != nil {
+		err = readErr
+		return
+	}
+	return setLogical(v, value)
+}
+
+// resolveLogical
+// the writer value is read by its logical type, or by the logical type of the reader when the writer has none.
+func (d *decoder) resolveLogical(writer *Schema, reader *Schema, v reflect.Value, depth int) (err error) {
+	if !knownLogical(writer.LogicalType) {
+		if !promotable(writer.Type, reader.Type) || writer.Size != reader.Size {
+			return incompatible(writer, reader)
+		}
+		writer = &Schema{Type: writer.Type, Size: writer.Size, LogicalType: reader.LogicalType, Precision: reader.Precision, Scale: reader.Scale}
+	}
+	value, readErr := d.readGeneric(writer, depth)
+	if readErr != nil {
+		err = readErr
+		return
+	}
+	if setErr := setLogical(v, value); setErr != nil {
+		err = errors.Join(incompatible(writer, reader), setErr)
+	}
+	return
+}
+
+// knownLogical
+// whether the logical type is supported, unknown ones are read as their underlying types.
+func knownLogical(name string) bool {
+	switch name {
+	case LogicalDecimal, LogicalUUID, LogicalDate, LogicalTimeMillis, LogicalTimeMicros,
+		LogicalTimestampMillis, LogicalTimestampMicros, LogicalDuration:
+		return true
+	default:
+		return false
+	}
+}
+
+// decimalBytes
+// the two's-complement big-endian unscaled value, the value must be exact at the scale.
+func decimalBytes(r *big.Rat, precision int, scale int) (b []byte, err error) {
+	unscaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+	if !unscaled.IsInt() {
+		err = fmt.Errorf("avro: %s is not exact at scale %d", r.RatString(), scale)
+		return
+	}
+	n := unscaled.Num()
+	if digits := len(new(big.Int).Abs(n).String()); n.Sign() != 0 && digits > precision {
+		err = fmt.Errorf("avro: %s exceeds precision %d", r.RatString(), precision)
+		return
+	}
+	if n.Sign() >= 0 {
+		b = n.Bytes()
+		if len(b) == 0 || b[0]&0x80 != 0 {
+			b = append([]byte{0}, b...)
+		}
+		return
+	}
+	// -n-1 with inverted bits is the two's complement of n.
+	b = new(big.Int).Sub(new(big.Int).Neg(n), big.NewInt(1)).Bytes()
+	for i := range b {
+		b[i] = ^b[i]
+	}
+	if len(b) == 0 || b[0]&0x80 == 0 {
+		b = append([]byte{0xff}, b...)
+	}
+	return
+}
+
+func decimalRat(b []byte, scale int) *big.Rat {
+	n := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	return new(big.Rat).SetFrac(n, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil))
+}
+
+func formatUUID(id [16]byte) string {
+	s := hex.EncodeToString(id[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+func parseUUID(s string) (id [16]byte, err error) {
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		err = fmt.Errorf("avro: invalid uuid %q", s)
+		return
+	}
+	if _, decodeErr := hex.Decode(id[:], []byte(s[:8]+s[9:13]+s[14:18]+s[19:23]+s[24:])); decodeErr != nil {
+		err = fmt.Errorf("avro: invalid uuid %q", s)
+	}
+	return
+}
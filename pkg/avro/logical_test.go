@@ -0,0 +1,177 @@
+package avro_test
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/brickingsoft/brick/pkg/avro"
+)
+
+type Invoice struct {
+	Amount  big.Rat       `avro:"amount" decimal:"18,2"`
+	Tax     *big.Rat      `avro:"tax"`
+	Created time.Time     `avro:"created"`
+	Due     time.Time     `avro:"due" logical:"date"`
+	Paid    *time.Time    `avro:"paid" logical:"timestamp-millis"`
+	Elapsed time.Duration `avro:"elapsed"`
+	Precise time.Duration `avro:"precise" logical:"time-micros"`
+	ID      [16]byte      `avro:"id" logical:"uuid"`
+	Ref     string        `avro:"ref" logical:"uuid"`
+	Period  avro.Duration `avro:"period"`
+	Days    []time.Time   `avro:"days" logical:"date"`
+}
+
+func TestLogical(t *testing.T) {
+	paid := time.Date(2026, 3, 1, 8, 30, 0, 123000000, time.UTC)
+	in := Invoice{
+		Tax:     big.NewRat(1, 8),
+		Created: time.Date(2026, 2, 27, 10, 0, 0, 123456000, time.UTC),
+		Due:     time.Date(2026, 3, 31, 0, 0, 0, 0, time.UTC),
+		Paid:    &paid,
+		Elapsed: 1500 * time.Millisecond,
+		Precise: 1500 * time.Microsecond,
+		ID:      [16]byte{0x12, 0x3e, 0x45, 0x67, 0xe8, 0x9b, 0x12, 0xd3, 0xa4, 0x56, 0x42, 0x66, 0x14, 0x17, 0x40, 0x00},
+		Ref:     "123e4567-e89b-12d3-a456-426614174000",
+		Period:  avro.Duration{Months: 1, Days: 2, Milliseconds: 3},
+		Days:    []time.Time{time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC)},
+	}
+	in.Amount.SetString("-1234.56")
+	b, err := avro.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := Invoice{}
+	if err = avro.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Amount.Cmp(&in.Amount) != 0 || out.Tax.Cmp(in.Tax) != 0 {
+		t.Fatal("unexpected decimals", out.Amount.RatString(), out.Tax)
+	}
+	out.Amount, out.Tax, in.Amount, in.Tax = big.Rat{}, nil, big.Rat{}, nil
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("unexpected invoice\n%+v\n%+v", in, out)
+	}
+
+	schema, err := avro.SchemaOf(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"type":"record","name":"avro_test.Invoice","fields":[` +
+		`{"name":"amount","type":{"type":"bytes","logicalType":"decimal","precision":18,"scale":2}},` +
+		`{"name":"tax","type":["null",{"type":"bytes","logicalType":"decimal","precision":38,"scale":9}],"default":null},` +
+		`{"name":"created","type":{"type":"long","logicalType":"timestamp-micros"}},` +
+		`{"name":"due","type":{"type":"int","logicalType":"date"}},` +
+		`{"name":"paid","type":["null",{"type":"long","logicalType":"timestamp-millis"}],"default":null},` +
+		`{"name":"elapsed","type":{"type":"int","logicalType":"time-millis"}},` +
+		`{"name":"precise","type":{"type":"long","logicalType":"time-micros"}},` +
+		`{"name":"id","type":{"type":"fixed","name":"avro.UUID","size":16,"logicalType":"uuid"}},` +
+		`{"name":"ref","type":{"type":"string","logicalType":"uuid"}},` +
+		`{"name":"period","type":{"type":"fixed","name":"avro.Duration","size":12,"logicalType":"duration"}},` +
+		`{"name":"days","type":{"type":"array","items":{"type":"int","logicalType":"date"}}}]}`
+	if schema.String() != expected {
+		t.Fatal("unexpected schema", schema)
+	}
+	parsed, err := avro.ParseSchema(expected)
+	if err != nil || parsed.String() != expected {
+		t.Fatal("unexpected parsed schema", parsed, err)
+	}
+
+	// values of logical types are read into interfaces by the writer schema.
+	var value any
+	if err = avro.UnmarshalWithSchema(b, parsed, &value); err != nil {
+		t.Fatal(err)
+	}
+	generic := value.(map[string]any)
+	if generic["created"] != in.Created || generic["ref"] != in.Ref || generic["id"] != in.Ref || generic["period"] != in.Period ||
+		generic["amount"].(*big.Rat).RatString() != "-30864/25" || generic["elapsed"] != in.Elapsed {
+		t.Fatal("unexpected generic invoice", generic)
+	}
+}
+
+func TestLogical_Format(t *testing.T) {
+	type Decimal struct {
+		V big.Rat `decimal:"4,2"`
+	}
+	cases := []struct {
+		v   string
+		hex string
+	}{
+		{"0", "0200"},
+		{"1.23", "027b"},
+		{"-1.28", "0280"},
+		{"-1.29", "04ff7f"},
+		{"1.28", "040080"},
+	}
+	for _, c := range cases {
+		v := Decimal{}
+		v.V.SetString(c.v)
+		b, err := avro.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(b) != c.hex {
+			t.Fatal("unexpected decimal of", c.v, hex.EncodeToString(b))
+		}
+	}
+	b, err := avro.Marshal(struct {
+		D time.Time `logical:"date"`
+	}{D: time.Date(1970, 1, 2, 23, 0, 0, 0, time.FixedZone("", -3600))})
+	if err != nil || hex.EncodeToString(b) != "02" {
+		t.Fatal("unexpected date", hex.EncodeToString(b), err)
+	}
+
+	invalid := []any{
+		func() any { v := Decimal{}; v.V.SetString("1.234"); return v }(),
+		func() any { v := Decimal{}; v.V.SetString("100"); return v }(),
+		struct {
+			S string `logical:"uuid"`
+		}{S: "x"},
+		struct {
+			N int `logical:"date"`
+		}{},
+		struct {
+			D time.Duration
+		}{D: 1000 * time.Hour},
+	}
+	for _, v := range invalid {
+		if _, err = avro.Marshal(v); err == nil {
+			t.Fatal("expected invalid logical value", v)
+		}
+	}
+}
+
+func TestLogical_Resolution(t *testing.T) {
+	type Reader struct {
+		At     time.Time `avro:"at"`
+		Since  time.Time `avro:"since"`
+		Amount big.Rat   `avro:"amount" decimal:"10,3"`
+		Ref    [16]byte  `avro:"ref" logical:"uuid"`
+		Due    time.Time `avro:"due" logical:"date" default:"1"`
+	}
+	writer, err := avro.ParseSchema(`{"type":"record","name":"Writer","fields":[
+		{"name":"at","type":{"type":"long","logicalType":"timestamp-millis"}},
+		{"name":"since","type":"long"},
+		{"name":"amount","type":{"type":"fixed","name":"D","size":2,"logicalType":"decimal","precision":4,"scale":1}},
+		{"name":"ref","type":{"type":"string","logicalType":"uuid"}}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1000ms, 2µs, -12.8, uuid
+	b, _ := hex.DecodeString("d00f" + "04" + "ff80" + "48" + hex.EncodeToString([]byte("123e4567-e89b-12d3-a456-426614174000")))
+	out := Reader{}
+	if err = avro.UnmarshalWithSchema(b, writer, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !out.At.Equal(time.Unix(1, 0)) || !out.Since.Equal(time.UnixMicro(2)) || out.Amount.RatString() != "-64/5" ||
+		out.Ref[0] != 0x12 || !out.Due.Equal(time.Unix(86400, 0)) {
+		t.Fatal("unexpected reader", out)
+	}
+	text, _ := avro.ParseSchema(`{"type":"record","name":"Writer","fields":[{"name":"at","type":"string"}]}`)
+	if err = avro.UnmarshalWithSchema([]byte{0x00}, text, &out); !errors.Is(err, avro.ErrIncompatibleSchema) {
+		t.Fatal("expected incompatible time", err)
+	}
+}
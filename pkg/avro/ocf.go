@@ -0,0 +1,595 @@
+package avro
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// codecs of blocks of object container files
+const (
+	CodecNull      = "null"
+	CodecDeflate   = "deflate"
+	CodecSnappy    = "snappy"
+	CodecZstandard = "zstandard"
+)
+
+const (
+	// DefaultBlockSize
+	// blocks are flushed when their encoded records are not smaller than it.
+	DefaultBlockSize = 64 << 10
+	// DefaultMaxBlockSize
+	// the max size of a block which is read, compressed or not, it stops compression bombs.
+	DefaultMaxBlockSize = 64 << 20
+)
+
+const (
+	schemaMetadataKey = "avro.schema"
+	codecMetadataKey  = "avro.codec"
+	syncSize          = 16
+)
+
+var magic = []byte{'O', 'b', 'j', 1}
+
+var (
+	ErrInvalidMagic     = errors.New("avro: not an object container file")
+	ErrInvalidSync      = errors.New("avro: invalid sync marker")
+	ErrUnsupportedCodec = errors.New("avro: unsupported codec")
+	ErrBlockTooLarge    = errors.New("avro: block is too large")
+	ErrSchemaMismatch   = errors.New("avro: schema of the value does not match the schema of the file")
+)
+
+type blockCodec struct {
+	encode func(src []byte) ([]byte, error)
+	decode func(src []byte, limit int) ([]byte, error)
+}
+
+var (
+	zstdEncoder = sync.OnceValue(func() *zstd.Encoder {
+		encoder, _ := zstd.NewWriter(nil)
+		return encoder
+	})
+	blockCodecs = map[string]blockCodec{
+		CodecNull: {
+			encode: func(src []byte) ([]byte, error) {
+				return src, nil
+			},
+			decode: func(src []byte, limit int) ([]byte, error) {
+				return src, nil
+			},
+		},
+		CodecDeflate: {
+			// raw deflate without zlib headers.
+			encode: func(src []byte) ([]byte, error) {
+				buf := new(bytes.Buffer)
+				w, err := flate.NewWriter(buf, flate.DefaultCompression)
+				if err != nil {
+					return nil, err
+				}
+				if _, err = w.Write(src); err != nil {
+					return nil, err
+				}
+				if err = w.Close(); err != nil {
+					return nil, err
+				}
+				return buf.Bytes(), nil
+			},
+			decode: func(src []byte, limit int) ([]byte, error) {
+				r := flate.NewReader(bytes.NewReader(src))
+				defer r.Close()
+				plain, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+				if err != nil {
+					return nil, err
+				}
+				if len(plain) > limit {
+					return nil, ErrBlockTooLarge
+				}
+				return plain, nil
+			},
+		},
+		CodecSnappy: {
+			// the snappy block is followed by the big-endian crc32 of the uncompressed data.
+			encode: func(src []byte) ([]byte, error) {
+				dst := snappy.Encode(nil, src)
+				return binary.BigEndian.AppendUint32(dst, crc32.ChecksumIEEE(src)), nil
+			},
+			decode: func(src []byte, limit int) ([]byte, error) {
+				if len(src) < 4 {
+					return nil, ErrShortBuffer
+				}
+				block, checksum := src[:len(src)-4], binary.BigEndian.Uint32(src[len(src)-4:])
+				n, err := snappy.DecodedLen(block)
+				if err != nil {
+					return nil, err
+				}
+				if n > limit {
+					return nil, ErrBlockTooLarge
+				}
+				plain, err := snappy.Decode(nil, block)
+				if err != nil {
+					return nil, err
+				}
+				if crc32.ChecksumIEEE(plain) != checksum {
+					return nil, errors.New("avro: invalid checksum of snappy block")
+				}
+				return plain, nil
+			},
+		},
+		CodecZstandard: {
+			encode: func(src []byte) ([]byte, error) {
+				return zstdEncoder().EncodeAll(src, nil), nil
+			},
+			decode: func(src []byte, limit int) ([]byte, error) {
+				// the block is decoded as a stream, and its window is not larger than the limit, so a bomb is stopped at the limit.
+				r, err := zstd.NewReader(bytes.NewReader(src), zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(uint64(max(limit, zstd.MinWindowSize))))
+				if err != nil {
+					return nil, err
+				}
+				defer r.Close()
+				plain, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+				if errors.Is(err, zstd.ErrWindowSizeExceeded) || errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+					return nil, ErrBlockTooLarge
+				}
+				if err != nil {
+					return nil, err
+				}
+				if len(plain) > limit {
+					return nil, ErrBlockTooLarge
+				}
+				return plain, nil
+			},
+		},
+	}
+)
+
+// WriterOptions
+// the options of object container file writers.
+type WriterOptions struct {
+	codec     string
+	blockSize int
+	metadata  map[string][]byte
+}
+
+type WriterOption func(*WriterOptions) error
+
+// Codec
+// the codec of blocks, it is null, deflate, snappy or zstandard, the default is null.
+func Codec(name string) WriterOption {
+	return func(o *WriterOptions) error {
+		if _, ok := blockCodecs[name]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnsupportedCodec, name)
+		}
+		o.codec = name
+		return nil
+	}
+}
+
+// BlockSize
+// blocks are flushed when their encoded records are not smaller than n bytes, the default is 64KB.
+func BlockSize(n int) WriterOption {
+	return func(o *WriterOptions) error {
+		if n < 1 {
+			n = DefaultBlockSize
+		}
+		o.blockSize = n
+		return nil
+	}
+}
+
+// Metadata
+// the metadata of the header, keys which start with `avro.` are reserved.
+func Metadata(key string, value []byte) WriterOption {
+	return func(o *WriterOptions) error {
+		if strings.HasPrefix(key, "avro.") {
+			return fmt.Errorf("avro: metadata key %s is reserved", key)
+		}
+		o.metadata[key] = value
+		return nil
+	}
+}
+
+// Writer
+// the writer of an object container file, records are buffered in blocks which are written with sync markers.
+// it is safe for concurrent use, and Close must be called to flush the last block, it does not close the underlying writer.
+type Writer struct {
+	locker    sync.Mutex
+	w         io.Writer
+	schema    *Schema
+	json      string
+	typ       reflect.Type
+	codec     blockCodec
+	blockSize int
+	sync      [syncSize]byte
+	block     encoder
+	count     int64
+	err       error
+}
+
+// NewWriter
+// write the header of the schema into w, appended values must be of the schema, e.g. the schema is SchemaOf(v).
+func NewWriter(w io.Writer, schema *Schema, options ...WriterOption) (writer *Writer, err error) {
+	if schema == nil {
+		err = errors.New("avro: schema of writer is nil")
+		return
+	}
+	opts := WriterOptions{codec: CodecNull, blockSize: DefaultBlockSize, metadata: make(map[string][]byte)}
+	for _, option := range options {
+		if err = option(&opts); err != nil {
+			err = errors.Join(errors.New("avro: new writer failed"), err)
+			return
+		}
+	}
+	writer = &Writer{
+		w:         w,
+		schema:    schema,
+		json:      schema.String(),
+		codec:     blockCodecs[opts.codec],
+		blockSize: opts.blockSize,
+	}
+	if _, err = rand.Read(writer.sync[:]); err != nil {
+		err = errors.Join(errors.New("avro: new writer failed"), err)
+		return
+	}
+	opts.metadata[schemaMetadataKey] = []byte(writer.json)
+	opts.metadata[codecMetadataKey] = []byte(opts.codec)
+	header := &encoder{buf: append([]byte(nil), magic...)}
+	keys := slices.Sorted(maps.Keys(opts.metadata))
+	_ = header.writeBlocks(len(keys), func(i int) error {
+		header.writeString(keys[i])
+		header.writeBytes(opts.metadata[keys[i]])
+		return nil
+	})
+	header.buf = append(header.buf, writer.sync[:]...)
+	if _, err = w.Write(header.buf); err != nil {
+		err = errors.Join(errors.New("avro: write header failed"), err)
+		writer = nil
+	}
+	return
+}
+
+// Schema
+// the schema of the file.
+func (w *Writer) Schema() *Schema {
+	return w.schema
+}
+
+// Append
+// encode the value into the current block, the block is flushed when it is full.
+func (w *Writer) Append(v any) (err error) {
+	w.locker.Lock()
+	defer w.locker.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	rv := rootValue(reflect.ValueOf(v))
+	if !rv.IsValid() {
+		return errors.New("avro: append nil")
+	}
+	if rv.Type() != w.typ {
+		// the schema of the go type is checked once.
+		schema, schemaErr := SchemaOfType(rv.Type())
+		if schemaErr != nil {
+			return schemaErr
+		}
+		if schema != w.schema && schema.String() != w.json {
+			return fmt.Errorf("%w: %s", ErrSchemaMismatch, rv.Type())
+		}
+		w.typ = rv.Type()
+	}
+	mark := len(w.block.buf)
+	if err = w.block.encode(rv, nil, 0); err != nil {
+		// the partial record is dropped.
+		w.block.buf = w.block.buf[:mark]
+		return
+	}
+	w.count++
+	if len(w.block.buf) >= w.blockSize {
+		err = w.flush()
+	}
+	return
+}
+
+// Flush
+// write the current block.
+func (w *Writer) Flush() error {
+	w.locker.Lock()
+	defer w.locker.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	return w.flush()
+}
+
+func (w *Writer) flush() (err error) {
+	if w.count == 0 {
+		return
+	}
+	data, encodeErr := w.codec.encode(w.block.buf)
+	if encodeErr != nil {
+		err = errors.Join(errors.New("avro: compress block failed"), encodeErr)
+		return
+	}
+	head := &encoder{buf: make([]byte, 0, 2*binary.MaxVarintLen64)}
+	head.writeLong(w.count)
+	head.writeLong(int64(len(data)))
+	for _, b := range [][]byte{head.buf, data, w.sync[:]} {
+		if _, err = w.w.Write(b); err != nil {
+			// the file is broken when a block is partially written.
+			w.err = errors.Join(errors.New("avro: write block failed"), err)
+			err = w.err
+			return
+		}
+	}
+	w.block.buf = w.block.buf[:0]
+	w.count = 0
+	return
+}
+
+// Close
+// flush the last block, the writer can not be used after it.
+func (w *Writer) Close() (err error) {
+	w.locker.Lock()
+	defer w.locker.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	err = w.flush()
+	if w.err == nil {
+		w.err = errors.New("avro: writer is closed")
+	}
+	return
+}
+
+// ReaderOptions
+// the options of object container file readers.
+type ReaderOptions struct {
+	maxBlockSize int
+}
+
+type ReaderOption func(*ReaderOptions) error
+
+// MaxBlockSize
+// the max size of a block which is read, compressed or not, the default is 64MB.
+func MaxBlockSize(n int) ReaderOption {
+	return func(o *ReaderOptions) error {
+		if n < 1 {
+			n = DefaultMaxBlockSize
+		}
+		o.maxBlockSize = n
+		return nil
+	}
+}
+
+// Reader
+// the reader of an object container file, records are iterated by Next and decoded by Decode, e.g.
+//
+//	for reader.Next() {
+//		if err := reader.Decode(&v); err != nil { ... }
+//	}
+//	if err := reader.Err(); err != nil { ... }
+//
+// records which are not decoded are skipped, and values are decoded by the schema resolution (see UnmarshalWithSchema).
+type Reader struct {
+	src          *decoder
+	schema       *Schema
+	codec        string
+	metadata     map[string][]byte
+	sync         [syncSize]byte
+	maxBlockSize int
+	block        *sliceSource
+	remaining    int64
+	pending      bool
+	err          error
+}
+
+// NewReader
+// read the header of the file from r, r is buffered when it is not an io.ByteReader.
+func NewReader(r io.Reader, options ...ReaderOption) (reader *Reader, err error) {
+	opts := ReaderOptions{maxBlockSize: DefaultMaxBlockSize}
+	for _, option := range options {
+		if err = option(&opts); err != nil {
+			err = errors.Join(errors.New("avro: new reader failed"), err)
+			return
+		}
+	}
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		buffered := bufio.NewReader(r)
+		r, br = buffered, buffered
+	}
+	reader = &Reader{
+		src:          &decoder{src: &streamSource{r: r, br: br}},
+		metadata:     make(map[string][]byte),
+		maxBlockSize: opts.maxBlockSize,
+	}
+	if err = reader.readHeader(); err != nil {
+		err = errors.Join(errors.New("avro: read header failed"), err)
+		reader = nil
+	}
+	return
+}
+
+func (r *Reader) readHeader() (err error) {
+	head, readErr := r.src.src.next(len(magic))
+	if readErr != nil || !bytes.Equal(head, magic) {
+		return ErrInvalidMagic
+	}
+	size := 0
+	err = r.src.readBlocks(func() error {
+		key, keyErr := r.src.readBytes()
+		if keyErr != nil {
+			return keyErr
+		}
+		name := string(key)
+		value, valueErr := r.src.readBytes()
+		if valueErr != nil {
+			return valueErr
+		}
+		if size += len(name) + len(value); size > r.maxBlockSize {
+			return ErrBlockTooLarge
+		}
+		r.metadata[name] = append([]byte(nil), value...)
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	sync, syncErr := r.src.src.next(syncSize)
+	if syncErr != nil {
+		return syncErr
+	}
+	copy(r.sync[:], sync)
+	if r.schema, err = ParseSchema(string(r.metadata[schemaMetadataKey])); err != nil {
+		return
+	}
+	r.codec = CodecNull
+	if codec, has := r.metadata[codecMetadataKey]; has && len(codec) > 0 {
+		r.codec = string(codec)
+	}
+	if _, ok := blockCodecs[r.codec]; !ok {
+		err = fmt.Errorf("%w: %s", ErrUnsupportedCodec, r.codec)
+	}
+	return
+}
+
+// Schema
+// the writer schema of the file.
+func (r *Reader) Schema() *Schema {
+	return r.schema
+}
+
+// Codec
+// the codec of blocks.
+func (r *Reader) Codec() string {
+	return r.codec
+}
+
+// Metadata
+// the metadata of the header, including `avro.schema` and `avro.codec`.
+func (r *Reader) Metadata() map[string][]byte {
+	return r.metadata
+}
+
+// Next
+// move to the next record, it is false at the end of the file or on errors (see Err).
+func (r *Reader) Next() bool {
+	if r.err != nil {
+		return false
+	}
+	if r.pending {
+		// the record which is not decoded is skipped.
+		r.pending = false
+		if r.err = (&decoder{src: r.block}).skip(r.schema, 0); r.err != nil {
+			return false
+		}
+	}
+	for r.remaining == 0 {
+		if r.block != nil && r.block.pos != len(r.block.data) {
+			r.err = fmt.Errorf("%w in the block", ErrTrailingData)
+			return false
+		}
+		var ok bool
+		if ok, r.err = r.readBlock(); !ok {
+			return false
+		}
+	}
+	r.remaining--
+	r.pending = true
+	return true
+}
+
+// readBlock
+// ok is false at the end of the file.
+func (r *Reader) readBlock() (ok bool, err error) {
+	count, countErr := r.src.readLong()
+	if countErr != nil {
+		if errors.Is(countErr, io.EOF) {
+			return
+		}
+		err = countErr
+		return
+	}
+	size, sizeErr := r.src.readLong()
+	if sizeErr != nil {
+		err = sizeErr
+		return
+	}
+	if count < 0 || count > MaxItems || size < 0 {
+		err = fmt.Errorf("avro: invalid block of %d records in %d bytes", count, size)
+		return
+	}
+	if size > int64(r.maxBlockSize) {
+		err = ErrBlockTooLarge
+		return
+	}
+	data, dataErr := r.src.src.next(int(size))
+	if dataErr != nil {
+		err = dataErr
+		return
+	}
+	if r.codec == CodecNull {
+		// the buffer of the source is reused by the next block.
+		data = append([]byte(nil), data...)
+	} else if data, err = blockCodecs[r.codec].decode(data, r.maxBlockSize); err != nil {
+		err = errors.Join(errors.New("avro: decompress block failed"), err)
+		return
+	}
+	sync, syncErr := r.src.src.next(syncSize)
+	if syncErr != nil {
+		err = syncErr
+		return
+	}
+	if !bytes.Equal(sync, r.sync[:]) {
+		err = ErrInvalidSync
+		return
+	}
+	r.block = &sliceSource{data: data}
+	r.remaining = count
+	ok = true
+	return
+}
+
+// Decode
+// decode the current record into v.
+func (r *Reader) Decode(v any) (err error) {
+	if !r.pending {
+		return errors.New("avro: Decode is called without Next")
+	}
+	r.pending = false
+	rv, reader, targetErr := resolveTarget(r.schema, v)
+	if targetErr != nil {
+		// the record is skipped, so the next one can be read.
+		if r.err = (&decoder{src: r.block}).skip(r.schema, 0); r.err != nil {
+			return r.err
+		}
+		return targetErr
+	}
+	if err = (&decoder{src: r.block}).resolve(r.schema, reader, rv, 0); err != nil {
+		// the position in the block is unknown.
+		r.err = err
+	}
+	return
+}
+
+// Err
+// the error which stops Next, it is nil at the end of the file.
+func (r *Reader) Err() error {
+	if errors.Is(r.err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return r.err
+}
@@ -0,0 +1,183 @@
+package avro_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/brickingsoft/brick/pkg/avro"
+)
+
+type Event struct {
+	ID   int64     `avro:"id"`
+	Name string    `avro:"name"`
+	At   time.Time `avro:"at"`
+	Tags []string  `avro:"tags"`
+}
+
+func writeEvents(t *testing.T, n int, options ...avro.WriterOption) (events []Event, file []byte) {
+	t.Helper()
+	schema, err := avro.SchemaOf(Event{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	w, err := avro.NewWriter(buf, schema, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		event := Event{ID: int64(i), Name: fmt.Sprintf("event-%d", i), At: time.UnixMicro(int64(i) * 1000).UTC(), Tags: []string{"a", "b"}}
+		if err = w.Append(&event); err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, event)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Append(events[0]); err == nil {
+		t.Fatal("expected closed writer")
+	}
+	file = buf.Bytes()
+	return
+}
+
+func TestOCF(t *testing.T) {
+	for _, codec := range []string{avro.CodecNull, avro.CodecDeflate, avro.CodecSnappy, avro.CodecZstandard} {
+		t.Run(codec, func(t *testing.T) {
+			events, file := writeEvents(t, 100, avro.Codec(codec), avro.BlockSize(256), avro.Metadata("source", []byte("test")))
+			if !bytes.HasPrefix(file, []byte("Obj\x01")) {
+				t.Fatal("unexpected magic", file[:4])
+			}
+			r, err := avro.NewReader(bytes.NewReader(file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			schema, _ := avro.SchemaOf(Event{})
+			if r.Codec() != codec || string(r.Metadata()["source"]) != "test" || r.Schema().String() != schema.String() {
+				t.Fatal("unexpected header", r.Codec(), r.Metadata())
+			}
+			var decoded []Event
+			for r.Next() {
+				event := Event{}
+				if err = r.Decode(&event); err != nil {
+					t.Fatal(err)
+				}
+				decoded = append(decoded, event)
+			}
+			if err = r.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(events, decoded) {
+				t.Fatal("unexpected events", len(decoded))
+			}
+		})
+	}
+}
+
+func TestOCF_Iteration(t *testing.T) {
+	_, file := writeEvents(t, 10, avro.BlockSize(64))
+	// records which are not decoded are skipped, and records are resolved into evolved types.
+	type Summary struct {
+		ID    int64  `avro:"id"`
+		Level string `avro:"level" default:"info"`
+	}
+	r, err := avro.NewReader(io.MultiReader(bytes.NewReader(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	for i := 0; r.Next(); i++ {
+		if i%3 != 0 {
+			continue
+		}
+		summary := Summary{}
+		if err = r.Decode(&summary); err != nil {
+			t.Fatal(err)
+		}
+		if summary.Level != "info" {
+			t.Fatal("unexpected default", summary)
+		}
+		ids = append(ids, summary.ID)
+	}
+	if err = r.Err(); err != nil || !reflect.DeepEqual(ids, []int64{0, 3, 6, 9}) {
+		t.Fatal("unexpected ids", ids, err)
+	}
+	if err = r.Decode(&Summary{}); err == nil {
+		t.Fatal("expected decode without next")
+	}
+}
+
+func TestOCF_Invalid(t *testing.T) {
+	_, file := writeEvents(t, 10, avro.Codec(avro.CodecSnappy), avro.BlockSize(64))
+	if _, err := avro.NewReader(bytes.NewReader([]byte("Obj\x02"))); !errors.Is(err, avro.ErrInvalidMagic) {
+		t.Fatal("expected invalid magic", err)
+	}
+
+	corrupted := bytes.Clone(file)
+	corrupted[len(corrupted)-1] ^= 0xff
+	r, err := avro.NewReader(bytes.NewReader(corrupted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for r.Next() {
+	}
+	if !errors.Is(r.Err(), avro.ErrInvalidSync) {
+		t.Fatal("expected invalid sync", r.Err())
+	}
+
+	if r, err = avro.NewReader(bytes.NewReader(file[:len(file)-5])); err != nil {
+		t.Fatal(err)
+	}
+	for r.Next() {
+	}
+	if !errors.Is(r.Err(), io.ErrUnexpectedEOF) {
+		t.Fatal("expected unexpected eof", r.Err())
+	}
+
+	if r, err = avro.NewReader(bytes.NewReader(file), avro.MaxBlockSize(8)); err == nil {
+		for r.Next() {
+		}
+		err = r.Err()
+	}
+	if !errors.Is(err, avro.ErrBlockTooLarge) {
+		t.Fatal("expected block too large", err)
+	}
+
+	// the compressed block is under the limit, but the decompressed one is not.
+	for _, codec := range []string{avro.CodecDeflate, avro.CodecZstandard} {
+		const limit = 64 << 10
+		_, bomb := writeEvents(t, 8000, avro.Codec(codec), avro.BlockSize(1<<20))
+		if len(bomb) >= limit {
+			t.Fatal("expected compressed file under the limit", codec, len(bomb))
+		}
+		if r, err = avro.NewReader(bytes.NewReader(bomb), avro.MaxBlockSize(limit)); err == nil {
+			for r.Next() {
+			}
+			err = r.Err()
+		}
+		if !errors.Is(err, avro.ErrBlockTooLarge) {
+			t.Fatal("expected block too large", codec, err)
+		}
+	}
+
+	schema, _ := avro.SchemaOf(Event{})
+	if _, err = avro.NewWriter(io.Discard, schema, avro.Codec("lz4")); !errors.Is(err, avro.ErrUnsupportedCodec) {
+		t.Fatal("expected unsupported codec", err)
+	}
+	if _, err = avro.NewWriter(io.Discard, schema, avro.Metadata("avro.codec", nil)); err == nil {
+		t.Fatal("expected reserved metadata")
+	}
+	w, err := avro.NewWriter(io.Discard, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Append("event"); !errors.Is(err, avro.ErrSchemaMismatch) {
+		t.Fatal("expected schema mismatch", err)
+	}
+}
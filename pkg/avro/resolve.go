@@ -0,0 +1,507 @@
+package avro
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"slices"
+
+	"github.com/brickingsoft/brick/pkg/internal/codec"
+)
+
+// UnmarshalWithSchema
+// decode the value which is encoded by the writer schema into v by the avro schema resolution,
+// the reader schema is the schema of v (see SchemaOf), so writers and readers can evolve separately:
+// fields are matched by names and aliases of the reader, fields which are missing in the reader are skipped,
+// fields which are missing in the writer take their defaults, int, long and float are promoted to wider numbers,
+// string and bytes are interchangeable, union branches are matched by types and names,
+// and enum symbols are matched by names. names of records, enums and fixed are only checked to match union branches.
+func UnmarshalWithSchema(b []byte, writer *Schema, v any) (err error) {
+	if v == nil || len(b) == 0 {
+		return
+	}
+	rv, reader, targetErr := resolveTarget(writer, v)
+	if targetErr != nil {
+		err = targetErr
+		return
+	}
+	src := &sliceSource{data: b}
+	d := &decoder{src: src}
+	if err = d.resolve(writer, reader, rv, 0); err != nil {
+		return
+	}
+	if src.pos != len(src.data) {
+		err = ErrTrailingData
+	}
+	return
+}
+
+// DecodeFromWithSchema
+// decode one value which is encoded by the writer schema from r, see UnmarshalWithSchema and DecodeFrom.
+func DecodeFromWithSchema(r io.Reader, writer *Schema, v any) (err error) {
+	rv, reader, targetErr := resolveTarget(writer, v)
+	if targetErr != nil {
+		err = targetErr
+		return
+	}
+	src := &streamSource{r: r}
+	if br, ok := r.(io.ByteReader); ok {
+		src.br = br
+	}
+	d := &decoder{src: src}
+	if err = d.resolve(writer, reader, rv, 0); err != nil {
+		if errors.Is(err, io.EOF) && src.n > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+	return
+}
+
+func resolveTarget(writer *Schema, v any) (rv reflect.Value, reader *Schema, err error) {
+	if writer == nil {
+		err = errors.New("avro: writer schema is nil")
+		return
+	}
+	if rv, err = targetValue(v); err != nil {
+		return
+	}
+	reader, err = SchemaOfType(rv.Type())
+	return
+}
+
+// ErrIncompatibleSchema
+// the writer schema can not be resolved by the reader schema.
+var ErrIncompatibleSchema = errors.New("avro: incompatible schema")
+
+func incompatible(writer *Schema, reader *Schema) error {
+	return fmt.Errorf("%w: %s can not be read as %s", ErrIncompatibleSchema, describe(writer), describe(reader))
+}
+
+func describe(schema *Schema) string {
+	if schema.Name != "" {
+		return schema.Type + " " + schema.Name
+	}
+	return schema.Type
+}
+
+func (d *decoder) resolve(writer *Schema, reader *Schema, v reflect.Value, depth int) (err error) {
+	if depth > MaxDepth {
+		err = ErrMaxDepth
+		return
+	}
+	if writer.Type == TypeUnion {
+		index, branchErr := d.readBranch(len(writer.Branches))
+		if branchErr != nil {
+			err = branchErr
+			return
+		}
+		writer = writer.Branches[index]
+	}
+	if reader.Type == TypeUnion {
+		return d.resolveUnion(writer, reader, v, depth)
+	}
+	if reader.Type == TypeRecord && reader.Name == AnyName && v.Kind() == reflect.Interface {
+		return d.resolveAny(writer, v, depth)
+	}
+	if reader.LogicalType != "" {
+		return d.resolveLogical(writer, reader, v, depth)
+	}
+	switch reader.Type {
+	case TypeBoolean:
+		if writer.Type != TypeBoolean {
+			return incompatible(writer, reader)
+		}
+		b, readErr := d.src.ReadByte()
+		if readErr != nil {
+			err = readErr
+			return
+		}
+		v.SetBool(b != 0)
+	case TypeInt, TypeLong, TypeFloat, TypeDouble:
+		if !promotable(writer.Type, reader.Type) {
+			return incompatible(writer, reader)
+		}
+		return d.resolveNumber(writer, v)
+	case TypeString, TypeBytes:
+		if writer.Type != TypeString && writer.Type != TypeBytes {
+			return incompatible(writer, reader)
+		}
+		b, readErr := d.readBytes()
+		if readErr != nil {
+			err = readErr
+			return
+		}
+		if v.CanAddr() && v.Addr().Type().Implements(unmarshalerType) {
+			return v.Addr().Interface().(Unmarshaler).UnmarshalAVRO(append([]byte(nil), b...))
+		}
+		if v.Kind() == reflect.String {
+			v.SetString(string(b))
+			return
+		}
+		v.SetBytes(append([]byte(nil), b...))
+	case TypeFixed:
+		if writer.Type != TypeFixed || writer.Size != reader.Size {
+			return incompatible(writer, reader)
+		}
+		b, readErr := d.src.next(writer.Size)
+		if readErr != nil {
+			err = readErr
+			return
+		}
+		reflect.Copy(v, reflect.ValueOf(b))
+	case TypeEnum:
+		if writer.Type != TypeEnum {
+			return incompatible(writer, reader)
+		}
+		return d.resolveEnum(writer, reader, v)
+	case TypeArray:
+		if writer.Type != TypeArray {
+			return incompatible(writer, reader)
+		}
+		return d.resolveArray(writer, reader, v, depth)
+	case TypeMap:
+		if writer.Type != TypeMap {
+			return incompatible(writer, reader)
+		}
+		return d.resolveMap(writer, reader, v, depth)
+	case TypeRecord:
+		if writer.Type != TypeRecord {
+			return incompatible(writer, reader)
+		}
+		return d.resolveRecord(writer, reader, v, depth)
+	default:
+		return incompatible(writer, reader)
+	}
+	return
+}
+
+// promotable
+// whether the writer type can be read as the reader type.
+func promotable(writer string, reader string) bool {
+	if writer == reader {
+		return true
+	}
+	switch writer {
+	case TypeInt:
+		return reader == TypeLong || reader == TypeFloat || reader == TypeDouble
+	case TypeLong:
+		return reader == TypeFloat || reader == TypeDouble
+	case TypeFloat:
+		return reader == TypeDouble
+	case TypeString:
+		return reader == TypeBytes
+	case TypeBytes:
+		return reader == TypeString
+	default:
+		return false
+	}
+}
+
+func (d *decoder) resolveNumber(writer *Schema, v reflect.Value) (err error) {
+	switch writer.Type {
+	case TypeInt, TypeLong:
+		n, readErr := d.readLong()
+		if readErr != nil {
+			err = readErr
+			return
+		}
+		return codec.SetInt(v, n)
+	case TypeFloat:
+		b, readErr := d.src.next(4)
+		if readErr != nil {
+			err = readErr
+			return
+		}
+		v.SetFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))))
+	default:
+		b, readErr := d.src.next(8)
+		if readErr != nil {
+			err = readErr
+			return
+		}
+		v.SetFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+	}
+	return
+}
+
+// resolveUnion
+// the writer value is read by the first reader branch which matches it by type and name,
+// then by type, then by promotion.
+func (d *decoder) resolveUnion(writer *Schema, reader *Schema, v reflect.Value, depth int) (err error) {
+	index := -1
+	for _, match := range []func(w, r *Schema) bool{
+		func(w, r *Schema) bool { return w.Type == r.Type && (!r.named() || matchName(w, r)) },
+		func(w, r *Schema) bool { return w.Type == r.Type },
+		func(w, r *Schema) bool { return promotable(w.Type, r.Type) },
+	} {
+		if index = slices.IndexFunc(reader.Branches, func(r *Schema) bool { return match(writer, r) }); index >= 0 {
+			break
+		}
+	}
+	if index < 0 {
+		return incompatible(writer, reader)
+	}
+	branch := reader.Branches[index]
+	if branch.Type == TypeNull {
+		codec.SetNil(v)
+		return
+	}
+	if v.Kind() == reflect.Pointer {
+		return d.resolve(writer, branch, codec.Indirect(v), depth+1)
+	}
+	// registered unions, branch 0 is null.
+	branches, _ := unionOf(v.Type())
+	typ := branches[index-1]
+	value := reflect.New(typ).Elem()
+	if typ.Kind() == reflect.Pointer {
+		value.Set(reflect.New(typ.Elem()))
+		err = d.resolve(writer, branch, value.Elem(), depth+1)
+	} else {
+		err = d.resolve(writer, branch, value, depth+1)
+	}
+	if err != nil {
+		return
+	}
+	v.Set(value)
+	return
+}
+
+// resolveAny
+// any value of the writer is read into the interface which is not registered.
+func (d *decoder) resolveAny(writer *Schema, v reflect.Value, depth int) (err error) {
+	var value any
+	if writer.Type == TypeRecord && writer.Name == AnyName {
+		value, err = d.decodeAny(depth)
+	} else {
+		value, err = d.readGeneric(writer, depth)
+	}
+	if err != nil {
+		return
+	}
+	if value == nil {
+		codec.SetNil(v)
+		return
+	}
+	v.Set(reflect.ValueOf(value))
+	return
+}
+
+// readGeneric
+// read the value of the writer schema as go values: int and long are int64, float and double are float64,
+// bytes and fixed are []byte, enums are their symbols, arrays are []any, maps and records are map[string]any,
+// and values of logical types are converted by logicalValue.
+func (d *decoder) readGeneric(writer *Schema, depth int) (value any, err error) {
+	if value, err = d.readValue(writer, depth); err != nil || writer.LogicalType == "" {
+		return
+	}
+	value = logicalValue(writer, value)
+	return
+}
+
+func (d *decoder) readValue(writer *Schema, depth int) (value any, err error) {
+	if depth > MaxDepth {
+		err = ErrMaxDepth
+		return
+	}
+	switch writer.Type {
+	case TypeNull:
+		return
+	case TypeBoolean:
+		b, readErr := d.src.ReadByte()
+		value, err = b != 0, readErr
+	case TypeInt, TypeLong:
+		value, err = d.readLong()
+	case TypeFloat, TypeDouble:
+		f := 0.0
+		if err = d.resolveNumber(writer, reflect.ValueOf(&f).Elem()); err != nil {
+			return
+		}
+		value = f
+	case TypeBytes:
+		b, readErr := d.readBytes()
+		value, err = append([]byte(nil), b...), readErr
+	case TypeString:
+		b, readErr := d.readBytes()
+		value, err = string(b), readErr
+	case TypeFixed:
+		b, readErr := d.src.next(writer.Size)
+		value, err = append([]byte(nil), b...), readErr
+	case TypeEnum:
+		index, readErr := d.readLong()
+		if readErr != nil {
+			err = readErr
+			return
+		}
+		if index < 0 || index >= int64(len(writer.Symbols)) {
+			err = fmt.Errorf("avro: invalid symbol %d of enum %s", index, writer.Name)
+			return
+		}
+		value = writer.Symbols[index]
+	case TypeUnion:
+		index, branchErr := d.readBranch(len(writer.Branches))
+		if branchErr != nil {
+			err = branchErr
+			return
+		}
+		return d.readGeneric(writer.Branches[index], depth+1)
+	case TypeArray:
+		values := make([]any, 0, 1)
+		err = d.readBlocks(func() error {
+			item, itemErr := d.readGeneric(writer.Items, depth+1)
+			values = append(values, item)
+			return itemErr
+		})
+		value = values
+	case TypeMap:
+		values := make(map[string]any)
+		err = d.readBlocks(func() error {
+			key, keyErr := d.readBytes()
+			if keyErr != nil {
+				return keyErr
+			}
+			name := string(key)
+			item, itemErr := d.readGeneric(writer.Values, depth+1)
+			values[name] = item
+			return itemErr
+		})
+		value = values
+	case TypeRecord:
+		if writer.Name == AnyName {
+			return d.decodeAny(depth)
+		}
+		values := make(map[string]any, len(writer.Fields))
+		for _, field := range writer.Fields {
+			if values[field.Name], err = d.readGeneric(field.Type, depth+1); err != nil {
+				return
+			}
+		}
+		value = values
+	default:
+		err = fmt.Errorf("avro: invalid schema type %s", writer.Type)
+	}
+	return
+}
+
+// skip
+// read the value of the writer schema which is missing in the reader.
+func (d *decoder) skip(writer *Schema, depth int) (err error) {
+	_, err = d.readGeneric(writer, depth)
+	return
+}
+
+// resolveEnum
+// the symbol of the writer is matched by name, unknown symbols are the default of the reader.
+func (d *decoder) resolveEnum(writer *Schema, reader *Schema, v reflect.Value) (err error) {
+	index, readErr := d.readLong()
+	if readErr != nil {
+		err = readErr
+		return
+	}
+	if index < 0 || index >= int64(len(writer.Symbols)) {
+		err = fmt.Errorf("avro: invalid symbol %d of enum %s", index, writer.Name)
+		return
+	}
+	symbol := writer.Symbols[index]
+	if !slices.Contains(reader.Symbols, symbol) {
+		if reader.Default == "" {
+			err = fmt.Errorf("%w: symbol %s of enum %s is unknown", ErrIncompatibleSchema, symbol, reader.Name)
+			return
+		}
+		symbol = reader.Default
+	}
+	return setSymbol(v, reader, symbol)
+}
+
+func (d *decoder) resolveArray(writer *Schema, reader *Schema, v reflect.Value, depth int) (err error) {
+	i := 0
+	if v.Kind() == reflect.Slice {
+		v.SetLen(0)
+		if v.IsNil() {
+			v.Set(reflect.MakeSlice(v.Type(), 0, 1))
+		}
+	}
+	err = d.readBlocks(func() error {
+		defer func() { i++ }()
+		if v.Kind() == reflect.Slice {
+			v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+			return d.resolve(writer.Items, reader.Items, v.Index(i), depth+1)
+		}
+		if i < v.Len() {
+			return d.resolve(writer.Items, reader.Items, v.Index(i), depth+1)
+		}
+		// items which are out of the array are dropped.
+		return d.skip(writer.Items, depth+1)
+	})
+	return
+}
+
+func (d *decoder) resolveMap(writer *Schema, reader *Schema, v reflect.Value, depth int) (err error) {
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(v.Type()))
+	}
+	kt, vt := v.Type().Key(), v.Type().Elem()
+	err = d.readBlocks(func() error {
+		b, keyErr := d.readBytes()
+		if keyErr != nil {
+			return keyErr
+		}
+		key := reflect.New(kt).Elem()
+		key.SetString(string(b))
+		value := reflect.New(vt).Elem()
+		if valueErr := d.resolve(writer.Values, reader.Values, value, depth+1); valueErr != nil {
+			return valueErr
+		}
+		v.SetMapIndex(key, value)
+		return nil
+	})
+	return
+}
+
+// resolveRecord
+// fields of the writer are read in its order, fields of the reader which are not written take their defaults.
+func (d *decoder) resolveRecord(writer *Schema, reader *Schema, v reflect.Value, depth int) (err error) {
+	fields := codec.StructFields(v.Type(), tags...)
+	written := make([]bool, len(reader.Fields))
+	for _, wf := range writer.Fields {
+		index := slices.IndexFunc(reader.Fields, func(rf *Field) bool {
+			return rf.Name == wf.Name || slices.Contains(rf.Aliases, wf.Name)
+		})
+		if index < 0 {
+			if err = d.skip(wf.Type, depth+1); err != nil {
+				return
+			}
+			continue
+		}
+		written[index] = true
+		fv, ok := codec.FieldByIndex(v, fields.List[index].Index, true)
+		if !ok {
+			// the field of an embedded pointer which can not be allocated is dropped.
+			fv = reflect.New(fields.List[index].Type).Elem()
+		}
+		if err = d.resolve(wf.Type, reader.Fields[index].Type, fv, depth+1); err != nil {
+			err = fmt.Errorf("avro: field %s of %s: %w", wf.Name, v.Type(), err)
+			return
+		}
+	}
+	for index, rf := range reader.Fields {
+		if written[index] {
+			continue
+		}
+		if !rf.HasDefault {
+			err = fmt.Errorf("%w: field %s of %s has no default", ErrIncompatibleSchema, rf.Name, v.Type())
+			return
+		}
+		fv, ok := codec.FieldByIndex(v, fields.List[index].Index, true)
+		if !ok {
+			continue
+		}
+		if err = setDefault(fv, rf.Type, rf.Default, depth+1); err != nil {
+			err = fmt.Errorf("avro: field %s of %s: %w", rf.Name, v.Type(), err)
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,429 @@
+package avro
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// types of schemas
+const (
+	TypeNull    = "null"
+	TypeBoolean = "boolean"
+	TypeInt     = "int"
+	TypeLong    = "long"
+	TypeFloat   = "float"
+	TypeDouble  = "double"
+	TypeBytes   = "bytes"
+	TypeString  = "string"
+	TypeRecord  = "record"
+	TypeEnum    = "enum"
+	TypeArray   = "array"
+	TypeMap     = "map"
+	TypeFixed   = "fixed"
+	TypeUnion   = "union"
+)
+
+// Schema
+// an avro schema, named schemas (records, enums and fixed) are shared by pointers, so a schema may be recursive.
+type Schema struct {
+	Type string
+	// Name
+	// the full name of named schemas, e.g. `brick.Card`.
+	Name    string
+	Aliases []string
+	// Fields
+	// the fields of records.
+	Fields []*Field
+	// Symbols
+	// the symbols of enums, Default is the symbol which is used when a symbol of the writer is unknown.
+	Symbols []string
+	Default string
+	// Items
+	// the items of arrays.
+	Items *Schema
+	// Values
+	// the values of maps.
+	Values *Schema
+	// Size
+	// the size of fixed.
+	Size int
+	// Branches
+	// the branches of unions.
+	Branches []*Schema
+	// LogicalType
+	// the logical type which annotates the schema, Precision and Scale are of decimals.
+	LogicalType string
+	Precision   int
+	Scale       int
+}
+
+// Field
+// a field of a record, Default is the decoded json value of the default.
+type Field struct {
+	Name       string
+	Aliases    []string
+	Type       *Schema
+	Default    any
+	HasDefault bool
+}
+
+// named
+// whether the schema is a record, an enum or a fixed.
+func (s *Schema) named() bool {
+	return s.Type == TypeRecord || s.Type == TypeEnum || s.Type == TypeFixed
+}
+
+// shortName
+// the name without namespace.
+func shortName(name string) string {
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// matchName
+// whether the names of named schemas match, unqualified names and aliases of the reader are matched too.
+func matchName(writer *Schema, reader *Schema) bool {
+	if writer.Name == reader.Name || shortName(writer.Name) == shortName(reader.Name) {
+		return true
+	}
+	for _, alias := range reader.Aliases {
+		if alias == writer.Name || shortName(alias) == shortName(writer.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// String
+// the json of the schema, named schemas are defined at their first occurrence and referenced by names later.
+func (s *Schema) String() string {
+	buf := new(bytes.Buffer)
+	s.writeJSON(buf, make(map[*Schema]bool))
+	return buf.String()
+}
+
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Schema) UnmarshalJSON(b []byte) error {
+	parsed, err := ParseSchema(string(b))
+	if err != nil {
+		return err
+	}
+	*s = *parsed
+	return nil
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	b, _ := json.Marshal(s)
+	buf.Write(b)
+}
+
+func (s *Schema) writeJSON(buf *bytes.Buffer, defined map[*Schema]bool) {
+	if s.named() {
+		if defined[s] {
+			writeJSONString(buf, s.Name)
+			return
+		}
+		defined[s] = true
+	}
+	switch s.Type {
+	case TypeUnion:
+		buf.WriteByte('[')
+		for i, branch := range s.Branches {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			branch.writeJSON(buf, defined)
+		}
+		buf.WriteByte(']')
+		return
+	case TypeRecord, TypeEnum, TypeFixed, TypeArray, TypeMap:
+		break
+	default:
+		if s.LogicalType == "" {
+			writeJSONString(buf, s.Type)
+			return
+		}
+	}
+	buf.WriteString(`{"type":`)
+	writeJSONString(buf, s.Type)
+	if s.named() {
+		buf.WriteString(`,"name":`)
+		writeJSONString(buf, s.Name)
+		if len(s.Aliases) > 0 {
+			b, _ := json.Marshal(s.Aliases)
+			buf.WriteString(`,"aliases":`)
+			buf.Write(b)
+		}
+	}
+	switch s.Type {
+	case TypeRecord:
+		buf.WriteString(`,"fields":[`)
+		for i, field := range s.Fields {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.WriteString(`{"name":`)
+			writeJSONString(buf, field.Name)
+			if len(field.Aliases) > 0 {
+				b, _ := json.Marshal(field.Aliases)
+				buf.WriteString(`,"aliases":`)
+				buf.Write(b)
+			}
+			buf.WriteString(`,"type":`)
+			field.Type.writeJSON(buf, defined)
+			if field.HasDefault {
+				b, _ := json.Marshal(field.Default)
+				buf.WriteString(`,"default":`)
+				buf.Write(b)
+			}
+			buf.WriteByte('}')
+		}
+		buf.WriteByte(']')
+	case TypeEnum:
+		b, _ := json.Marshal(s.Symbols)
+		buf.WriteString(`,"symbols":`)
+		buf.Write(b)
+		if s.Default != "" {
+			buf.WriteString(`,"default":`)
+			writeJSONString(buf, s.Default)
+		}
+	case TypeFixed:
+		fmt.Fprintf(buf, `,"size":%d`, s.Size)
+	case TypeArray:
+		buf.WriteString(`,"items":`)
+		s.Items.writeJSON(buf, defined)
+	case TypeMap:
+		buf.WriteString(`,"values":`)
+		s.Values.writeJSON(buf, defined)
+	default:
+		break
+	}
+	if s.LogicalType != "" {
+		buf.WriteString(`,"logicalType":`)
+		writeJSONString(buf, s.LogicalType)
+		if s.LogicalType == LogicalDecimal {
+			fmt.Fprintf(buf, `,"precision":%d,"scale":%d`, s.Precision, s.Scale)
+		}
+	}
+	buf.WriteByte('}')
+}
+
+// ParseSchema
+// parse the json of a schema, named schemas are referenced by full names or names in the enclosing namespace.
+func ParseSchema(s string) (schema *Schema, err error) {
+	decoder := json.NewDecoder(strings.NewReader(s))
+	decoder.UseNumber()
+	var v any
+	if err = decoder.Decode(&v); err != nil {
+		err = errors.Join(errors.New("avro: parse schema failed"), err)
+		return
+	}
+	p := &schemaParser{names: make(map[string]*Schema)}
+	if schema, err = p.parse(v, ""); err != nil {
+		err = errors.Join(errors.New("avro: parse schema failed"), err)
+	}
+	return
+}
+
+type schemaParser struct {
+	names map[string]*Schema
+}
+
+func isPrimitive(name string) bool {
+	switch name {
+	case TypeNull, TypeBoolean, TypeInt, TypeLong, TypeFloat, TypeDouble, TypeBytes, TypeString:
+		return true
+	default:
+		return false
+	}
+}
+
+// fullName
+// the name is qualified by the namespace unless it has dots.
+func fullName(name string, namespace string) string {
+	if strings.Contains(name, ".") || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+func (p *schemaParser) parse(v any, namespace string) (schema *Schema, err error) {
+	switch v := v.(type) {
+	case string:
+		if isPrimitive(v) {
+			schema = &Schema{Type: v}
+			return
+		}
+		if named, has := p.names[fullName(v, namespace)]; has {
+			schema = named
+			return
+		}
+		if named, has := p.names[v]; has {
+			schema = named
+			return
+		}
+		err = fmt.Errorf("unknown type %q", v)
+	case []any:
+		schema = &Schema{Type: TypeUnion, Branches: make([]*Schema, 0, len(v))}
+		for _, item := range v {
+			branch, branchErr := p.parse(item, namespace)
+			if branchErr != nil {
+				err = branchErr
+				return
+			}
+			if branch.Type == TypeUnion {
+				err = errors.New("union must not contain union")
+				return
+			}
+			schema.Branches = append(schema.Branches, branch)
+		}
+	case map[string]any:
+		typ, _ := v["type"].(string)
+		switch typ {
+		case TypeRecord, "error", TypeEnum, TypeFixed:
+			return p.parseNamed(v, typ, namespace)
+		case TypeArray:
+			items, itemsErr := p.parse(v["items"], namespace)
+			if itemsErr != nil {
+				err = itemsErr
+				return
+			}
+			schema = &Schema{Type: TypeArray, Items: items}
+		case TypeMap:
+			values, valuesErr := p.parse(v["values"], namespace)
+			if valuesErr != nil {
+				err = valuesErr
+				return
+			}
+			schema = &Schema{Type: TypeMap, Values: values}
+		default:
+			if _, nested := v["type"].(string); !nested || !isPrimitive(typ) {
+				return p.parse(v["type"], namespace)
+			}
+			schema = &Schema{Type: typ}
+			parseLogical(schema, v)
+		}
+	default:
+		err = fmt.Errorf("invalid schema %v", v)
+	}
+	return
+}
+
+func (p *schemaParser) parseNamed(v map[string]any, typ string, namespace string) (schema *Schema, err error) {
+	name, _ := v["name"].(string)
+	if name == "" {
+		err = fmt.Errorf("name of %s is missing", typ)
+		return
+	}
+	if ns, has := v["namespace"].(string); has && !strings.Contains(name, ".") {
+		namespace = ns
+	}
+	name = fullName(name, namespace)
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		namespace = name[:i]
+	}
+	if _, has := p.names[name]; has {
+		err = fmt.Errorf("type %q is defined twice", name)
+		return
+	}
+	if typ == "error" {
+		typ = TypeRecord
+	}
+	schema = &Schema{Type: typ, Name: name, Aliases: stringsOf(v["aliases"])}
+	for i, alias := range schema.Aliases {
+		schema.Aliases[i] = fullName(alias, namespace)
+	}
+	p.names[name] = schema
+	switch typ {
+	case TypeRecord:
+		fields, _ := v["fields"].([]any)
+		for _, item := range fields {
+			f, ok := item.(map[string]any)
+			if !ok {
+				err = fmt.Errorf("invalid field of %s", name)
+				return
+			}
+			field := &Field{Aliases: stringsOf(f["aliases"])}
+			if field.Name, _ = f["name"].(string); field.Name == "" {
+				err = fmt.Errorf("name of field of %s is missing", name)
+				return
+			}
+			if field.Type, err = p.parse(f["type"], namespace); err != nil {
+				return
+			}
+			if def, has := f["default"]; has {
+				field.Default, field.HasDefault = normalizeJSON(def), true
+			}
+			schema.Fields = append(schema.Fields, field)
+		}
+	case TypeEnum:
+		schema.Symbols = stringsOf(v["symbols"])
+		schema.Default, _ = v["default"].(string)
+	case TypeFixed:
+		number, _ := v["size"].(json.Number)
+		size, sizeErr := number.Int64()
+		if sizeErr != nil || size < 0 {
+			err = fmt.Errorf("invalid size of %s", name)
+			return
+		}
+		schema.Size = int(size)
+		parseLogical(schema, v)
+	default:
+		break
+	}
+	return
+}
+
+// parseLogical
+// the logical type of primitives and fixed.
+func parseLogical(schema *Schema, v map[string]any) {
+	schema.LogicalType, _ = v["logicalType"].(string)
+	if precision, ok := v["precision"].(json.Number); ok {
+		n, _ := precision.Int64()
+		schema.Precision = int(n)
+	}
+	if scale, ok := v["scale"].(json.Number); ok {
+		n, _ := scale.Int64()
+		schema.Scale = int(n)
+	}
+}
+
+func stringsOf(v any) (ss []string) {
+	items, _ := v.([]any)
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			ss = append(ss, s)
+		}
+	}
+	return
+}
+
+// normalizeJSON
+// numbers of json are float64 in defaults.
+func normalizeJSON(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case []any:
+		for i, item := range v {
+			v[i] = normalizeJSON(item)
+		}
+		return v
+	case map[string]any:
+		for k, item := range v {
+			v[k] = normalizeJSON(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
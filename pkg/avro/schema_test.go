@@ -0,0 +1,206 @@
+package avro_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/brickingsoft/brick/pkg/avro"
+)
+
+func TestSchemaOf(t *testing.T) {
+	avro.RegisterUnion[Shape](Circle{}, &Square{})
+	type Point struct {
+		X     int32   `avro:"x"`
+		Y     int32   `avro:"y" default:"-1"`
+		Label string  `avro:"label" default:"origin" aliases:"name"`
+		Next  *Point  `avro:"next"`
+		Hash  [2]byte `avro:"hash"`
+		Suit  Suit    `avro:"suit" default:"HEARTS"`
+	}
+	schema, err := avro.SchemaOf(&Point{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"type":"record","name":"avro_test.Point","fields":[` +
+		`{"name":"x","type":"int"},` +
+		`{"name":"y","type":"int","default":-1},` +
+		`{"name":"label","aliases":["name"],"type":"string","default":"origin"},` +
+		`{"name":"next","type":["null","avro_test.Point"],"default":null},` +
+		`{"name":"hash","type":{"type":"fixed","name":"avro.Fixed2","size":2}},` +
+		`{"name":"suit","type":{"type":"enum","name":"avro_test.Suit","symbols":["SPADES","HEARTS","DIAMONDS","CLUBS"]},"default":"HEARTS"}]}`
+	if schema.String() != expected {
+		t.Fatal("unexpected schema", schema)
+	}
+	parsed, err := avro.ParseSchema(schema.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.String() != expected {
+		t.Fatal("unexpected parsed schema", parsed)
+	}
+
+	// the schema of Marshal resolves all values of the go type.
+	card, err := avro.SchemaOf(Card{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card, err = avro.ParseSchema(card.String()); err != nil {
+		t.Fatal(err)
+	}
+	in := Card{
+		Name:   "ace",
+		Color:  "red",
+		Label:  "up",
+		Digest: [4]byte{1, 2, 3, 4},
+		Shape:  Circle{R: 1},
+		Extra:  []any{int64(1), "a", map[string]any{"b": nil}},
+		Next:   &Card{Color: "green", Shape: &Square{Side: 2}, Extra: true},
+	}
+	b, err := avro.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedCard, out := Card{}, Card{}
+	if err = avro.Unmarshal(b, &expectedCard); err != nil {
+		t.Fatal(err)
+	}
+	if err = avro.UnmarshalWithSchema(b, card, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(expectedCard, out) {
+		t.Fatalf("unexpected card\n%+v\n%+v", expectedCard, out)
+	}
+}
+
+func TestParseSchema(t *testing.T) {
+	schema, err := avro.ParseSchema(`{
+		"type": "record", "name": "Node", "namespace": "tree", "fields": [
+			{"name": "value", "type": {"type": "long"}},
+			{"name": "children", "type": {"type": "array", "items": "Node"}},
+			{"name": "kind", "type": {"type": "enum", "name": "other.Kind", "symbols": ["A", "B"], "default": "A"}},
+			{"name": "again", "type": ["null", "other.Kind", "tree.Node"]}
+		]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema.Name != "tree.Node" || schema.Fields[1].Type.Items != schema || schema.Fields[3].Type.Branches[1] != schema.Fields[2].Type {
+		t.Fatal("unexpected schema", schema)
+	}
+	if schema.Fields[2].Type.Default != "A" {
+		t.Fatal("unexpected enum default", schema.Fields[2].Type.Default)
+	}
+	for _, invalid := range []string{`"Unknown"`, `{"type":"fixed","name":"F"}`, `[["int"]]`, `{"type":"record"}`, `{`} {
+		if _, err = avro.ParseSchema(invalid); err == nil {
+			t.Fatal("expected invalid schema", invalid)
+		}
+	}
+}
+
+type UserV1 struct {
+	ID      int32   `avro:"id"`
+	Name    string  `avro:"name"`
+	Removed string  `avro:"removed"`
+	Score   float32 `avro:"score"`
+	Avatar  string  `avro:"avatar"`
+	Color   Color   `avro:"color"`
+	Parent  *UserV1 `avro:"parent"`
+	Shape   Shape   `avro:"shape"`
+}
+
+type UserV2 struct {
+	Nickname string            `avro:"nickname" aliases:"name"`
+	ID       int64             `avro:"id"`
+	Score    float64           `avro:"score"`
+	Avatar   []byte            `avro:"avatar"`
+	Color    Color             `avro:"color"`
+	Parent   *UserV2           `avro:"parent"`
+	Shape    Shape             `avro:"shape"`
+	Count    int               `avro:"count" default:"10"`
+	Tags     []string          `avro:"tags" default:"[\"new\"]"`
+	Labels   map[string]string `avro:"labels" default:"{\"a\":\"b\"}"`
+	Note     *string           `avro:"note"`
+	Extra    any               `avro:"extra"`
+}
+
+func TestUnmarshalWithSchema(t *testing.T) {
+	avro.RegisterUnion[Shape](Circle{}, &Square{})
+	writer, err := avro.SchemaOf(UserV1{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := UserV1{ID: 7, Name: "bob", Removed: "x", Score: 1.5, Avatar: "png", Color: "green",
+		Parent: &UserV1{ID: 1, Color: "red"}, Shape: &Square{Side: 2}}
+	b, err := avro.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	note := "stale"
+	out := UserV2{Note: &note}
+	if err = avro.UnmarshalWithSchema(b, writer, &out); err != nil {
+		t.Fatal(err)
+	}
+	expected := UserV2{
+		Nickname: "bob", ID: 7, Score: 1.5, Avatar: []byte("png"), Color: "green",
+		Parent: &UserV2{ID: 1, Color: "red", Count: 10, Tags: []string{"new"}, Labels: map[string]string{"a": "b"}},
+		Shape:  &Square{Side: 2}, Count: 10, Tags: []string{"new"}, Labels: map[string]string{"a": "b"},
+	}
+	if !reflect.DeepEqual(expected, out) {
+		t.Fatalf("unexpected user\n%+v\n%+v", expected, out)
+	}
+
+	// union branches are matched by types and names, or promoted.
+	union, err := avro.ParseSchema(`["null", "int", "string", {"type": "record", "name": "Circle", "fields": [{"name": "r", "type": "float"}]}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n *int64
+	if err = avro.UnmarshalWithSchema([]byte{0x02, 0x54}, union, &n); err != nil || n == nil || *n != 42 {
+		t.Fatal("unexpected promoted branch", n, err)
+	}
+	var shape Shape
+	if err = avro.UnmarshalWithSchema([]byte{0x06, 0x00, 0x00, 0x00, 0x40}, union, &shape); err != nil || shape != (Circle{R: 2}) {
+		t.Fatal("unexpected record branch", shape, err)
+	}
+	var extra any
+	if err = avro.UnmarshalWithSchema([]byte{0x06, 0x00, 0x00, 0x00, 0x40}, union, &extra); err != nil || !reflect.DeepEqual(extra, map[string]any{"r": 2.0}) {
+		t.Fatal("unexpected generic record", extra, err)
+	}
+	if err = avro.UnmarshalWithSchema([]byte{0x04, 0x02, 'a'}, union, &n); !errors.Is(err, avro.ErrIncompatibleSchema) {
+		t.Fatal("expected incompatible branch", err)
+	}
+	if err = avro.UnmarshalWithSchema([]byte{0x00}, union, &shape); err != nil || shape != nil {
+		t.Fatal("unexpected null branch", shape, err)
+	}
+}
+
+func TestUnmarshalWithSchema_Incompatible(t *testing.T) {
+	long, _ := avro.ParseSchema(`"long"`)
+	if err := avro.UnmarshalWithSchema([]byte{0x02}, long, new(int32)); !errors.Is(err, avro.ErrIncompatibleSchema) {
+		t.Fatal("expected long is not int", err)
+	}
+	if err := avro.UnmarshalWithSchema([]byte{0x02}, long, new(string)); !errors.Is(err, avro.ErrIncompatibleSchema) {
+		t.Fatal("expected long is not string", err)
+	}
+	record, _ := avro.ParseSchema(`{"type":"record","name":"R","fields":[{"name":"x","type":"long"}]}`)
+	required := struct {
+		X int64 `avro:"x"`
+		Y int64 `avro:"y"`
+	}{}
+	if err := avro.UnmarshalWithSchema([]byte{0x02}, record, &required); !errors.Is(err, avro.ErrIncompatibleSchema) {
+		t.Fatal("expected missing default", err)
+	}
+	enum, _ := avro.ParseSchema(`{"type":"enum","name":"Suit","symbols":["JOKER","HEARTS"]}`)
+	var suit Suit
+	if err := avro.UnmarshalWithSchema([]byte{0x02}, enum, &suit); err != nil || suit != 1 {
+		t.Fatal("unexpected symbol", suit, err)
+	}
+	if err := avro.UnmarshalWithSchema([]byte{0x00}, enum, &suit); !errors.Is(err, avro.ErrIncompatibleSchema) {
+		t.Fatal("expected unknown symbol", err)
+	}
+	if _, err := avro.SchemaOf(struct {
+		N int `default:"x"`
+	}{}); err == nil {
+		t.Fatal("expected invalid default")
+	}
+}
@@ -0,0 +1,55 @@
+package avro
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var (
+	unions       = make(map[reflect.Type][]reflect.Type)
+	unionsLocker sync.RWMutex
+)
+
+// RegisterUnion
+// register the branches of the interface type I, e.g. RegisterUnion[Shape](Circle{}, &Square{}).
+// the branch 0 is null and the branch i+1 is the type of branches[i], a value is encoded by the branch of its exact type.
+// interfaces which are not registered are the union of null, boolean, long, double, bytes, string, array and map.
+func RegisterUnion[I any](branches ...I) {
+	iface := reflect.TypeFor[I]()
+	if iface.Kind() != reflect.Interface {
+		panic(fmt.Sprintf("avro: %s is not an interface", iface))
+	}
+	types := make([]reflect.Type, 0, len(branches))
+	for _, branch := range branches {
+		typ := reflect.TypeOf(any(branch))
+		if typ == nil {
+			panic("avro: branch of union must not be nil")
+		}
+		types = append(types, typ)
+	}
+	unionsLocker.Lock()
+	unions[iface] = types
+	unionsLocker.Unlock()
+}
+
+// unionOf
+// the registered branches of the interface type.
+func unionOf(iface reflect.Type) (branches []reflect.Type, ok bool) {
+	unionsLocker.RLock()
+	branches, ok = unions[iface]
+	unionsLocker.RUnlock()
+	return
+}
+
+// the branches of interfaces which are not registered.
+const (
+	anyNull = iota
+	anyBoolean
+	anyLong
+	anyDouble
+	anyBytes
+	anyString
+	anyArray
+	anyMap
+)
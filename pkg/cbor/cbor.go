@@ -0,0 +1,96 @@
+package cbor
+
+import (
+	"errors"
+	"reflect"
+)
+
+// Marshal
+// encode the value as cbor, fields of structs are named by `cbor`, `json` then `yaml` tags.
+// time.Time is an RFC 3339 date time (tag 0), encoding.TextMarshaler is a text string and Marshaler writes itself.
+func Marshal(v any) (b []byte, err error) {
+	e := &encoder{buf: make([]byte, 0, 64)}
+	if err = e.encode(reflect.ValueOf(v), 0); err != nil {
+		return
+	}
+	b = e.buf
+	return
+}
+
+// Unmarshal
+// decode the cbor into v which must be a non-nil pointer.
+func Unmarshal(b []byte, v any) (err error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		err = ErrInvalidUnmarshal
+		return
+	}
+	d := &decoder{data: b}
+	if err = d.decode(rv.Elem(), 0); err != nil {
+		return
+	}
+	if d.pos != len(d.data) {
+		err = ErrTrailingData
+	}
+	return
+}
+
+var (
+	ErrInvalidUnmarshal = errors.New("cbor: unmarshal target must be a non-nil pointer")
+	ErrShortBuffer      = errors.New("cbor: unexpected end of data")
+	ErrTrailingData     = errors.New("cbor: trailing data after the value")
+	ErrMaxDepth         = errors.New("cbor: exceeded max depth")
+)
+
+// MaxDepth
+// the max nesting depth of values, it stops cycles of encoding and hostile inputs of decoding.
+const MaxDepth = 1000
+
+// Marshaler
+// the value encodes itself, b must be a whole cbor value.
+type Marshaler interface {
+	MarshalCBOR() (b []byte, err error)
+}
+
+// Unmarshaler
+// the value decodes itself, b is a whole cbor value.
+type Unmarshaler interface {
+	UnmarshalCBOR(b []byte) (err error)
+}
+
+var (
+	marshalerType   = reflect.TypeFor[Marshaler]()
+	unmarshalerType = reflect.TypeFor[Unmarshaler]()
+	tags            = []string{"cbor", "json", "yaml"}
+)
+
+const (
+	majorUint   byte = 0
+	majorNint   byte = 1
+	majorBytes  byte = 2
+	majorText   byte = 3
+	majorArray  byte = 4
+	majorMap    byte = 5
+	majorTag    byte = 6
+	majorSimple byte = 7
+
+	codeFalse     byte = 0xf4
+	codeTrue      byte = 0xf5
+	codeNull      byte = 0xf6
+	codeUndefined byte = 0xf7
+	codeFloat16   byte = 0xf9
+	codeFloat32   byte = 0xfa
+	codeFloat64   byte = 0xfb
+	codeBreak     byte = 0xff
+
+	// infoIndefinite
+	// the additional info of indefinite lengths.
+	infoIndefinite byte = 31
+
+	// tagDateTime
+	// the tag of RFC 3339 date time strings.
+	tagDateTime uint64 = 0
+	// tagEpochTime
+	// the tag of epoch based date time numbers.
+	tagEpochTime uint64 = 1
+)
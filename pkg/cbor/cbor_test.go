@@ -0,0 +1,177 @@
+package cbor_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brickingsoft/brick/pkg/cbor"
+)
+
+type Base struct {
+	ID   int    `json:"id"`
+	Kind string `yaml:"kind"`
+}
+
+type Upper string
+
+func (u Upper) MarshalCBOR() ([]byte, error) {
+	return cbor.Marshal(strings.ToUpper(string(u)))
+}
+
+func (u *Upper) UnmarshalCBOR(b []byte) error {
+	var s string
+	if err := cbor.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	*u = Upper(strings.ToLower(s))
+	return nil
+}
+
+type Item struct {
+	Base
+	Name    string         `cbor:"name" json:"title"`
+	Count   uint16         `json:"count,omitempty"`
+	Score   float64        `json:"score"`
+	Tags    []string       `json:"tags"`
+	Raw     []byte         `json:"raw"`
+	At      time.Time      `json:"at"`
+	Addr    netip.Addr     `json:"addr"`
+	Upper   Upper          `json:"upper"`
+	Next    *Item          `json:"next,omitempty"`
+	Extra   map[string]any `json:"extra"`
+	Ignored string         `json:"-"`
+}
+
+func TestMarshal(t *testing.T) {
+	in := Item{
+		Base:    Base{ID: -7, Kind: "k"},
+		Name:    "foo",
+		Score:   1.5,
+		Tags:    []string{"a", "b"},
+		Raw:     []byte{1, 2, 3},
+		At:      time.Date(2024, 5, 6, 7, 8, 9, 10, time.UTC),
+		Addr:    netip.MustParseAddr("10.0.0.1"),
+		Upper:   "up",
+		Next:    &Item{Name: "bar", Count: 300},
+		Extra:   map[string]any{"n": int64(-1), "s": "x", "l": []any{true, nil}},
+		Ignored: "ignored",
+	}
+	b, err := cbor.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := Item{}
+	if err = cbor.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !out.At.Equal(in.At) {
+		t.Fatal("unexpected time", out.At)
+	}
+	out.At = in.At
+	in.Ignored = ""
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("unexpected item\n%+v\n%+v", in, out)
+	}
+
+	generic := map[string]any{}
+	if err = cbor.Unmarshal(b, &generic); err != nil {
+		t.Fatal(err)
+	}
+	if generic["name"] != "foo" || generic["id"] != int64(-7) || generic["kind"] != "k" || generic["upper"] != "UP" || generic["addr"] != "10.0.0.1" {
+		t.Fatal("unexpected names", generic)
+	}
+	if _, has := generic["count"]; has {
+		t.Fatal("count should be omitted")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	cases := []struct {
+		v   any
+		hex string
+	}{
+		{nil, "f6"},
+		{true, "f5"},
+		{10, "0a"},
+		{1000000, "1a000f4240"},
+		{-1000, "3903e7"},
+		{"IETF", "6449455446"},
+		{[]byte{1, 2}, "420102"},
+		{[]int{1, 2}, "820102"},
+		{map[string]int{"b": 2, "a": 1}, "a2616101616202"},
+		{time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC), "c074323031332d30332d32315432303a30343a30305a"},
+	}
+	for _, c := range cases {
+		b, err := cbor.Marshal(c.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(b) != c.hex {
+			t.Fatal("unexpected encoding of", c.v, hex.EncodeToString(b))
+		}
+	}
+}
+
+func TestUnmarshal_Indefinite(t *testing.T) {
+	cases := []struct {
+		hex      string
+		expected any
+	}{
+		{"9f018202039f0405ffff", []any{int64(1), []any{int64(2), int64(3)}, []any{int64(4), int64(5)}}},
+		{"bf61610161629f0203ffff", map[string]any{"a": int64(1), "b": []any{int64(2), int64(3)}}},
+		{"7f657374726561646d696e67ff", "streaming"},
+		{"f93c00", 1.0},
+		{"f9c400", -4.0},
+		{"c11a514b67b0", time.Unix(1363896240, 0).UTC()},
+		{"d82076687474703a2f2f7777772e6578616d706c652e636f6d", "http://www.example.com"},
+	}
+	for _, c := range cases {
+		b, _ := hex.DecodeString(c.hex)
+		var v any
+		if err := cbor.Unmarshal(b, &v); err != nil {
+			t.Fatal(c.hex, err)
+		}
+		if !reflect.DeepEqual(v, c.expected) {
+			t.Fatalf("unexpected value of %s: %#v", c.hex, v)
+		}
+	}
+	b, _ := hex.DecodeString("9f0102ff")
+	var numbers []int
+	if err := cbor.Unmarshal(b, &numbers); err != nil || !reflect.DeepEqual(numbers, []int{1, 2}) {
+		t.Fatal("unexpected numbers", numbers, err)
+	}
+}
+
+func TestUnmarshal_Invalid(t *testing.T) {
+	var s string
+	if err := cbor.Unmarshal([]byte{0x65, 'a'}, &s); !errors.Is(err, cbor.ErrShortBuffer) {
+		t.Fatal("expected short buffer", err)
+	}
+	if err := cbor.Unmarshal([]byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, &s); !errors.Is(err, cbor.ErrShortBuffer) {
+		t.Fatal("expected short buffer", err)
+	}
+	if err := cbor.Unmarshal([]byte{0x01, 0x02}, new(int)); !errors.Is(err, cbor.ErrTrailingData) {
+		t.Fatal("expected trailing data", err)
+	}
+	if err := cbor.Unmarshal([]byte{0x19, 0x01, 0x00}, new(int8)); err == nil {
+		t.Fatal("expected overflow")
+	}
+	if err := cbor.Unmarshal(bytes.Repeat([]byte{0x81}, cbor.MaxDepth+2), new(any)); !errors.Is(err, cbor.ErrMaxDepth) {
+		t.Fatal("expected max depth", err)
+	}
+	if err := cbor.Unmarshal([]byte{0x01}, s); !errors.Is(err, cbor.ErrInvalidUnmarshal) {
+		t.Fatal("expected invalid unmarshal", err)
+	}
+	// an array or a map is not a key of map[any]any.
+	for _, b := range [][]byte{{0xa1, 0x81, 0x01, 0x02}, {0xa1, 0xa1, 0x01, 0x02, 0x03}} {
+		if err := cbor.Unmarshal(b, new(map[any]any)); err == nil || !strings.Contains(err.Error(), "cannot unmarshal map key") {
+			t.Fatal("expected unhashable key", err)
+		}
+	}
+}
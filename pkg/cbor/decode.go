@@ -0,0 +1,527 @@
+package cbor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/brickingsoft/brick/pkg/internal/codec"
+)
+
+type kind int
+
+const (
+	kindNil kind = iota
+	kindBool
+	kindInt
+	kindUint
+	kindFloat
+	kindBytes
+	kindText
+	kindArray
+	kindMap
+	kindTag
+)
+
+func (k kind) String() string {
+	switch k {
+	case kindNil:
+		return "null"
+	case kindBool:
+		return "bool"
+	case kindInt, kindUint:
+		return "integer"
+	case kindFloat:
+		return "float"
+	case kindBytes:
+		return "bytes"
+	case kindText:
+		return "text"
+	case kindArray:
+		return "array"
+	case kindMap:
+		return "map"
+	default:
+		return "tag"
+	}
+}
+
+// token
+// a decoded head, data is the payload of strings, n is the length of arrays and maps, and u is the number of tags.
+// the length of indefinite arrays and maps is -1, they are ended by a break.
+type token struct {
+	kind kind
+	b    bool
+	i    int64
+	u    uint64
+	f    float64
+	n    int
+	data []byte
+}
+
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *decoder) read(n int) (b []byte, err error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		err = ErrShortBuffer
+		return
+	}
+	b = d.data[d.pos : d.pos+n]
+	d.pos += n
+	return
+}
+
+// head
+// the major type and the argument, indefinite is true when the additional info is 31.
+func (d *decoder) head() (major byte, n uint64, indefinite bool, err error) {
+	b, readErr := d.read(1)
+	if readErr != nil {
+		err = readErr
+		return
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info <= 27:
+		size := 1 << (info - 24)
+		arg, argErr := d.read(size)
+		if argErr != nil {
+			err = argErr
+			return
+		}
+		switch size {
+		case 1:
+			n = uint64(arg[0])
+		case 2:
+			n = uint64(binary.BigEndian.Uint16(arg))
+		case 4:
+			n = uint64(binary.BigEndian.Uint32(arg))
+		default:
+			n = binary.BigEndian.Uint64(arg)
+		}
+	case info == infoIndefinite && major >= majorBytes && major <= majorMap:
+		indefinite = true
+	default:
+		err = fmt.Errorf("cbor: invalid additional info %d of major type %d", info, major)
+	}
+	return
+}
+
+// length
+// the length is limited by the remaining data since each element takes one byte at least.
+func (d *decoder) length(n uint64, perElement uint64) (int, error) {
+	if n > uint64(len(d.data)-d.pos)/perElement {
+		return 0, ErrShortBuffer
+	}
+	return int(n), nil
+}
+
+// consumeBreak
+// whether the next byte is the break of an indefinite length item.
+func (d *decoder) consumeBreak() bool {
+	if d.pos < len(d.data) && d.data[d.pos] == codeBreak {
+		d.pos++
+		return true
+	}
+	return false
+}
+
+func (d *decoder) next() (t token, err error) {
+	if d.pos < len(d.data) {
+		switch d.data[d.pos] {
+		case codeFalse, codeTrue:
+			t.kind, t.b = kindBool, d.data[d.pos] == codeTrue
+			d.pos++
+			return
+		case codeNull, codeUndefined:
+			t.kind = kindNil
+			d.pos++
+			return
+		case codeFloat16, codeFloat32, codeFloat64:
+			size := 2 << (d.data[d.pos] - codeFloat16)
+			d.pos++
+			b, readErr := d.read(size)
+			if readErr != nil {
+				err = readErr
+				return
+			}
+			t.kind = kindFloat
+			switch size {
+			case 2:
+				t.f = float16(binary.BigEndian.Uint16(b))
+			case 4:
+				t.f = float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+			default:
+				t.f = math.Float64frombits(binary.BigEndian.Uint64(b))
+			}
+			return
+		default:
+			break
+		}
+	}
+	major, n, indefinite, headErr := d.head()
+	if headErr != nil {
+		err = headErr
+		return
+	}
+	switch major {
+	case majorUint:
+		t.kind, t.u = kindUint, n
+	case majorNint:
+		if n > math.MaxInt64 {
+			err = errors.New("cbor: negative integer overflows int64")
+			return
+		}
+		t.kind, t.i = kindInt, -1-int64(n)
+	case majorBytes, majorText:
+		t.kind = kindBytes
+		if major == majorText {
+			t.kind = kindText
+		}
+		if indefinite {
+			t.data, err = d.chunks(major)
+			return
+		}
+		size, lenErr := d.length(n, 1)
+		if lenErr != nil {
+			err = lenErr
+			return
+		}
+		t.data, err = d.read(size)
+	case majorArray, majorMap:
+		t.kind = kindArray
+		perElement := uint64(1)
+		if major == majorMap {
+			t.kind, perElement = kindMap, 2
+		}
+		if indefinite {
+			t.n = -1
+			return
+		}
+		t.n, err = d.length(n, perElement)
+	case majorTag:
+		t.kind, t.u = kindTag, n
+	default:
+		err = fmt.Errorf("cbor: unsupported simple value %d", n)
+	}
+	return
+}
+
+// chunks
+// concatenate the definite chunks of an indefinite string.
+func (d *decoder) chunks(major byte) (data []byte, err error) {
+	data = make([]byte, 0, 16)
+	for !d.consumeBreak() {
+		chunkMajor, n, indefinite, headErr := d.head()
+		if headErr != nil {
+			err = headErr
+			return
+		}
+		if chunkMajor != major || indefinite {
+			err = errors.New("cbor: invalid chunk of indefinite string")
+			return
+		}
+		size, lenErr := d.length(n, 1)
+		if lenErr != nil {
+			err = lenErr
+			return
+		}
+		chunk, readErr := d.read(size)
+		if readErr != nil {
+			err = readErr
+			return
+		}
+		data = append(data, chunk...)
+	}
+	return
+}
+
+// more
+// whether the container has the i-th item, n is -1 when it is indefinite.
+func (d *decoder) more(i int, n int) bool {
+	if n < 0 {
+		return !d.consumeBreak()
+	}
+	return i < n
+}
+
+// skip
+// skip the next item.
+func (d *decoder) skip(depth int) (err error) {
+	if depth > MaxDepth {
+		err = ErrMaxDepth
+		return
+	}
+	t, nextErr := d.next()
+	if nextErr != nil {
+		err = nextErr
+		return
+	}
+	switch t.kind {
+	case kindArray, kindMap:
+		for i := 0; d.more(i, t.n); i++ {
+			if err = d.skip(depth + 1); err != nil {
+				return
+			}
+			if t.kind == kindMap {
+				if err = d.skip(depth + 1); err != nil {
+					return
+				}
+			}
+		}
+	case kindTag:
+		err = d.skip(depth + 1)
+	default:
+		break
+	}
+	return
+}
+
+func (d *decoder) decode(v reflect.Value, depth int) (err error) {
+	if depth > MaxDepth {
+		err = ErrMaxDepth
+		return
+	}
+	if d.pos < len(d.data) && (d.data[d.pos] == codeNull || d.data[d.pos] == codeUndefined) {
+		d.pos++
+		switch v.Kind() {
+		case reflect.Interface, reflect.Pointer, reflect.Map, reflect.Slice:
+			codec.SetNil(v)
+		default:
+			break
+		}
+		return
+	}
+	v, u := codec.IndirectTo(v, unmarshalerType)
+	if u != nil {
+		start := d.pos
+		if err = d.skip(depth); err != nil {
+			return
+		}
+		err = u.(Unmarshaler).UnmarshalCBOR(d.data[start:d.pos])
+		return
+	}
+	t, nextErr := d.next()
+	if nextErr != nil {
+		err = nextErr
+		return
+	}
+	switch t.kind {
+	case kindBool:
+		err = codec.SetBool(v, t.b)
+	case kindInt:
+		err = codec.SetInt(v, t.i)
+	case kindUint:
+		err = codec.SetUint(v, t.u)
+	case kindFloat:
+		err = codec.SetFloat(v, t.f)
+	case kindText:
+		err = codec.SetString(v, string(t.data))
+	case kindBytes:
+		err = codec.SetBytes(v, t.data)
+	case kindArray:
+		err = d.decodeArray(v, t.n, depth)
+	case kindMap:
+		err = d.decodeMap(v, t.n, depth)
+	case kindTag:
+		err = d.decodeTag(v, t.u, depth)
+	default:
+		break
+	}
+	return
+}
+
+// decodeTag
+// date times are decoded into time.Time and empty interfaces, the content of other tags is decoded as it is.
+func (d *decoder) decodeTag(v reflect.Value, tag uint64, depth int) (err error) {
+	if (tag != tagDateTime && tag != tagEpochTime) || (v.Type() != codec.TimeType && !codec.IsAny(v)) {
+		return d.decode(v, depth+1)
+	}
+	t, nextErr := d.next()
+	if nextErr != nil {
+		err = nextErr
+		return
+	}
+	var at time.Time
+	switch {
+	case tag == tagDateTime && t.kind == kindText:
+		if at, err = time.Parse(time.RFC3339Nano, string(t.data)); err != nil {
+			err = fmt.Errorf("cbor: invalid date time: %w", err)
+			return
+		}
+	case tag == tagEpochTime && t.kind == kindUint && t.u <= math.MaxInt64:
+		at = time.Unix(int64(t.u), 0).UTC()
+	case tag == tagEpochTime && t.kind == kindInt:
+		at = time.Unix(t.i, 0).UTC()
+	case tag == tagEpochTime && t.kind == kindFloat && !math.IsNaN(t.f) && !math.IsInf(t.f, 0):
+		sec, frac := math.Modf(t.f)
+		at = time.Unix(int64(sec), int64(frac*1e9)).UTC()
+	default:
+		err = fmt.Errorf("cbor: invalid %s of date time tag %d", t.kind, tag)
+		return
+	}
+	err = codec.SetTime(v, at)
+	return
+}
+
+func (d *decoder) decodeArray(v reflect.Value, n int, depth int) (err error) {
+	switch {
+	case v.Kind() == reflect.Slice:
+		size := max(n, 0)
+		if v.IsNil() || v.Cap() < size {
+			v.Set(reflect.MakeSlice(v.Type(), size, size))
+		} else {
+			v.SetLen(size)
+		}
+		for i := 0; d.more(i, n); i++ {
+			if i >= v.Len() {
+				v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+			}
+			if err = d.decode(v.Index(i), depth+1); err != nil {
+				return
+			}
+		}
+	case v.Kind() == reflect.Array:
+		i := 0
+		for ; d.more(i, n); i++ {
+			if i < v.Len() {
+				err = d.decode(v.Index(i), depth+1)
+			} else {
+				err = d.skip(depth + 1)
+			}
+			if err != nil {
+				return
+			}
+		}
+		for ; i < v.Len(); i++ {
+			codec.SetNil(v.Index(i))
+		}
+	case codec.IsAny(v):
+		values := make([]any, 0, max(n, 0))
+		for i := 0; d.more(i, n); i++ {
+			values = append(values, nil)
+			if err = d.decode(reflect.ValueOf(&values[i]).Elem(), depth+1); err != nil {
+				return
+			}
+		}
+		v.Set(reflect.ValueOf(values))
+	default:
+		err = &codec.TypeError{Value: "array", Type: v.Type()}
+	}
+	return
+}
+
+func (d *decoder) decodeMap(v reflect.Value, n int, depth int) (err error) {
+	switch {
+	case v.Kind() == reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(v.Type(), max(n, 0)))
+		}
+		kt, vt := v.Type().Key(), v.Type().Elem()
+		for i := 0; d.more(i, n); i++ {
+			key := reflect.New(kt).Elem()
+			if err = d.decode(key, depth+1); err != nil {
+				return
+			}
+			if !key.Comparable() {
+				// an array or a map is decoded into a key of interface type.
+				err = &codec.TypeError{Value: "map key " + key.Elem().Type().String(), Type: v.Type()}
+				return
+			}
+			value := reflect.New(vt).Elem()
+			if err = d.decode(value, depth+1); err != nil {
+				return
+			}
+			v.SetMapIndex(key, value)
+		}
+	case v.Kind() == reflect.Struct:
+		fields := codec.StructFields(v.Type(), tags...)
+		for i := 0; d.more(i, n); i++ {
+			t, nextErr := d.next()
+			if nextErr != nil {
+				err = nextErr
+				return
+			}
+			if t.kind != kindText && t.kind != kindBytes {
+				err = &codec.TypeError{Value: "map key " + t.kind.String(), Type: v.Type()}
+				return
+			}
+			var fv reflect.Value
+			if field, has := fields.Lookup(string(t.data)); has {
+				fv, _ = codec.FieldByIndex(v, field.Index, true)
+			}
+			if !fv.IsValid() {
+				if err = d.skip(depth + 1); err != nil {
+					return
+				}
+				continue
+			}
+			if err = d.decode(fv, depth+1); err != nil {
+				return
+			}
+		}
+	case codec.IsAny(v):
+		keys, values := make([]any, 0, max(n, 0)), make([]any, 0, max(n, 0))
+		strKeys := true
+		for i := 0; d.more(i, n); i++ {
+			keys, values = append(keys, nil), append(values, nil)
+			if err = d.decode(reflect.ValueOf(&keys[i]).Elem(), depth+1); err != nil {
+				return
+			}
+			if _, ok := keys[i].(string); !ok {
+				strKeys = false
+			}
+			if err = d.decode(reflect.ValueOf(&values[i]).Elem(), depth+1); err != nil {
+				return
+			}
+		}
+		if strKeys {
+			m := make(map[string]any, len(keys))
+			for i, key := range keys {
+				m[key.(string)] = values[i]
+			}
+			v.Set(reflect.ValueOf(m))
+			return
+		}
+		m := make(map[any]any, len(keys))
+		for i, key := range keys {
+			if key != nil && !reflect.TypeOf(key).Comparable() {
+				err = &codec.TypeError{Value: "map key " + reflect.TypeOf(key).String(), Type: v.Type()}
+				return
+			}
+			m[key] = values[i]
+		}
+		v.Set(reflect.ValueOf(m))
+	default:
+		err = &codec.TypeError{Value: "map", Type: v.Type()}
+	}
+	return
+}
+
+// float16
+// the value of a half precision float.
+func float16(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1.0
+	}
+	exp, mant := int(h>>10&0x1f), float64(h&0x3ff)
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(mant, -24)
+	case 0x1f:
+		if mant == 0 {
+			return math.Inf(int(sign))
+		}
+		return math.NaN()
+	default:
+		return sign * math.Ldexp(mant+1024, exp-25)
+	}
+}
@@ -0,0 +1,229 @@
+package cbor
+
+import (
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/brickingsoft/brick/pkg/internal/codec"
+)
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) encode(v reflect.Value, depth int) (err error) {
+	if depth > MaxDepth {
+		err = ErrMaxDepth
+		return
+	}
+	if !v.IsValid() {
+		e.buf = append(e.buf, codeNull)
+		return
+	}
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			e.buf = append(e.buf, codeNull)
+			return
+		}
+		return e.encode(v.Elem(), depth+1)
+	}
+	if m, ok := codec.Implemented(v, marshalerType); ok {
+		b, marshalErr := m.(Marshaler).MarshalCBOR()
+		if marshalErr != nil {
+			err = fmt.Errorf("cbor: marshal %s failed: %w", v.Type(), marshalErr)
+			return
+		}
+		e.buf = append(e.buf, b...)
+		return
+	}
+	if v.Type() == codec.TimeType {
+		e.writeHead(majorTag, tagDateTime)
+		e.writeString(v.Interface().(time.Time).Format(time.RFC3339Nano))
+		return
+	}
+	if m, ok := codec.Implemented(v, codec.TextMarshalerType); ok {
+		text, marshalErr := m.(encoding.TextMarshaler).MarshalText()
+		if marshalErr != nil {
+			err = fmt.Errorf("cbor: marshal %s failed: %w", v.Type(), marshalErr)
+			return
+		}
+		e.writeString(string(text))
+		return
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, codeTrue)
+		} else {
+			e.buf = append(e.buf, codeFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n := v.Int(); n < 0 {
+			e.writeHead(majorNint, uint64(^n))
+		} else {
+			e.writeHead(majorUint, uint64(n))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.writeHead(majorUint, v.Uint())
+	case reflect.Float32:
+		e.buf = append(e.buf, codeFloat32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.buf = append(e.buf, codeFloat64)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.writeString(v.String())
+	case reflect.Pointer:
+		if v.IsNil() {
+			e.buf = append(e.buf, codeNull)
+			return
+		}
+		return e.encode(v.Elem(), depth+1)
+	case reflect.Slice:
+		if v.IsNil() {
+			e.buf = append(e.buf, codeNull)
+			return
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.writeBytes(v.Bytes())
+			return
+		}
+		return e.encodeArray(v, depth)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			e.writeBytes(b)
+			return
+		}
+		return e.encodeArray(v, depth)
+	case reflect.Map:
+		if v.IsNil() {
+			e.buf = append(e.buf, codeNull)
+			return
+		}
+		return e.encodeMap(v, depth)
+	case reflect.Struct:
+		return e.encodeStruct(v, depth)
+	default:
+		err = fmt.Errorf("cbor: unsupported type %s", v.Type())
+	}
+	return
+}
+
+func (e *encoder) encodeArray(v reflect.Value, depth int) (err error) {
+	n := v.Len()
+	e.writeHead(majorArray, uint64(n))
+	for i := 0; i < n; i++ {
+		if err = e.encode(v.Index(i), depth+1); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (e *encoder) encodeMap(v reflect.Value, depth int) (err error) {
+	keys := v.MapKeys()
+	sortKeys(keys)
+	e.writeHead(majorMap, uint64(len(keys)))
+	for _, key := range keys {
+		if err = e.encode(key, depth+1); err != nil {
+			return
+		}
+		if err = e.encode(v.MapIndex(key), depth+1); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (e *encoder) encodeStruct(v reflect.Value, depth int) (err error) {
+	fields := codec.StructFields(v.Type(), tags...)
+	values := make([]reflect.Value, len(fields.List))
+	n := 0
+	for i, field := range fields.List {
+		fv, ok := codec.FieldByIndex(v, field.Index, false)
+		if !ok || (field.OmitEmpty && codec.IsEmpty(fv)) {
+			continue
+		}
+		values[i] = fv
+		n++
+	}
+	e.writeHead(majorMap, uint64(n))
+	for i, field := range fields.List {
+		if !values[i].IsValid() {
+			continue
+		}
+		e.writeString(field.Name)
+		if err = e.encode(values[i], depth+1); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// sortKeys
+// keys of strings and numbers are sorted, so the output is deterministic.
+func sortKeys(keys []reflect.Value) {
+	if len(keys) < 2 {
+		return
+	}
+	switch keys[0].Kind() {
+	case reflect.String:
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return strings.Compare(a.String(), b.String()) })
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return compare(a.Int(), b.Int()) })
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return compare(a.Uint(), b.Uint()) })
+	default:
+		break
+	}
+}
+
+func compare[T int64 | uint64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// writeHead
+// the major type and the argument in the shortest form.
+func (e *encoder) writeHead(major byte, n uint64) {
+	major <<= 5
+	switch {
+	case n < 24:
+		e.buf = append(e.buf, major|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, major|24, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, major|25)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	case n <= math.MaxUint32:
+		e.buf = append(e.buf, major|26)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	default:
+		e.buf = append(e.buf, major|27)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, n)
+	}
+}
+
+func (e *encoder) writeString(s string) {
+	e.writeHead(majorText, uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) writeBytes(b []byte) {
+	e.writeHead(majorBytes, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
@@ -0,0 +1,182 @@
+package codec
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Field
+// an encoded field of a struct, the index is the path of embedded structs.
+type Field struct {
+	Name      string
+	Index     []int
+	Type      reflect.Type
+	OmitEmpty bool
+}
+
+// Fields
+// the encoded fields of a struct in declaration order.
+type Fields struct {
+	List   []Field
+	byName map[string]int
+	byFold map[string]int
+}
+
+// Lookup
+// the field of the name, the case-insensitive match is used when the exact one is missing.
+func (fields *Fields) Lookup(name string) (field *Field, ok bool) {
+	i, has := fields.byName[name]
+	if !has {
+		if i, has = fields.byFold[strings.ToLower(name)]; !has {
+			return
+		}
+	}
+	field, ok = &fields.List[i], true
+	return
+}
+
+type fieldsKey struct {
+	typ  reflect.Type
+	tags string
+}
+
+var fieldsCache sync.Map
+
+// StructFields
+// the encoded fields of the struct type, names are taken from the first present tag of tags,
+// e.g. `msgpack`, `json` then `yaml`, and the name of the go field is used when the tag has no name.
+// a field whose name is `-` is skipped, and fields of embedded structs without names are promoted.
+func StructFields(typ reflect.Type, tags ...string) *Fields {
+	key := fieldsKey{typ: typ, tags: strings.Join(tags, ",")}
+	if cached, ok := fieldsCache.Load(key); ok {
+		return cached.(*Fields)
+	}
+	fields := &Fields{
+		byName: make(map[string]int),
+		byFold: make(map[string]int),
+	}
+	depths := make(map[string]int)
+	collectFields(fields, depths, typ, nil, tags, make(map[reflect.Type]bool))
+	cached, _ := fieldsCache.LoadOrStore(key, fields)
+	return cached.(*Fields)
+}
+
+func collectFields(fields *Fields, depths map[string]int, typ reflect.Type, index []int, tags []string, visited map[reflect.Type]bool) {
+	if visited[typ] {
+		return
+	}
+	visited[typ] = true
+	defer delete(visited, typ)
+
+	var embedded []reflect.StructField
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		name, omitEmpty, tagged := fieldTag(sf, tags)
+		if name == "-" {
+			continue
+		}
+		if sf.Anonymous && !tagged {
+			ft := sf.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				// promoted fields are collected after the fields of this level, so they are shadowed by them.
+				embedded = append(embedded, sf)
+				continue
+			}
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		addField(fields, depths, Field{
+			Name:      name,
+			Index:     append(append([]int(nil), index...), i),
+			Type:      sf.Type,
+			OmitEmpty: omitEmpty,
+		})
+	}
+	for _, sf := range embedded {
+		ft := sf.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		collectFields(fields, depths, ft, append(append([]int(nil), index...), sf.Index...), tags, visited)
+	}
+}
+
+func addField(fields *Fields, depths map[string]int, field Field) {
+	if depth, has := depths[field.Name]; has && depth <= len(field.Index) {
+		return
+	}
+	depths[field.Name] = len(field.Index)
+	if i, has := fields.byName[field.Name]; has {
+		fields.List[i] = field
+		return
+	}
+	fields.List = append(fields.List, field)
+	fields.byName[field.Name] = len(fields.List) - 1
+	if _, has := fields.byFold[strings.ToLower(field.Name)]; !has {
+		fields.byFold[strings.ToLower(field.Name)] = len(fields.List) - 1
+	}
+}
+
+// fieldTag
+// the name and options of the first present tag.
+func fieldTag(sf reflect.StructField, tags []string) (name string, omitEmpty bool, tagged bool) {
+	for _, tag := range tags {
+		value, has := sf.Tag.Lookup(tag)
+		if !has {
+			continue
+		}
+		name, options, _ := strings.Cut(value, ",")
+		for _, option := range strings.Split(options, ",") {
+			if option == "omitempty" || option == "omitzero" {
+				omitEmpty = true
+			}
+		}
+		tagged = name != ""
+		return name, omitEmpty, tagged
+	}
+	return
+}
+
+// FieldByIndex
+// the field of the struct value, embedded nil pointers are allocated when alloc is true, otherwise ok is false.
+func FieldByIndex(v reflect.Value, index []int, alloc bool) (field reflect.Value, ok bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				if !alloc || !v.CanSet() {
+					return
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	field, ok = v, true
+	return
+}
+
+// IsEmpty
+// whether the value is omitted by omitempty.
+func IsEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Pointer, reflect.Struct:
+		return v.IsZero()
+	default:
+		return false
+	}
+}
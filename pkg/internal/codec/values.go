@@ -0,0 +1,218 @@
+package codec
+
+import (
+	"encoding"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+var (
+	TimeType            = reflect.TypeFor[time.Time]()
+	TextMarshalerType   = reflect.TypeFor[encoding.TextMarshaler]()
+	TextUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// TypeError
+// the encoded value can not be stored in the go value.
+type TypeError struct {
+	Value string
+	Type  reflect.Type
+}
+
+func (err *TypeError) Error() string {
+	return fmt.Sprintf("cannot unmarshal %s into go value of type %s", err.Value, err.Type)
+}
+
+// IsAny
+// whether the value is an empty interface, decoded values are stored as they are.
+func IsAny(v reflect.Value) bool {
+	return v.Kind() == reflect.Interface && v.NumMethod() == 0
+}
+
+// Indirect
+// follow pointers and allocate nil ones, it stops at empty interfaces.
+func Indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// IndirectTo
+// follow pointers like Indirect, u is the first value whose pointer implements the interface type, e.g. an unmarshaler.
+func IndirectTo(v reflect.Value, iface reflect.Type) (elem reflect.Value, u any) {
+	for {
+		if v.Kind() != reflect.Pointer {
+			if v.CanAddr() && v.Addr().Type().Implements(iface) {
+				u = v.Addr().Interface()
+			}
+			elem = v
+			return
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+}
+
+// Implemented
+// the value as the interface type, e.g. a marshaler, nil pointers do not implement any.
+func Implemented(v reflect.Value, iface reflect.Type) (u any, ok bool) {
+	if v.Kind() == reflect.Pointer && v.IsNil() {
+		return
+	}
+	if v.Type().Implements(iface) {
+		u, ok = v.Interface(), true
+		return
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(iface) {
+		u, ok = v.Addr().Interface(), true
+	}
+	return
+}
+
+// SetNil
+// set the zero value.
+func SetNil(v reflect.Value) {
+	v.Set(reflect.Zero(v.Type()))
+}
+
+func SetBool(v reflect.Value, b bool) (err error) {
+	switch {
+	case v.Kind() == reflect.Bool:
+		v.SetBool(b)
+	case IsAny(v):
+		v.Set(reflect.ValueOf(b))
+	default:
+		err = &TypeError{Value: "bool", Type: v.Type()}
+	}
+	return
+}
+
+func SetInt(v reflect.Value, n int64) (err error) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.OverflowInt(n) {
+			err = &TypeError{Value: fmt.Sprintf("number %d", n), Type: v.Type()}
+			return
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n < 0 || v.OverflowUint(uint64(n)) {
+			err = &TypeError{Value: fmt.Sprintf("number %d", n), Type: v.Type()}
+			return
+		}
+		v.SetUint(uint64(n))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(float64(n))
+	default:
+		if IsAny(v) {
+			v.Set(reflect.ValueOf(n))
+			return
+		}
+		err = &TypeError{Value: "number", Type: v.Type()}
+	}
+	return
+}
+
+func SetUint(v reflect.Value, n uint64) (err error) {
+	if n <= math.MaxInt64 {
+		return SetInt(v, int64(n))
+	}
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		if v.OverflowUint(n) {
+			err = &TypeError{Value: fmt.Sprintf("number %d", n), Type: v.Type()}
+			return
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(float64(n))
+	default:
+		if IsAny(v) {
+			v.Set(reflect.ValueOf(n))
+			return
+		}
+		err = &TypeError{Value: fmt.Sprintf("number %d", n), Type: v.Type()}
+	}
+	return
+}
+
+func SetFloat(v reflect.Value, f float64) (err error) {
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		if v.OverflowFloat(f) {
+			err = &TypeError{Value: fmt.Sprintf("number %v", f), Type: v.Type()}
+			return
+		}
+		v.SetFloat(f)
+	default:
+		if IsAny(v) {
+			v.Set(reflect.ValueOf(f))
+			return
+		}
+		err = &TypeError{Value: "float", Type: v.Type()}
+	}
+	return
+}
+
+// SetString
+// strings are stored in strings, byte slices and encoding.TextUnmarshaler values.
+func SetString(v reflect.Value, s string) (err error) {
+	if v.CanAddr() && v.Addr().Type().Implements(TextUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(s)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		v.SetBytes([]byte(s))
+	case IsAny(v):
+		v.Set(reflect.ValueOf(s))
+	default:
+		err = &TypeError{Value: "string", Type: v.Type()}
+	}
+	return
+}
+
+// SetBytes
+// bytes are copied into byte slices, byte arrays and strings.
+func SetBytes(v reflect.Value, b []byte) (err error) {
+	switch {
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		v.SetBytes(append([]byte(nil), b...))
+	case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+		if v.Len() != len(b) {
+			err = &TypeError{Value: fmt.Sprintf("%d bytes", len(b)), Type: v.Type()}
+			return
+		}
+		reflect.Copy(v, reflect.ValueOf(b))
+	case v.Kind() == reflect.String:
+		v.SetString(string(b))
+	case IsAny(v):
+		v.Set(reflect.ValueOf(append([]byte(nil), b...)))
+	default:
+		err = &TypeError{Value: "bytes", Type: v.Type()}
+	}
+	return
+}
+
+// SetTime
+// times are stored in time.Time values.
+func SetTime(v reflect.Value, t time.Time) (err error) {
+	switch {
+	case v.Type() == TimeType:
+		v.Set(reflect.ValueOf(t))
+	case IsAny(v):
+		v.Set(reflect.ValueOf(t))
+	default:
+		err = &TypeError{Value: "time", Type: v.Type()}
+	}
+	return
+}
@@ -0,0 +1,446 @@
+package msgpack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/brickingsoft/brick/pkg/internal/codec"
+)
+
+type kind int
+
+const (
+	kindNil kind = iota
+	kindBool
+	kindInt
+	kindUint
+	kindFloat
+	kindStr
+	kindBin
+	kindExt
+	kindArray
+	kindMap
+)
+
+func (k kind) String() string {
+	switch k {
+	case kindNil:
+		return "nil"
+	case kindBool:
+		return "bool"
+	case kindInt, kindUint:
+		return "integer"
+	case kindFloat:
+		return "float"
+	case kindStr:
+		return "string"
+	case kindBin:
+		return "binary"
+	case kindExt:
+		return "extension"
+	case kindArray:
+		return "array"
+	default:
+		return "map"
+	}
+}
+
+// token
+// a decoded header, data is the payload of strings, binaries and extensions, n is the length of arrays and maps.
+type token struct {
+	kind kind
+	b    bool
+	i    int64
+	u    uint64
+	f    float64
+	n    int
+	ext  int8
+	data []byte
+}
+
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *decoder) read(n int) (b []byte, err error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		err = ErrShortBuffer
+		return
+	}
+	b = d.data[d.pos : d.pos+n]
+	d.pos += n
+	return
+}
+
+func (d *decoder) readUint(size int) (n uint64, err error) {
+	b, readErr := d.read(size)
+	if readErr != nil {
+		err = readErr
+		return
+	}
+	switch size {
+	case 1:
+		n = uint64(b[0])
+	case 2:
+		n = uint64(binary.BigEndian.Uint16(b))
+	case 4:
+		n = uint64(binary.BigEndian.Uint32(b))
+	default:
+		n = binary.BigEndian.Uint64(b)
+	}
+	return
+}
+
+// length
+// the length of a container, it is limited by the remaining data since each element takes one byte at least.
+func (d *decoder) length(size int, perElement int) (n int, err error) {
+	u, readErr := d.readUint(size)
+	if readErr != nil {
+		err = readErr
+		return
+	}
+	if u > uint64(len(d.data)-d.pos)/uint64(perElement) {
+		err = ErrShortBuffer
+		return
+	}
+	n = int(u)
+	return
+}
+
+func (d *decoder) next() (t token, err error) {
+	code, readErr := d.read(1)
+	if readErr != nil {
+		err = readErr
+		return
+	}
+	c := code[0]
+	size := 0
+	switch {
+	case c <= 0x7f:
+		t.kind, t.i = kindInt, int64(c)
+		return
+	case c >= 0xe0:
+		t.kind, t.i = kindInt, int64(int8(c))
+		return
+	case c&0xf0 == 0x80:
+		t.kind, t.n = kindMap, int(c&0x0f)
+		return
+	case c&0xf0 == 0x90:
+		t.kind, t.n = kindArray, int(c&0x0f)
+		return
+	case c&0xe0 == 0xa0:
+		t.kind = kindStr
+		t.data, err = d.read(int(c & 0x1f))
+		return
+	}
+	switch c {
+	case codeNil:
+		t.kind = kindNil
+	case codeFalse, codeTrue:
+		t.kind, t.b = kindBool, c == codeTrue
+	case codeUint8, codeUint16, codeUint32, codeUint64:
+		t.kind = kindUint
+		t.u, err = d.readUint(1 << (c - codeUint8))
+	case codeInt8, codeInt16, codeInt32, codeInt64:
+		size = 1 << (c - codeInt8)
+		u, readErr := d.readUint(size)
+		if readErr != nil {
+			err = readErr
+			return
+		}
+		t.kind = kindInt
+		switch size {
+		case 1:
+			t.i = int64(int8(u))
+		case 2:
+			t.i = int64(int16(u))
+		case 4:
+			t.i = int64(int32(u))
+		default:
+			t.i = int64(u)
+		}
+	case codeFloat32:
+		u, readErr := d.readUint(4)
+		t.kind, t.f, err = kindFloat, float64(math.Float32frombits(uint32(u))), readErr
+	case codeFloat64:
+		u, readErr := d.readUint(8)
+		t.kind, t.f, err = kindFloat, math.Float64frombits(u), readErr
+	case codeStr8, codeStr16, codeStr32, codeBin8, codeBin16, codeBin32:
+		t.kind = kindStr
+		size = 1 << (c - codeStr8)
+		if c <= codeBin32 {
+			t.kind = kindBin
+			size = 1 << (c - codeBin8)
+		}
+		n, lenErr := d.length(size, 1)
+		if lenErr != nil {
+			err = lenErr
+			return
+		}
+		t.data, err = d.read(n)
+	case codeArray16, codeArray32:
+		t.kind = kindArray
+		t.n, err = d.length(2<<(c-codeArray16), 1)
+	case codeMap16, codeMap32:
+		t.kind = kindMap
+		t.n, err = d.length(2<<(c-codeMap16), 2)
+	case codeFixExt1, codeFixExt2, codeFixExt4, codeFixExt8, codeFixExt16:
+		err = d.readExt(&t, 1<<(c-codeFixExt1))
+	case codeExt8, codeExt16, codeExt32:
+		n, lenErr := d.length(1<<(c-codeExt8), 1)
+		if lenErr != nil {
+			err = lenErr
+			return
+		}
+		err = d.readExt(&t, n)
+	default:
+		err = fmt.Errorf("msgpack: invalid code 0x%x", c)
+	}
+	return
+}
+
+func (d *decoder) readExt(t *token, n int) (err error) {
+	typ, readErr := d.read(1)
+	if readErr != nil {
+		err = readErr
+		return
+	}
+	t.kind, t.ext = kindExt, int8(typ[0])
+	t.data, err = d.read(n)
+	return
+}
+
+// skip
+// skip the next value.
+func (d *decoder) skip(depth int) (err error) {
+	if depth > MaxDepth {
+		err = ErrMaxDepth
+		return
+	}
+	t, nextErr := d.next()
+	if nextErr != nil {
+		err = nextErr
+		return
+	}
+	n := t.n
+	if t.kind == kindMap {
+		n *= 2
+	} else if t.kind != kindArray {
+		n = 0
+	}
+	for i := 0; i < n; i++ {
+		if err = d.skip(depth + 1); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (d *decoder) decode(v reflect.Value, depth int) (err error) {
+	if depth > MaxDepth {
+		err = ErrMaxDepth
+		return
+	}
+	if d.pos < len(d.data) && d.data[d.pos] == codeNil {
+		d.pos++
+		switch v.Kind() {
+		case reflect.Interface, reflect.Pointer, reflect.Map, reflect.Slice:
+			codec.SetNil(v)
+		default:
+			break
+		}
+		return
+	}
+	v, u := codec.IndirectTo(v, unmarshalerType)
+	if u != nil {
+		start := d.pos
+		if err = d.skip(depth); err != nil {
+			return
+		}
+		err = u.(Unmarshaler).UnmarshalMsgpack(d.data[start:d.pos])
+		return
+	}
+	t, nextErr := d.next()
+	if nextErr != nil {
+		err = nextErr
+		return
+	}
+	switch t.kind {
+	case kindBool:
+		err = codec.SetBool(v, t.b)
+	case kindInt:
+		err = codec.SetInt(v, t.i)
+	case kindUint:
+		err = codec.SetUint(v, t.u)
+	case kindFloat:
+		err = codec.SetFloat(v, t.f)
+	case kindStr:
+		err = codec.SetString(v, string(t.data))
+	case kindBin:
+		err = codec.SetBytes(v, t.data)
+	case kindExt:
+		err = d.decodeExt(v, t)
+	case kindArray:
+		err = d.decodeArray(v, t.n, depth)
+	case kindMap:
+		err = d.decodeMap(v, t.n, depth)
+	default:
+		break
+	}
+	return
+}
+
+func (d *decoder) decodeExt(v reflect.Value, t token) (err error) {
+	if t.ext != timestampExt {
+		err = fmt.Errorf("msgpack: unsupported extension type %d", t.ext)
+		return
+	}
+	var sec, nsec int64
+	switch len(t.data) {
+	case 4:
+		sec = int64(binary.BigEndian.Uint32(t.data))
+	case 8:
+		u := binary.BigEndian.Uint64(t.data)
+		sec, nsec = int64(u&(1<<34-1)), int64(u>>34)
+	case 12:
+		nsec, sec = int64(binary.BigEndian.Uint32(t.data)), int64(binary.BigEndian.Uint64(t.data[4:]))
+	default:
+		err = errors.New("msgpack: invalid timestamp")
+		return
+	}
+	err = codec.SetTime(v, time.Unix(sec, nsec).UTC())
+	return
+}
+
+func (d *decoder) decodeArray(v reflect.Value, n int, depth int) (err error) {
+	switch {
+	case v.Kind() == reflect.Slice:
+		if v.IsNil() || v.Cap() < n {
+			v.Set(reflect.MakeSlice(v.Type(), n, n))
+		} else {
+			v.SetLen(n)
+		}
+		for i := 0; i < n; i++ {
+			if err = d.decode(v.Index(i), depth+1); err != nil {
+				return
+			}
+		}
+	case v.Kind() == reflect.Array:
+		for i := 0; i < n; i++ {
+			if i < v.Len() {
+				err = d.decode(v.Index(i), depth+1)
+			} else {
+				err = d.skip(depth + 1)
+			}
+			if err != nil {
+				return
+			}
+		}
+		for i := n; i < v.Len(); i++ {
+			codec.SetNil(v.Index(i))
+		}
+	case codec.IsAny(v):
+		values := make([]any, n)
+		rv := reflect.ValueOf(values)
+		for i := 0; i < n; i++ {
+			if err = d.decode(rv.Index(i), depth+1); err != nil {
+				return
+			}
+		}
+		v.Set(rv)
+	default:
+		err = &codec.TypeError{Value: "array", Type: v.Type()}
+	}
+	return
+}
+
+func (d *decoder) decodeMap(v reflect.Value, n int, depth int) (err error) {
+	switch {
+	case v.Kind() == reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(v.Type(), n))
+		}
+		kt, vt := v.Type().Key(), v.Type().Elem()
+		for i := 0; i < n; i++ {
+			key := reflect.New(kt).Elem()
+			if err = d.decode(key, depth+1); err != nil {
+				return
+			}
+			if !key.Comparable() {
+				// an array or a map is decoded into a key of interface type.
+				err = &codec.TypeError{Value: "map key " + key.Elem().Type().String(), Type: v.Type()}
+				return
+			}
+			value := reflect.New(vt).Elem()
+			if err = d.decode(value, depth+1); err != nil {
+				return
+			}
+			v.SetMapIndex(key, value)
+		}
+	case v.Kind() == reflect.Struct:
+		fields := codec.StructFields(v.Type(), tags...)
+		for i := 0; i < n; i++ {
+			t, nextErr := d.next()
+			if nextErr != nil {
+				err = nextErr
+				return
+			}
+			if t.kind != kindStr && t.kind != kindBin {
+				err = &codec.TypeError{Value: "map key " + t.kind.String(), Type: v.Type()}
+				return
+			}
+			var fv reflect.Value
+			if field, has := fields.Lookup(string(t.data)); has {
+				fv, _ = codec.FieldByIndex(v, field.Index, true)
+			}
+			if !fv.IsValid() {
+				if err = d.skip(depth + 1); err != nil {
+					return
+				}
+				continue
+			}
+			if err = d.decode(fv, depth+1); err != nil {
+				return
+			}
+		}
+	case codec.IsAny(v):
+		keys, values := make([]any, n), make([]any, n)
+		strKeys := true
+		for i := 0; i < n; i++ {
+			if err = d.decode(reflect.ValueOf(&keys[i]).Elem(), depth+1); err != nil {
+				return
+			}
+			if _, ok := keys[i].(string); !ok {
+				strKeys = false
+			}
+			if err = d.decode(reflect.ValueOf(&values[i]).Elem(), depth+1); err != nil {
+				return
+			}
+		}
+		if strKeys {
+			m := make(map[string]any, n)
+			for i, key := range keys {
+				m[key.(string)] = values[i]
+			}
+			v.Set(reflect.ValueOf(m))
+			return
+		}
+		m := make(map[any]any, n)
+		for i, key := range keys {
+			if key != nil && !reflect.TypeOf(key).Comparable() {
+				err = &codec.TypeError{Value: "map key " + reflect.TypeOf(key).String(), Type: v.Type()}
+				return
+			}
+			m[key] = values[i]
+		}
+		v.Set(reflect.ValueOf(m))
+	default:
+		err = &codec.TypeError{Value: "map", Type: v.Type()}
+	}
+	return
+}
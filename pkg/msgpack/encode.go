@@ -0,0 +1,307 @@
+package msgpack
+
+import (
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/brickingsoft/brick/pkg/internal/codec"
+)
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) encode(v reflect.Value, depth int) (err error) {
+	if depth > MaxDepth {
+		err = ErrMaxDepth
+		return
+	}
+	if !v.IsValid() {
+		e.buf = append(e.buf, codeNil)
+		return
+	}
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			e.buf = append(e.buf, codeNil)
+			return
+		}
+		return e.encode(v.Elem(), depth+1)
+	}
+	if m, ok := codec.Implemented(v, marshalerType); ok {
+		b, marshalErr := m.(Marshaler).MarshalMsgpack()
+		if marshalErr != nil {
+			err = fmt.Errorf("msgpack: marshal %s failed: %w", v.Type(), marshalErr)
+			return
+		}
+		e.buf = append(e.buf, b...)
+		return
+	}
+	if v.Type() == codec.TimeType {
+		e.writeTime(v.Interface().(time.Time))
+		return
+	}
+	if m, ok := codec.Implemented(v, codec.TextMarshalerType); ok {
+		text, marshalErr := m.(encoding.TextMarshaler).MarshalText()
+		if marshalErr != nil {
+			err = fmt.Errorf("msgpack: marshal %s failed: %w", v.Type(), marshalErr)
+			return
+		}
+		e.writeString(string(text))
+		return
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, codeTrue)
+		} else {
+			e.buf = append(e.buf, codeFalse)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.writeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.writeUint(v.Uint())
+	case reflect.Float32:
+		e.buf = append(e.buf, codeFloat32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.buf = append(e.buf, codeFloat64)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, math.Float64bits(v.Float()))
+	case reflect.String:
+		e.writeString(v.String())
+	case reflect.Pointer:
+		if v.IsNil() {
+			e.buf = append(e.buf, codeNil)
+			return
+		}
+		return e.encode(v.Elem(), depth+1)
+	case reflect.Slice:
+		if v.IsNil() {
+			e.buf = append(e.buf, codeNil)
+			return
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.writeBytes(v.Bytes())
+			return
+		}
+		return e.encodeArray(v, depth)
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			e.writeBytes(b)
+			return
+		}
+		return e.encodeArray(v, depth)
+	case reflect.Map:
+		if v.IsNil() {
+			e.buf = append(e.buf, codeNil)
+			return
+		}
+		return e.encodeMap(v, depth)
+	case reflect.Struct:
+		return e.encodeStruct(v, depth)
+	default:
+		err = fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+	return
+}
+
+func (e *encoder) encodeArray(v reflect.Value, depth int) (err error) {
+	n := v.Len()
+	e.writeArrayHeader(n)
+	for i := 0; i < n; i++ {
+		if err = e.encode(v.Index(i), depth+1); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (e *encoder) encodeMap(v reflect.Value, depth int) (err error) {
+	keys := v.MapKeys()
+	sortKeys(keys)
+	e.writeMapHeader(len(keys))
+	for _, key := range keys {
+		if err = e.encode(key, depth+1); err != nil {
+			return
+		}
+		if err = e.encode(v.MapIndex(key), depth+1); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (e *encoder) encodeStruct(v reflect.Value, depth int) (err error) {
+	fields := codec.StructFields(v.Type(), tags...)
+	values := make([]reflect.Value, len(fields.List))
+	n := 0
+	for i, field := range fields.List {
+		fv, ok := codec.FieldByIndex(v, field.Index, false)
+		if !ok || (field.OmitEmpty && codec.IsEmpty(fv)) {
+			continue
+		}
+		values[i] = fv
+		n++
+	}
+	e.writeMapHeader(n)
+	for i, field := range fields.List {
+		if !values[i].IsValid() {
+			continue
+		}
+		e.writeString(field.Name)
+		if err = e.encode(values[i], depth+1); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// sortKeys
+// keys of strings and numbers are sorted, so the output is deterministic.
+func sortKeys(keys []reflect.Value) {
+	if len(keys) < 2 {
+		return
+	}
+	switch keys[0].Kind() {
+	case reflect.String:
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return strings.Compare(a.String(), b.String()) })
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return compare(a.Int(), b.Int()) })
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return compare(a.Uint(), b.Uint()) })
+	default:
+		break
+	}
+}
+
+func compare[T int64 | uint64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (e *encoder) writeInt(n int64) {
+	switch {
+	case n >= 0:
+		e.writeUint(uint64(n))
+	case n >= -32:
+		e.buf = append(e.buf, byte(n))
+	case n >= math.MinInt8:
+		e.buf = append(e.buf, codeInt8, byte(n))
+	case n >= math.MinInt16:
+		e.buf = append(e.buf, codeInt16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	case n >= math.MinInt32:
+		e.buf = append(e.buf, codeInt32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	default:
+		e.buf = append(e.buf, codeInt64)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(n))
+	}
+}
+
+func (e *encoder) writeUint(n uint64) {
+	switch {
+	case n <= 0x7f:
+		e.buf = append(e.buf, byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, codeUint8, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, codeUint16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	case n <= math.MaxUint32:
+		e.buf = append(e.buf, codeUint32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	default:
+		e.buf = append(e.buf, codeUint64)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, n)
+	}
+}
+
+func (e *encoder) writeString(s string) {
+	n := len(s)
+	switch {
+	case n < 32:
+		e.buf = append(e.buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, codeStr8, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, codeStr16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	default:
+		e.buf = append(e.buf, codeStr32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	}
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) writeBytes(b []byte) {
+	n := len(b)
+	switch {
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, codeBin8, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, codeBin16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	default:
+		e.buf = append(e.buf, codeBin32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	}
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) writeArrayHeader(n int) {
+	switch {
+	case n < 16:
+		e.buf = append(e.buf, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, codeArray16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	default:
+		e.buf = append(e.buf, codeArray32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	}
+}
+
+func (e *encoder) writeMapHeader(n int) {
+	switch {
+	case n < 16:
+		e.buf = append(e.buf, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		e.buf = append(e.buf, codeMap16)
+		e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(n))
+	default:
+		e.buf = append(e.buf, codeMap32)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(n))
+	}
+}
+
+// writeTime
+// the smallest of timestamp 32, 64 and 96.
+func (e *encoder) writeTime(t time.Time) {
+	sec, nsec := t.Unix(), int64(t.Nanosecond())
+	switch {
+	case sec >= 0 && sec>>34 == 0 && nsec == 0 && sec <= math.MaxUint32:
+		e.buf = append(e.buf, codeFixExt4, timestampExtCode)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(sec))
+	case sec >= 0 && sec>>34 == 0:
+		e.buf = append(e.buf, codeFixExt8, timestampExtCode)
+		e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(nsec)<<34|uint64(sec))
+	default:
+		e.buf = append(e.buf, codeExt8, 12, timestampExtCode)
+		e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(nsec))
+		e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(sec))
+	}
+}
@@ -0,0 +1,104 @@
+package msgpack
+
+import (
+	"errors"
+	"reflect"
+)
+
+// Marshal
+// encode the value as message pack, fields of structs are named by `msgpack`, `json` then `yaml` tags.
+// time.Time is the timestamp extension, encoding.TextMarshaler is a string and Marshaler writes itself.
+func Marshal(v any) (b []byte, err error) {
+	e := &encoder{buf: make([]byte, 0, 64)}
+	if err = e.encode(reflect.ValueOf(v), 0); err != nil {
+		return
+	}
+	b = e.buf
+	return
+}
+
+// Unmarshal
+// decode the message pack into v which must be a non-nil pointer.
+func Unmarshal(b []byte, v any) (err error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		err = ErrInvalidUnmarshal
+		return
+	}
+	d := &decoder{data: b}
+	if err = d.decode(rv.Elem(), 0); err != nil {
+		return
+	}
+	if d.pos != len(d.data) {
+		err = ErrTrailingData
+	}
+	return
+}
+
+var (
+	ErrInvalidUnmarshal = errors.New("msgpack: unmarshal target must be a non-nil pointer")
+	ErrShortBuffer      = errors.New("msgpack: unexpected end of data")
+	ErrTrailingData     = errors.New("msgpack: trailing data after the value")
+	ErrMaxDepth         = errors.New("msgpack: exceeded max depth")
+)
+
+// MaxDepth
+// the max nesting depth of values, it stops cycles of encoding and hostile inputs of decoding.
+const MaxDepth = 1000
+
+// Marshaler
+// the value encodes itself, b must be a whole message pack value.
+type Marshaler interface {
+	MarshalMsgpack() (b []byte, err error)
+}
+
+// Unmarshaler
+// the value decodes itself, b is a whole message pack value.
+type Unmarshaler interface {
+	UnmarshalMsgpack(b []byte) (err error)
+}
+
+var (
+	marshalerType   = reflect.TypeFor[Marshaler]()
+	unmarshalerType = reflect.TypeFor[Unmarshaler]()
+	tags            = []string{"msgpack", "json", "yaml"}
+)
+
+const (
+	codeNil      byte = 0xc0
+	codeFalse    byte = 0xc2
+	codeTrue     byte = 0xc3
+	codeBin8     byte = 0xc4
+	codeBin16    byte = 0xc5
+	codeBin32    byte = 0xc6
+	codeExt8     byte = 0xc7
+	codeExt16    byte = 0xc8
+	codeExt32    byte = 0xc9
+	codeFloat32  byte = 0xca
+	codeFloat64  byte = 0xcb
+	codeUint8    byte = 0xcc
+	codeUint16   byte = 0xcd
+	codeUint32   byte = 0xce
+	codeUint64   byte = 0xcf
+	codeInt8     byte = 0xd0
+	codeInt16    byte = 0xd1
+	codeInt32    byte = 0xd2
+	codeInt64    byte = 0xd3
+	codeFixExt1  byte = 0xd4
+	codeFixExt2  byte = 0xd5
+	codeFixExt4  byte = 0xd6
+	codeFixExt8  byte = 0xd7
+	codeFixExt16 byte = 0xd8
+	codeStr8     byte = 0xd9
+	codeStr16    byte = 0xda
+	codeStr32    byte = 0xdb
+	codeArray16  byte = 0xdc
+	codeArray32  byte = 0xdd
+	codeMap16    byte = 0xde
+	codeMap32    byte = 0xdf
+
+	// timestampExt
+	// the extension type of timestamps.
+	timestampExt     int8 = -1
+	timestampExtCode byte = 0xff
+)
@@ -0,0 +1,148 @@
+package msgpack_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brickingsoft/brick/pkg/msgpack"
+)
+
+type Base struct {
+	ID   int    `json:"id"`
+	Kind string `yaml:"kind"`
+}
+
+type Upper string
+
+func (u Upper) MarshalMsgpack() ([]byte, error) {
+	return msgpack.Marshal(strings.ToUpper(string(u)))
+}
+
+func (u *Upper) UnmarshalMsgpack(b []byte) error {
+	var s string
+	if err := msgpack.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	*u = Upper(strings.ToLower(s))
+	return nil
+}
+
+type Item struct {
+	Base
+	Name    string         `msgpack:"name" json:"title"`
+	Count   uint16         `json:"count,omitempty"`
+	Score   float64        `json:"score"`
+	Tags    []string       `json:"tags"`
+	Raw     []byte         `json:"raw"`
+	At      time.Time      `json:"at"`
+	Addr    netip.Addr     `json:"addr"`
+	Upper   Upper          `json:"upper"`
+	Next    *Item          `json:"next,omitempty"`
+	Extra   map[string]any `json:"extra"`
+	Ignored string         `json:"-"`
+}
+
+func TestMarshal(t *testing.T) {
+	in := Item{
+		Base:    Base{ID: -7, Kind: "k"},
+		Name:    "foo",
+		Score:   1.5,
+		Tags:    []string{"a", "b"},
+		Raw:     []byte{1, 2, 3},
+		At:      time.Date(2024, 5, 6, 7, 8, 9, 10, time.UTC),
+		Addr:    netip.MustParseAddr("10.0.0.1"),
+		Upper:   "up",
+		Next:    &Item{Name: "bar", Count: 300},
+		Extra:   map[string]any{"n": int64(-1), "s": "x", "l": []any{true, nil}},
+		Ignored: "ignored",
+	}
+	b, err := msgpack.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := Item{}
+	if err = msgpack.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !out.At.Equal(in.At) {
+		t.Fatal("unexpected time", out.At)
+	}
+	out.At = in.At
+	in.Ignored = ""
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("unexpected item\n%+v\n%+v", in, out)
+	}
+
+	generic := map[string]any{}
+	if err = msgpack.Unmarshal(b, &generic); err != nil {
+		t.Fatal(err)
+	}
+	if generic["name"] != "foo" || generic["id"] != int64(-7) || generic["kind"] != "k" || generic["upper"] != "UP" || generic["addr"] != "10.0.0.1" {
+		t.Fatal("unexpected names", generic)
+	}
+	if _, has := generic["count"]; has {
+		t.Fatal("count should be omitted")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	cases := []struct {
+		v   any
+		hex string
+	}{
+		{nil, "c0"},
+		{true, "c3"},
+		{1, "01"},
+		{-1, "ff"},
+		{-33, "d0df"},
+		{200, "ccc8"},
+		{70000, "ce00011170"},
+		{"a", "a161"},
+		{[]int{1, 2}, "920102"},
+		{map[string]int{"b": 2, "a": 1}, "82a16101a16202"},
+		{time.Unix(1, 0), "d6ff00000001"},
+	}
+	for _, c := range cases {
+		b, err := msgpack.Marshal(c.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(b) != c.hex {
+			t.Fatal("unexpected encoding of", c.v, hex.EncodeToString(b))
+		}
+	}
+}
+
+func TestUnmarshal_Invalid(t *testing.T) {
+	var s string
+	if err := msgpack.Unmarshal([]byte{0xa5, 'a'}, &s); !errors.Is(err, msgpack.ErrShortBuffer) {
+		t.Fatal("expected short buffer", err)
+	}
+	if err := msgpack.Unmarshal([]byte{0xdd, 0xff, 0xff, 0xff, 0xff}, &s); !errors.Is(err, msgpack.ErrShortBuffer) {
+		t.Fatal("expected short buffer", err)
+	}
+	if err := msgpack.Unmarshal([]byte{0x01, 0x02}, new(int)); !errors.Is(err, msgpack.ErrTrailingData) {
+		t.Fatal("expected trailing data", err)
+	}
+	if err := msgpack.Unmarshal([]byte{0xcd, 0x01, 0x00}, new(int8)); err == nil {
+		t.Fatal("expected overflow")
+	}
+	if err := msgpack.Unmarshal(bytes.Repeat([]byte{0x91}, msgpack.MaxDepth+2), new(any)); !errors.Is(err, msgpack.ErrMaxDepth) {
+		t.Fatal("expected max depth", err)
+	}
+	if err := msgpack.Unmarshal([]byte{0x01}, s); !errors.Is(err, msgpack.ErrInvalidUnmarshal) {
+		t.Fatal("expected invalid unmarshal", err)
+	}
+	// an array or a map is not a key of map[any]any.
+	for _, b := range [][]byte{{0x81, 0x91, 0x01, 0x02}, {0x81, 0x81, 0x01, 0x02, 0x03}} {
+		if err := msgpack.Unmarshal(b, new(map[any]any)); err == nil || !strings.Contains(err.Error(), "cannot unmarshal map key") {
+			t.Fatal("expected unhashable key", err)
+		}
+	}
+}
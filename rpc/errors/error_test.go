@@ -1,6 +1,7 @@
 package errors_test
 
 import (
+	stderrors "errors"
 	"fmt"
 	"io"
 	"testing"
@@ -36,6 +37,24 @@ func TestWrap(t *testing.T) {
 	t.Log(fmt.Sprintf("%+v", errors.Wrap(err)))
 }
 
+type multiError []error
+
+func (errs multiError) Error() string { return "multi" }
+
+func (errs multiError) Unwrap() []error { return errs }
+
+func TestWrap_Joined(t *testing.T) {
+	err, ok := errors.Wrap(stderrors.Join(io.EOF, io.ErrUnexpectedEOF)).(*errors.Error)
+	if !ok || len(err.Wrapped) != 2 {
+		t.Fatal("unexpected wrapped", err)
+	}
+	// nil of a joined error is skipped.
+	if err, ok = errors.Wrap(multiError{io.EOF, nil}).(*errors.Error); !ok || len(err.Wrapped) != 1 {
+		t.Fatal("unexpected wrapped", err)
+	}
+	t.Log(fmt.Sprintf("%+v", err))
+}
+
 func TestJoin(t *testing.T) {
 	err := errors.New("err 0")
 	err = errors.Join(err, errors.New("err 1"))
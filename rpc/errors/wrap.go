@@ -32,8 +32,14 @@ func wrap(target error) *Error {
 		if errsLen == 0 {
 			return nil
 		}
+		err = &Error{
+			Message: target.Error(),
+		}
 		for i := 0; i < errsLen; i++ {
-			err.Wrapped = append(err.Wrapped, wrap(errs[i]))
+			// a joined error of other packages may contain nil.
+			if wrapped := wrap(errs[i]); wrapped != nil {
+				err.Wrapped = append(err.Wrapped, wrapped)
+			}
 		}
 		return err
 	}
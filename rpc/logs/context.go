@@ -35,6 +35,16 @@ func Load(ctx context.Context) Logger {
 	return logger
 }
 
+// Lookup
+// get the logger of context without panic, ok is false when the context does not contain a logger.
+func Lookup(ctx context.Context) (logger Logger, ok bool) {
+	if ctx == nil {
+		return
+	}
+	logger, ok = ctx.Value(ctxKey).(Logger)
+	return
+}
+
 func Group(ctx context.Context, name string) context.Context {
 	logger := Load(ctx)
 	logger = logger.Group(name)
@@ -0,0 +1,729 @@
+package transports
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brickingsoft/brick/transports"
+	"github.com/cespare/xxhash/v2"
+)
+
+const (
+	RoundRobinStrategy = "round-robin"
+	PowerOfTwoStrategy = "p2c"
+	WeightedStrategy   = "weighted"
+	// ConsistentHashStrategy
+	// the rendezvous hashing on the key of request.
+	ConsistentHashStrategy = "consistent-hash"
+	DefaultEjectFailures   = 3
+	DefaultEjectDuration   = 30 * time.Second
+	// DefaultResolveInterval
+	// the default interval of resolving the targets again.
+	DefaultResolveInterval = 30 * time.Second
+)
+
+var (
+	ErrNoAvailableTarget = errors.New("no available target")
+)
+
+// Target
+// an address of a service.
+type Target struct {
+	Address string `json:"address" yaml:"address"`
+	// Weight
+	// the weight of weighted strategy, the default is 1.
+	Weight int `json:"weight" yaml:"weight"`
+}
+
+// Resolver
+// resolve the targets of a service, e.g. by discovery.
+type Resolver interface {
+	Resolve(ctx context.Context) (targets []Target, err error)
+}
+
+// StaticResolver
+// the targets never change.
+type StaticResolver []Target
+
+func NewStaticResolver(addresses ...string) StaticResolver {
+	targets := make(StaticResolver, 0, len(addresses))
+	for _, address := range addresses {
+		if address = strings.TrimSpace(address); address != "" {
+			targets = append(targets, Target{Address: address})
+		}
+	}
+	return targets
+}
+
+func (r StaticResolver) Resolve(_ context.Context) (targets []Target, err error) {
+	targets = r
+	return
+}
+
+// BalancerConfig
+// the balancer node of a client config.
+type BalancerConfig struct {
+	// Strategy
+	// round-robin, p2c, weighted and consistent-hash, the default is round-robin.
+	Strategy string `json:"strategy" yaml:"strategy"`
+	// HashKey
+	// the key of consistent-hash, `header:{name}`, `endpoint` or `function`.
+	HashKey string `json:"hashKey" yaml:"hashKey"`
+	// KeyFunc
+	// the key of consistent-hash which overrides the HashKey, e.g. a field of the request body.
+	KeyFunc func(request transports.Request) string `json:"-" yaml:"-"`
+	// Targets
+	// the static targets, they are used when no resolver is given.
+	Targets []Target `json:"targets" yaml:"targets"`
+	// EjectFailures
+	// the consecutive failures of a target to eject it.
+	EjectFailures int `json:"ejectFailures" yaml:"ejectFailures"`
+	// EjectDuration
+	// the duration of an ejected target being not picked.
+	EjectDuration   time.Duration `json:"ejectDuration" yaml:"ejectDuration"`
+	ResolveInterval time.Duration `json:"resolveInterval" yaml:"resolveInterval"`
+}
+
+// balancerNode
+// a target and its client, the client is connected at the first pick.
+type balancerNode struct {
+	address    string
+	hash       uint64
+	weight     atomic.Int64
+	locker     sync.Mutex
+	client     *balancerClient
+	connecting *balancerConnect
+	inflight   atomic.Int64
+	failures   atomic.Int32
+	ejected    atomic.Int64
+	current    int
+}
+
+// balancerClient
+// a client of a node and the calls which use it, a retired client is closed when its calls are done.
+type balancerClient struct {
+	client  transports.Client
+	users   int
+	retired bool
+}
+
+// balancerConnect
+// a pending connect of a node, the concurrent callers wait for it together, it is canceled when all of them are gone.
+type balancerConnect struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	client  *balancerClient
+	err     error
+}
+
+func (node *balancerNode) available(now int64) bool {
+	return node.ejected.Load() <= now
+}
+
+// acquire
+// the caller must release the client when the call is done.
+// the client is connected out of the locker, and a caller stops waiting for the connect when its ctx is done.
+func (node *balancerNode) acquire(ctx context.Context, transport transports.Transport) (c *balancerClient, err error) {
+	node.locker.Lock()
+	if node.client != nil {
+		c = node.client
+		c.users++
+		node.locker.Unlock()
+		return
+	}
+	pending := node.connecting
+	if pending == nil {
+		connectCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		pending = &balancerConnect{done: make(chan struct{}), cancel: cancel}
+		node.connecting = pending
+		go node.connect(connectCtx, transport, pending)
+	}
+	pending.waiters++
+	node.locker.Unlock()
+
+	select {
+	case <-pending.done:
+		c, err = pending.client, pending.err
+		return
+	case <-ctx.Done():
+		break
+	}
+	node.locker.Lock()
+	select {
+	case <-pending.done:
+		// the connect is done meanwhile, the caller is one of the users of the client.
+		node.locker.Unlock()
+		if pending.client != nil {
+			node.release(pending.client)
+		}
+		break
+	default:
+		pending.waiters--
+		if pending.waiters == 0 {
+			if node.connecting == pending {
+				node.connecting = nil
+			}
+			pending.cancel()
+		}
+		node.locker.Unlock()
+		break
+	}
+	err = ctx.Err()
+	return
+}
+
+// connect
+// the client is used by the waiters of pending, and it is kept by the node when pending is not left or retired.
+func (node *balancerNode) connect(ctx context.Context, transport transports.Transport, pending *balancerConnect) {
+	defer pending.cancel()
+	client, connectErr := transport.Connect(ctx, node.address)
+	node.locker.Lock()
+	if connectErr != nil {
+		pending.err = connectErr
+	} else {
+		pending.client = &balancerClient{client: client, users: pending.waiters}
+	}
+	closing := false
+	if node.connecting == pending {
+		node.connecting = nil
+		if pending.client != nil {
+			node.client = pending.client
+		}
+	} else if pending.client != nil {
+		pending.client.retired = true
+		closing = pending.client.users == 0
+	}
+	close(pending.done)
+	node.locker.Unlock()
+	if closing {
+		_ = client.Close()
+	}
+}
+
+func (node *balancerNode) release(c *balancerClient) {
+	node.locker.Lock()
+	c.users--
+	closing := c.retired && c.users == 0
+	node.locker.Unlock()
+	if closing {
+		_ = c.client.Close()
+	}
+}
+
+// retire
+// new calls connect again, the current client is closed when its calls are done, so they are not failed by it.
+func (node *balancerNode) retire() {
+	node.locker.Lock()
+	c := node.client
+	node.client = nil
+	// the pending client is retired when it is connected.
+	node.connecting = nil
+	closing := false
+	if c != nil {
+		c.retired = true
+		closing = c.users == 0
+	}
+	node.locker.Unlock()
+	if closing {
+		_ = c.client.Close()
+	}
+}
+
+// close
+// close the current client at once, it is used when the balancer is closed.
+func (node *balancerNode) close() {
+	node.locker.Lock()
+	c := node.client
+	node.client = nil
+	if node.connecting != nil {
+		node.connecting.cancel()
+		node.connecting = nil
+	}
+	node.locker.Unlock()
+	if c != nil {
+		_ = c.client.Close()
+	}
+}
+
+// balanceStrategy
+// pick one of the nodes, the nodes are not empty.
+type balanceStrategy interface {
+	pick(nodes []*balancerNode, request transports.Request) *balancerNode
+}
+
+type roundRobin struct {
+	n atomic.Uint64
+}
+
+func (s *roundRobin) pick(nodes []*balancerNode, _ transports.Request) *balancerNode {
+	return nodes[(s.n.Add(1)-1)%uint64(len(nodes))]
+}
+
+// powerOfTwo
+// pick two nodes randomly, and use the one which has fewer in-flight calls.
+type powerOfTwo struct{}
+
+func (s *powerOfTwo) pick(nodes []*balancerNode, _ transports.Request) *balancerNode {
+	if len(nodes) == 1 {
+		return nodes[0]
+	}
+	i := rand.IntN(len(nodes))
+	j := rand.IntN(len(nodes) - 1)
+	if j >= i {
+		j++
+	}
+	a, b := nodes[i], nodes[j]
+	if b.inflight.Load() < a.inflight.Load() {
+		return b
+	}
+	return a
+}
+
+// weighted
+// the smooth weighted round-robin of nginx.
+type weighted struct {
+	locker sync.Mutex
+}
+
+func (s *weighted) pick(nodes []*balancerNode, _ transports.Request) (picked *balancerNode) {
+	s.locker.Lock()
+	total := 0
+	for _, node := range nodes {
+		weight := int(node.weight.Load())
+		node.current += weight
+		total += weight
+		if picked == nil || node.current > picked.current {
+			picked = node
+		}
+	}
+	picked.current -= total
+	s.locker.Unlock()
+	return
+}
+
+// consistentHash
+// the rendezvous hashing, when a target is gone, only its keys are moved to others.
+// requests without key are picked by round-robin.
+type consistentHash struct {
+	key      func(request transports.Request) string
+	fallback roundRobin
+}
+
+func (s *consistentHash) pick(nodes []*balancerNode, request transports.Request) *balancerNode {
+	key := s.key(request)
+	if key == "" {
+		return s.fallback.pick(nodes, request)
+	}
+	return s.owner(nodes, key)
+}
+
+// owner
+// the node of the highest weighted score, the score is `-weight/ln(hash)` where the hash is in (0, 1).
+func (s *consistentHash) owner(nodes []*balancerNode, key string) (owner *balancerNode) {
+	kh := xxhash.Sum64String(key)
+	best := math.Inf(-1)
+	for _, node := range nodes {
+		h := mix64(kh ^ node.hash)
+		u := (float64(h>>11) + 0.5) / (1 << 53)
+		score := -float64(node.weight.Load()) / math.Log(u)
+		if score > best || (score == best && node.address < owner.address) {
+			best, owner = score, node
+		}
+	}
+	return
+}
+
+// mix64
+// the finalizer of splitmix64.
+func mix64(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+// HashKeyOf
+// the key func of spec, the spec is `header:{name}`, `endpoint` or `function`.
+func HashKeyOf(spec string) (key func(request transports.Request) string, err error) {
+	spec = strings.TrimSpace(spec)
+	switch {
+	case strings.EqualFold(spec, "endpoint"):
+		key = func(request transports.Request) string {
+			return request.Endpoint()
+		}
+		break
+	case strings.EqualFold(spec, "function"):
+		key = func(request transports.Request) string {
+			return request.Endpoint() + "." + request.Function()
+		}
+		break
+	case len(spec) > 7 && strings.EqualFold(spec[:7], "header:"):
+		name := strings.TrimSpace(spec[7:])
+		key = func(request transports.Request) string {
+			if header := request.Header(); header != nil {
+				return header.Get(name)
+			}
+			return ""
+		}
+		break
+	default:
+		err = errors.New("invalid hash key " + spec)
+		break
+	}
+	return
+}
+
+func newBalanceStrategy(config BalancerConfig) (strategy balanceStrategy, err error) {
+	switch strings.ToLower(strings.TrimSpace(config.Strategy)) {
+	case "", RoundRobinStrategy:
+		strategy = new(roundRobin)
+		break
+	case PowerOfTwoStrategy:
+		strategy = new(powerOfTwo)
+		break
+	case WeightedStrategy:
+		strategy = new(weighted)
+		break
+	case ConsistentHashStrategy:
+		key := config.KeyFunc
+		if key == nil {
+			if key, err = HashKeyOf(config.HashKey); err != nil {
+				return
+			}
+		}
+		strategy = &consistentHash{key: key}
+		break
+	default:
+		err = errors.New("invalid balance strategy " + config.Strategy)
+		break
+	}
+	return
+}
+
+// Balancer
+// a client over the targets of a service, each call picks a target by the strategy,
+// targets are ejected for a while after consecutive failures.
+type Balancer struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	transport transports.Transport
+	resolver  Resolver
+	strategy  balanceStrategy
+	config    BalancerConfig
+	nodes     atomic.Pointer[[]*balancerNode]
+	locker    sync.Mutex
+	done      chan struct{}
+}
+
+// NewBalancer
+// the targets are resolved at once, and then resolved by the interval until the balancer is closed.
+// the config.Targets are used when resolver is nil.
+func NewBalancer(ctx context.Context, transport transports.Transport, resolver Resolver, config BalancerConfig) (balancer *Balancer, err error) {
+	if ctx == nil {
+		err = errors.Join(errors.New("new balancer failed"), errors.New("context is missing"))
+		return
+	}
+	if transport == nil {
+		err = errors.Join(errors.New("new balancer failed"), errors.New("transport is missing"))
+		return
+	}
+	if resolver == nil {
+		resolver = StaticResolver(config.Targets)
+	}
+	strategy, strategyErr := newBalanceStrategy(config)
+	if strategyErr != nil {
+		err = errors.Join(errors.New("new balancer failed"), strategyErr)
+		return
+	}
+	if config.EjectFailures < 1 {
+		config.EjectFailures = DefaultEjectFailures
+	}
+	if config.EjectDuration <= 0 {
+		config.EjectDuration = DefaultEjectDuration
+	}
+	if config.ResolveInterval <= 0 {
+		config.ResolveInterval = DefaultResolveInterval
+	}
+	balancer = &Balancer{
+		transport: transport,
+		resolver:  resolver,
+		strategy:  strategy,
+		config:    config,
+		done:      make(chan struct{}),
+	}
+	balancer.nodes.Store(new([]*balancerNode))
+	if err = balancer.resolve(ctx); err != nil {
+		balancer = nil
+		err = errors.Join(errors.New("new balancer failed"), err)
+		return
+	}
+	balancer.ctx, balancer.cancel = context.WithCancel(context.WithoutCancel(ctx))
+	go balancer.watch()
+	return
+}
+
+// Targets
+// the current targets.
+func (balancer *Balancer) Targets() (targets []Target) {
+	for _, node := range *balancer.nodes.Load() {
+		targets = append(targets, Target{Address: node.address, Weight: int(node.weight.Load())})
+	}
+	return
+}
+
+func (balancer *Balancer) watch() {
+	defer close(balancer.done)
+	ticker := time.NewTicker(balancer.config.ResolveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-balancer.ctx.Done():
+			return
+		case <-ticker.C:
+			_ = balancer.resolve(balancer.ctx)
+		}
+	}
+}
+
+// resolve
+// the nodes of kept targets are reused, the nodes of removed targets are retired.
+func (balancer *Balancer) resolve(ctx context.Context) (err error) {
+	targets, resolveErr := balancer.resolver.Resolve(ctx)
+	if resolveErr != nil {
+		err = resolveErr
+		return
+	}
+	balancer.locker.Lock()
+	defer balancer.locker.Unlock()
+	prev := *balancer.nodes.Load()
+	nodes := make([]*balancerNode, 0, len(targets))
+	for _, target := range targets {
+		if target.Address = strings.TrimSpace(target.Address); target.Address == "" {
+			continue
+		}
+		if target.Weight < 1 {
+			target.Weight = 1
+		}
+		idx := slices.IndexFunc(prev, func(node *balancerNode) bool {
+			return node.address == target.Address
+		})
+		if idx > -1 {
+			prev[idx].weight.Store(int64(target.Weight))
+			nodes = append(nodes, prev[idx])
+			continue
+		}
+		if slices.ContainsFunc(nodes, func(node *balancerNode) bool { return node.address == target.Address }) {
+			continue
+		}
+		node := &balancerNode{address: target.Address, hash: xxhash.Sum64String(target.Address)}
+		node.weight.Store(int64(target.Weight))
+		nodes = append(nodes, node)
+	}
+	balancer.nodes.Store(&nodes)
+	for _, node := range prev {
+		if !slices.Contains(nodes, node) {
+			node.retire()
+		}
+	}
+	return
+}
+
+// Route
+// the target which owns the key of request, ok is false when the strategy is not consistent-hash or the request has no key.
+func (balancer *Balancer) Route(request transports.Request) (address string, ok bool) {
+	node, has := balancer.route(request)
+	if has {
+		address, ok = node.address, true
+	}
+	return
+}
+
+func (balancer *Balancer) route(request transports.Request) (node *balancerNode, ok bool) {
+	strategy, isHash := balancer.strategy.(*consistentHash)
+	if !isHash {
+		return
+	}
+	key := strategy.key(request)
+	if key == "" {
+		return
+	}
+	nodes := balancer.available()
+	if len(nodes) == 0 {
+		return
+	}
+	node, ok = strategy.owner(nodes, key), true
+	return
+}
+
+// pick
+// when all targets are ejected, all are used, so the service is not cut off by a burst of failures.
+func (balancer *Balancer) pick(request transports.Request) (node *balancerNode, err error) {
+	available := balancer.available()
+	if len(available) == 0 {
+		err = ErrNoAvailableTarget
+		return
+	}
+	node = balancer.strategy.pick(available, request)
+	return
+}
+
+// available
+// the targets which are not ejected, or all when all are ejected.
+func (balancer *Balancer) available() []*balancerNode {
+	nodes := *balancer.nodes.Load()
+	now := time.Now().UnixNano()
+	available := nodes
+	for i, n := range nodes {
+		if !n.available(now) {
+			available = make([]*balancerNode, 0, len(nodes))
+			available = append(available, nodes[:i]...)
+			for _, rest := range nodes[i+1:] {
+				if rest.available(now) {
+					available = append(available, rest)
+				}
+			}
+			break
+		}
+	}
+	if len(available) == 0 {
+		available = nodes
+	}
+	return available
+}
+
+// report
+// a failure of transport ejects the node after the consecutive failures, the failed responses are not failures of transport.
+func (balancer *Balancer) report(node *balancerNode, err error) {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		if err == nil {
+			node.failures.Store(0)
+		}
+		return
+	}
+	if failures := node.failures.Add(1); int(failures) >= balancer.config.EjectFailures {
+		node.failures.Store(0)
+		node.ejected.Store(time.Now().Add(balancer.config.EjectDuration).UnixNano())
+		node.retire()
+	}
+}
+
+func (balancer *Balancer) Do(ctx context.Context, request transports.Request) (response transports.Response, err error) {
+	if ctx == nil {
+		err = errors.New("context is missing")
+		return
+	}
+	node, pickErr := balancer.pick(request)
+	if pickErr != nil {
+		err = pickErr
+		return
+	}
+	response, err = balancer.do(ctx, node, request)
+	return
+}
+
+func (balancer *Balancer) do(ctx context.Context, node *balancerNode, request transports.Request) (response transports.Response, err error) {
+	node.inflight.Add(1)
+	defer node.inflight.Add(-1)
+	c, connectErr := node.acquire(ctx, balancer.transport)
+	if connectErr != nil {
+		balancer.report(node, connectErr)
+		err = connectErr
+		return
+	}
+	response, err = c.client.Do(ctx, request)
+	node.release(c)
+	balancer.report(node, err)
+	return
+}
+
+// Stream
+// the client of picked target must be a transports.StreamClient.
+func (balancer *Balancer) Stream(ctx context.Context, request transports.Request) (stream transports.ClientStream, err error) {
+	if ctx == nil {
+		err = errors.New("context is missing")
+		return
+	}
+	node, pickErr := balancer.pick(request)
+	if pickErr != nil {
+		err = pickErr
+		return
+	}
+	stream, err = balancer.stream(ctx, node, request)
+	return
+}
+
+func (balancer *Balancer) stream(ctx context.Context, node *balancerNode, request transports.Request) (stream transports.ClientStream, err error) {
+	c, connectErr := node.acquire(ctx, balancer.transport)
+	if connectErr != nil {
+		balancer.report(node, connectErr)
+		err = connectErr
+		return
+	}
+	sc, ok := c.client.(transports.StreamClient)
+	if !ok {
+		node.release(c)
+		err = errors.New("stream is unsupported by the client of " + balancer.transport.Name())
+		return
+	}
+	s, streamErr := sc.Stream(ctx, request)
+	if streamErr != nil {
+		node.release(c)
+		balancer.report(node, streamErr)
+		err = streamErr
+		return
+	}
+	balancer.report(node, nil)
+	stream = &balancerStream{ClientStream: s, node: node, client: c}
+	return
+}
+
+// balancerStream
+// the stream uses the client until it is closed or ended.
+type balancerStream struct {
+	transports.ClientStream
+	node     *balancerNode
+	client   *balancerClient
+	released atomic.Bool
+}
+
+func (s *balancerStream) Receive() (response transports.Response, err error) {
+	if response, err = s.ClientStream.Receive(); err != nil {
+		s.release()
+	}
+	return
+}
+
+func (s *balancerStream) Close() (err error) {
+	err = s.ClientStream.Close()
+	s.release()
+	return
+}
+
+func (s *balancerStream) release() {
+	if s.released.CompareAndSwap(false, true) {
+		s.node.release(s.client)
+	}
+}
+
+func (balancer *Balancer) Close() (err error) {
+	balancer.cancel()
+	<-balancer.done
+	balancer.locker.Lock()
+	nodes := *balancer.nodes.Load()
+	balancer.nodes.Store(new([]*balancerNode))
+	balancer.locker.Unlock()
+	for _, node := range nodes {
+		node.close()
+	}
+	return
+}
@@ -0,0 +1,319 @@
+package transports_test
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brickingsoft/brick/rpc/transports"
+	brick "github.com/brickingsoft/brick/transports"
+)
+
+type countHandler struct {
+	n atomic.Int64
+}
+
+func (h *countHandler) Handle(r brick.RequestCtx) {
+	h.n.Add(1)
+	r.Response().Succeed(nil)
+}
+
+func newCountTransports(t *testing.T, names ...string) (tr brick.Transport, handlers []*countHandler) {
+	t.Helper()
+	ctx := context.Background()
+	for _, name := range names {
+		mem, err := transports.NewMemTransport(ctx, *mustConfig(t, "name: "+name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler := &countHandler{}
+		if err = mem.Listen(ctx, handler); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = mem.Close() })
+		handlers = append(handlers, handler)
+		tr = mem
+	}
+	return
+}
+
+func TestBalancer(t *testing.T) {
+	transports.RegisterFunction("foo", "count")
+	ctx := context.Background()
+	request := &testRequest{endpoint: "foo", function: "count"}
+
+	tests := []struct {
+		strategy string
+		targets  []transports.Target
+		expected []int64
+	}{
+		{
+			strategy: transports.RoundRobinStrategy,
+			targets:  []transports.Target{{Address: "mem://lb-rr-a"}, {Address: "mem://lb-rr-b"}, {Address: "mem://lb-rr-c"}},
+			expected: []int64{10, 10, 10},
+		},
+		{
+			strategy: transports.WeightedStrategy,
+			targets:  []transports.Target{{Address: "mem://lb-w-a", Weight: 2}, {Address: "mem://lb-w-b", Weight: 1}},
+			expected: []int64{20, 10},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.strategy, func(t *testing.T) {
+			names := make([]string, 0, len(test.targets))
+			for _, target := range test.targets {
+				names = append(names, target.Address)
+			}
+			tr, handlers := newCountTransports(t, names...)
+			balancer, err := transports.NewBalancer(ctx, tr, nil, transports.BalancerConfig{Strategy: test.strategy, Targets: test.targets})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer balancer.Close()
+			for i := 0; i < 30; i++ {
+				if _, err = balancer.Do(ctx, request); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for i, handler := range handlers {
+				if n := handler.n.Load(); n != test.expected[i] {
+					t.Fatal(names[i], "expected", test.expected[i], "got", n)
+				}
+			}
+		})
+	}
+}
+
+func TestBalancer_PowerOfTwo(t *testing.T) {
+	transports.RegisterFunction("foo", "count")
+	ctx := context.Background()
+	tr, handlers := newCountTransports(t, "mem://lb-p2c-a", "mem://lb-p2c-b")
+	balancer, err := transports.NewBalancer(ctx, tr, transports.NewStaticResolver("mem://lb-p2c-a", "mem://lb-p2c-b"), transports.BalancerConfig{
+		Strategy: transports.PowerOfTwoStrategy,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer balancer.Close()
+	for i := 0; i < 20; i++ {
+		if _, err = balancer.Do(ctx, &testRequest{endpoint: "foo", function: "count"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a, b := handlers[0].n.Load(), handlers[1].n.Load(); a+b != 20 {
+		t.Fatal("expected 20 calls, got", a+b)
+	}
+}
+
+func TestBalancer_Eject(t *testing.T) {
+	transports.RegisterFunction("foo", "count")
+	ctx := context.Background()
+	tr, handlers := newCountTransports(t, "mem://lb-eject-a")
+	balancer, err := transports.NewBalancer(ctx, tr, transports.NewStaticResolver("mem://lb-eject-a", "mem://lb-eject-missing"), transports.BalancerConfig{
+		EjectFailures: 2,
+		EjectDuration: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer balancer.Close()
+
+	failures := 0
+	for i := 0; i < 20; i++ {
+		if _, err = balancer.Do(ctx, &testRequest{endpoint: "foo", function: "count"}); err != nil {
+			failures++
+		}
+	}
+	if failures != 2 {
+		t.Fatal("expected 2 failures before ejection, got", failures)
+	}
+	if n := handlers[0].n.Load(); n != 18 {
+		t.Fatal("expected 18 calls, got", n)
+	}
+
+	// the ejected target is picked again after the duration
+	time.Sleep(150 * time.Millisecond)
+	failures = 0
+	for i := 0; i < 4; i++ {
+		if _, err = balancer.Do(ctx, &testRequest{endpoint: "foo", function: "count"}); err != nil {
+			failures++
+		}
+	}
+	if failures == 0 {
+		t.Fatal("expected the ejected target to be picked again")
+	}
+}
+
+type mutableResolver struct {
+	locker  sync.Mutex
+	targets []transports.Target
+}
+
+func (r *mutableResolver) Resolve(_ context.Context) ([]transports.Target, error) {
+	r.locker.Lock()
+	defer r.locker.Unlock()
+	return slices.Clone(r.targets), nil
+}
+
+func (r *mutableResolver) set(addresses ...string) {
+	r.locker.Lock()
+	r.targets = r.targets[:0]
+	for _, address := range addresses {
+		r.targets = append(r.targets, transports.Target{Address: address})
+	}
+	r.locker.Unlock()
+}
+
+func TestBalancer_ConsistentHash(t *testing.T) {
+	ctx := context.Background()
+	tr, _ := newCountTransports(t, "mem://lb-hash-a")
+	resolver := new(mutableResolver)
+	resolver.set("mem://lb-hash-a", "mem://lb-hash-b", "mem://lb-hash-c", "mem://lb-hash-d")
+	balancer, err := transports.NewBalancer(ctx, tr, resolver, transports.BalancerConfig{
+		Strategy:        transports.ConsistentHashStrategy,
+		HashKey:         "header:x-key",
+		ResolveInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer balancer.Close()
+
+	if _, ok := balancer.Route(&testRequest{endpoint: "foo", function: "count", header: testHeader{}}); ok {
+		t.Fatal("request without key should not be routed")
+	}
+	owners := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		owner, ok := balancer.Route(&testRequest{endpoint: "foo", function: "count", header: testHeader{"x-key": {key}}})
+		if !ok {
+			t.Fatal("request with key should be routed")
+		}
+		owners[key] = owner
+		counts[owner]++
+	}
+	if len(counts) != 4 {
+		t.Fatal("expected 4 owners, got", len(counts))
+	}
+	for owner, n := range counts {
+		if n < 150 {
+			t.Fatal(owner, "owns too few keys", n)
+		}
+	}
+
+	// only the keys of the removed target are moved
+	resolver.set("mem://lb-hash-a", "mem://lb-hash-c", "mem://lb-hash-d")
+	time.Sleep(50 * time.Millisecond)
+	for key, prev := range owners {
+		owner, _ := balancer.Route(&testRequest{endpoint: "foo", function: "count", header: testHeader{"x-key": {key}}})
+		if prev == "mem://lb-hash-b" {
+			if owner == prev {
+				t.Fatal("key", key, "is still owned by the removed target")
+			}
+			continue
+		}
+		if owner != prev {
+			t.Fatal("key", key, "moved from", prev, "to", owner)
+		}
+	}
+}
+
+func TestBalancer_Retire(t *testing.T) {
+	transports.RegisterFunction("foo", "slow")
+	ctx := context.Background()
+	tr, _ := newCountTransports(t, "mem://lb-retire-b")
+	slow, slowErr := transports.NewMemTransport(ctx, *mustConfig(t, "name: mem://lb-retire-a"))
+	if slowErr != nil {
+		t.Fatal(slowErr)
+	}
+	if err := slow.Listen(ctx, &slowHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	defer slow.Close()
+	resolver := new(mutableResolver)
+	resolver.set("mem://lb-retire-a")
+	balancer, err := transports.NewBalancer(ctx, tr, resolver, transports.BalancerConfig{ResolveInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer balancer.Close()
+
+	// the in-flight call is not failed by removing its target
+	done := make(chan error, 1)
+	go func() {
+		_, doErr := balancer.Do(ctx, &testRequest{endpoint: "foo", function: "slow"})
+		done <- doErr
+	}()
+	time.Sleep(10 * time.Millisecond)
+	resolver.set("mem://lb-retire-b")
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// gatedTransport
+// the connects wait for the gate, so the callers wait for a pending connect.
+type gatedTransport struct {
+	brick.Transport
+	gate     chan struct{}
+	connects atomic.Int64
+}
+
+func (tr *gatedTransport) Connect(ctx context.Context, address string) (brick.Client, error) {
+	tr.connects.Add(1)
+	select {
+	case <-tr.gate:
+		break
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return tr.Transport.Connect(ctx, address)
+}
+
+func TestBalancer_Connect(t *testing.T) {
+	transports.RegisterFunction("foo", "bar")
+	ctx := context.Background()
+	mem, _ := newCountTransports(t, "mem://lb-connect")
+	tr := &gatedTransport{Transport: mem, gate: make(chan struct{})}
+	balancer, err := transports.NewBalancer(ctx, tr, transports.NewStaticResolver("mem://lb-connect"), transports.BalancerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer balancer.Close()
+
+	// the first caller connects, the others wait for its connect.
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, doErr := balancer.Do(ctx, &testRequest{endpoint: "foo", function: "bar"})
+			done <- doErr
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// a waiter leaves when its ctx is done, though the connect is pending.
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	began := time.Now()
+	if _, err = balancer.Do(timeout, &testRequest{endpoint: "foo", function: "bar"}); err == nil {
+		t.Fatal("expected the waiter is timeout")
+	}
+	if elapsed := time.Since(began); elapsed > time.Second {
+		t.Fatal("the waiter is blocked by the pending connect", elapsed)
+	}
+
+	close(tr.gate)
+	for i := 0; i < 2; i++ {
+		if err = <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := tr.connects.Load(); n != 1 {
+		t.Fatal("expected 1 connect, got", n)
+	}
+}
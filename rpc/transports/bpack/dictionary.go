@@ -1,7 +1,10 @@
 package bpack
 
 import (
+	"encoding/binary"
 	"unsafe"
+
+	"github.com/cespare/xxhash/v2"
 )
 
 type HeaderField struct {
@@ -105,6 +108,29 @@ func (dict *Dictionary) Range(f func(i int, name []byte, value []byte) bool) {
 	}
 }
 
+// Len
+// the count of fields.
+func (dict *Dictionary) Len() int {
+	return len(dict.fields)
+}
+
+// Fingerprint
+// the hash of the first n fields, dictionaries which have the same fingerprint index fields in the same way.
+func (dict *Dictionary) Fingerprint(n int) uint64 {
+	n = min(max(n, 0), len(dict.fields))
+	d := xxhash.New()
+	var p [8]byte
+	for _, field := range dict.fields[:n] {
+		binary.BigEndian.PutUint64(p[:], uint64(len(field.Name)))
+		_, _ = d.Write(p[:])
+		_, _ = d.WriteString(field.Name)
+		binary.BigEndian.PutUint64(p[:], uint64(len(field.Value)))
+		_, _ = d.Write(p[:])
+		_, _ = d.Write(field.Value)
+	}
+	return d.Sum64()
+}
+
 func (dict *Dictionary) Reset() {
 	dict.fields = dict.fields[:0]
 	clear(dict.indexes)
@@ -0,0 +1,180 @@
+package bpack
+
+import (
+	"errors"
+	"slices"
+	"unsafe"
+)
+
+const (
+	// DefaultHeaderTableSize
+	// the default max size of dynamic tables.
+	DefaultHeaderTableSize = 4096
+	// entryOverhead
+	// the overhead of an entry which is counted into the table size, it is the same as hpack.
+	entryOverhead = 32
+)
+
+var (
+	ErrHeaderTableSizeExceeded = errors.New("header table size exceeded")
+)
+
+// DynamicTable
+// the dynamic table of one direction of a connection, the packer side and the unpacker side have their own tables.
+// they are kept in sync by header blocks, so blocks must be unpacked in the order of packing.
+//
+// fields which are not in the Dictionary are inserted when they are packed, the oldest ones are evicted when the table is full,
+// and an entry is referenced by its relative index, 0 is the newest one.
+type DynamicTable struct {
+	maxSize  int
+	capacity int
+	size     int
+	inserted uint64
+	entries  []HeaderField
+	fields   map[string]uint64
+	names    map[string]uint64
+	// update
+	// the packer side announces the capacity at the next block.
+	update bool
+	// reset
+	// the unpacker side evicts all entries before the update, it is used after a failed packing.
+	reset bool
+}
+
+// NewDynamicTable
+// the max size is the capacity of the packer side and the limit of the unpacker side,
+// so the packer side must not be larger than the unpacker side.
+func NewDynamicTable(maxSize int) *DynamicTable {
+	if maxSize < 0 {
+		maxSize = 0
+	}
+	return &DynamicTable{
+		maxSize: maxSize,
+		fields:  make(map[string]uint64),
+		names:   make(map[string]uint64),
+		update:  maxSize > 0,
+	}
+}
+
+// Len
+// the count of entries.
+func (table *DynamicTable) Len() int {
+	return len(table.entries)
+}
+
+// Size
+// the size of entries, an entry is the length of name and value plus 32.
+func (table *DynamicTable) Size() int {
+	return table.size
+}
+
+func entrySize(name []byte, value []byte) int {
+	return len(name) + len(value) + entryOverhead
+}
+
+func (table *DynamicTable) setCapacity(n int) (err error) {
+	if n > table.maxSize {
+		err = ErrHeaderTableSizeExceeded
+		return
+	}
+	table.capacity = n
+	for table.size > table.capacity {
+		table.evict()
+	}
+	return
+}
+
+func (table *DynamicTable) fits(name []byte, value []byte) bool {
+	return entrySize(name, value) <= table.capacity
+}
+
+// add
+// the entry which is larger than the capacity empties the table.
+func (table *DynamicTable) add(name []byte, value []byte) {
+	es := entrySize(name, value)
+	for len(table.entries) > 0 && table.size+es > table.capacity {
+		table.evict()
+	}
+	if es > table.capacity {
+		return
+	}
+	field := HeaderField{Name: string(name), Value: slices.Clone(value)}
+	abs := table.inserted
+	table.inserted++
+	table.entries = append(table.entries, field)
+	table.size += es
+	table.fields[fieldKey(field.Name, field.Value)] = abs
+	table.names[field.Name] = abs
+}
+
+func (table *DynamicTable) evict() {
+	field := table.entries[0]
+	abs := table.inserted - uint64(len(table.entries))
+	table.entries[0] = HeaderField{}
+	table.entries = table.entries[1:]
+	table.size -= len(field.Name) + len(field.Value) + entryOverhead
+	if key := fieldKey(field.Name, field.Value); table.fields[key] == abs {
+		delete(table.fields, key)
+	}
+	if table.names[field.Name] == abs {
+		delete(table.names, field.Name)
+	}
+}
+
+func (table *DynamicTable) clear() {
+	clear(table.entries)
+	table.entries = table.entries[:0]
+	table.size = 0
+	clear(table.fields)
+	clear(table.names)
+}
+
+// invalidate
+// a failed block is not sent, so the packer side starts over and tells the unpacker side to do the same.
+func (table *DynamicTable) invalidate() {
+	if table.maxSize == 0 {
+		return
+	}
+	table.clear()
+	table.update = true
+	table.reset = true
+}
+
+func (table *DynamicTable) relative(abs uint64) int {
+	return int(table.inserted - 1 - abs)
+}
+
+// indexField
+// the relative index of the field, it is -1 when not found.
+func (table *DynamicTable) indexField(name []byte, value []byte) int {
+	key := fieldKey(unsafe.String(unsafe.SliceData(name), len(name)), value)
+	if abs, has := table.fields[key]; has {
+		return table.relative(abs)
+	}
+	return -1
+}
+
+// indexName
+// the relative index of the newest field of the name, it is -1 when not found.
+func (table *DynamicTable) indexName(name []byte) int {
+	if abs, has := table.names[unsafe.String(unsafe.SliceData(name), len(name))]; has {
+		return table.relative(abs)
+	}
+	return -1
+}
+
+// get
+// the field of the relative index, the name is empty when it is out of the table.
+func (table *DynamicTable) get(i int) (name []byte, value []byte) {
+	if i < 0 || i >= len(table.entries) {
+		return
+	}
+	field := table.entries[len(table.entries)-1-i]
+	name = unsafe.Slice(unsafe.StringData(field.Name), len(field.Name))
+	value = field.Value
+	return
+}
+
+func fieldKey(name string, value []byte) string {
+	return name + "\x00" + unsafe.String(unsafe.SliceData(value), len(value))
+}
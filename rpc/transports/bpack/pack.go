@@ -103,6 +103,14 @@ type Packer struct {
 type HeaderIterator iter.Seq2[[]byte, []byte]
 
 func (packer *Packer) PackTo(w io.Writer, iter HeaderIterator) (err error) {
+	err = packer.PackToWithTable(w, iter, nil)
+	return
+}
+
+// PackToWithTable
+// pack with the dynamic table of the connection, fields which are not in the dictionary are indexed by the table.
+// the table is nil means no dynamic table.
+func (packer *Packer) PackToWithTable(w io.Writer, iter HeaderIterator, table *DynamicTable) (err error) {
 	if w == nil {
 		err = errors.New("pack failed for writer is nil")
 		return
@@ -114,6 +122,23 @@ func (packer *Packer) PackTo(w io.Writer, iter HeaderIterator) (err error) {
 	buf := bytebuffers.Acquire()
 	defer bytebuffers.Release(buf)
 
+	if table != nil {
+		// the block may be not sent when failed, so the peer can not see the insertions of it.
+		defer func() {
+			if err != nil {
+				table.invalidate()
+			}
+		}()
+		if table.update {
+			if table.reset {
+				writeVarInt(buf, 0x00, 5, 0)
+			}
+			writeVarInt(buf, 0x00, 5, uint64(table.maxSize))
+			_ = table.setCapacity(table.maxSize)
+			table.update, table.reset = false, false
+		}
+	}
+
 	for name, value := range iter {
 		if len(name) == 0 {
 			err = errors.New("pack failed for one empty name")
@@ -124,12 +149,32 @@ func (packer *Packer) PackTo(w io.Writer, iter HeaderIterator) (err error) {
 			return
 		}
 		i, j := packer.dict.Index(name, value)
-		if i < 0 { // not found
-			packer.writeLiteralFieldWithoutNameReference(buf, name, value)
-		} else if j < 0 { // found name
-			packer.writeLiteralFieldWithNameReference(buf, i, value)
-		} else { // found name and value
+		if j > -1 { // found name and value
 			packer.writeIndexedField(buf, j)
+			continue
+		}
+		if table == nil {
+			if i < 0 { // not found
+				packer.writeLiteralFieldWithoutNameReference(buf, name, value)
+			} else { // found name
+				packer.writeLiteralFieldWithNameReference(buf, i, value)
+			}
+			continue
+		}
+		if k := table.indexField(name, value); k > -1 {
+			packer.writeDynamicIndexedField(buf, k)
+			continue
+		}
+		insert := table.fits(name, value)
+		if i > -1 {
+			packer.writeLiteralField(buf, 0x10, i, value, insert)
+		} else if k := table.indexName(name); k > -1 {
+			packer.writeLiteralField(buf, 0x00, k, value, insert)
+		} else {
+			packer.writeLiteralFieldWithoutName(buf, name, value, insert)
+		}
+		if insert {
+			table.add(name, value)
 		}
 	}
 	bLen := buf.Len()
@@ -155,6 +200,8 @@ func (packer *Packer) PackTo(w io.Writer, iter HeaderIterator) (err error) {
 	return
 }
 
+// writeLiteral
+// write p as a length prefixed string, the bit above the n bits length prefix is the huffman flag.
 func (packer *Packer) writeLiteral(b bytebuffers.Buffer, prefix byte, n byte, p []byte) {
 	pLen := uint64(len(p))
 	if pLen == 0 {
@@ -164,7 +211,7 @@ func (packer *Packer) writeLiteral(b bytebuffers.Buffer, prefix byte, n byte, p
 	s := unsafe.String(unsafe.SliceData(p), pLen)
 	if hpack.HuffmanEncodeLength(s) < pLen {
 		sp := hpack.AppendHuffmanString(nil, s)
-		writeVarInt(b, prefix, n, uint64(len(sp)))
+		writeVarInt(b, prefix|(1<<n), n, uint64(len(sp)))
 		_, _ = b.Write(sp)
 	} else {
 		writeVarInt(b, prefix, n, pLen)
@@ -173,24 +220,57 @@ func (packer *Packer) writeLiteral(b bytebuffers.Buffer, prefix byte, n byte, p
 }
 
 func (packer *Packer) writeLiteralFieldWithoutNameReference(b bytebuffers.Buffer, name []byte, value []byte) {
-	packer.writeLiteral(b, 0x20^0x8, 3, name)
-	packer.writeLiteral(b, 0x80, 7, value)
+	packer.writeLiteral(b, 0x20, 3, name)
+	packer.writeLiteral(b, 0x00, 7, value)
 }
 
 func (packer *Packer) writeLiteralFieldWithNameReference(b bytebuffers.Buffer, i int, value []byte) {
 	writeVarInt(b, 0x50, 4, uint64(i))
-	packer.writeLiteral(b, 0x80, 7, value)
+	packer.writeLiteral(b, 0x00, 7, value)
 }
 
 func (packer *Packer) writeIndexedField(b bytebuffers.Buffer, i int) {
 	writeVarInt(b, 0xc0, 6, uint64(i))
 }
 
+// writeLiteralField
+// the name is referenced by the dictionary when static is 0x10, else by the dynamic table.
+// the field is inserted into the dynamic table by the peer when insert is true.
+func (packer *Packer) writeLiteralField(b bytebuffers.Buffer, static byte, i int, value []byte, insert bool) {
+	prefix := 0x40 | static
+	if insert {
+		prefix |= 0x20
+	}
+	writeVarInt(b, prefix, 4, uint64(i))
+	packer.writeLiteral(b, 0x00, 7, value)
+}
+
+func (packer *Packer) writeLiteralFieldWithoutName(b bytebuffers.Buffer, name []byte, value []byte, insert bool) {
+	var prefix byte = 0x20
+	if insert {
+		prefix |= 0x10
+	}
+	packer.writeLiteral(b, prefix, 3, name)
+	packer.writeLiteral(b, 0x00, 7, value)
+}
+
+func (packer *Packer) writeDynamicIndexedField(b bytebuffers.Buffer, i int) {
+	writeVarInt(b, 0x80, 6, uint64(i))
+}
+
 type HeaderWriter interface {
 	Set(key []byte, value []byte) error
 }
 
 func (packer *Packer) UnpackFrom(r io.Reader, header HeaderWriter) (err error) {
+	err = packer.UnpackFromWithTable(r, header, nil)
+	return
+}
+
+// UnpackFromWithTable
+// unpack with the dynamic table of the connection, blocks must be unpacked in the order of packing.
+// the table is nil means no dynamic table.
+func (packer *Packer) UnpackFromWithTable(r io.Reader, header HeaderWriter, table *DynamicTable) (err error) {
 	if r == nil {
 		err = errors.New("unpack failed for reader is nil")
 		return
@@ -232,29 +312,49 @@ func (packer *Packer) UnpackFrom(r io.Reader, header HeaderWriter) (err error) {
 	for buf.Len() > 0 && err == nil {
 		prefix := buf.Peek(1)[0]
 		switch {
-		case prefix&0x80 > 0:
+		case prefix&0xc0 == 0xc0:
 			err = packer.readIndexedField(buf, header)
 			break
+		case prefix&0xc0 == 0x80:
+			err = packer.readDynamicIndexedField(buf, header, table)
+			break
 		case prefix&0xc0 == 0x40:
-			err = packer.readLiteralFieldWithNameReference(buf, header)
+			err = packer.readLiteralFieldWithNameReference(buf, header, table)
 			break
 		case prefix&0xe0 == 0x20:
-			err = packer.readLiteralFieldWithoutNameReference(buf, header)
+			name, value, readErr := packer.readLiteralFieldWithoutNameReference(buf)
+			if readErr != nil {
+				err = readErr
+				break
+			}
+			if prefix&0x10 != 0 {
+				if table == nil {
+					err = errors.New("no dynamic table to insert")
+					break
+				}
+				table.add(name, value)
+			}
+			err = header.Set(name, value)
 			break
 		default:
-			err = errors.New("unknown field kind")
-			return
+			err = packer.readTableSizeUpdate(buf, table)
+			break
 		}
 	}
 	if errors.Is(err, io.EOF) {
 		err = nil
-	} else {
-		errors.Join(errors.New("unpack failed"), err)
+	} else if err != nil {
+		err = errors.Join(errors.New("unpack failed"), err)
 	}
 	return
 }
 
 func (packer *Packer) readLiteral(b bytebuffers.Buffer, n byte) (p []byte, err error) {
+	if b.Len() == 0 {
+		err = io.EOF
+		return
+	}
+	usesHuffman := b.Peek(1)[0]&(1<<n) != 0
 	i, iErr := readVarInt(b, n)
 	if iErr != nil {
 		err = iErr
@@ -267,39 +367,46 @@ func (packer *Packer) readLiteral(b bytebuffers.Buffer, n byte) (p []byte, err e
 	if err != nil {
 		return
 	}
-	if usesHuffman := p[0]&0x80 > 0; usesHuffman {
+	if usesHuffman {
 		s, sErr := hpack.HuffmanDecodeToString(p)
 		if sErr != nil {
 			err = sErr
 			return
 		}
 		p = []byte(s)
+		return
 	}
+	// the buffer is released after unpacking, so the header must not refer to it.
+	p = slices.Clone(p)
 	return
 }
 
-func (packer *Packer) readLiteralFieldWithoutNameReference(b bytebuffers.Buffer, header HeaderWriter) (err error) {
-	name, nameErr := packer.readLiteral(b, 3)
-	if nameErr != nil {
-		err = nameErr
-		return
-	}
-	value, valueErr := packer.readLiteral(b, 7)
-	if valueErr != nil {
-		err = valueErr
+func (packer *Packer) readLiteralFieldWithoutNameReference(b bytebuffers.Buffer) (name []byte, value []byte, err error) {
+	if name, err = packer.readLiteral(b, 3); err != nil {
 		return
 	}
-	err = header.Set(name, value)
+	value, err = packer.readLiteral(b, 7)
 	return
 }
 
-func (packer *Packer) readLiteralFieldWithNameReference(b bytebuffers.Buffer, header HeaderWriter) (err error) {
+func (packer *Packer) readLiteralFieldWithNameReference(b bytebuffers.Buffer, header HeaderWriter, table *DynamicTable) (err error) {
+	prefix := b.Peek(1)[0]
+	static, insert := prefix&0x10 != 0, prefix&0x20 != 0
+	if (!static || insert) && table == nil {
+		err = errors.New("no dynamic table to reference")
+		return
+	}
 	ni, niErr := readVarInt(b, 4)
 	if niErr != nil {
 		err = niErr
 		return
 	}
-	name, _ := packer.dict.Get(int(ni))
+	var name []byte
+	if static {
+		name, _ = packer.dict.Get(int(ni))
+	} else {
+		name, _ = table.get(int(ni))
+	}
 	if len(name) == 0 {
 		err = errors.New("read no name from dictionary")
 		return
@@ -309,6 +416,9 @@ func (packer *Packer) readLiteralFieldWithNameReference(b bytebuffers.Buffer, he
 		err = valueErr
 		return
 	}
+	if insert {
+		table.add(name, value)
+	}
 	err = header.Set(name, value)
 	return
 }
@@ -328,6 +438,70 @@ func (packer *Packer) readIndexedField(b bytebuffers.Buffer, header HeaderWriter
 	return
 }
 
+func (packer *Packer) readDynamicIndexedField(b bytebuffers.Buffer, header HeaderWriter, table *DynamicTable) (err error) {
+	if table == nil {
+		err = errors.New("no dynamic table to reference")
+		return
+	}
+	ni, niErr := readVarInt(b, 6)
+	if niErr != nil {
+		err = niErr
+		return
+	}
+	name, value := table.get(int(ni))
+	if len(name) == 0 {
+		err = errors.New("read nothing from dynamic table")
+		return
+	}
+	err = header.Set(name, value)
+	return
+}
+
+func (packer *Packer) readTableSizeUpdate(b bytebuffers.Buffer, table *DynamicTable) (err error) {
+	if table == nil {
+		err = errors.New("no dynamic table to update")
+		return
+	}
+	n, nErr := readVarInt(b, 5)
+	if nErr != nil {
+		err = nErr
+		return
+	}
+	if n > uint64(table.maxSize) {
+		err = ErrHeaderTableSizeExceeded
+		return
+	}
+	err = table.setCapacity(int(n))
+	return
+}
+
+// Len
+// the count of fields of the dictionary.
+func (packer *Packer) Len() int {
+	return packer.dict.Len()
+}
+
+// Fingerprint
+// the fingerprint of the first n fields of the dictionary.
+//
+// a dictionary which only appends fields to another one keeps the fingerprint of the other one as its prefix,
+// so the peers of different versions still understand each other by the shorter one.
+func (packer *Packer) Fingerprint(n int) uint64 {
+	return packer.dict.Fingerprint(n)
+}
+
+// Prefix
+// a packer which only has the first n fields of the dictionary, it has no field when n is 0, so fields are packed as literals.
+func (packer *Packer) Prefix(n int) *Packer {
+	n = min(max(n, 0), packer.dict.Len())
+	prefix := &Packer{
+		maxHeaderBytes: packer.maxHeaderBytes,
+		dict:           new(Dictionary),
+	}
+	prefix.dict.Load(slices.Clone(packer.dict.fields[:n]))
+	return prefix
+}
+
 func (packer *Packer) Reset(fields []HeaderField) {
 	packer.dict.Load(fields)
 }
@@ -410,7 +584,12 @@ func (packer *Packer) LoadFrom(r io.Reader) (err error) {
 
 	hw := new(HeaderFields)
 	for {
-		if err = packer.readLiteralFieldWithoutNameReference(buf, hw); err != nil {
+		name, value, readErr := packer.readLiteralFieldWithoutNameReference(buf)
+		if readErr != nil {
+			err = readErr
+			break
+		}
+		if err = hw.Set(name, value); err != nil {
 			break
 		}
 	}
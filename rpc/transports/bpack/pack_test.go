@@ -2,7 +2,9 @@ package bpack_test
 
 import (
 	"bytes"
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -134,3 +136,87 @@ func TestPack_DumpTo(t *testing.T) {
 		t.Log("name:", string(name), "value:", string(value))
 	}
 }
+
+func TestPack_DynamicTable(t *testing.T) {
+	pack, packErr := bpack.New(
+		bpack.MaxHeaderSize(256),
+		bpack.Field("f1", "f1-a"),
+	)
+	if packErr != nil {
+		t.Fatal(packErr)
+	}
+	encoder, decoder := bpack.NewDynamicTable(256), bpack.NewDynamicTable(256)
+	roundTrip := func(h *Header) (n int, err error) {
+		buf := bytes.NewBuffer(nil)
+		if err = pack.PackToWithTable(buf, h.Iterator(), encoder); err != nil {
+			return
+		}
+		n = buf.Len()
+		h2 := &Header{value: make(http.Header)}
+		if err = pack.UnpackFromWithTable(buf, h2, decoder); err != nil {
+			return
+		}
+		for name, values := range h.value {
+			if h2.value.Get(name) != values[0] {
+				err = errors.New("unexpected value of " + name + ": " + h2.value.Get(name))
+				return
+			}
+		}
+		return
+	}
+
+	h := &Header{value: make(http.Header)}
+	h.Set([]byte("f1"), []byte("f1-x"))
+	h.Set([]byte("authorization"), []byte("Bearer eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiJicmljayJ9"))
+	h.Set([]byte("x-trace-id"), []byte("4bf92f3577b34da6a3ce929d0e0e4736"))
+	first, firstErr := roundTrip(h)
+	if firstErr != nil {
+		t.Fatal(firstErr)
+	}
+	second, secondErr := roundTrip(h)
+	if secondErr != nil {
+		t.Fatal(secondErr)
+	}
+	if second > 8 {
+		t.Fatal("repeated header should be indexed, first", first, "second", second)
+	}
+	if encoder.Len() != 3 || decoder.Len() != 3 || encoder.Size() != decoder.Size() {
+		t.Fatal("tables are not in sync", encoder.Len(), decoder.Len())
+	}
+
+	// the oldest are evicted when the table is full
+	for i := 0; i < 8; i++ {
+		h2 := &Header{value: make(http.Header)}
+		h2.Set([]byte("x-trace-id"), []byte(strings.Repeat(strconv.Itoa(i), 32)))
+		if _, err := roundTrip(h2); err != nil {
+			t.Fatal(err)
+		}
+		if encoder.Size() > 256 || encoder.Size() != decoder.Size() {
+			t.Fatal("unexpected table size", encoder.Size(), decoder.Size())
+		}
+	}
+
+	// a failed block resets both tables
+	large := &Header{value: make(http.Header)}
+	large.Set([]byte("x-large"), []byte(strings.Repeat("l", 64)))
+	large.Set([]byte("x-larger"), []byte(strings.Repeat("r", 256)))
+	if err := pack.PackToWithTable(bytes.NewBuffer(nil), large.Iterator(), encoder); err == nil {
+		t.Fatal("header should be too large")
+	}
+	if _, err := roundTrip(h); err != nil {
+		t.Fatal(err)
+	}
+	if encoder.Len() != decoder.Len() || encoder.Size() != decoder.Size() {
+		t.Fatal("tables are not in sync after failure", encoder.Len(), decoder.Len())
+	}
+
+	// the peer which has a smaller table rejects the size
+	small := bpack.NewDynamicTable(128)
+	buf := bytes.NewBuffer(nil)
+	if err := pack.PackToWithTable(buf, h.Iterator(), bpack.NewDynamicTable(256)); err != nil {
+		t.Fatal(err)
+	}
+	if err := pack.UnpackFromWithTable(buf, &Header{value: make(http.Header)}, small); !errors.Is(err, bpack.ErrHeaderTableSizeExceeded) {
+		t.Fatal("expected table size exceeded, got", err)
+	}
+}
@@ -0,0 +1,352 @@
+package transports
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brickingsoft/brick/transports"
+	"github.com/brickingsoft/brick/transports/encoding"
+)
+
+const (
+	// MaskedHeaderValue
+	// the value of masked headers in capture records.
+	MaskedHeaderValue = "******"
+)
+
+// DefaultCaptureMaskedHeaders
+// the headers which are masked when no masked headers are given.
+var DefaultCaptureMaskedHeaders = []string{AuthorizationHeaderStringKey, SignatureHeaderStringKey}
+
+// CaptureField
+// a header field of a capture record.
+type CaptureField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// CaptureMessage
+// the header and the raw body of a request or a response, Succeed is only used by responses.
+type CaptureMessage struct {
+	Succeed bool           `json:"succeed,omitempty"`
+	Header  []CaptureField `json:"header,omitempty"`
+	Body    []byte         `json:"body,omitempty"`
+}
+
+// CaptureRecord
+// a request and its response, Time is when the request is received or sent.
+type CaptureRecord struct {
+	Time     time.Time      `json:"time"`
+	Duration time.Duration  `json:"duration"`
+	Endpoint string         `json:"endpoint"`
+	Function string         `json:"function"`
+	Request  CaptureMessage `json:"request"`
+	Response CaptureMessage `json:"response"`
+}
+
+// Capture
+// write capture records as json lines, it is safe for concurrent use.
+type Capture struct {
+	locker  sync.Mutex
+	encoder *json.Encoder
+	masked  []string
+	err     error
+}
+
+// NewCapture
+// the masked headers are DefaultCaptureMaskedHeaders when none is given.
+func NewCapture(w io.Writer, masked ...string) *Capture {
+	if len(masked) == 0 {
+		masked = DefaultCaptureMaskedHeaders
+	}
+	names := make([]string, 0, len(masked))
+	for _, name := range masked {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return &Capture{
+		encoder: json.NewEncoder(w),
+		masked:  names,
+	}
+}
+
+func (c *Capture) isMasked(name string) bool {
+	for _, masked := range c.masked {
+		if masked == name {
+			return true
+		}
+	}
+	return false
+}
+
+// fields
+// copy the header, the values of masked headers are replaced.
+func (c *Capture) fields(h transports.Header) (fields []CaptureField) {
+	if h == nil {
+		return
+	}
+	for name, value := range h.All() {
+		field := CaptureField{Name: string(name), Value: string(value)}
+		if c.isMasked(field.Name) {
+			field.Value = MaskedHeaderValue
+		}
+		fields = append(fields, field)
+	}
+	return
+}
+
+// Record
+// write the record, the first failure is kept and returned by Err.
+func (c *Capture) Record(record *CaptureRecord) {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	if c.err != nil {
+		return
+	}
+	if err := c.encoder.Encode(record); err != nil {
+		c.err = errors.Join(errors.New("failed to write capture record"), err)
+	}
+}
+
+// Err
+// the failure of writing records.
+func (c *Capture) Err() error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	return c.err
+}
+
+// ReadCaptureRecords
+// read records of a capture until the end.
+func ReadCaptureRecords(r io.Reader, f func(index int, record *CaptureRecord) bool) (err error) {
+	decoder := json.NewDecoder(r)
+	for i := 0; ; i++ {
+		record := new(CaptureRecord)
+		if err = decoder.Decode(record); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+				return
+			}
+			err = errors.Join(errors.New("failed to read capture record"), err)
+			return
+		}
+		if !f(i, record) {
+			return
+		}
+	}
+}
+
+// NewCaptureTransport
+// a transport which records the requests and responses of the brick protocol, both served and sent ones.
+// hijacked requests (streams) are not recorded, and the bodies of streaming functions are buffered to be recorded.
+func NewCaptureTransport(transport transports.Transport, capture *Capture) (tr *CaptureTransport, err error) {
+	if transport == nil {
+		err = errors.Join(errors.New("new capture transport failed"), errors.New("transport is missing"))
+		return
+	}
+	if transport.Name() == HTTPTransportName {
+		err = errors.Join(errors.New("new capture transport failed"), errors.New("http transport is not the brick protocol"))
+		return
+	}
+	if capture == nil {
+		err = errors.Join(errors.New("new capture transport failed"), errors.New("capture is missing"))
+		return
+	}
+	tr = &CaptureTransport{
+		transport: transport,
+		capture:   capture,
+	}
+	return
+}
+
+// CaptureTransport
+// the decorator of a transport which records traffic.
+type CaptureTransport struct {
+	transport transports.Transport
+	capture   *Capture
+}
+
+func (tr *CaptureTransport) Name() string {
+	return tr.transport.Name()
+}
+
+func (tr *CaptureTransport) Listen(ctx context.Context, handler transports.ServeHandler) (err error) {
+	return tr.transport.Listen(ctx, &captureHandler{handler: handler, capture: tr.capture})
+}
+
+func (tr *CaptureTransport) Connect(ctx context.Context, address string) (client transports.Client, err error) {
+	if client, err = tr.transport.Connect(ctx, address); err != nil {
+		return
+	}
+	cc := &captureClient{Client: client, capture: tr.capture}
+	if sc, ok := client.(transports.StreamClient); ok {
+		client = &captureStreamClient{captureClient: cc, stream: sc}
+		return
+	}
+	client = cc
+	return
+}
+
+func (tr *CaptureTransport) Close() (err error) {
+	return tr.transport.Close()
+}
+
+// Shutdown
+// it is Close when the transport is not graceful.
+func (tr *CaptureTransport) Shutdown(ctx context.Context) (err error) {
+	if graceful, ok := tr.transport.(transports.GracefulTransport); ok {
+		return graceful.Shutdown(ctx)
+	}
+	return tr.transport.Close()
+}
+
+// Unwrap
+// the decorated transport.
+func (tr *CaptureTransport) Unwrap() transports.Transport {
+	return tr.transport
+}
+
+type captureHandler struct {
+	handler transports.ServeHandler
+	capture *Capture
+}
+
+func (h *captureHandler) Handle(r transports.RequestCtx) {
+	record := &CaptureRecord{
+		Time:     time.Now(),
+		Endpoint: r.Endpoint(),
+		Function: r.Function(),
+	}
+	record.Request.Header = h.capture.fields(r.Header())
+	if body, err := r.Body(); err == nil && len(body) > 0 {
+		record.Request.Body = append([]byte(nil), body...)
+	}
+	ctx := &captureRequestCtx{
+		RequestCtx: r,
+		writer:     &captureResponseWriter{ResponseWriter: r.Response(), capture: h.capture, record: record},
+	}
+	h.handler.Handle(ctx)
+	if ctx.Hijacked() {
+		return
+	}
+	if !ctx.writer.responded {
+		// the server succeeds the request which is not responded.
+		ctx.writer.Succeed(nil)
+	}
+}
+
+// captureRequestCtx
+// the response of the request is recorded when it is written.
+type captureRequestCtx struct {
+	transports.RequestCtx
+	writer *captureResponseWriter
+}
+
+func (r *captureRequestCtx) Response() transports.ResponseWriter {
+	return r.writer
+}
+
+// BodyReader
+// the body was buffered to be recorded.
+func (r *captureRequestCtx) BodyReader() io.Reader {
+	if sr, ok := r.RequestCtx.(transports.StreamingRequest); ok {
+		return sr.BodyReader()
+	}
+	body, _ := r.RequestCtx.Body()
+	return bytes.NewReader(body)
+}
+
+type captureResponseWriter struct {
+	transports.ResponseWriter
+	capture   *Capture
+	record    *CaptureRecord
+	responded bool
+}
+
+func (w *captureResponseWriter) Succeed(v any) {
+	if w.responded {
+		return
+	}
+	encoderOf := func() (encoding.Encoder, error) {
+		return encoding.Retrieve(encoding.AvroEncoderType), nil
+	}
+	if be, ok := w.ResponseWriter.(interface {
+		bodyEncoder() (encoding.Encoder, error)
+	}); ok {
+		encoderOf = be.bodyEncoder
+	}
+	body, err := encodeBody(v, encoderOf)
+	if err != nil {
+		w.Failed(err)
+		return
+	}
+	w.done(true, body)
+	w.ResponseWriter.Succeed(RawBody(body))
+}
+
+func (w *captureResponseWriter) Failed(err error) {
+	if w.responded {
+		return
+	}
+	w.done(false, encodeFailure(err))
+	w.ResponseWriter.Failed(err)
+}
+
+// done
+// record before writing, the header of the response is reset after it is written.
+func (w *captureResponseWriter) done(succeed bool, body []byte) {
+	w.responded = true
+	w.record.Duration = time.Since(w.record.Time)
+	w.record.Response = CaptureMessage{
+		Succeed: succeed,
+		Header:  w.capture.fields(w.ResponseWriter.Header()),
+		Body:    append([]byte(nil), body...),
+	}
+	w.capture.Record(w.record)
+}
+
+type captureClient struct {
+	transports.Client
+	capture *Capture
+}
+
+func (c *captureClient) Do(ctx context.Context, request transports.Request) (response transports.Response, err error) {
+	record := &CaptureRecord{
+		Time:     time.Now(),
+		Endpoint: request.Endpoint(),
+		Function: request.Function(),
+	}
+	record.Request.Header = c.capture.fields(request.Header())
+	if body, bodyErr := request.Body(); bodyErr == nil && len(body) > 0 {
+		record.Request.Body = append([]byte(nil), body...)
+	}
+	if response, err = c.Client.Do(ctx, request); err != nil {
+		return
+	}
+	record.Duration = time.Since(record.Time)
+	record.Response.Succeed = response.Succeed()
+	record.Response.Header = c.capture.fields(response.Header())
+	if body, bodyErr := response.Body(); bodyErr == nil && len(body) > 0 {
+		record.Response.Body = append([]byte(nil), body...)
+	}
+	c.capture.Record(record)
+	return
+}
+
+type captureStreamClient struct {
+	*captureClient
+	stream transports.StreamClient
+}
+
+// Stream
+// streams are not recorded.
+func (c *captureStreamClient) Stream(ctx context.Context, request transports.Request) (stream transports.ClientStream, err error) {
+	return c.stream.Stream(ctx, request)
+}
@@ -0,0 +1,117 @@
+package transports_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/brickingsoft/brick/rpc/transports"
+	brick "github.com/brickingsoft/brick/transports"
+)
+
+type upperEchoHandler struct{}
+
+func (h *upperEchoHandler) Handle(r brick.RequestCtx) {
+	if r.Function() != "bar" {
+		(&echoHandler{}).Handle(r)
+		return
+	}
+	body, _ := r.Body()
+	r.Response().Header().Set("echo", string(bytes.ToUpper(body)))
+	r.Response().Header().Set("function", r.Endpoint()+"."+r.Function())
+	r.Response().Succeed(nil)
+}
+
+func TestCapture(t *testing.T) {
+	transports.RegisterFunction("foo", "bar")
+	transports.RegisterFunction("foo", "fail")
+	ctx := context.Background()
+
+	buf := bytes.NewBuffer(nil)
+	capture := transports.NewCapture(buf)
+	tcp, _ := transports.NewTCPTransport(ctx, *mustConfig(t, `address: 127.0.0.1:0`))
+	tr, trErr := transports.NewCaptureTransport(tcp, capture)
+	if trErr != nil {
+		t.Fatal(trErr)
+	}
+	if err := tr.Listen(ctx, &echoHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	client, clientErr := transports.Dial(ctx, "tcp", tcp.(*transports.TCPTransport).Addr().String())
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	defer client.Close()
+
+	requests := []*testRequest{
+		{endpoint: "foo", function: "bar", header: testHeader{"authorization": {"secret"}, "x-id": {"1"}}, body: []byte("hi")},
+		{endpoint: "foo", function: "fail"},
+	}
+	for _, request := range requests {
+		if _, err := client.Do(ctx, request); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := capture.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	var records []*transports.CaptureRecord
+	if err := transports.ReadCaptureRecords(bytes.NewReader(buf.Bytes()), func(_ int, record *transports.CaptureRecord) bool {
+		records = append(records, record)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatal("expected 2 records, got", len(records))
+	}
+	if bytes.Contains(buf.Bytes(), []byte("secret")) {
+		t.Fatal("authorization should be masked")
+	}
+	if record := records[0]; string(record.Request.Body) != "hi" || !record.Response.Succeed || record.Function != "bar" {
+		t.Fatal("unexpected record", record)
+	}
+	if records[1].Response.Succeed {
+		t.Fatal("failure should be recorded")
+	}
+
+	tests := []struct {
+		name    string
+		handler brick.ServeHandler
+		diffs   int
+	}{
+		{name: "same", handler: &echoHandler{}, diffs: 0},
+		{name: "changed", handler: &upperEchoHandler{}, diffs: 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target, _ := transports.NewTCPTransport(ctx, *mustConfig(t, `address: 127.0.0.1:0`))
+			if err := target.Listen(ctx, test.handler); err != nil {
+				t.Fatal(err)
+			}
+			defer target.Close()
+			targetClient, err := transports.Dial(ctx, "tcp", target.(*transports.TCPTransport).Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer targetClient.Close()
+			replayer := &transports.Replayer{
+				Client: targetClient,
+				Prepare: func(_ *transports.CaptureRecord, header brick.Header) {
+					if header.Get("authorization") != "" {
+						t.Error("masked header should not be sent")
+					}
+				},
+			}
+			diffs, replayErr := replayer.Replay(ctx, bytes.NewReader(buf.Bytes()))
+			if replayErr != nil {
+				t.Fatal(replayErr)
+			}
+			if len(diffs) != test.diffs {
+				t.Fatal("unexpected diffs", diffs)
+			}
+		})
+	}
+}
@@ -0,0 +1,615 @@
+package transports
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brickingsoft/brick/rpc/transports/bpack"
+	"github.com/brickingsoft/brick/transports"
+)
+
+var (
+	ErrClientClosed = errors.New("client closed")
+)
+
+// Client
+// calls of one client share one connection, they are multiplexed by request id.
+type Client struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	dict    *dictionaries
+	wl      sync.Mutex
+	seq     atomic.Uint64
+	locker  sync.Mutex
+	pending map[uint64]chan *Response
+	streams map[uint64]*clientStream
+	// pings
+	// the pings of ping, they are closed by pongs.
+	pings    map[uint64]chan struct{}
+	ka       *keepalive
+	compress *compression
+	// functions
+	// the function ids which are named by FunctionFrame on the connection, it is guarded by wl.
+	functions map[uint64]struct{}
+	// encodeTable and decodeTable
+	// the dynamic header tables of requests and responses, they are nil when disabled.
+	// the encodeTable is settled by the handshake, see dictionaries.handshake.
+	encodeTable *bpack.DynamicTable
+	decodeTable *bpack.DynamicTable
+	// maxBodySize
+	// responses which are larger than it are failed by ErrBodyTooLarge.
+	maxBodySize int64
+	err         error
+	done        chan struct{}
+}
+
+func Dial(ctx context.Context, network string, address string, options ...Option) (client *Client, err error) {
+	if ctx == nil {
+		err = errors.Join(errors.New("dial failed"), errors.New("context is missing"))
+		return
+	}
+	dialer := net.Dialer{}
+	conn, dialErr := dialer.DialContext(ctx, network, address)
+	if dialErr != nil {
+		err = errors.Join(errors.New("dial failed"), dialErr)
+		return
+	}
+	if client, err = NewClient(conn, options...); err != nil {
+		_ = conn.Close()
+		return
+	}
+	return
+}
+
+// DialTLS
+// dial and handshake by the tls config.
+func DialTLS(ctx context.Context, network string, address string, config *tls.Config, options ...Option) (client *Client, err error) {
+	if ctx == nil {
+		err = errors.Join(errors.New("dial failed"), errors.New("context is missing"))
+		return
+	}
+	config, configErr := clientTLSOf(config, address)
+	if configErr != nil {
+		err = errors.Join(errors.New("dial failed"), configErr)
+		return
+	}
+	dialer := tls.Dialer{Config: config}
+	conn, dialErr := dialer.DialContext(ctx, network, address)
+	if dialErr != nil {
+		err = errors.Join(errors.New("dial failed"), dialErr)
+		return
+	}
+	if client, err = NewClient(conn, options...); err != nil {
+		_ = conn.Close()
+		return
+	}
+	return
+}
+
+func NewClient(conn net.Conn, options ...Option) (client *Client, err error) {
+	opts, optsErr := newOptions(options...)
+	if optsErr != nil {
+		err = errors.Join(errors.New("new client failed"), optsErr)
+		return
+	}
+	packer, packerErr := newHeaderPacker(opts.MaxHeaderSize, opts.HeaderFields)
+	if packerErr != nil {
+		err = errors.Join(errors.New("new client failed"), packerErr)
+		return
+	}
+	client = &Client{
+		conn:        conn,
+		reader:      bufio.NewReader(conn),
+		dict:        newDictionaries(packer, packer.Prefix(0), opts),
+		pending:     make(map[uint64]chan *Response),
+		streams:     make(map[uint64]*clientStream),
+		pings:       make(map[uint64]chan struct{}),
+		ka:          newKeepalive(opts),
+		compress:    newCompression(opts),
+		functions:   make(map[uint64]struct{}),
+		decodeTable: newHeaderTable(opts),
+		maxBodySize: opts.MaxBodySize,
+		done:        make(chan struct{}),
+	}
+	// read before the handshake, the peer of a synchronous conn may be writing its handshake.
+	go client.read()
+	client.wl.Lock()
+	err = writeHandshake(conn, packer.Len(), packer.Fingerprint(packer.Len()), client.dict.tableSize)
+	client.wl.Unlock()
+	if err != nil {
+		client.abort(err)
+		client = nil
+		err = errors.Join(errors.New("new client failed"), err)
+		return
+	}
+	if client.ka != nil {
+		go client.keepAlive()
+	}
+	return
+}
+
+func (client *Client) Do(ctx context.Context, request transports.Request) (response transports.Response, err error) {
+	if ctx == nil {
+		err = errors.New("context is missing")
+		return
+	}
+	req, reqErr := newRequest(request)
+	if reqErr != nil {
+		err = reqErr
+		return
+	}
+	defer ReleaseRequest(req)
+
+	id := client.seq.Add(1)
+	ch := make(chan *Response, 1)
+	client.locker.Lock()
+	if client.err != nil {
+		err = client.err
+		client.locker.Unlock()
+		return
+	}
+	client.pending[id] = ch
+	client.locker.Unlock()
+
+	if err = client.write(RequestFrame, id, req); err != nil {
+		client.locker.Lock()
+		delete(client.pending, id)
+		client.locker.Unlock()
+		return
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			err = client.failure()
+			return
+		}
+		response = &clientResponse{response: resp}
+		break
+	case <-ctx.Done():
+		client.locker.Lock()
+		delete(client.pending, id)
+		client.locker.Unlock()
+		// the response is sent in the lock, so it is in the channel when it came before the deletion.
+		select {
+		case resp, ok := <-ch:
+			if ok {
+				ReleaseResponse(resp)
+			}
+			break
+		default:
+			break
+		}
+		err = ctx.Err()
+		break
+	}
+	return
+}
+
+// Stream
+// open a stream by the request, the server side must hijack it, otherwise the stream is closed after the first response.
+func (client *Client) Stream(ctx context.Context, request transports.Request) (stream transports.ClientStream, err error) {
+	if ctx == nil {
+		err = errors.New("context is missing")
+		return
+	}
+	req, reqErr := newRequest(request)
+	if reqErr != nil {
+		err = reqErr
+		return
+	}
+	defer ReleaseRequest(req)
+
+	id := client.seq.Add(1)
+	s := &clientStream{
+		ctx:       ctx,
+		client:    client,
+		id:        id,
+		responses: newQueue[*Response](),
+	}
+	client.locker.Lock()
+	if client.err != nil {
+		err = client.err
+		client.locker.Unlock()
+		return
+	}
+	client.streams[id] = s
+	client.locker.Unlock()
+
+	if err = client.write(StreamFrame, id, req); err != nil {
+		client.removeStream(id)
+		return
+	}
+	stream = s
+	return
+}
+
+func (client *Client) Close() (err error) {
+	client.locker.Lock()
+	if client.err == nil {
+		client.err = ErrClientClosed
+	}
+	client.locker.Unlock()
+	err = client.conn.Close()
+	<-client.done
+	return
+}
+
+func (client *Client) write(typ FrameType, id uint64, request *Request) (err error) {
+	client.compress.encodeRequest(request)
+	client.wl.Lock()
+	defer client.wl.Unlock()
+	if _, named := client.functions[request.function]; !named {
+		if err = writeFunction(client.conn, request.function); err != nil {
+			return
+		}
+		client.functions[request.function] = struct{}{}
+	}
+	err = writeRequest(client.conn, client.dict.encoder, client.encodeTable, typ, id, request)
+	return
+}
+
+func (client *Client) writeControl(typ FrameType, id uint64) (err error) {
+	client.wl.Lock()
+	err = writeControl(client.conn, typ, id)
+	client.wl.Unlock()
+	return
+}
+
+// ping
+// send a ping and wait for its pong, it is the active health check of pools.
+// a pong of a keepalive ping with the same id is taken as well, it proves the peer is alive too.
+func (client *Client) ping(ctx context.Context) (err error) {
+	id := client.seq.Add(1)
+	pong := make(chan struct{})
+	client.locker.Lock()
+	if client.err != nil {
+		err = client.err
+		client.locker.Unlock()
+		return
+	}
+	client.pings[id] = pong
+	client.locker.Unlock()
+	defer func() {
+		client.locker.Lock()
+		delete(client.pings, id)
+		client.locker.Unlock()
+	}()
+
+	if err = client.writeControl(PingFrame, id); err != nil {
+		return
+	}
+	select {
+	case <-pong:
+		break
+	case <-client.done:
+		err = client.failure()
+		break
+	case <-ctx.Done():
+		err = ctx.Err()
+		break
+	}
+	return
+}
+
+func (client *Client) removeStream(id uint64) (removed bool) {
+	client.locker.Lock()
+	if _, removed = client.streams[id]; removed {
+		delete(client.streams, id)
+	}
+	client.locker.Unlock()
+	return
+}
+
+func (client *Client) alive() (ok bool) {
+	client.locker.Lock()
+	ok = client.err == nil
+	client.locker.Unlock()
+	return
+}
+
+// abort
+// close the connection by the err, calls are failed by it.
+func (client *Client) abort(err error) {
+	client.locker.Lock()
+	if client.err == nil {
+		client.err = err
+	}
+	client.locker.Unlock()
+	_ = client.conn.Close()
+}
+
+// goAway
+// no more calls are sent, and the calls after the last id are failed by ErrGoAway, they were not handled.
+func (client *Client) goAway(last uint64) {
+	client.locker.Lock()
+	if client.err == nil {
+		client.err = ErrGoAway
+	}
+	for id, ch := range client.pending {
+		if id > last {
+			close(ch)
+			delete(client.pending, id)
+		}
+	}
+	for id, stream := range client.streams {
+		if id > last {
+			stream.responses.end()
+			delete(client.streams, id)
+		}
+	}
+	client.locker.Unlock()
+}
+
+// keepAlive
+// ping when nothing is read for the interval, and abort when the ping is not answered in time.
+func (client *Client) keepAlive() {
+	ticker := time.NewTicker(client.ka.tick())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-client.done:
+			return
+		case now := <-ticker.C:
+			ping, id, dead := client.ka.check(now)
+			if dead {
+				client.abort(ErrKeepAliveTimeout)
+				return
+			}
+			if ping {
+				// a blocked write must not block the check.
+				go func(client *Client, id uint64) {
+					_ = client.writeControl(PingFrame, id)
+				}(client, id)
+			}
+		}
+	}
+}
+
+func (client *Client) failure() (err error) {
+	client.locker.Lock()
+	err = client.err
+	client.locker.Unlock()
+	if err == nil {
+		err = ErrClientClosed
+	}
+	return
+}
+
+func (client *Client) read() {
+	var err error
+	for err == nil {
+		typ, id, headErr := readFrameHead(client.reader)
+		if headErr != nil {
+			err = headErr
+			break
+		}
+		if client.ka != nil {
+			client.ka.touch()
+		}
+		switch typ {
+		case ResponseFrame:
+			response := AcquireResponse()
+			if err = parseResponse(client.reader, client.dict.decoder, client.decodeTable, response, client.maxBodySize); err != nil {
+				if !errors.Is(err, ErrBodyTooLarge) {
+					ReleaseResponse(response)
+					break
+				}
+				failResponse(response, err)
+				err = nil
+			} else if decompressErr := decompressBody(response.body, response.header, client.maxBodySize); decompressErr != nil {
+				failResponse(response, decompressErr)
+			}
+			client.compress.learn(response.header)
+			client.locker.Lock()
+			ch, isCall := client.pending[id]
+			if isCall {
+				delete(client.pending, id)
+				// the channel is buffered, the canceled call releases the response.
+				ch <- response
+			}
+			stream, isStream := client.streams[id]
+			client.locker.Unlock()
+			if !isCall && (!isStream || !stream.responses.push(response)) {
+				ReleaseResponse(response)
+			}
+			break
+		case CloseFrame:
+			client.locker.Lock()
+			stream, isStream := client.streams[id]
+			delete(client.streams, id)
+			client.locker.Unlock()
+			if isStream {
+				stream.responses.end()
+			}
+			break
+		case HandshakeFrame:
+			encoder, table, reply, handshakeErr := client.dict.handshake(client.reader)
+			if handshakeErr != nil {
+				err = handshakeErr
+				break
+			}
+			client.wl.Lock()
+			err = client.dict.answer(client.conn, reply)
+			if encoder != nil {
+				client.dict.encoder = encoder
+			}
+			if table != nil {
+				client.encodeTable = table
+			}
+			client.wl.Unlock()
+			break
+		case DictionaryFrame:
+			err = client.dict.load(client.reader)
+			break
+		case PingFrame:
+			_ = client.writeControl(PongFrame, id)
+			break
+		case PongFrame:
+			client.locker.Lock()
+			if pong, has := client.pings[id]; has {
+				close(pong)
+				delete(client.pings, id)
+			}
+			client.locker.Unlock()
+			break
+		case GoAwayFrame:
+			client.goAway(id)
+			break
+		default:
+			err = ErrInvalidFrame
+			break
+		}
+	}
+
+	client.locker.Lock()
+	if client.err == nil {
+		if errors.Is(err, io.EOF) {
+			err = ErrClientClosed
+		}
+		client.err = err
+	}
+	for id, ch := range client.pending {
+		close(ch)
+		delete(client.pending, id)
+	}
+	for id, stream := range client.streams {
+		stream.responses.end()
+		delete(client.streams, id)
+	}
+	client.locker.Unlock()
+	_ = client.conn.Close()
+	close(client.done)
+}
+
+func newRequest(request transports.Request) (req *Request, err error) {
+	if request == nil {
+		err = errors.New("request is missing")
+		return
+	}
+	req = AcquireRequest(RegisterFunction(request.Endpoint(), request.Function()))
+	if header := request.Header(); header != nil {
+		// the request is written before Do returns, so the bytes of the header are shared.
+		transports.CopyHeader(req.header, header)
+	}
+	body, bodyErr := request.Body()
+	if bodyErr != nil {
+		ReleaseRequest(req)
+		req = nil
+		err = errors.Join(transports.WriteBodyFailed, bodyErr)
+		return
+	}
+	if len(body) > 0 {
+		_, _ = req.body.Write(body)
+	}
+	return
+}
+
+type clientResponse struct {
+	response *Response
+}
+
+func (r *clientResponse) Succeed() bool {
+	return r.response.succeed
+}
+
+func (r *clientResponse) Header() transports.Header {
+	return r.response.header
+}
+
+func (r *clientResponse) Body() (body []byte, err error) {
+	body = r.response.body.Peek(r.response.body.Len())
+	return
+}
+
+// ParseBody
+// when the response is failed, the error of server side is returned.
+func (r *clientResponse) ParseBody(v any) (err error) {
+	body, _ := r.Body()
+	if !r.response.succeed {
+		err = decodeFailure(body)
+		return
+	}
+	encoder, encoderErr := encoderOfContentType(r.response.header.ContentType())
+	if encoderErr != nil {
+		err = errors.Join(transports.ParseBodyFailed, encoderErr)
+		return
+	}
+	if err = encoder.Unmarshal(body, v); err != nil {
+		err = errors.Join(transports.ParseBodyFailed, err)
+	}
+	return
+}
+
+func decodeFailure(body []byte) (err error) {
+	failure := struct {
+		Message string `json:"message"`
+	}{}
+	if decodeErr := json.Unmarshal(body, &failure); decodeErr != nil || strings.TrimSpace(failure.Message) == "" {
+		err = errors.New(string(body))
+		return
+	}
+	err = errors.New(failure.Message)
+	return
+}
+
+type clientStream struct {
+	ctx       context.Context
+	client    *Client
+	id        uint64
+	responses *queue[*Response]
+	closed    atomic.Bool
+}
+
+func (s *clientStream) Send(request transports.Request) (err error) {
+	if s.closed.Load() {
+		err = io.ErrClosedPipe
+		return
+	}
+	req, reqErr := newRequest(request)
+	if reqErr != nil {
+		err = reqErr
+		return
+	}
+	err = s.client.write(StreamFrame, s.id, req)
+	ReleaseRequest(req)
+	return
+}
+
+// Receive
+// io.EOF is returned when the stream was closed by the server side.
+func (s *clientStream) Receive() (response transports.Response, err error) {
+	resp, ok := s.responses.pop(s.ctx)
+	if !ok {
+		if ctxErr := s.ctx.Err(); ctxErr != nil {
+			err = ctxErr
+			return
+		}
+		err = io.EOF
+		return
+	}
+	response = &clientResponse{response: resp}
+	return
+}
+
+func (s *clientStream) Close() (err error) {
+	if !s.closed.CompareAndSwap(false, true) {
+		return
+	}
+	if s.client.removeStream(s.id) {
+		err = s.client.writeControl(CloseFrame, s.id)
+	}
+	for _, response := range s.responses.drain() {
+		ReleaseResponse(response)
+	}
+	return
+}
@@ -0,0 +1,228 @@
+package transports
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/brickingsoft/bytebuffers"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// DefaultCompressThreshold
+	// bodies which are smaller than it are not compressed.
+	DefaultCompressThreshold = 1024
+	// DefaultMaxDecompressedBodySize
+	// the max size of a decompressed body, it stops compression bombs.
+	DefaultMaxDecompressedBodySize = 64 << 20
+)
+
+var (
+	ErrUnsupportedContentEncoding = errors.New("unsupported content encoding")
+	ErrDecompressBodyFailed       = errors.New("failed to decompress body")
+)
+
+// CompressionConfig
+// the compression node of a transport config.
+type CompressionConfig struct {
+	// Encodings
+	// the content encodings in preference order, they are snappy and zstd, compression is disabled when it is empty.
+	// the server tells its encodings by the first response of a connection, requests are compressed after it.
+	Encodings []string `json:"encodings" yaml:"encodings"`
+	// Threshold
+	// bodies which are smaller than it are not compressed, the default is 1KB.
+	Threshold int `json:"threshold" yaml:"threshold"`
+}
+
+type codec struct {
+	encode func(src []byte) []byte
+	decode func(src []byte, limit int) ([]byte, error)
+}
+
+var (
+	zstdEncoder = sync.OnceValue(func() *zstd.Encoder {
+		encoder, _ := zstd.NewWriter(nil)
+		return encoder
+	})
+	// zstdDecoders
+	// the synchronous stream decoders, they have no goroutines, so they can be pooled.
+	zstdDecoders = sync.Pool{
+		New: func() any {
+			decoder, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(DefaultMaxDecompressedBodySize))
+			return decoder
+		},
+	}
+	codecs = map[string]codec{
+		SnappyContentEncodingValueString: {
+			encode: func(src []byte) []byte {
+				return snappy.Encode(nil, src)
+			},
+			decode: func(src []byte, limit int) ([]byte, error) {
+				n, err := snappy.DecodedLen(src)
+				if err != nil {
+					return nil, err
+				}
+				if n > limit {
+					return nil, ErrBodyTooLarge
+				}
+				return snappy.Decode(nil, src)
+			},
+		},
+		ZstdContentEncodingValueString: {
+			encode: func(src []byte) []byte {
+				return zstdEncoder().EncodeAll(src, nil)
+			},
+			decode: func(src []byte, limit int) ([]byte, error) {
+				// the body is decoded as a stream, so a bomb is stopped at the limit.
+				decoder := zstdDecoders.Get().(*zstd.Decoder)
+				defer func() {
+					_ = decoder.Reset(nil)
+					zstdDecoders.Put(decoder)
+				}()
+				if err := decoder.Reset(bytes.NewReader(src)); err != nil {
+					return nil, err
+				}
+				plain, err := io.ReadAll(io.LimitReader(decoder, int64(limit)+1))
+				if err != nil {
+					return nil, err
+				}
+				if len(plain) > limit {
+					return nil, ErrBodyTooLarge
+				}
+				return plain, nil
+			},
+		},
+	}
+)
+
+// compression
+// the body compression of a peer, it is nil when compression is disabled.
+type compression struct {
+	encodings []string
+	accept    []byte
+	threshold int
+	// encoding
+	// the encoding of requests, it is learned from the accept-encoding of responses, it is nil before that.
+	encoding atomic.Pointer[string]
+}
+
+func newCompression(opts Options) (c *compression) {
+	if len(opts.ContentEncodings) == 0 {
+		return
+	}
+	c = &compression{
+		encodings: opts.ContentEncodings,
+		accept:    []byte(strings.Join(opts.ContentEncodings, ",")),
+		threshold: opts.CompressThreshold,
+	}
+	if c.threshold < 1 {
+		c.threshold = DefaultCompressThreshold
+	}
+	return
+}
+
+// encodeRequest
+// tell the server which encodings are accepted, and compress the body by the learned one.
+// the body is not compressed before the encodings of the server are known.
+func (c *compression) encodeRequest(request *Request) {
+	if c == nil {
+		return
+	}
+	request.header.SetBytes(AcceptEncodingHeaderKey, c.accept)
+	if encoding := c.encoding.Load(); encoding != nil {
+		compressBody(request.body, request.header, *encoding, c.threshold)
+	}
+}
+
+// learn
+// the accept-encoding of a response is the encodings of the server, requests use the preferred one of them.
+func (c *compression) learn(h *header) {
+	if c == nil || c.encoding.Load() != nil {
+		return
+	}
+	accept := h.Peek(AcceptEncodingHeaderKey)
+	if len(accept) == 0 {
+		return
+	}
+	encoding := c.negotiate(accept)
+	c.encoding.Store(&encoding)
+}
+
+// negotiate
+// the first encoding of the server which is accepted by the client, it is empty when none is accepted.
+func (c *compression) negotiate(accept []byte) (encoding string) {
+	if c == nil || len(accept) == 0 {
+		return
+	}
+	accepts := strings.Split(string(accept), ",")
+	for _, candidate := range c.encodings {
+		for _, a := range accepts {
+			if strings.TrimSpace(a) == candidate {
+				encoding = candidate
+				return
+			}
+		}
+	}
+	return
+}
+
+// compressBody
+// the body is kept as it is when it is smaller than the threshold or the compressed one is not smaller.
+func compressBody(body bytebuffers.Buffer, h *header, encoding string, threshold int) {
+	bLen := body.Len()
+	if encoding == "" || bLen < threshold {
+		return
+	}
+	c, has := codecs[encoding]
+	if !has {
+		return
+	}
+	compressed := c.encode(body.Peek(bLen))
+	if len(compressed) >= bLen {
+		return
+	}
+	body.Reset()
+	_, _ = body.Write(compressed)
+	h.SetContentEncoding([]byte(encoding))
+}
+
+// decompressBody
+// the content encoding is removed after decompressing, so handlers see the plain body.
+// ErrBodyTooLarge is returned when the decompressed body is larger than the limit.
+func decompressBody(body bytebuffers.Buffer, h *header, limit int64) (err error) {
+	encoding := h.ContentEncoding()
+	if len(encoding) == 0 {
+		return
+	}
+	c, has := codecs[string(encoding)]
+	if !has {
+		err = errors.Join(ErrUnsupportedContentEncoding, errors.New(string(encoding)))
+		return
+	}
+	if limit < 1 || limit > DefaultMaxDecompressedBodySize {
+		limit = DefaultMaxDecompressedBodySize
+	}
+	plain, decodeErr := c.decode(body.Peek(body.Len()), int(limit))
+	if decodeErr != nil {
+		if errors.Is(decodeErr, ErrBodyTooLarge) {
+			err = ErrBodyTooLarge
+			return
+		}
+		err = errors.Join(ErrDecompressBodyFailed, decodeErr)
+		return
+	}
+	body.Reset()
+	_, _ = body.Write(plain)
+	h.SetContentEncoding(nil)
+	return
+}
+
+func validContentEncoding(encoding string) bool {
+	_, has := codecs[encoding]
+	return has
+}
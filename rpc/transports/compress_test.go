@@ -0,0 +1,171 @@
+package transports_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/brickingsoft/brick/rpc/transports"
+	brick "github.com/brickingsoft/brick/transports"
+	"github.com/klauspost/compress/zstd"
+)
+
+type rawEchoHandler struct{}
+
+func (h *rawEchoHandler) Handle(r brick.RequestCtx) {
+	body, err := r.Body()
+	if err != nil {
+		r.Response().Failed(err)
+		return
+	}
+	r.Response().Succeed(transports.RawBody(body))
+}
+
+type countingConn struct {
+	net.Conn
+	read    atomic.Int64
+	written atomic.Int64
+}
+
+func (c *countingConn) Read(p []byte) (n int, err error) {
+	n, err = c.Conn.Read(p)
+	c.read.Add(int64(n))
+	return
+}
+
+func (c *countingConn) Write(p []byte) (n int, err error) {
+	n, err = c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return
+}
+
+func TestCompression(t *testing.T) {
+	transports.RegisterFunction("foo", "raw")
+	ctx := context.Background()
+	tr, _ := transports.NewTCPTransport(ctx, *mustConfig(t, `
+address: 127.0.0.1:0
+compression:
+  encodings: [zstd]
+  threshold: 64
+`))
+	if err := tr.Listen(ctx, &rawEchoHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	address := tr.(*transports.TCPTransport).Addr().String()
+	body := bytes.Repeat([]byte("brick list item;"), 4096)
+
+	tests := []struct {
+		name      string
+		encodings []string
+		maxBytes  int64
+	}{
+		{name: "zstd", encodings: []string{"zstd", "snappy"}, maxBytes: 8 << 10},
+		// the server does not accept snappy, so nothing is compressed.
+		{name: "snappy", encodings: []string{"snappy"}, maxBytes: 2*int64(len(body)) + 8<<10},
+		{name: "none", encodings: nil, maxBytes: 2*int64(len(body)) + 8<<10},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			raw, dialErr := net.Dial("tcp", address)
+			if dialErr != nil {
+				t.Fatal(dialErr)
+			}
+			conn := &countingConn{Conn: raw}
+			client, clientErr := transports.NewClient(conn, transports.WithCompression(64, test.encodings...))
+			if clientErr != nil {
+				t.Fatal(clientErr)
+			}
+			defer client.Close()
+			// the first request is not compressed, its response tells the encodings of the server.
+			for i := 0; i < 2; i++ {
+				conn.read.Store(0)
+				conn.written.Store(0)
+				response, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "raw", body: body})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !response.Succeed() {
+					b, _ := response.Body()
+					t.Fatal("unexpected failure", string(b))
+				}
+				if b, _ := response.Body(); !bytes.Equal(b, body) {
+					t.Fatal("unexpected body of", len(b), "bytes")
+				}
+				if response.Header().Get("content-encoding") != "" {
+					t.Fatal("content encoding should be removed after decompressing")
+				}
+			}
+			if n := conn.read.Load() + conn.written.Load(); n > test.maxBytes {
+				t.Fatal("expected at most", test.maxBytes, "bytes on wire, got", n)
+			}
+		})
+	}
+}
+
+func TestCompression_Unsupported(t *testing.T) {
+	transports.RegisterFunction("foo", "raw")
+	ctx := context.Background()
+	tr, _ := transports.NewTCPTransport(ctx, *mustConfig(t, `address: 127.0.0.1:0`))
+	if err := tr.Listen(ctx, &rawEchoHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	address := tr.(*transports.TCPTransport).Addr().String()
+
+	if _, err := transports.Dial(ctx, "tcp", address, transports.WithCompression(0, "gzip")); err == nil {
+		t.Fatal("unknown encoding should be rejected")
+	}
+
+	client, dialErr := transports.Dial(ctx, "tcp", address)
+	if dialErr != nil {
+		t.Fatal(dialErr)
+	}
+	defer client.Close()
+	response, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "raw", header: testHeader{"content-encoding": {"br"}}, body: []byte("x")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Succeed() {
+		t.Fatal("unknown content encoding should be rejected")
+	}
+	if b, _ := response.Body(); !strings.Contains(string(b), transports.ErrUnsupportedContentEncoding.Error()) {
+		t.Fatal("unexpected failure", string(b))
+	}
+}
+
+func TestCompression_Bomb(t *testing.T) {
+	transports.RegisterFunction("foo", "raw")
+	ctx := context.Background()
+	tr, _ := transports.NewTCPTransport(ctx, *mustConfig(t, `
+address: 127.0.0.1:0
+limits:
+  maxBodySize: 65536
+`))
+	if err := tr.Listen(ctx, &rawEchoHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	client, dialErr := transports.Dial(ctx, "tcp", tr.(*transports.TCPTransport).Addr().String())
+	if dialErr != nil {
+		t.Fatal(dialErr)
+	}
+	defer client.Close()
+	encoder, _ := zstd.NewWriter(nil)
+	bomb := encoder.EncodeAll(make([]byte, 32<<20), nil)
+	_ = encoder.Close()
+	response, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "raw", header: testHeader{"content-encoding": {"zstd"}}, body: bomb})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Succeed() {
+		t.Fatal("the decompressed body should be limited")
+	}
+	if b, _ := response.Body(); !strings.Contains(string(b), transports.ErrBodyTooLarge.Error()) {
+		t.Fatal("unexpected failure", string(b))
+	}
+}
@@ -0,0 +1,360 @@
+package transports
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/brickingsoft/brick/rpc/errors"
+	"github.com/brickingsoft/brick/transports"
+	"github.com/brickingsoft/brick/transports/encoding"
+)
+
+var (
+	ErrHijackUnsupported = errors.New("hijack is unsupported by unary request")
+	ErrAlreadyHijacked   = errors.New("request was already hijacked")
+)
+
+// RawBody
+// a body which is written as it is instead of being encoded, e.g. a forwarded body.
+type RawBody []byte
+
+// encodeBody
+// the body of a succeeded response, RawBody is kept without looking up its encoder, and others are encoded by the encoder.
+func encodeBody(v any, encoderOf func() (encoding.Encoder, error)) (b []byte, err error) {
+	if raw, ok := v.(RawBody); ok {
+		b = raw
+		return
+	}
+	encoder, encoderErr := encoderOf()
+	if encoderErr != nil {
+		err = errors.Join(transports.WriteBodyFailed, encoderErr)
+		return
+	}
+	if v == nil {
+		return
+	}
+	if b, err = encoder.Marshal(v); err != nil {
+		err = errors.Join(transports.WriteBodyFailed, err)
+	}
+	return
+}
+
+// encoderOfContentType
+// the encoder of a body, avro is used when the content type is empty.
+func encoderOfContentType(contentType []byte) (encoder encoding.Encoder, err error) {
+	if len(contentType) == 0 {
+		encoder = encoding.Retrieve(encoding.AvroEncoderType)
+		return
+	}
+	ok := false
+	if encoder, ok = encoding.RetrieveByContentType(string(contentType)); !ok {
+		err = ErrUnsupportedContentType
+	}
+	return
+}
+
+// negotiateContentType
+// the content type of response bodies is the one preferred by the accept header, or the content type of the request.
+// it is empty when avro is negotiated, so the header of avro responses is not written.
+func negotiateContentType(header Header) (contentType string, err error) {
+	fallback := encoding.AvroEncoderType
+	if name, ok := encoding.NameOfContentType(string(header.ContentType())); ok && encoding.Registered(name) {
+		fallback = name
+	}
+	name, ok := encoding.Negotiate(string(header.Peek(AcceptHeaderKey)), fallback)
+	if !ok {
+		err = ErrNotAcceptable
+		return
+	}
+	if name != encoding.AvroEncoderType {
+		contentType = encoding.ContentType(name)
+	}
+	return
+}
+
+// encodeFailure
+// the body of a failed response.
+func encodeFailure(err error) []byte {
+	if err == nil {
+		err = errors.New("failed without error")
+	}
+	b, _ := json.Marshal(errors.Wrap(err))
+	return b
+}
+
+type responseWriter struct {
+	conn      *serverConn
+	id        uint64
+	response  *Response
+	multiple  bool
+	responded bool
+	// encoding
+	// the negotiated content encoding of response bodies.
+	encoding string
+	// contentType
+	// the negotiated content type of response bodies, empty is avro.
+	contentType string
+}
+
+// negotiate
+// negotiate the content type by the header of the request, the content type header is set when it is not avro.
+func (w *responseWriter) negotiate(header Header) (err error) {
+	if w.contentType, err = negotiateContentType(header); err != nil {
+		return
+	}
+	if w.contentType != "" {
+		w.response.header.SetContentType([]byte(w.contentType))
+	}
+	return
+}
+
+// bodyEncoder
+// the encoder of the content type header, which may be set by handlers.
+func (w *responseWriter) bodyEncoder() (encoding.Encoder, error) {
+	return encoderOfContentType(w.response.header.ContentType())
+}
+
+func (w *responseWriter) Header() transports.Header {
+	return w.response.header
+}
+
+func (w *responseWriter) Succeed(v any) {
+	if w.responded && !w.multiple {
+		return
+	}
+	b, encodeErr := encodeBody(v, w.bodyEncoder)
+	if encodeErr != nil {
+		w.Failed(encodeErr)
+		return
+	}
+	_, _ = w.response.body.Write(b)
+	w.response.succeed = true
+	w.flush()
+}
+
+func (w *responseWriter) Failed(err error) {
+	if w.responded && !w.multiple {
+		return
+	}
+	w.response.body.Reset()
+	_, _ = w.response.body.Write(encodeFailure(err))
+	w.response.succeed = false
+	w.flush()
+}
+
+func (w *responseWriter) flush() {
+	w.responded = true
+	w.conn.advertise(w.response.header)
+	if w.encoding != "" {
+		compressBody(w.response.body, w.response.header, w.encoding, w.conn.srv.compress.threshold)
+	}
+	if err := w.conn.writeResponse(w.id, w.response); err != nil && errors.Is(err, ErrWriteHeaderFailed) {
+		// the header can not be packed, so tell the peer why instead of nothing.
+		w.response.Reset()
+		b, _ := json.Marshal(errors.Wrap(err))
+		_, _ = w.response.body.Write(b)
+		_ = w.conn.writeResponse(w.id, w.response)
+	}
+	w.response.Reset()
+	if w.multiple && w.contentType != "" {
+		// the next response of the stream keeps the negotiated content type.
+		w.response.header.SetContentType([]byte(w.contentType))
+	}
+}
+
+type requestCtx struct {
+	context.Context
+	endpoint string
+	function string
+	conn     *serverConn
+	request  *Request
+	writer   *responseWriter
+	stream   *serverStream
+	hijacker transports.HijackHandler
+	hijacked bool
+	// body
+	// the body of a streaming function, it is nil when the body is buffered.
+	body *streamingBody
+}
+
+func (r *requestCtx) Endpoint() string {
+	return r.endpoint
+}
+
+func (r *requestCtx) Function() string {
+	return r.function
+}
+
+func (r *requestCtx) Header() transports.Header {
+	return r.request.header
+}
+
+// Body
+// the body is only valid in the handling, copy it when it is used after the handling.
+// the body of a streaming function is buffered by it, the part which was read by BodyReader is not included.
+func (r *requestCtx) Body() (body []byte, err error) {
+	if r.body != nil {
+		if remaining := r.body.remaining(); remaining > 0 {
+			if _, err = r.request.body.ReadFromLimited(r.body, int(remaining)); err != nil {
+				return
+			}
+		}
+		if err = r.body.failed(); err != nil {
+			return
+		}
+	}
+	body = r.request.body.Peek(r.request.body.Len())
+	return
+}
+
+// BodyReader
+// read the body without buffering when the function is streaming, see BodyLimit.
+// the buffered body is read when the body was buffered by Body.
+func (r *requestCtx) BodyReader() io.Reader {
+	if r.body != nil && r.body.remaining() > 0 {
+		return r.body
+	}
+	return bytes.NewReader(r.request.body.Peek(r.request.body.Len()))
+}
+
+func (r *requestCtx) ParseBody(v any) (err error) {
+	encoder, encoderErr := encoderOfContentType(r.request.header.ContentType())
+	if encoderErr != nil {
+		err = errors.Join(transports.ParseBodyFailed, encoderErr)
+		return
+	}
+	body, bodyErr := r.Body()
+	if bodyErr != nil {
+		err = errors.Join(transports.ParseBodyFailed, bodyErr)
+		return
+	}
+	if err = encoder.Unmarshal(body, v); err != nil {
+		err = errors.Join(transports.ParseBodyFailed, err)
+	}
+	return
+}
+
+func (r *requestCtx) Response() (response transports.ResponseWriter) {
+	return r.writer
+}
+
+func (r *requestCtx) RemoteAddr() net.Addr {
+	return r.conn.raw.RemoteAddr()
+}
+
+func (r *requestCtx) ClientAddr() string {
+	return resolveClientAddr(r.RemoteAddr(), r.request.header.Forwarded(), &r.conn.srv.options.TrustedProxies)
+}
+
+func (r *requestCtx) Hijacked() bool {
+	return r.hijacked
+}
+
+func (r *requestCtx) Hijack(handler transports.HijackHandler) (err error) {
+	if handler == nil {
+		err = errors.New("hijack handler is nil")
+		return
+	}
+	if r.hijacked {
+		err = ErrAlreadyHijacked
+		return
+	}
+	if r.stream == nil {
+		err = ErrHijackUnsupported
+		return
+	}
+	r.hijacker = handler
+	r.hijacked = true
+	return
+}
+
+// serverStream
+// a hijacked stream, requests of the stream are read from the queue which is filled by the connection.
+// Close can be called while Next is waiting, then Next returns false.
+type serverStream struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	conn     *serverConn
+	id       uint64
+	endpoint string
+	function string
+	requests *queue[*Request]
+	writer   *responseWriter
+	// current and closed are guarded by locker.
+	locker  sync.Mutex
+	current *Request
+	closed  bool
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *serverStream) Next() (r transports.RequestCtx, ok bool) {
+	s.locker.Lock()
+	if s.current != nil {
+		ReleaseRequest(s.current)
+		s.current = nil
+	}
+	closed := s.closed
+	s.locker.Unlock()
+	if closed {
+		return
+	}
+	request, has := s.requests.pop(s.ctx)
+	if !has {
+		return
+	}
+	s.locker.Lock()
+	if s.closed {
+		s.locker.Unlock()
+		ReleaseRequest(request)
+		return
+	}
+	s.current = request
+	s.locker.Unlock()
+	r = &requestCtx{
+		Context:  s.ctx,
+		endpoint: s.endpoint,
+		function: s.function,
+		conn:     s.conn,
+		request:  request,
+		writer:   s.writer,
+		stream:   s,
+		hijacked: true,
+	}
+	ok = true
+	return
+}
+
+func (s *serverStream) Response() transports.ResponseWriter {
+	return s.writer
+}
+
+func (s *serverStream) Close() (err error) {
+	s.locker.Lock()
+	if s.closed {
+		s.locker.Unlock()
+		return
+	}
+	s.closed = true
+	current := s.current
+	s.current = nil
+	s.locker.Unlock()
+	if s.conn.removeStream(s.id) {
+		err = s.conn.writeControl(CloseFrame, s.id)
+	}
+	s.cancel()
+	for _, request := range s.requests.drain() {
+		ReleaseRequest(request)
+	}
+	if current != nil {
+		ReleaseRequest(current)
+	}
+	ReleaseResponse(s.writer.response)
+	return
+}
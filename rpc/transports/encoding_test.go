@@ -0,0 +1,168 @@
+package transports_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/brickingsoft/brick/rpc/transports"
+	brick "github.com/brickingsoft/brick/transports"
+	"github.com/brickingsoft/brick/transports/encoding"
+)
+
+func TestContentType(t *testing.T) {
+	transports.RegisterFunction("foo", "sum")
+	ctx := context.Background()
+	tr, _ := transports.NewTCPTransport(ctx, *mustConfig(t, `address: 127.0.0.1:0`))
+	if err := tr.Listen(ctx, &sumHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	client, clientErr := tr.Connect(ctx, tr.(*transports.TCPTransport).Addr().String())
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	defer client.Close()
+
+	t.Run("json", func(t *testing.T) {
+		response, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "sum", header: testHeader{"content-type": {"application/json"}}, body: []byte(`[1, 2, 3]`)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType := response.Header().Get("content-type"); contentType != "application/json" {
+			t.Fatal("unexpected content type", contentType)
+		}
+		if body, _ := response.Body(); string(body) != `{"sum":6}` {
+			t.Fatal("unexpected body", string(body))
+		}
+		result := map[string]int{}
+		if err = response.ParseBody(&result); err != nil {
+			t.Fatal(err)
+		}
+		if result["sum"] != 6 {
+			t.Fatal("unexpected sum", result)
+		}
+	})
+
+	t.Run("msgpack", func(t *testing.T) {
+		body, _ := encoding.Retrieve(encoding.MsgpackEncoderType).Marshal([]int{1, 2, 3})
+		response, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "sum", header: testHeader{
+			"content-type": {"application/msgpack"},
+			"accept":       {"application/cbor"},
+		}, body: body})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType := response.Header().Get("content-type"); contentType != "application/cbor" {
+			t.Fatal("unexpected content type", contentType)
+		}
+		result := map[string]int{}
+		if err = response.ParseBody(&result); err != nil {
+			t.Fatal(err)
+		}
+		if result["sum"] != 6 {
+			t.Fatal("unexpected sum", result)
+		}
+	})
+
+	t.Run("accept", func(t *testing.T) {
+		response, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "sum", header: testHeader{
+			"content-type": {"application/json"},
+			"accept":       {"application/avro;q=0.5, application/json;q=0.1"},
+		}, body: []byte(`[1, 2]`)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !response.Succeed() || response.Header().Get("content-type") != "" {
+			t.Fatal("expected an avro response", response.Header().Get("content-type"))
+		}
+		result := map[string]int{}
+		if err = response.ParseBody(&result); err != nil {
+			t.Fatal(err)
+		}
+		if result["sum"] != 3 {
+			t.Fatal("unexpected sum", result)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		response, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "sum", header: testHeader{"content-type": {"application/xml"}}, body: []byte(`<sum/>`)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = response.ParseBody(nil); err == nil || err.Error() != brick.ParseBodyFailed.Error() {
+			t.Fatal("expected parse body failed", err)
+		}
+		response, err = client.Do(ctx, &testRequest{endpoint: "foo", function: "sum", header: testHeader{"accept": {"application/xml"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = response.ParseBody(nil); err == nil || err.Error() != transports.ErrNotAcceptable.Error() {
+			t.Fatal("expected not acceptable", err)
+		}
+	})
+}
+
+func TestHTTPContentType(t *testing.T) {
+	tr := newHTTPTransport(t, false)
+	defer tr.Close()
+	request, _ := http.NewRequest(http.MethodPost, "http://"+tr.Addr().String()+"/foo/sum", strings.NewReader(`[1, 2, 3]`))
+	request.Header.Set("Accept", "application/xml")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = response.Body.Close()
+	if response.StatusCode != http.StatusNotAcceptable {
+		t.Fatal("unexpected status", response.StatusCode)
+	}
+}
+
+type upperEncoder struct{}
+
+func (e *upperEncoder) Marshal(v any) ([]byte, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, errors.New("not a string")
+	}
+	return []byte(strings.ToUpper(s)), nil
+}
+
+func (e *upperEncoder) Unmarshal(b []byte, v any) error {
+	p, ok := v.(*string)
+	if !ok {
+		return errors.New("not a string")
+	}
+	*p = strings.ToLower(string(b))
+	return nil
+}
+
+func TestEncodingRegistry(t *testing.T) {
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			encoding.Register("Upper", &upperEncoder{})
+		}()
+		go func() {
+			defer wg.Done()
+			_ = encoding.Retrieve(encoding.JsonEncoderType)
+			_, _ = encoding.RetrieveByContentType("application/x-upper")
+		}()
+	}
+	wg.Wait()
+	encoder, ok := encoding.RetrieveByContentType("application/x-upper; charset=utf-8")
+	if !ok {
+		t.Fatal("expected the registered encoder")
+	}
+	if b, _ := encoder.Marshal("hi"); string(b) != "HI" {
+		t.Fatal("unexpected encoder", string(b))
+	}
+	if name, ok := encoding.Negotiate("text/html, application/upper;q=0.8, */*;q=0.1", encoding.AvroEncoderType); !ok || name != "upper" {
+		t.Fatal("unexpected negotiation", name)
+	}
+}
@@ -0,0 +1,191 @@
+package transports
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/brickingsoft/brick/endpoints"
+	"github.com/brickingsoft/brick/rpc/configs"
+	"github.com/brickingsoft/brick/transports"
+)
+
+const (
+	// ForwardedByHeader
+	// the header of a forwarded request, the value is the address of the forwarder.
+	// a forwarded request is always handled locally, so it is never forwarded twice.
+	// the header is not authenticated, any client can set it to skip the routing, so the key must not be a security boundary.
+	ForwardedByHeader = "x-brick-forwarded-by"
+)
+
+// ForwardingEndpointRetrieverBuilder
+// a builder of endpoints.EndpointRetriever which forwards misrouted requests to the owner of their keys.
+// the balancer must be consistent-hash, and the self is the address of this server in its targets.
+// streams are relayed to the owner as the gateway does.
+// requests which carry ForwardedByHeader are handled locally, whoever set it.
+func ForwardingEndpointRetrieverBuilder(self string, balancer *Balancer) endpoints.EndpointRetrieverBuilder {
+	return func(ctx context.Context, entries []endpoints.Endpoint, config configs.Config) (retriever endpoints.EndpointRetriever, err error) {
+		if balancer == nil {
+			err = errors.Join(errors.New("new forwarding endpoint retriever failed"), errors.New("balancer is missing"))
+			return
+		}
+		if _, ok := balancer.strategy.(*consistentHash); !ok {
+			err = errors.Join(errors.New("new forwarding endpoint retriever failed"), errors.New("balancer is not consistent-hash"))
+			return
+		}
+		if self = strings.TrimSpace(self); self == "" {
+			err = errors.Join(errors.New("new forwarding endpoint retriever failed"), errors.New("self is missing"))
+			return
+		}
+		local, localErr := endpoints.DefaultEndpointRetrieverBuilder(ctx, entries, config)
+		if localErr != nil {
+			err = errors.Join(errors.New("new forwarding endpoint retriever failed"), localErr)
+			return
+		}
+		retriever = &ForwardingEndpointRetriever{
+			self:     self,
+			balancer: balancer,
+			local:    local,
+		}
+		return
+	}
+}
+
+// ForwardingEndpointRetriever
+// the endpoint of a request whose key is owned by another target is a forwarding endpoint.
+type ForwardingEndpointRetriever struct {
+	self     string
+	balancer *Balancer
+	local    endpoints.EndpointRetriever
+}
+
+func (r *ForwardingEndpointRetriever) Retrieve(ctx context.Context, name string) (endpoint endpoints.Endpoint) {
+	request, ok := ctx.(transports.Request)
+	if !ok {
+		return r.local.Retrieve(ctx, name)
+	}
+	if header := request.Header(); header != nil && header.Get(ForwardedByHeader) != "" {
+		return r.local.Retrieve(ctx, name)
+	}
+	node, routed := r.balancer.route(request)
+	if !routed || node.address == r.self {
+		return r.local.Retrieve(ctx, name)
+	}
+	endpoint = &forwardingEndpoint{
+		name:     name,
+		self:     r.self,
+		balancer: r.balancer,
+		node:     node,
+	}
+	return
+}
+
+// forwardingEndpoint
+// forward the request to the owner and write back what the owner responded.
+type forwardingEndpoint struct {
+	name     string
+	self     string
+	balancer *Balancer
+	node     *balancerNode
+}
+
+func (ep *forwardingEndpoint) Name() string {
+	return ep.name
+}
+
+func (ep *forwardingEndpoint) Handle(ctx endpoints.RequestCtx) {
+	forwarded := AcquireHeader()
+	transports.CopyHeader(forwarded, ctx.Header())
+	forwarded.Set(ForwardedByHeader, ep.self)
+	appendForwarded(forwarded, ep.self, ctx.RemoteAddr())
+	if err := ctx.Hijack(&remoteStreamHandler{balancer: ep.balancer, node: ep.node, request: ctx, header: forwarded}); err == nil {
+		// the header is released by the stream handler.
+		return
+	}
+	defer ReleaseHeader(forwarded)
+
+	body, bodyErr := ctx.Body()
+	if bodyErr != nil {
+		ctx.Response().Failed(errors.Join(transports.ParseBodyFailed, bodyErr))
+		return
+	}
+	response, err := ep.balancer.do(ctx, ep.node, &forwardedRequest{
+		endpoint: ctx.Endpoint(),
+		function: ctx.Function(),
+		header:   forwarded,
+		body:     body,
+	})
+	if err != nil {
+		ctx.Response().Failed(err)
+		return
+	}
+	relayResponse(ctx.Response(), response)
+}
+
+// relayResponse
+// write what the remote responded, the body is written as it is.
+// the relayed headers replace the local ones, e.g. the content type of the body.
+func relayResponse(writer endpoints.ResponseWriter, response transports.Response) {
+	if rh := response.Header(); rh != nil {
+		for _, key := range rh.Keys() {
+			writer.RemoveHeader(key).AddHeader(key, rh.Values(key)...)
+		}
+	}
+	body, err := response.Body()
+	if err != nil {
+		writer.Failed(err)
+		return
+	}
+	if !response.Succeed() {
+		writer.Failed(decodeFailure(body))
+		return
+	}
+	writer.Succeed(RawBody(body))
+}
+
+// relayWriter
+// relay a response to a transports.ResponseWriter by relayResponse.
+type relayWriter struct {
+	writer transports.ResponseWriter
+}
+
+func (w *relayWriter) AddHeader(key string, values ...string) endpoints.ResponseWriter {
+	w.writer.Header().Add(key, values...)
+	return w
+}
+
+func (w *relayWriter) GetHeader(key string) (values []string) {
+	return w.writer.Header().Values(key)
+}
+
+func (w *relayWriter) RemoveHeader(key string) endpoints.ResponseWriter {
+	w.writer.Header().Remove(key)
+	return w
+}
+
+func (w *relayWriter) Succeed(v any) {
+	w.writer.Succeed(v)
+}
+
+func (w *relayWriter) Failed(v error) {
+	w.writer.Failed(v)
+}
+
+func (ep *forwardingEndpoint) Close() (err error) {
+	return
+}
+
+type forwardedRequest struct {
+	endpoint string
+	function string
+	header   transports.Header
+	body     []byte
+}
+
+func (r *forwardedRequest) Endpoint() string { return r.endpoint }
+
+func (r *forwardedRequest) Function() string { return r.function }
+
+func (r *forwardedRequest) Header() transports.Header { return r.header }
+
+func (r *forwardedRequest) Body() ([]byte, error) { return r.body, nil }
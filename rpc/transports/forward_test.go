@@ -0,0 +1,181 @@
+package transports_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/brickingsoft/brick/endpoints"
+	"github.com/brickingsoft/brick/rpc/transports"
+	brick "github.com/brickingsoft/brick/transports"
+)
+
+type ownerEndpoint struct {
+	self string
+}
+
+func (ep *ownerEndpoint) Name() string { return "foo" }
+
+func (ep *ownerEndpoint) Handle(ctx endpoints.RequestCtx) {
+	if ctx.Function() == "stream" {
+		if err := ctx.Hijack(&ownerStreamHandler{self: ep.self}); err != nil {
+			ctx.Response().Failed(err)
+		}
+		return
+	}
+	ctx.Response().AddHeader("handled-by", ep.self)
+	if ctx.Function() == "fail" {
+		ctx.Response().Failed(errors.New("failed by " + ep.self))
+		return
+	}
+	if ctx.Function() == "raw" {
+		// a raw body of a content type which has no encoder.
+		ctx.Response().RemoveHeader("content-type").AddHeader("content-type", "text/plain")
+		ctx.Response().Succeed(transports.RawBody("raw by " + ep.self))
+		return
+	}
+	ctx.Response().Succeed(nil)
+}
+
+func (ep *ownerEndpoint) Close() error { return nil }
+
+type ownerStreamHandler struct {
+	self string
+}
+
+func (h *ownerStreamHandler) Handle(_ context.Context, stream endpoints.Stream) {
+	for {
+		r, ok := stream.Next()
+		if !ok {
+			return
+		}
+		body, _ := r.Body()
+		if string(body) == "bye" {
+			return
+		}
+		stream.Response().AddHeader("handled-by", h.self)
+		stream.Response().Succeed(nil)
+	}
+}
+
+func TestForwardingEndpointRetriever(t *testing.T) {
+	transports.RegisterFunction("foo", "owned")
+	transports.RegisterFunction("foo", "fail")
+	transports.RegisterFunction("foo", "stream")
+	transports.RegisterFunction("foo", "raw")
+	ctx := context.Background()
+	addresses := []string{"mem://fw-a", "mem://fw-b"}
+	var balancers []*transports.Balancer
+	for _, address := range addresses {
+		tr, trErr := transports.NewMemTransport(ctx, *mustConfig(t, "name: "+address))
+		if trErr != nil {
+			t.Fatal(trErr)
+		}
+		defer tr.Close()
+		balancer, err := transports.NewBalancer(ctx, tr, transports.NewStaticResolver(addresses...), transports.BalancerConfig{
+			Strategy: transports.ConsistentHashStrategy,
+			HashKey:  "header:x-key",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer balancer.Close()
+		balancers = append(balancers, balancer)
+		eps, epsErr := endpoints.New(ctx, []endpoints.Endpoint{&ownerEndpoint{self: address}}, endpoints.Options{
+			Builder: transports.ForwardingEndpointRetrieverBuilder(address, balancer),
+		})
+		if epsErr != nil {
+			t.Fatal(epsErr)
+		}
+		if err = tr.Listen(ctx, eps); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a key owned by fw-b
+	var key string
+	for i := 0; key == ""; i++ {
+		k := strconv.Itoa(i)
+		if owner, _ := balancers[0].Route(&testRequest{header: testHeader{"x-key": {k}}}); owner == "mem://fw-b" {
+			key = k
+		}
+	}
+
+	tr, _ := transports.NewMemTransport(ctx, *mustConfig(t, "name: mem://fw-client"))
+	client, clientErr := tr.Connect(ctx, "mem://fw-a")
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	defer client.Close()
+
+	response, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "owned", header: testHeader{"x-key": {key}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !response.Succeed() || response.Header().Get("handled-by") != "mem://fw-b" {
+		t.Fatal("expected to be forwarded to fw-b, handled by", response.Header().Get("handled-by"))
+	}
+
+	response, err = client.Do(ctx, &testRequest{endpoint: "foo", function: "fail", header: testHeader{"x-key": {key}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Succeed() {
+		t.Fatal("expected failed response")
+	}
+	if body, _ := response.Body(); !strings.Contains(string(body), "failed by mem://fw-b") {
+		t.Fatal("unexpected failure", string(body))
+	}
+
+	response, err = client.Do(ctx, &testRequest{endpoint: "foo", function: "raw", header: testHeader{"x-key": {key}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := response.Body(); !response.Succeed() || string(body) != "raw by mem://fw-b" {
+		t.Fatal("unexpected raw response", string(body))
+	}
+	if contentType := response.Header().Values("content-type"); len(contentType) != 1 || contentType[0] != "text/plain" {
+		t.Fatal("unexpected content type", contentType)
+	}
+
+	// requests without key are handled locally
+	response, err = client.Do(ctx, &testRequest{endpoint: "foo", function: "owned", header: testHeader{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Header().Get("handled-by") != "mem://fw-a" {
+		t.Fatal("expected to be handled by fw-a, handled by", response.Header().Get("handled-by"))
+	}
+
+	// streams are relayed to the owner
+	stream, streamErr := client.(brick.StreamClient).Stream(ctx, &testRequest{endpoint: "foo", function: "stream", header: testHeader{"x-key": {key}}})
+	if streamErr != nil {
+		t.Fatal(streamErr)
+	}
+	defer stream.Close()
+	for _, s := range []string{"a", "b", "bye"} {
+		if err = stream.Send(&testRequest{endpoint: "foo", function: "stream", header: testHeader{"x-key": {key}}, body: []byte(s)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	received := 0
+	for {
+		response, err = stream.Receive()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.Fatal(err)
+			}
+			break
+		}
+		if response.Header().Get("handled-by") != "mem://fw-b" {
+			t.Fatal("expected the stream to be relayed to fw-b, handled by", response.Header().Get("handled-by"))
+		}
+		received++
+	}
+	if received != 2 {
+		t.Fatal("expected 2 responses, got", received)
+	}
+}
@@ -0,0 +1,222 @@
+package transports
+
+import (
+	"errors"
+	"io"
+
+	"github.com/brickingsoft/brick/pkg/quicvarint"
+	"github.com/brickingsoft/bytebuffers"
+)
+
+/* frame
++------+------------+---------+
+| type | request id | payload |
++------+------------+---------+
+| 1    | varint     | ...     |
++------+------------+---------+
+
+request payload:
++----------+-----------------+-------------+------+
+| function | header          | body length | body |
++----------+-----------------+-------------+------+
+| varint   | uint16 + fields | varint      | ...  |
++----------+-----------------+-------------+------+
+
+response payload:
++-------+-----------------+-------------+------+
+| flags | header          | body length | body |
++-------+-----------------+-------------+------+
+| 1     | uint16 + fields | varint      | ...  |
++-------+-----------------+-------------+------+
+
+function payload:
++-----------------+----------+-----------------+----------+
+| endpoint length | endpoint | function length | function |
++-----------------+----------+-----------------+----------+
+| varint          | ...      | varint          | ...      |
++-----------------+----------+-----------------+----------+
+
+close, ping, pong and goaway payloads are empty, see handshake.go for handshake and dictionary payloads.
+*/
+
+type FrameType byte
+
+const (
+	// RequestFrame
+	// a unary request, the peer responds exactly one ResponseFrame with the same request id.
+	RequestFrame FrameType = iota + 1
+	// ResponseFrame
+	// a response of a request or a message of a stream.
+	ResponseFrame
+	// StreamFrame
+	// a request of a stream, the first one opens the stream.
+	StreamFrame
+	// CloseFrame
+	// close the stream of the request id.
+	CloseFrame
+	// PingFrame
+	// the peer responds a PongFrame with the same id, the id is opaque.
+	PingFrame
+	// PongFrame
+	// the answer of a PingFrame.
+	PongFrame
+	// GoAwayFrame
+	// the server stops accepting requests of the connection, the id is the last request id which will be handled.
+	// requests after it are dropped, the client should send them on another connection.
+	GoAwayFrame
+	// HandshakeFrame
+	// the fingerprint of the header dictionary, it is the first frame of each side of a connection.
+	HandshakeFrame
+	// DictionaryFrame
+	// the header dictionary of the sender, it is sent when the peer has a different one.
+	DictionaryFrame
+	// FunctionFrame
+	// the names of the function id, the id is the function id.
+	// it is sent before the first request of the function on a connection, so the server side does not need a registry.
+	FunctionFrame
+)
+
+func (typ FrameType) String() string {
+	switch typ {
+	case RequestFrame:
+		return "request"
+	case ResponseFrame:
+		return "response"
+	case StreamFrame:
+		return "stream"
+	case CloseFrame:
+		return "close"
+	case PingFrame:
+		return "ping"
+	case PongFrame:
+		return "pong"
+	case GoAwayFrame:
+		return "goaway"
+	case HandshakeFrame:
+		return "handshake"
+	case DictionaryFrame:
+		return "dictionary"
+	case FunctionFrame:
+		return "function"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	succeedResponseFlag byte = 1 << iota
+)
+
+var (
+	ErrInvalidFrame      = errors.New("invalid frame")
+	ErrReadFrameFailed   = errors.New("failed to read frame")
+	ErrWriteFrameFailed  = errors.New("failed to write frame")
+	ErrFunctionNotFound  = errors.New("function not found")
+	ErrUnexpectedEOFBody = errors.New("unexpected eof of body")
+)
+
+type frameReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+func writeFrameHead(b bytebuffers.Buffer, typ FrameType, id uint64) {
+	_ = b.WriteByte(byte(typ))
+	_, _ = quicvarint.Write(b, id)
+}
+
+func readFrameHead(r frameReader) (typ FrameType, id uint64, err error) {
+	t, tErr := r.ReadByte()
+	if tErr != nil {
+		err = tErr
+		return
+	}
+	typ = FrameType(t)
+	if typ < RequestFrame || typ > FunctionFrame {
+		err = ErrInvalidFrame
+		return
+	}
+	if id, err = quicvarint.Read(r); err != nil {
+		err = errors.Join(ErrReadFrameFailed, err)
+		return
+	}
+	return
+}
+
+func writeBody(b bytebuffers.Buffer, body bytebuffers.Buffer) {
+	bLen := body.Len()
+	_, _ = quicvarint.Write(b, uint64(bLen))
+	if bLen > 0 {
+		_, _ = b.Write(body.Peek(bLen))
+	}
+}
+
+// readBody
+// a body which is larger than the limit is discarded without being buffered, and ErrBodyTooLarge is returned.
+func readBody(r frameReader, body bytebuffers.Buffer, limit int64) (err error) {
+	bLen, lenErr := readBodyLength(r)
+	if lenErr != nil {
+		err = lenErr
+		return
+	}
+	if limit > 0 && bLen > limit {
+		if err = discardBody(r, bLen); err == nil {
+			err = ErrBodyTooLarge
+		}
+		return
+	}
+	err = readBodyN(r, body, bLen)
+	return
+}
+
+func readBodyLength(r frameReader) (n int64, err error) {
+	bLen, lenErr := quicvarint.Read(r)
+	if lenErr != nil {
+		err = lenErr
+		return
+	}
+	n = int64(bLen)
+	return
+}
+
+func readBodyN(r frameReader, body bytebuffers.Buffer, bLen int64) (err error) {
+	if bLen == 0 {
+		return
+	}
+	n, rErr := body.ReadFromLimited(r, int(bLen))
+	if rErr != nil {
+		err = rErr
+		return
+	}
+	if int64(n) != bLen {
+		err = ErrUnexpectedEOFBody
+		return
+	}
+	return
+}
+
+// discardBody
+// skip the body on the wire, so the following frames can be read.
+func discardBody(r frameReader, bLen int64) (err error) {
+	n, copyErr := io.CopyN(io.Discard, r, bLen)
+	if copyErr != nil {
+		err = copyErr
+		if n < bLen && errors.Is(copyErr, io.EOF) {
+			err = ErrUnexpectedEOFBody
+		}
+	}
+	return
+}
+
+// writeControl
+// write a frame without payload, they are close, ping, pong and goaway.
+func writeControl(w io.Writer, typ FrameType, id uint64) (err error) {
+	b := bytebuffers.Acquire()
+	defer bytebuffers.Release(b)
+
+	writeFrameHead(b, typ, id)
+	if _, err = b.WriteTo(w); err != nil {
+		err = errors.Join(ErrWriteFrameFailed, err)
+	}
+	return
+}
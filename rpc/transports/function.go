@@ -0,0 +1,184 @@
+package transports
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/brickingsoft/brick/pkg/quicvarint"
+	"github.com/brickingsoft/bytebuffers"
+	"github.com/cespare/xxhash/v2"
+)
+
+type functionName struct {
+	endpoint   string
+	function   string
+	idempotent bool
+}
+
+var (
+	functionLocker = sync.RWMutex{}
+	functionNames  = make(map[uint64]functionName)
+)
+
+// FunctionId
+// the id of a function on the wire, it is the xxhash of `endpoint.function` in the range of quic varint,
+// so both peers get the same id without any negotiation.
+func FunctionId(endpoint string, function string) uint64 {
+	d := xxhash.New()
+	_, _ = d.WriteString(endpoint)
+	_, _ = d.WriteString(".")
+	_, _ = d.WriteString(function)
+	return d.Sum64() & quicvarint.Max
+}
+
+// RegisterFunction
+// register the function, then its names are sent by FunctionFrame before the first request of it on a connection.
+// the server side resolves function ids by FunctionFrame of the connection first, then by the registry of the process.
+func RegisterFunction(endpoint string, function string) (id uint64) {
+	return registerFunction(endpoint, function, false)
+}
+
+// RegisterIdempotentFunction
+// register the function as an idempotent one, its calls may be sent and handled as 0-RTT data of quic, which can be replayed.
+// the calls of other functions wait for the handshake on both sides.
+func RegisterIdempotentFunction(endpoint string, function string) (id uint64) {
+	return registerFunction(endpoint, function, true)
+}
+
+func registerFunction(endpoint string, function string, idempotent bool) (id uint64) {
+	endpoint = strings.TrimSpace(endpoint)
+	function = strings.TrimSpace(function)
+	id = FunctionId(endpoint, function)
+	functionLocker.RLock()
+	name, has := functionNames[id]
+	functionLocker.RUnlock()
+	if has && (name.idempotent || !idempotent) {
+		return
+	}
+	functionLocker.Lock()
+	functionNames[id] = functionName{endpoint: endpoint, function: function, idempotent: idempotent}
+	functionLocker.Unlock()
+	return
+}
+
+// IsIdempotentFunction
+// whether the function id is registered by RegisterIdempotentFunction in the registry of the process.
+func IsIdempotentFunction(id uint64) bool {
+	functionLocker.RLock()
+	name := functionNames[id]
+	functionLocker.RUnlock()
+	return name.idempotent
+}
+
+// LookupFunction
+// the names of the function id in the registry of the process.
+func LookupFunction(id uint64) (endpoint string, function string, ok bool) {
+	functionLocker.RLock()
+	name, has := functionNames[id]
+	functionLocker.RUnlock()
+	if !has {
+		return
+	}
+	endpoint, function, ok = name.endpoint, name.function, true
+	return
+}
+
+const (
+	// maxFunctionNameSize
+	// the max size of an endpoint name or a function name in a FunctionFrame.
+	maxFunctionNameSize = 1024
+	// maxConnFunctions
+	// the max count of functions which are named on a connection.
+	maxConnFunctions = 1 << 16
+)
+
+// functionTable
+// the names of function ids which are sent by the peer of a connection.
+type functionTable struct {
+	locker sync.RWMutex
+	names  map[uint64]functionName
+}
+
+// lookup
+// the names are looked up in the registry of the process when the peer did not send them.
+func (table *functionTable) lookup(id uint64) (endpoint string, function string, ok bool) {
+	table.locker.RLock()
+	name, has := table.names[id]
+	table.locker.RUnlock()
+	if has {
+		endpoint, function, ok = name.endpoint, name.function, true
+		return
+	}
+	endpoint, function, ok = LookupFunction(id)
+	return
+}
+
+// read
+// read the payload of a FunctionFrame of the id.
+func (table *functionTable) read(r frameReader, id uint64) (err error) {
+	name := functionName{}
+	if name.endpoint, err = readFunctionName(r); err != nil {
+		return
+	}
+	if name.function, err = readFunctionName(r); err != nil {
+		return
+	}
+	if FunctionId(name.endpoint, name.function) != id {
+		err = errors.Join(ErrInvalidFrame, errors.New("function id does not match its names"))
+		return
+	}
+	table.locker.Lock()
+	defer table.locker.Unlock()
+	if table.names == nil {
+		table.names = make(map[uint64]functionName)
+	}
+	if _, has := table.names[id]; !has && len(table.names) >= maxConnFunctions {
+		err = errors.Join(ErrInvalidFrame, errors.New("too many functions"))
+		return
+	}
+	table.names[id] = name
+	return
+}
+
+func readFunctionName(r frameReader) (name string, err error) {
+	n, nErr := quicvarint.Read(r)
+	if nErr != nil {
+		err = errors.Join(ErrReadFrameFailed, nErr)
+		return
+	}
+	if n > maxFunctionNameSize {
+		err = errors.Join(ErrInvalidFrame, errors.New("function name is too large"))
+		return
+	}
+	b := make([]byte, n)
+	if _, err = io.ReadFull(r, b); err != nil {
+		err = errors.Join(ErrReadFrameFailed, err)
+		return
+	}
+	name = string(b)
+	return
+}
+
+// writeFunction
+// write the FunctionFrame of the registered function id.
+func writeFunction(w io.Writer, id uint64) (err error) {
+	endpoint, function, ok := LookupFunction(id)
+	if !ok {
+		err = ErrFunctionNotFound
+		return
+	}
+	b := bytebuffers.Acquire()
+	defer bytebuffers.Release(b)
+
+	writeFrameHead(b, FunctionFrame, id)
+	_, _ = quicvarint.Write(b, uint64(len(endpoint)))
+	_, _ = b.Write([]byte(endpoint))
+	_, _ = quicvarint.Write(b, uint64(len(function)))
+	_, _ = b.Write([]byte(function))
+	if _, err = b.WriteTo(w); err != nil {
+		err = errors.Join(ErrWriteFrameFailed, err)
+	}
+	return
+}
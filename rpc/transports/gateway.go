@@ -0,0 +1,273 @@
+package transports
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/brickingsoft/brick/endpoints"
+	"github.com/brickingsoft/brick/rpc/configs"
+	"github.com/brickingsoft/brick/transports"
+)
+
+const (
+	// DefaultGatewayName
+	// the default name of a gateway in forwarded entries.
+	DefaultGatewayName = "gateway"
+)
+
+// GatewayConfig
+// the routes of a gateway, requests of a routed endpoint are proxied to its upstream addresses.
+type GatewayConfig struct {
+	// Name
+	// the name of the gateway in forwarded entries, the default is gateway.
+	Name string `json:"name" yaml:"name"`
+	// MaxHops
+	// the max count of forwarded entries which the gateway relays, the default is 8.
+	MaxHops int            `json:"maxHops" yaml:"maxHops"`
+	Routes  []GatewayRoute `json:"routes" yaml:"routes"`
+}
+
+// GatewayRoute
+// an endpoint and its upstream addresses, the balancer picks one of them for each request.
+type GatewayRoute struct {
+	Endpoint  string         `json:"endpoint" yaml:"endpoint"`
+	Addresses []string       `json:"addresses" yaml:"addresses"`
+	Balancer  BalancerConfig `json:"balancer" yaml:"balancer"`
+}
+
+// GatewayEndpointRetrieverBuilder
+// a builder of endpoints.EndpointRetriever whose routed endpoints are proxies of remote services,
+// the transport connects upstream addresses, and endpoints which are not routed are retrieved locally.
+func GatewayEndpointRetrieverBuilder(transport transports.Transport, config GatewayConfig) endpoints.EndpointRetrieverBuilder {
+	return func(ctx context.Context, entries []endpoints.Endpoint, cfg configs.Config) (retriever endpoints.EndpointRetriever, err error) {
+		if transport == nil {
+			err = errors.Join(errors.New("new gateway endpoint retriever failed"), errors.New("transport is missing"))
+			return
+		}
+		name := strings.TrimSpace(config.Name)
+		if name == "" {
+			name = DefaultGatewayName
+		}
+		if strings.ContainsAny(name, ";,") {
+			err = errors.Join(errors.New("new gateway endpoint retriever failed"), errors.New("name must not contain ';' or ','"))
+			return
+		}
+		maxHops := config.MaxHops
+		if maxHops < 1 {
+			maxHops = DefaultMaxForwardedHops
+		}
+		local, localErr := endpoints.DefaultEndpointRetrieverBuilder(ctx, entries, cfg)
+		if localErr != nil {
+			err = errors.Join(errors.New("new gateway endpoint retriever failed"), localErr)
+			return
+		}
+		r := &GatewayEndpointRetriever{
+			remotes: make(map[string]*remoteEndpoint),
+			local:   local,
+		}
+		for _, route := range config.Routes {
+			endpoint := strings.TrimSpace(route.Endpoint)
+			if endpoint == "" {
+				_ = r.Close()
+				err = errors.Join(errors.New("new gateway endpoint retriever failed"), errors.New("endpoint of route is missing"))
+				return
+			}
+			if _, has := r.remotes[endpoint]; has {
+				_ = r.Close()
+				err = errors.Join(errors.New("new gateway endpoint retriever failed"), errors.New("endpoint "+endpoint+" is routed twice"))
+				return
+			}
+			if len(route.Addresses) == 0 {
+				_ = r.Close()
+				err = errors.Join(errors.New("new gateway endpoint retriever failed"), errors.New("addresses of "+endpoint+" are missing"))
+				return
+			}
+			balancer, balancerErr := NewBalancer(ctx, transport, NewStaticResolver(route.Addresses...), route.Balancer)
+			if balancerErr != nil {
+				_ = r.Close()
+				err = errors.Join(errors.New("new gateway endpoint retriever failed"), balancerErr)
+				return
+			}
+			r.remotes[endpoint] = &remoteEndpoint{
+				name:     endpoint,
+				gateway:  name,
+				maxHops:  maxHops,
+				balancer: balancer,
+			}
+		}
+		retriever = r
+		return
+	}
+}
+
+// GatewayEndpointRetriever
+// the endpoint of a routed name is a proxy of the remote service.
+type GatewayEndpointRetriever struct {
+	remotes map[string]*remoteEndpoint
+	local   endpoints.EndpointRetriever
+}
+
+func (r *GatewayEndpointRetriever) Retrieve(ctx context.Context, name string) (endpoint endpoints.Endpoint) {
+	if remote, has := r.remotes[name]; has {
+		return remote
+	}
+	return r.local.Retrieve(ctx, name)
+}
+
+// Close
+// close the balancers of routes.
+func (r *GatewayEndpointRetriever) Close() (err error) {
+	var errs []error
+	for _, remote := range r.remotes {
+		if closeErr := remote.Close(); closeErr != nil {
+			errs = append(errs, closeErr)
+		}
+	}
+	if len(errs) > 0 {
+		err = errors.Join(errors.New("failed to close gateway endpoint retriever"), errors.Join(errs...))
+	}
+	return
+}
+
+// remoteEndpoint
+// proxy requests and streams to the remote service, headers and bodies are passed through.
+type remoteEndpoint struct {
+	name     string
+	gateway  string
+	maxHops  int
+	balancer *Balancer
+}
+
+func (ep *remoteEndpoint) Name() string {
+	return ep.name
+}
+
+func (ep *remoteEndpoint) Handle(ctx endpoints.RequestCtx) {
+	header := AcquireHeader()
+	if err := forwardHeader(header, ctx.Header(), ep.gateway, ctx.RemoteAddr(), ep.maxHops); err != nil {
+		ReleaseHeader(header)
+		ctx.Response().Failed(err)
+		return
+	}
+	if err := ctx.Hijack(&remoteStreamHandler{balancer: ep.balancer, request: ctx, header: header}); err == nil {
+		// the header is released by the stream handler.
+		return
+	}
+	defer ReleaseHeader(header)
+
+	body, bodyErr := ctx.Body()
+	if bodyErr != nil {
+		ctx.Response().Failed(errors.Join(transports.ParseBodyFailed, bodyErr))
+		return
+	}
+	response, err := ep.balancer.Do(ctx, &forwardedRequest{
+		endpoint: ctx.Endpoint(),
+		function: ctx.Function(),
+		header:   header,
+		body:     body,
+	})
+	if err != nil {
+		ctx.Response().Failed(err)
+		return
+	}
+	relayResponse(ctx.Response(), response)
+}
+
+func (ep *remoteEndpoint) Close() (err error) {
+	return ep.balancer.Close()
+}
+
+// remoteStreamHandler
+// relay a stream, requests are sent by a goroutine and responses are written by the handler.
+// the upstream is picked by the balancer when the node is nil.
+type remoteStreamHandler struct {
+	balancer *Balancer
+	node     *balancerNode
+	request  endpoints.RequestCtx
+	header   Header
+}
+
+func (h *remoteStreamHandler) Handle(ctx context.Context, stream endpoints.Stream) {
+	defer ReleaseHeader(h.header)
+	body, bodyErr := h.request.Body()
+	if bodyErr != nil {
+		stream.Response().Failed(errors.Join(transports.ParseBodyFailed, bodyErr))
+		return
+	}
+	// the upstream is canceled when the stream of the client is ended.
+	upstreamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	upstream, err := h.open(upstreamCtx, &forwardedRequest{
+		endpoint: h.request.Endpoint(),
+		function: h.request.Function(),
+		header:   h.header,
+		body:     body,
+	})
+	if err != nil {
+		stream.Response().Failed(err)
+		return
+	}
+	defer upstream.Close()
+
+	// a request of the stream is invalid after the stream is closed, so closed is checked before sending it.
+	var (
+		locker sync.Mutex
+		closed bool
+	)
+	go func() {
+		defer cancel()
+		for {
+			r, ok := stream.Next()
+			if !ok {
+				return
+			}
+			locker.Lock()
+			if closed {
+				locker.Unlock()
+				return
+			}
+			sendErr := h.send(upstream, r)
+			locker.Unlock()
+			if sendErr != nil {
+				return
+			}
+		}
+	}()
+	for {
+		response, receiveErr := upstream.Receive()
+		if receiveErr != nil {
+			break
+		}
+		relayResponse(stream.Response(), response)
+	}
+	locker.Lock()
+	closed = true
+	locker.Unlock()
+}
+
+func (h *remoteStreamHandler) open(ctx context.Context, request transports.Request) (transports.ClientStream, error) {
+	if h.node == nil {
+		return h.balancer.Stream(ctx, request)
+	}
+	return h.balancer.stream(ctx, h.node, request)
+}
+
+func (h *remoteStreamHandler) send(upstream transports.ClientStream, r endpoints.RequestCtx) (err error) {
+	header := AcquireHeader()
+	defer ReleaseHeader(header)
+	transports.CopyHeader(header, r.Header())
+	body, bodyErr := r.Body()
+	if bodyErr != nil {
+		err = errors.Join(transports.ParseBodyFailed, bodyErr)
+		return
+	}
+	err = upstream.Send(&forwardedRequest{
+		endpoint: r.Endpoint(),
+		function: r.Function(),
+		header:   header,
+		body:     body,
+	})
+	return
+}
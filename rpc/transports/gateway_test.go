@@ -0,0 +1,119 @@
+package transports_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/brickingsoft/brick/endpoints"
+	"github.com/brickingsoft/brick/rpc/transports"
+	brick "github.com/brickingsoft/brick/transports"
+)
+
+type localEndpoint struct{}
+
+func (ep *localEndpoint) Name() string { return "local" }
+
+func (ep *localEndpoint) Handle(ctx endpoints.RequestCtx) {
+	ctx.Response().AddHeader("handled-by", "gateway")
+	ctx.Response().Succeed(nil)
+}
+
+func (ep *localEndpoint) Close() error { return nil }
+
+func TestGatewayEndpointRetriever(t *testing.T) {
+	transports.RegisterFunction("foo", "bar")
+	transports.RegisterFunction("foo", "stream")
+	transports.RegisterFunction("local", "bar")
+	ctx := context.Background()
+
+	backend := newTCPTransport(t)
+	defer backend.Close()
+
+	gatewayConfig := transports.GatewayConfig{}
+	if err := mustConfig(t, `
+name: edge
+routes:
+  - endpoint: foo
+    addresses: [`+backend.Addr().String()+`]
+`).As(&gatewayConfig); err != nil {
+		t.Fatal(err)
+	}
+	upstream, _ := transports.NewTCPTransport(ctx, *mustConfig(t, `address: 127.0.0.1:0`))
+	defer upstream.Close()
+	eps, epsErr := endpoints.New(ctx, []endpoints.Endpoint{&localEndpoint{}}, endpoints.Options{
+		Builder: transports.GatewayEndpointRetrieverBuilder(upstream, gatewayConfig),
+	})
+	if epsErr != nil {
+		t.Fatal(epsErr)
+	}
+	defer eps.Close()
+	gateway, _ := transports.NewTCPTransport(ctx, *mustConfig(t, `address: 127.0.0.1:0`))
+	if err := gateway.Listen(ctx, eps); err != nil {
+		t.Fatal(err)
+	}
+	defer gateway.Close()
+
+	client, clientErr := gateway.Connect(ctx, gateway.(*transports.TCPTransport).Addr().String())
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	defer client.Close()
+
+	t.Run("unary", func(t *testing.T) {
+		response, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "bar", body: []byte("hi")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !response.Succeed() || response.Header().Get("echo") != "hi" || response.Header().Get("function") != "foo.bar" {
+			t.Fatal("unexpected response", response.Header().Get("echo"), response.Header().Get("function"))
+		}
+		response, err = client.Do(ctx, &testRequest{endpoint: "foo", function: "fail"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = response.ParseBody(nil); err == nil || err.Error() != "boom" {
+			t.Fatal("unexpected failure", err)
+		}
+	})
+
+	t.Run("local", func(t *testing.T) {
+		response, err := client.Do(ctx, &testRequest{endpoint: "local", function: "bar"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.Header().Get("handled-by") != "gateway" {
+			t.Fatal("expected to be handled by the gateway")
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		stream, streamErr := client.(brick.StreamClient).Stream(ctx, &testRequest{endpoint: "foo", function: "stream"})
+		if streamErr != nil {
+			t.Fatal(streamErr)
+		}
+		defer stream.Close()
+		words := []string{"a", "b", "c"}
+		for _, s := range append(words, "bye") {
+			if err := stream.Send(&testRequest{endpoint: "foo", function: "stream", body: []byte(s)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var echoes []string
+		for {
+			response, err := stream.Receive()
+			if err != nil {
+				if !errors.Is(err, io.EOF) {
+					t.Fatal(err)
+				}
+				break
+			}
+			echoes = append(echoes, response.Header().Get("echo"))
+		}
+		if strings.Join(echoes, ",") != strings.Join(words, ",") {
+			t.Fatal("unexpected echoes", echoes)
+		}
+	})
+}
@@ -0,0 +1,279 @@
+package transports
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/brickingsoft/brick/pkg/quicvarint"
+	"github.com/brickingsoft/brick/rpc/transports/bpack"
+	"github.com/brickingsoft/bytebuffers"
+)
+
+/* handshake
+each side sends a HandshakeFrame before any other frame, the id is 0.
++-------------+-------------+------------+
+| field count | fingerprint | table size |
++-------------+-------------+------------+
+| varint      | 8           | varint     |
++-------------+-------------+------------+
+
+the table size is the size of the local dynamic header table, 0 means none,
+each side packs headers by a dynamic table of the smaller size of both sides, and by none when either side has none.
+
+the side which has the longer dictionary answers a HandshakeFrame of the field count of the peer,
+so the peer knows whether its dictionary is a prefix of the longer one.
+
+when neither dictionary is a prefix of the other one, the local one is sent by a DictionaryFrame, the id is 0.
++-------------+-----------------------+
+| dump length | dump of bpack.Packer  |
++-------------+-----------------------+
+| varint      | ...                   |
++-------------+-----------------------+
+
+a quic connection exchanges the handshakes by control streams without dictionaries,
+and each quic stream declares the prefix which packs its headers by a HandshakeFrame before other frames,
+their table sizes are 0, for frames of quic streams are not ordered.
+*/
+
+var (
+	ErrHandshakeFailed = errors.New("failed to handshake")
+)
+
+// maxDictionaryDumpSize
+// a dump is the literal fields of a dictionary, it is far smaller than it.
+const maxDictionaryDumpSize = 1 << 20
+
+// dictionaries
+// the header dictionaries of a connection, they are settled by the handshake.
+//
+// a dictionary which appends fields to another one is a newer version of it,
+// the peers of a rolling upgrade use the shorter one without sending dictionaries.
+type dictionaries struct {
+	local    *bpack.Packer
+	exchange bool
+	// encoder
+	// the dictionary which is known by the peer, it is guarded by the write lock of the connection.
+	// headers are packed as literals until the handshake is settled.
+	encoder *bpack.Packer
+	// decoder
+	// the dictionary of the peer, it is only used by the read loop.
+	decoder *bpack.Packer
+	// tableSize
+	// the size of the local dynamic header table, it is 0 when the table is disabled.
+	tableSize int
+	// negotiated
+	// the dynamic table of packing is settled by the first handshake of the peer, it is only used by the read loop.
+	negotiated bool
+}
+
+// newDictionaries
+// literal is the empty prefix of local, headers are packed by it until the handshake is settled.
+func newDictionaries(local *bpack.Packer, literal *bpack.Packer, opts Options) *dictionaries {
+	return &dictionaries{
+		local:     local,
+		exchange:  !opts.DisableDictionaryExchange,
+		encoder:   literal,
+		decoder:   local,
+		tableSize: max(opts.HeaderTableSize, 0),
+	}
+}
+
+func writeHandshake(w io.Writer, count int, fingerprint uint64, tableSize int) (err error) {
+	b := bytebuffers.Acquire()
+	defer bytebuffers.Release(b)
+
+	writeFrameHead(b, HandshakeFrame, 0)
+	_, _ = quicvarint.Write(b, uint64(count))
+	var fp [8]byte
+	binary.BigEndian.PutUint64(fp[:], fingerprint)
+	_, _ = b.Write(fp[:])
+	_, _ = quicvarint.Write(b, uint64(tableSize))
+	if _, err = b.WriteTo(w); err != nil {
+		err = errors.Join(ErrWriteFrameFailed, err)
+	}
+	return
+}
+
+func readHandshake(r frameReader) (count uint64, fingerprint uint64, tableSize uint64, err error) {
+	if count, err = quicvarint.Read(r); err != nil {
+		err = errors.Join(ErrHandshakeFailed, err)
+		return
+	}
+	var fp [8]byte
+	if _, err = io.ReadFull(r, fp[:]); err != nil {
+		err = errors.Join(ErrHandshakeFailed, err)
+		return
+	}
+	fingerprint = binary.BigEndian.Uint64(fp[:])
+	if tableSize, err = quicvarint.Read(r); err != nil {
+		err = errors.Join(ErrHandshakeFailed, err)
+		return
+	}
+	return
+}
+
+// handshakeReply
+// the frames which answer the handshake of the peer.
+type handshakeReply struct {
+	// confirm
+	// the dictionary of the peer is shorter, so the peer waits for the handshake of its field count.
+	confirm bool
+	count   int
+	// dump
+	// neither dictionary is a prefix of the other one.
+	dump bool
+}
+
+// handshake
+// read the handshake of the peer, the encoder should be replaced by the returned one unless it is nil,
+// which means the dictionary of the peer is longer, and its answer is waited.
+// the table is the dynamic table of packing, it is returned once by the first handshake of the peer when both sides have tables.
+func (d *dictionaries) handshake(r frameReader) (encoder *bpack.Packer, table *bpack.DynamicTable, reply handshakeReply, err error) {
+	count, fp, tableSize, readErr := readHandshake(r)
+	if readErr != nil {
+		err = readErr
+		return
+	}
+	if !d.negotiated {
+		// the packing side must not be larger than the unpacking side, so the smaller size is used.
+		d.negotiated = true
+		if size := min(uint64(d.tableSize), tableSize); size > 0 {
+			table = bpack.NewDynamicTable(int(size))
+		}
+	}
+	n := d.local.Len()
+	if count > uint64(n) {
+		// the peer has a newer version or a different one, it tells which one by its answer.
+		return
+	}
+	reply.confirm, reply.count = count < uint64(n), int(count)
+	if d.local.Fingerprint(int(count)) == fp {
+		// the peer has the same one or an older version of it.
+		encoder = d.local
+		if reply.confirm {
+			encoder = d.local.Prefix(reply.count)
+		}
+		return
+	}
+	if !d.exchange {
+		// the peer can not understand the local dictionary, so fall back to literals.
+		encoder = d.local.Prefix(0)
+		return
+	}
+	// the peer reads the dump before the following headers.
+	encoder, reply.dump = d.local, true
+	return
+}
+
+// answer
+// write the reply of the handshake, the confirmation is written before the dump.
+func (d *dictionaries) answer(w io.Writer, reply handshakeReply) (err error) {
+	if reply.confirm {
+		if err = writeHandshake(w, reply.count, d.local.Fingerprint(reply.count), d.tableSize); err != nil {
+			return
+		}
+	}
+	if reply.dump {
+		err = writeDictionary(w, d.local)
+	}
+	return
+}
+
+// declared
+// read the declaration of a quic stream, it must be a prefix of the local dictionary,
+// then the requests are unpacked by the local one, and the responses are packed by the declared one.
+func (d *dictionaries) declared(r frameReader, prefixes *dictionaryPrefixes) (err error) {
+	count, fp, _, readErr := readHandshake(r)
+	if readErr != nil {
+		err = readErr
+		return
+	}
+	if count > uint64(d.local.Len()) {
+		err = errors.Join(ErrHandshakeFailed, errors.New("declared dictionary is not a prefix of the local one"))
+		return
+	}
+	prefix := prefixes.get(int(count))
+	if prefix.fingerprint != fp {
+		err = errors.Join(ErrHandshakeFailed, errors.New("declared dictionary is not a prefix of the local one"))
+		return
+	}
+	d.encoder, d.decoder = prefix.packer, d.local
+	return
+}
+
+// dictionaryPrefix
+// a prefix of the local dictionary with its fingerprint.
+type dictionaryPrefix struct {
+	packer      *bpack.Packer
+	fingerprint uint64
+}
+
+func newDictionaryPrefix(packer *bpack.Packer) *dictionaryPrefix {
+	return &dictionaryPrefix{packer: packer, fingerprint: packer.Fingerprint(packer.Len())}
+}
+
+// declare
+// a quic stream declares the prefix before other frames.
+func (prefix *dictionaryPrefix) declare(w io.Writer) error {
+	return writeHandshake(w, prefix.packer.Len(), prefix.fingerprint, 0)
+}
+
+// dictionaryPrefixes
+// the prefixes of the local dictionary which are declared by quic streams, each one is built once.
+type dictionaryPrefixes struct {
+	local  *bpack.Packer
+	values sync.Map
+}
+
+func (prefixes *dictionaryPrefixes) get(count int) *dictionaryPrefix {
+	if v, has := prefixes.values.Load(count); has {
+		return v.(*dictionaryPrefix)
+	}
+	packer := prefixes.local
+	if count < packer.Len() {
+		packer = packer.Prefix(count)
+	}
+	v, _ := prefixes.values.LoadOrStore(count, newDictionaryPrefix(packer))
+	return v.(*dictionaryPrefix)
+}
+
+func writeDictionary(w io.Writer, local *bpack.Packer) (err error) {
+	dump := bytebuffers.Acquire()
+	defer bytebuffers.Release(dump)
+	if err = local.DumpTo(dump); err != nil {
+		err = errors.Join(ErrHandshakeFailed, err)
+		return
+	}
+
+	b := bytebuffers.Acquire()
+	defer bytebuffers.Release(b)
+
+	writeFrameHead(b, DictionaryFrame, 0)
+	writeBody(b, dump)
+	if _, err = b.WriteTo(w); err != nil {
+		err = errors.Join(ErrWriteFrameFailed, err)
+	}
+	return
+}
+
+// load
+// read the dictionary of the peer, the following headers of the peer are unpacked by it.
+func (d *dictionaries) load(r frameReader) (err error) {
+	b := bytebuffers.Acquire()
+	defer bytebuffers.Release(b)
+
+	if err = readBody(r, b, maxDictionaryDumpSize); err != nil {
+		err = errors.Join(ErrHandshakeFailed, err)
+		return
+	}
+	decoder, _ := bpack.New()
+	if err = decoder.LoadFrom(bytes.NewReader(b.Peek(b.Len()))); err != nil {
+		err = errors.Join(ErrHandshakeFailed, err)
+		return
+	}
+	d.decoder = decoder
+	return
+}
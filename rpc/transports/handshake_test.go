@@ -0,0 +1,172 @@
+package transports_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/brickingsoft/brick/rpc/transports"
+	brick "github.com/brickingsoft/brick/transports"
+)
+
+type headerEchoHandler struct{}
+
+func (h *headerEchoHandler) Handle(r brick.RequestCtx) {
+	for _, key := range []string{"x-tenant", "x-region"} {
+		r.Response().Header().Set(key, r.Header().Get(key))
+	}
+	r.Response().Succeed(nil)
+}
+
+func TestHandshake(t *testing.T) {
+	transports.RegisterFunction("foo", "header")
+	ctx := context.Background()
+	tenant := transports.HeaderValues{Name: "x-tenant", Values: []string{"t1", "t2"}}
+
+	tests := []struct {
+		name    string
+		server  string
+		options []transports.Option
+	}{
+		{name: "same", server: `
+headers:
+  - name: x-tenant
+    values: [t1, t2]
+`, options: []transports.Option{transports.WithHeaderFields(tenant)}},
+		// the server appends a field, so the client has an older version of its dictionary.
+		{name: "upgrade", server: `
+headers:
+  - name: x-tenant
+    values: [t1, t2]
+  - name: x-region
+    values: [r1]
+`, options: []transports.Option{transports.WithHeaderFields(tenant)}},
+		{name: "different", server: `
+headers:
+  - name: x-region
+    values: [r1]
+`, options: []transports.Option{transports.WithHeaderFields(tenant)}},
+		{name: "literal", server: `
+headers:
+  - name: x-region
+    values: [r1]
+`, options: []transports.Option{transports.WithHeaderFields(tenant), transports.WithDictionaryExchange(false)}},
+	}
+	read, written := make(map[string]int64), make(map[string]int64)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tr, _ := transports.NewTCPTransport(ctx, *mustConfig(t, "address: 127.0.0.1:0\n"+test.server))
+			if err := tr.Listen(ctx, &headerEchoHandler{}); err != nil {
+				t.Fatal(err)
+			}
+			defer tr.Close()
+			raw, dialErr := net.Dial("tcp", tr.(*transports.TCPTransport).Addr().String())
+			if dialErr != nil {
+				t.Fatal(dialErr)
+			}
+			conn := &countingConn{Conn: raw}
+			client, clientErr := transports.NewClient(conn, test.options...)
+			if clientErr != nil {
+				t.Fatal(clientErr)
+			}
+			defer client.Close()
+			// the first call has no fields of the dictionaries, so its size does not depend on when the handshake is read.
+			if _, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "header"}); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 3; i++ {
+				response, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "header", header: testHeader{
+					"x-tenant": {"t1"},
+					"x-region": {"r1"},
+				}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !response.Succeed() {
+					b, _ := response.Body()
+					t.Fatal("unexpected failure", string(b))
+				}
+				if tenant, region := response.Header().Get("x-tenant"), response.Header().Get("x-region"); tenant != "t1" || region != "r1" {
+					t.Fatal("unexpected header", tenant, region)
+				}
+			}
+			read[test.name], written[test.name] = conn.read.Load(), conn.written.Load()
+		})
+	}
+	// the server answers the shorter dictionary of the client by a handshake frame, it is 13 bytes.
+	if read["upgrade"]-read["same"] != 13 || written["upgrade"] != written["same"] {
+		t.Fatal("compatible dictionaries should not be exchanged", read, written)
+	}
+	if read["different"] <= read["same"] || written["different"] <= written["same"] {
+		t.Fatal("different dictionaries should be exchanged", read, written)
+	}
+}
+
+func TestHandshake_QUIC(t *testing.T) {
+	transports.RegisterFunction("foo", "header")
+	ctx := context.Background()
+	certFile, keyFile := writeTestCertificate(t)
+	tenant := `
+headers:
+  - name: x-tenant
+    values: [t1, t2]
+`
+	region := `
+headers:
+  - name: x-region
+    values: [r1]
+`
+	tenantRegion := tenant + `  - name: x-region
+    values: [r1]
+`
+	tests := []struct {
+		name   string
+		server string
+		client string
+	}{
+		{name: "same", server: tenant, client: tenant},
+		{name: "upgrade", server: tenantRegion, client: tenant},
+		{name: "downgrade", server: tenant, client: tenantRegion},
+		{name: "different", server: region, client: tenant},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv, srvErr := newQUICTransportOf("127.0.0.1:0", certFile, keyFile, test.server)
+			if srvErr != nil {
+				t.Fatal(srvErr)
+			}
+			if err := srv.Listen(ctx, &headerEchoHandler{}); err != nil {
+				t.Fatal(err)
+			}
+			defer srv.Close()
+			tr, trErr := newQUICTransportOf("127.0.0.1:0", certFile, keyFile, test.client)
+			if trErr != nil {
+				t.Fatal(trErr)
+			}
+			client, clientErr := tr.Connect(ctx, srv.Addr().String())
+			if clientErr != nil {
+				t.Fatal(clientErr)
+			}
+			defer client.Close()
+			for i := 0; i < 3; i++ {
+				response, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "header", header: testHeader{
+					"x-tenant": {"t1"},
+					"x-region": {"r1"},
+				}})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !response.Succeed() {
+					b, _ := response.Body()
+					t.Fatal("unexpected failure", string(b))
+				}
+				if tenant, region := response.Header().Get("x-tenant"), response.Header().Get("x-region"); tenant != "t1" || region != "r1" {
+					t.Fatal("unexpected header", tenant, region)
+				}
+				// the later calls are packed by the settled dictionary.
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
@@ -6,12 +6,13 @@ import (
 	"io"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"unsafe"
 
-	"github.com/brickingsoft/brick/pkg/quicvarint"
 	"github.com/brickingsoft/brick/rpc/transports/bpack"
+	"github.com/brickingsoft/brick/transports"
 )
 
 var (
@@ -27,6 +28,10 @@ var (
 	ContentTypeHeaderStringKey     = string(ContentTypeHeaderKey)
 	ContentEncodingHeaderKey       = []byte("content-encoding")
 	ContentEncodingHeaderStringKey = string(ContentEncodingHeaderKey)
+	AcceptHeaderKey                = []byte("accept")
+	AcceptHeaderStringKey          = string(AcceptHeaderKey)
+	AcceptEncodingHeaderKey        = []byte("accept-encoding")
+	AcceptEncodingHeaderStringKey  = string(AcceptEncodingHeaderKey)
 	SignatureHeaderKey             = []byte("signature")
 	SignatureHeaderStringKey       = string(SignatureHeaderKey)
 	fakeBodyHeaderKey              = []byte("fake-body")
@@ -36,13 +41,22 @@ var (
 var (
 	SnappyContentEncodingValue       = []byte("snappy")
 	SnappyContentEncodingValueString = string(SnappyContentEncodingValue)
+	ZstdContentEncodingValue         = []byte("zstd")
+	ZstdContentEncodingValueString   = string(ZstdContentEncodingValue)
+	AvroContentTypeValueString       = "application/avro"
+	JsonContentTypeValueString       = "application/json"
 	fakeBodyHeaderValue              = []byte("1")
 	fakeBodyHeaderValueString        = string(fakeBodyHeaderValue)
 )
 
+var (
+	ErrReadHeaderFailed  = errors.New("failed to read header")
+	ErrWriteHeaderFailed = errors.New("failed to write header")
+)
+
 type HeaderValues struct {
-	Name   string
-	Values []string
+	Name   string   `json:"name" yaml:"name"`
+	Values []string `json:"values" yaml:"values"`
 }
 
 var (
@@ -51,8 +65,10 @@ var (
 		ForwardedHeaderStringKey:       nil,
 		AuthorizationHeaderStringKey:   nil,
 		ContentLengthHeaderStringKey:   nil,
-		ContentTypeHeaderStringKey:     nil,
-		ContentEncodingHeaderStringKey: {SnappyContentEncodingValueString},
+		ContentTypeHeaderStringKey:     {AvroContentTypeValueString, JsonContentTypeValueString},
+		ContentEncodingHeaderStringKey: {SnappyContentEncodingValueString, ZstdContentEncodingValueString},
+		AcceptHeaderStringKey:          {AvroContentTypeValueString, JsonContentTypeValueString},
+		AcceptEncodingHeaderStringKey:  nil,
 		SignatureHeaderStringKey:       nil,
 		fakeBodyHeaderStringKey:        {fakeBodyHeaderValueString},
 	}
@@ -95,6 +111,10 @@ func builtinHeaderFields() []HeaderValues {
 			Values: values,
 		})
 	}
+	// keep the order stable, peers must build the same dictionary.
+	slices.SortFunc(headers, func(a, b HeaderValues) int {
+		return strings.Compare(a.Name, b.Name)
+	})
 	return headers
 }
 
@@ -166,8 +186,6 @@ func newHeaderPacker(maxHeaderSize int, headers []HeaderValues) (packer *bpack.P
 	return
 }
 
-type HeaderIterator bpack.HeaderIterator
-
 type Agent struct {
 	id     []byte
 	device []byte
@@ -279,12 +297,16 @@ func (forwarded *Forwarded) Decode(p []byte) error {
 	return nil
 }
 
+// Header
+// the brick.Header of frames, the typed accessors are views of the fields.
 type Header interface {
+	transports.Header
 	Agent() *Agent
 	SetAgent(id []byte, device []byte)
+	// Forwarded
+	// the entries of all forwarded values.
 	Forwarded() *Forwarded
 	AddForwarded(name []byte, host []byte, proto []byte)
-	Authorization() []byte
 	SetAuthorization(authorization []byte)
 	ContentLength() uint64
 	SetContentLength(length uint64)
@@ -292,254 +314,115 @@ type Header interface {
 	SetContentType(typ []byte)
 	ContentEncoding() []byte
 	SetContentEncoding(encoding []byte)
-	Get(key []byte) []byte
-	Set(key []byte, value []byte) error
-	Remove(key []byte)
-	Iterator() HeaderIterator
 }
 
-type headerEntry struct {
-	key   []byte
-	value []byte
-}
+type noCopy struct{}
+
+func (*noCopy) Lock()   {}
+func (*noCopy) Unlock() {}
 
 type header struct {
-	noCopy          noCopy
-	agent           *Agent
-	forwarded       *Forwarded
-	authorization   []byte
-	contentType     []byte
-	contentEncoding []byte
-	contentLength   uint64
-	entries         []headerEntry
+	noCopy noCopy
+	transports.Fields
 }
 
 func (h *header) Agent() *Agent {
-	return h.agent
+	value := h.Peek(AgentHeaderKey)
+	if len(value) == 0 {
+		return nil
+	}
+	agent := new(Agent)
+	if err := agent.Decode(value); err != nil {
+		return nil
+	}
+	return agent
 }
 
 func (h *header) SetAgent(id []byte, device []byte) {
-	if h.agent == nil {
-		h.agent = new(Agent)
-	}
-	h.agent.id = id
-	h.agent.device = device
+	agent := Agent{id: id, device: device}
+	h.SetBytes(AgentHeaderKey, agent.Encode())
 }
 
 func (h *header) Forwarded() *Forwarded {
-	return h.forwarded
-}
-
-func (h *header) AddForwarded(name []byte, host []byte, proto []byte) {
-	if h.forwarded == nil {
-		h.forwarded = new(Forwarded)
+	values := h.PeekValues(ForwardedHeaderKey)
+	if len(values) == 0 {
+		return nil
 	}
-	h.forwarded.Add(name, host, proto)
+	forwarded := new(Forwarded)
+	for _, value := range values {
+		if err := forwarded.Decode(value); err != nil {
+			return nil
+		}
+	}
+	return forwarded
 }
 
-func (h *header) Authorization() []byte {
-	return h.authorization
+func (h *header) AddForwarded(name []byte, host []byte, proto []byte) {
+	entry := ForwardedEntry{name: name, host: host, proto: proto}
+	h.AddBytes(ForwardedHeaderKey, entry.Encode())
 }
 
 func (h *header) SetAuthorization(authorization []byte) {
-	h.authorization = authorization
+	h.SetBytes(AuthorizationHeaderKey, authorization)
 }
 
+// ContentLength
+// the value is decimal, it is 0 when the value is missing or invalid.
 func (h *header) ContentLength() uint64 {
-	return h.contentLength
+	value := h.Peek(ContentLengthHeaderKey)
+	if len(value) == 0 {
+		return 0
+	}
+	n, _ := strconv.ParseUint(unsafe.String(unsafe.SliceData(value), len(value)), 10, 64)
+	return n
 }
 
 func (h *header) SetContentLength(length uint64) {
-	h.contentLength = length
+	if length == 0 {
+		h.RemoveBytes(ContentLengthHeaderKey)
+		return
+	}
+	h.SetBytes(ContentLengthHeaderKey, strconv.AppendUint(nil, length, 10))
 }
 
 func (h *header) ContentType() []byte {
-	return h.contentType
+	return h.Peek(ContentTypeHeaderKey)
 }
 
 func (h *header) SetContentType(typ []byte) {
-	h.contentType = typ
+	h.SetBytes(ContentTypeHeaderKey, typ)
 }
 
 func (h *header) ContentEncoding() []byte {
-	return h.contentEncoding
+	return h.Peek(ContentEncodingHeaderKey)
 }
 
 func (h *header) SetContentEncoding(encoding []byte) {
-	h.contentEncoding = encoding
-}
-
-func (h *header) Get(key []byte) []byte {
-	if len(key) == 0 {
-		return nil
-	}
-	sk := unsafe.String(unsafe.SliceData(key), len(key))
-	switch sk {
-	case AgentHeaderStringKey:
-		return h.agent.Encode()
-	case ForwardedHeaderStringKey:
-		return h.forwarded.Encode()
-	case AuthorizationHeaderStringKey:
-		return h.authorization
-	case ContentLengthHeaderStringKey:
-		return quicvarint.Append(nil, h.contentLength)
-	case ContentTypeHeaderStringKey:
-		return h.contentType
-	case ContentEncodingHeaderStringKey:
-		return h.contentEncoding
-	default:
-		for _, kp := range h.entries {
-			if unsafe.String(unsafe.SliceData(kp.key), len(kp.key)) == sk {
-				return kp.value
-			}
-		}
-		return nil
-	}
-}
-
-func (h *header) Set(key []byte, value []byte) (err error) {
-	if len(key) == 0 {
-		return
-	}
-	sk := unsafe.String(unsafe.SliceData(key), len(key))
-	switch sk {
-	case AgentHeaderStringKey:
-		if h.agent == nil {
-			h.agent = new(Agent)
-		}
-		err = h.agent.Decode(key)
-		return
-	case ForwardedHeaderStringKey:
-		if h.forwarded == nil {
-			h.forwarded = new(Forwarded)
-		}
-		err = h.forwarded.Decode(key)
-		return
-	case AuthorizationHeaderStringKey:
-		h.authorization = value
-		return
-	case ContentLengthHeaderStringKey:
-		h.contentLength, _, err = quicvarint.Parse(value)
-		return
-	case ContentTypeHeaderStringKey:
-		h.contentType = value
-		return
-	case ContentEncodingHeaderStringKey:
-		h.contentEncoding = value
-		return
-	default:
-		for i, kp := range h.entries {
-			if unsafe.String(unsafe.SliceData(kp.key), len(kp.key)) == sk {
-				h.entries[i].value = value
-				return
-			}
-		}
-		h.entries = append(h.entries, headerEntry{key, value})
-		return
-	}
+	h.SetBytes(ContentEncodingHeaderKey, encoding)
 }
 
-func (h *header) Remove(key []byte) {
-	if len(key) == 0 {
-		return
-	}
-	sk := unsafe.String(unsafe.SliceData(key), len(key))
-	switch sk {
-	case AgentHeaderStringKey:
-		h.agent = nil
-		return
-	case ForwardedHeaderStringKey:
-		h.forwarded = nil
-		return
-	case AuthorizationHeaderStringKey:
-		h.authorization = nil
-		return
-	case ContentLengthHeaderStringKey:
-		h.contentLength = 0
-		return
-	case ContentTypeHeaderStringKey:
-		h.contentType = nil
-		return
-	case ContentEncodingHeaderStringKey:
-		h.contentEncoding = nil
-		return
-	default:
-		i := 0
-		for ; i < len(h.entries); i++ {
-			if unsafe.String(unsafe.SliceData(h.entries[i].key), len(h.entries[i].key)) == sk {
-				break
-			}
-		}
-		h.entries = append(h.entries[:i], h.entries[i+1:]...)
-		return
-	}
+// headerWriter
+// the bpack.HeaderWriter of header, values of a name are added in the order of packing.
+type headerWriter struct {
+	h *header
 }
 
-func (h *header) Iterator() HeaderIterator {
-	return func(yield func([]byte, []byte) bool) {
-		if h.agent != nil {
-			if !yield(AgentHeaderKey, h.agent.Encode()) {
-				return
-			}
-		}
-		if h.forwarded != nil {
-			if !yield(ForwardedHeaderKey, h.forwarded.Encode()) {
-				return
-			}
-		}
-		if len(h.authorization) != 0 {
-			if !yield(AuthorizationHeaderKey, h.authorization) {
-				return
-			}
-		}
-		if !yield(ContentLengthHeaderKey, quicvarint.Append(nil, h.contentLength)) {
-			return
-		}
-		if len(h.contentType) != 0 {
-			if !yield(ContentTypeHeaderKey, h.contentType) {
-				return
-			}
-		}
-		if len(h.contentEncoding) != 0 {
-			if !yield(ContentEncodingHeaderKey, h.contentEncoding) {
-				return
-			}
-		}
-		for _, k := range h.entries {
-			if !yield(k.key, k.value) {
-				return
-			}
-		}
-	}
-}
-
-func (h *header) Reset() {
-	if h.agent != nil {
-		h.agent.id = nil
-		h.agent.device = nil
-	}
-	if h.forwarded != nil {
-		h.forwarded.entries = h.forwarded.entries[:0]
-	}
-	h.authorization = nil
-	h.contentLength = 0
-	h.contentType = nil
-	h.contentEncoding = nil
-	if h.entries != nil {
-		h.entries = h.entries[:0]
-	}
+func (w headerWriter) Set(name []byte, value []byte) error {
+	w.h.AddBytes(name, value)
+	return nil
 }
 
-func (h *header) Parse(r io.Reader, packer *bpack.Packer) (err error) {
-	if err = packer.UnpackFrom(r, h); err != nil {
+// Parse
+// the table is the dynamic table of the connection, it is nil when the connection has no dynamic table.
+func (h *header) Parse(r io.Reader, packer *bpack.Packer, table *bpack.DynamicTable) (err error) {
+	if err = packer.UnpackFromWithTable(r, headerWriter{h}, table); err != nil {
 		err = errors.Join(ErrReadHeaderFailed, err)
 	}
 	return
 }
 
-func (h *header) Flush(w io.Writer, packer *bpack.Packer) (err error) {
-	iter := bpack.HeaderIterator(h.Iterator())
-	if err = packer.PackTo(w, iter); err != nil {
+func (h *header) Flush(w io.Writer, packer *bpack.Packer, table *bpack.DynamicTable) (err error) {
+	if err = packer.PackToWithTable(w, bpack.HeaderIterator(h.All()), table); err != nil {
 		err = errors.Join(ErrWriteHeaderFailed, err)
 	}
 	return
@@ -566,7 +449,3 @@ func ReleaseHeader(h Header) {
 		headerPool.Put(hh)
 	}
 }
-
-func UintHeaderValue(u uint64) []byte {
-	return quicvarint.Append(nil, u)
-}
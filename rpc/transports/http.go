@@ -0,0 +1,736 @@
+package transports
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"iter"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/brickingsoft/brick/rpc/configs"
+	rpcErrors "github.com/brickingsoft/brick/rpc/errors"
+	"github.com/brickingsoft/brick/transports"
+	"github.com/brickingsoft/brick/transports/encoding"
+)
+
+const (
+	HTTPTransportName = "http"
+	// DefaultHTTPMaxBodySize
+	// the default max size of http request body.
+	DefaultHTTPMaxBodySize = 4 << 20
+	defaultContentType     = "application/json"
+)
+
+var (
+	ErrUnsupportedContentType = errors.New("unsupported content type")
+	ErrNotAcceptable          = errors.New("no acceptable content type")
+	ErrRequestBodyTooLarge    = errors.New("request body too large")
+)
+
+type HTTPConfig struct {
+	Address           string        `json:"address" yaml:"address"`
+	MaxBodySize       int64         `json:"maxBodySize" yaml:"maxBodySize"`
+	ReadHeaderTimeout time.Duration `json:"readHeaderTimeout" yaml:"readHeaderTimeout"`
+	IdleTimeout       time.Duration `json:"idleTimeout" yaml:"idleTimeout"`
+	// H2C
+	// the client speaks http/2 with prior knowledge, the server always accepts both http/1.1 and h2c.
+	H2C bool `json:"h2c" yaml:"h2c"`
+	// TLS
+	// http/1.1 and h2 are served over tls when it is set, the h2c is ignored.
+	TLS *TLSConfig `json:"tls" yaml:"tls"`
+	// Forwarded
+	// the Forwarded and X-Forwarded-For headers of trusted proxies resolve the client address, the max hops is not used.
+	Forwarded ForwardedConfig `json:"forwarded" yaml:"forwarded"`
+}
+
+// NewHTTPTransport
+// a transports.Builder of the http gateway, the config is the node of http transport.
+func NewHTTPTransport(ctx context.Context, config configs.Config) (transport transports.Transport, err error) {
+	hc := HTTPConfig{}
+	if err = config.As(&hc); err != nil {
+		err = errors.Join(errors.New("new http transport failed"), err)
+		return
+	}
+	hc.Address = strings.TrimSpace(hc.Address)
+	if hc.Address == "" {
+		err = errors.Join(errors.New("new http transport failed"), errors.New("address is missing"))
+		return
+	}
+	if hc.MaxBodySize < 1 {
+		hc.MaxBodySize = DefaultHTTPMaxBodySize
+	}
+	opts, optsErr := newOptions(hc.Forwarded.options()...)
+	if optsErr != nil {
+		err = errors.Join(errors.New("new http transport failed"), optsErr)
+		return
+	}
+	tr := &HTTPTransport{
+		config:  hc,
+		trusted: opts.TrustedProxies,
+	}
+	if hc.TLS != nil {
+		if tr.tls, err = hc.TLS.Watch(ctx); err != nil {
+			err = errors.Join(errors.New("new http transport failed"), err)
+			return
+		}
+		tr.clientTLS = tr.tls.Client()
+	}
+	transport = tr
+	return
+}
+
+// HTTPTransport
+// a gateway for non brick clients, `POST /{endpoint}/{function}` is served as a request of the function.
+type HTTPTransport struct {
+	config    HTTPConfig
+	trusted   TrustedProxies
+	tls       *TLSWatcher
+	clientTLS *tls.Config
+	locker    sync.Mutex
+	server    *http.Server
+	addr      net.Addr
+}
+
+func (tr *HTTPTransport) Name() string {
+	return HTTPTransportName
+}
+
+// Addr
+// the listened address, it is nil before Listen.
+func (tr *HTTPTransport) Addr() net.Addr {
+	tr.locker.Lock()
+	defer tr.locker.Unlock()
+	return tr.addr
+}
+
+// Listen
+// listen the configured address and serve http/1.1 and h2c, or http/1.1 and h2 over tls, in background.
+func (tr *HTTPTransport) Listen(ctx context.Context, handler transports.ServeHandler) (err error) {
+	tr.locker.Lock()
+	defer tr.locker.Unlock()
+	if tr.server != nil {
+		err = errors.Join(errors.New("http transport listen failed"), errors.New("already listened"))
+		return
+	}
+	if handler == nil {
+		err = errors.Join(errors.New("http transport listen failed"), errors.New("handler is missing"))
+		return
+	}
+	var serverTLS *tls.Config
+	if tr.tls != nil {
+		var serverTLSErr error
+		if serverTLS, serverTLSErr = tr.tls.Server("h2", "http/1.1"); serverTLSErr != nil {
+			err = errors.Join(errors.New("http transport listen failed"), serverTLSErr)
+			return
+		}
+	}
+	config := net.ListenConfig{}
+	ln, lnErr := config.Listen(ctx, "tcp", tr.config.Address)
+	if lnErr != nil {
+		err = errors.Join(errors.New("http transport listen failed"), lnErr)
+		return
+	}
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	if serverTLS != nil {
+		protocols.SetHTTP2(true)
+		ln = tls.NewListener(ln, serverTLS)
+	} else {
+		protocols.SetUnencryptedHTTP2(true)
+	}
+	srv := &http.Server{
+		Handler: &HTTPGateway{
+			handler:     handler,
+			maxBodySize: tr.config.MaxBodySize,
+			trusted:     tr.trusted,
+		},
+		ReadHeaderTimeout: tr.config.ReadHeaderTimeout,
+		IdleTimeout:       tr.config.IdleTimeout,
+		Protocols:         protocols,
+		BaseContext: func(_ net.Listener) context.Context {
+			return ctx
+		},
+	}
+	tr.server = srv
+	tr.addr = ln.Addr()
+	go func(srv *http.Server, ln net.Listener) {
+		_ = srv.Serve(ln)
+	}(srv, ln)
+	return
+}
+
+func (tr *HTTPTransport) Connect(_ context.Context, address string) (client transports.Client, err error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		err = errors.Join(errors.New("http transport connect failed"), errors.New("address is missing"))
+		return
+	}
+	if !strings.Contains(address, "://") {
+		if tr.clientTLS != nil {
+			address = "https://" + address
+		} else {
+			address = "http://" + address
+		}
+	}
+	base, parseErr := url.Parse(address)
+	if parseErr != nil {
+		err = errors.Join(errors.New("http transport connect failed"), parseErr)
+		return
+	}
+	var clientTLS *tls.Config
+	if tr.clientTLS != nil && base.Scheme == "https" {
+		if clientTLS, err = clientTLSOf(tr.clientTLS, base.Host); err != nil {
+			err = errors.Join(errors.New("http transport connect failed"), err)
+			return
+		}
+	}
+	protocols := new(http.Protocols)
+	if tr.clientTLS != nil {
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+	} else if tr.config.H2C {
+		protocols.SetUnencryptedHTTP2(true)
+	} else {
+		protocols.SetHTTP1(true)
+	}
+	client = &HTTPClient{
+		base: base,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Protocols:       protocols,
+				TLSClientConfig: clientTLS,
+				IdleConnTimeout: tr.config.IdleTimeout,
+			},
+		},
+	}
+	return
+}
+
+// Shutdown
+// stop listening, and close the server after the handling requests are done or ctx is done.
+func (tr *HTTPTransport) Shutdown(ctx context.Context) (err error) {
+	tr.locker.Lock()
+	srv := tr.server
+	tr.server = nil
+	tr.locker.Unlock()
+	if tr.tls != nil {
+		_ = tr.tls.Close()
+	}
+	if srv == nil {
+		return
+	}
+	if err = srv.Shutdown(ctx); err != nil {
+		// the connections are still active when ctx is done.
+		_ = srv.Close()
+	}
+	return
+}
+
+func (tr *HTTPTransport) Close() (err error) {
+	tr.locker.Lock()
+	srv := tr.server
+	tr.server = nil
+	tr.locker.Unlock()
+	if tr.tls != nil {
+		_ = tr.tls.Close()
+	}
+	if srv == nil {
+		return
+	}
+	err = srv.Close()
+	return
+}
+
+// HTTPGateway
+// a http.Handler which maps `POST /{endpoint}/{function}` onto transports.RequestCtx.
+type HTTPGateway struct {
+	handler     transports.ServeHandler
+	maxBodySize int64
+	trusted     TrustedProxies
+}
+
+func NewHTTPGateway(handler transports.ServeHandler, maxBodySize int64) *HTTPGateway {
+	if maxBodySize < 1 {
+		maxBodySize = DefaultHTTPMaxBodySize
+	}
+	return &HTTPGateway{handler: handler, maxBodySize: maxBodySize}
+}
+
+func (gateway *HTTPGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writer := &httpResponseWriter{w: w, header: make(http.Header)}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writer.fail(http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	endpoint, function, ok := parseHTTPPath(r.URL.Path)
+	if !ok {
+		writer.fail(http.StatusNotFound, ErrFunctionNotFound)
+		return
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+	encoder, hasEncoder := encoding.RetrieveByContentType(contentType)
+	if !hasEncoder {
+		writer.fail(http.StatusUnsupportedMediaType, ErrUnsupportedContentType)
+		return
+	}
+	accepted, acceptable := encoding.Negotiate(r.Header.Get("Accept"), encoding.JsonEncoderType)
+	if !acceptable {
+		writer.fail(http.StatusNotAcceptable, ErrNotAcceptable)
+		return
+	}
+	writer.contentType = encoding.ContentType(accepted)
+	writer.encoder = encoding.Retrieve(accepted)
+	body, readErr := io.ReadAll(http.MaxBytesReader(w, r.Body, gateway.maxBodySize))
+	if readErr != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(readErr, &maxBytesErr) {
+			writer.fail(http.StatusRequestEntityTooLarge, ErrRequestBodyTooLarge)
+			return
+		}
+		writer.fail(http.StatusBadRequest, errors.Join(transports.ParseBodyFailed, readErr))
+		return
+	}
+
+	ctx := &httpRequestCtx{
+		Context:  withPeerIdentity(r.Context(), r.TLS),
+		endpoint: endpoint,
+		function: function,
+		header:   httpHeader{r.Header},
+		remote:   httpRemoteAddr(r.RemoteAddr),
+		trusted:  &gateway.trusted,
+		body:     body,
+		encoder:  encoder,
+		writer:   writer,
+	}
+	gateway.handler.Handle(ctx)
+	if !writer.responded {
+		writer.Succeed(nil)
+	}
+}
+
+func parseHTTPPath(path string) (endpoint string, function string, ok bool) {
+	path = strings.Trim(path, "/")
+	endpoint, function, ok = strings.Cut(path, "/")
+	if !ok || endpoint == "" || function == "" || strings.Contains(function, "/") {
+		ok = false
+		return
+	}
+	var err error
+	if endpoint, err = url.PathUnescape(endpoint); err != nil {
+		ok = false
+		return
+	}
+	if function, err = url.PathUnescape(function); err != nil {
+		ok = false
+		return
+	}
+	return
+}
+
+// httpStatusOf
+// the status code of a failure.
+func httpStatusOf(err error) int {
+	switch {
+	case errors.Is(err, ErrFunctionNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, transports.ParseBodyFailed):
+		return http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		// nginx's client closed request
+		return 499
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// httpHeader
+// transports.Header of http.Header, keys are lower case.
+type httpHeader struct {
+	h http.Header
+}
+
+func (h httpHeader) Get(key string) string {
+	return h.h.Get(key)
+}
+
+func (h httpHeader) Keys() (keys []string) {
+	keys = make([]string, 0, len(h.h))
+	for key := range h.h {
+		keys = append(keys, strings.ToLower(key))
+	}
+	return
+}
+
+func (h httpHeader) Values(key string) []string {
+	return h.h.Values(key)
+}
+
+func (h httpHeader) Set(key string, value string) {
+	h.h.Set(key, value)
+}
+
+func (h httpHeader) Add(key string, values ...string) {
+	for _, value := range values {
+		h.h.Add(key, value)
+	}
+}
+
+func (h httpHeader) Remove(key string) {
+	h.h.Del(key)
+}
+
+func (h httpHeader) Authorization() string {
+	return h.h.Get("Authorization")
+}
+
+// Peek
+// the value is a view of the string value, it must not be changed.
+func (h httpHeader) Peek(key []byte) []byte {
+	value := h.h.Get(string(key))
+	return unsafe.Slice(unsafe.StringData(value), len(value))
+}
+
+func (h httpHeader) PeekValues(key []byte) (values [][]byte) {
+	for _, value := range h.h.Values(string(key)) {
+		values = append(values, unsafe.Slice(unsafe.StringData(value), len(value)))
+	}
+	return
+}
+
+func (h httpHeader) SetBytes(key []byte, value []byte) {
+	h.h.Set(string(key), string(value))
+}
+
+func (h httpHeader) AddBytes(key []byte, value []byte) {
+	h.h.Add(string(key), string(value))
+}
+
+func (h httpHeader) RemoveBytes(key []byte) {
+	h.h.Del(string(key))
+}
+
+func (h httpHeader) All() iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		for key, values := range h.h {
+			name := []byte(strings.ToLower(key))
+			for _, value := range values {
+				if !yield(name, unsafe.Slice(unsafe.StringData(value), len(value))) {
+					return
+				}
+			}
+		}
+	}
+}
+
+type httpResponseWriter struct {
+	w      http.ResponseWriter
+	header http.Header
+	// contentType
+	// the negotiated content type of succeeded bodies, failures are always json.
+	contentType string
+	encoder     encoding.Encoder
+	responded   bool
+}
+
+func (w *httpResponseWriter) Header() transports.Header {
+	return httpHeader{w.header}
+}
+
+// Succeed
+// the value is rendered by the negotiated encoder, json by default, and 204 is used when it is nil.
+func (w *httpResponseWriter) Succeed(v any) {
+	if w.responded {
+		return
+	}
+	if v == nil {
+		w.write(http.StatusNoContent, nil, "")
+		return
+	}
+	if raw, ok := v.(RawBody); ok {
+		w.write(http.StatusOK, raw, w.contentType)
+		return
+	}
+	encoder := w.encoder
+	if encoder == nil {
+		encoder = encoding.Retrieve(encoding.JsonEncoderType)
+	}
+	b, encodeErr := encoder.Marshal(v)
+	if encodeErr != nil {
+		w.Failed(errors.Join(transports.WriteBodyFailed, encodeErr))
+		return
+	}
+	w.write(http.StatusOK, b, w.contentType)
+}
+
+func (w *httpResponseWriter) Failed(err error) {
+	if err == nil {
+		err = errors.New("failed without error")
+	}
+	w.fail(httpStatusOf(err), err)
+}
+
+func (w *httpResponseWriter) fail(status int, err error) {
+	if w.responded {
+		return
+	}
+	b, _ := json.Marshal(rpcErrors.Wrap(err))
+	w.write(status, b, defaultContentType)
+}
+
+func (w *httpResponseWriter) write(status int, body []byte, contentType string) {
+	w.responded = true
+	header := w.w.Header()
+	for key, values := range w.header {
+		header[key] = values
+	}
+	if body != nil {
+		if contentType == "" {
+			contentType = defaultContentType
+		}
+		header.Set("Content-Type", contentType)
+	}
+	w.w.WriteHeader(status)
+	if len(body) > 0 {
+		_, _ = w.w.Write(body)
+	}
+}
+
+type httpRequestCtx struct {
+	context.Context
+	endpoint string
+	function string
+	header   httpHeader
+	remote   net.Addr
+	trusted  *TrustedProxies
+	body     []byte
+	encoder  encoding.Encoder
+	writer   *httpResponseWriter
+}
+
+func (r *httpRequestCtx) Endpoint() string {
+	return r.endpoint
+}
+
+func (r *httpRequestCtx) Function() string {
+	return r.function
+}
+
+func (r *httpRequestCtx) Header() transports.Header {
+	return r.header
+}
+
+func (r *httpRequestCtx) Body() (body []byte, err error) {
+	body = r.body
+	return
+}
+
+// BodyReader
+// the body of http is buffered by the max body size.
+func (r *httpRequestCtx) BodyReader() io.Reader {
+	return bytes.NewReader(r.body)
+}
+
+// ParseBody
+// decode the body by the encoder of content type, v is untouched when the body is empty.
+func (r *httpRequestCtx) ParseBody(v any) (err error) {
+	if len(r.body) == 0 {
+		return
+	}
+	if err = r.encoder.Unmarshal(r.body, v); err != nil {
+		err = errors.Join(transports.ParseBodyFailed, err)
+	}
+	return
+}
+
+func (r *httpRequestCtx) Response() transports.ResponseWriter {
+	return r.writer
+}
+
+func (r *httpRequestCtx) RemoteAddr() net.Addr {
+	return r.remote
+}
+
+// ClientAddr
+// the client is resolved by the Forwarded header, or the X-Forwarded-For header when there is no Forwarded header,
+// by the same rule of the forwarded chain of brick frames, see resolveForwardedHosts.
+func (r *httpRequestCtx) ClientAddr() string {
+	client := AddrHost(r.remote)
+	if r.trusted.Len() == 0 {
+		return client
+	}
+	return resolveForwardedHosts(client, httpForwardedHosts(r.header.h), r.trusted)
+}
+
+// httpForwardedHosts
+// the hosts of forwarded headers in the order of hops, an unknown or obfuscated host is empty.
+func httpForwardedHosts(header http.Header) (hosts []string) {
+	if values := header.Values("Forwarded"); len(values) > 0 {
+		for _, value := range values {
+			for _, element := range strings.Split(value, ",") {
+				host := ""
+				for _, pair := range strings.Split(element, ";") {
+					if key, node, ok := strings.Cut(strings.TrimSpace(pair), "="); ok && strings.EqualFold(key, "for") {
+						host = httpForwardedNode(node)
+					}
+				}
+				hosts = append(hosts, host)
+			}
+		}
+		return
+	}
+	for _, value := range header.Values("X-Forwarded-For") {
+		for _, node := range strings.Split(value, ",") {
+			hosts = append(hosts, httpForwardedNode(node))
+		}
+	}
+	return
+}
+
+// httpForwardedNode
+// the ip of a node without port, e.g. `"[2001:db8::1]:4711"`, it is empty when the node is not an ip.
+func httpForwardedNode(node string) string {
+	node = strings.Trim(strings.TrimSpace(node), `"`)
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		node = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(node, "[]"))
+	if err != nil {
+		return ""
+	}
+	return addr.Unmap().String()
+}
+
+// httpRemoteAddr
+// the address of http.Request.RemoteAddr, it is nil when the address is not an ip address.
+func httpRemoteAddr(address string) net.Addr {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return nil
+	}
+	return net.TCPAddrFromAddrPort(addrPort)
+}
+
+func (r *httpRequestCtx) Hijacked() bool {
+	return false
+}
+
+// Hijack
+// streams are not supported by the http gateway.
+func (r *httpRequestCtx) Hijack(_ transports.HijackHandler) error {
+	return ErrHijackUnsupported
+}
+
+// HTTPClient
+// call functions of a http gateway.
+type HTTPClient struct {
+	base   *url.URL
+	client *http.Client
+}
+
+func (client *HTTPClient) Do(ctx context.Context, request transports.Request) (response transports.Response, err error) {
+	if ctx == nil {
+		err = errors.New("context is missing")
+		return
+	}
+	if request == nil {
+		err = errors.New("request is missing")
+		return
+	}
+	body, bodyErr := request.Body()
+	if bodyErr != nil {
+		err = errors.Join(transports.WriteBodyFailed, bodyErr)
+		return
+	}
+	target := client.base.JoinPath(strings.TrimSpace(request.Endpoint()), strings.TrimSpace(request.Function()))
+	req, reqErr := http.NewRequestWithContext(ctx, http.MethodPost, target.String(), bytes.NewReader(body))
+	if reqErr != nil {
+		err = reqErr
+		return
+	}
+	transports.CopyHeader(httpHeader{req.Header}, request.Header())
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", defaultContentType)
+	}
+	resp, doErr := client.client.Do(req)
+	if doErr != nil {
+		err = doErr
+		return
+	}
+	defer resp.Body.Close()
+	b, readErr := io.ReadAll(resp.Body)
+	if readErr != nil {
+		err = readErr
+		return
+	}
+	response = &httpResponse{
+		succeed: resp.StatusCode >= 200 && resp.StatusCode < 300,
+		header:  httpHeader{resp.Header},
+		body:    b,
+	}
+	return
+}
+
+func (client *HTTPClient) Close() (err error) {
+	client.client.CloseIdleConnections()
+	return
+}
+
+type httpResponse struct {
+	succeed bool
+	header  httpHeader
+	body    []byte
+}
+
+func (r *httpResponse) Succeed() bool {
+	return r.succeed
+}
+
+func (r *httpResponse) Header() transports.Header {
+	return r.header
+}
+
+func (r *httpResponse) Body() (body []byte, err error) {
+	body = r.body
+	return
+}
+
+// ParseBody
+// when the response is failed, the error of server side is returned.
+func (r *httpResponse) ParseBody(v any) (err error) {
+	if !r.succeed {
+		err = decodeFailure(r.body)
+		return
+	}
+	if len(r.body) == 0 {
+		return
+	}
+	contentType := r.header.Get("Content-Type")
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+	encoder, ok := encoding.RetrieveByContentType(contentType)
+	if !ok {
+		err = errors.Join(transports.ParseBodyFailed, ErrUnsupportedContentType)
+		return
+	}
+	if err = encoder.Unmarshal(r.body, v); err != nil {
+		err = errors.Join(transports.ParseBodyFailed, err)
+	}
+	return
+}
@@ -0,0 +1,172 @@
+package transports_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/brickingsoft/brick/rpc/configs"
+	"github.com/brickingsoft/brick/rpc/transports"
+	brick "github.com/brickingsoft/brick/transports"
+)
+
+type sumHandler struct{}
+
+func (h *sumHandler) Handle(r brick.RequestCtx) {
+	if r.Function() != "sum" {
+		(&echoHandler{}).Handle(r)
+		return
+	}
+	numbers := make([]int, 0, 1)
+	if err := r.ParseBody(&numbers); err != nil {
+		r.Response().Failed(err)
+		return
+	}
+	sum := 0
+	for _, n := range numbers {
+		sum += n
+	}
+	r.Response().Succeed(map[string]int{"sum": sum})
+}
+
+func newHTTPTransport(t *testing.T, h2c bool) *transports.HTTPTransport {
+	t.Helper()
+	config, configErr := configs.NewConfig([]byte(fmt.Sprintf("address: 127.0.0.1:0\nh2c: %v\n", h2c)))
+	if configErr != nil {
+		t.Fatal(configErr)
+	}
+	tr, trErr := transports.NewHTTPTransport(context.Background(), *config)
+	if trErr != nil {
+		t.Fatal(trErr)
+	}
+	if err := tr.Listen(context.Background(), &sumHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	return tr.(*transports.HTTPTransport)
+}
+
+func TestHTTPTransport(t *testing.T) {
+	for _, h2c := range []bool{false, true} {
+		t.Run(fmt.Sprintf("h2c=%v", h2c), func(t *testing.T) {
+			tr := newHTTPTransport(t, h2c)
+			defer tr.Close()
+
+			ctx := context.Background()
+			client, clientErr := tr.Connect(ctx, tr.Addr().String())
+			if clientErr != nil {
+				t.Fatal(clientErr)
+			}
+			defer client.Close()
+
+			resp, doErr := client.Do(ctx, &testRequest{endpoint: "foo", function: "sum", body: []byte(`[1, 2, 3]`)})
+			if doErr != nil {
+				t.Fatal(doErr)
+			}
+			result := map[string]int{}
+			if err := resp.ParseBody(&result); err != nil {
+				t.Fatal(err)
+			}
+			if result["sum"] != 6 {
+				t.Fatal("unexpected sum", result)
+			}
+
+			resp, doErr = client.Do(ctx, &testRequest{endpoint: "foo", function: "bar", header: testHeader{"x-id": {"1"}}, body: []byte(`"hi"`)})
+			if doErr != nil {
+				t.Fatal(doErr)
+			}
+			if !resp.Succeed() || resp.Header().Get("echo") != `"hi"` || resp.Header().Get("function") != "foo.bar" {
+				t.Fatal("unexpected echo", resp.Header().Get("echo"), resp.Header().Get("function"))
+			}
+
+			resp, doErr = client.Do(ctx, &testRequest{endpoint: "foo", function: "fail"})
+			if doErr != nil {
+				t.Fatal(doErr)
+			}
+			if err := resp.ParseBody(nil); err == nil || err.Error() != "boom" {
+				t.Fatal("unexpected failure", err)
+			}
+		})
+	}
+}
+
+func TestHTTPGateway(t *testing.T) {
+	tr := newHTTPTransport(t, false)
+	defer tr.Close()
+	address := "http://" + tr.Addr().String()
+
+	cases := []struct {
+		method      string
+		path        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{http.MethodPost, "/foo/sum", "application/json", `[1, 2]`, http.StatusOK},
+		{http.MethodPost, "/foo/sum", "application/json", `{`, http.StatusBadRequest},
+		{http.MethodPost, "/foo/fail", "", ``, http.StatusInternalServerError},
+		{http.MethodPost, "/foo/bar", "", ``, http.StatusNoContent},
+		{http.MethodPost, "/foo", "", ``, http.StatusNotFound},
+		{http.MethodPost, "/foo/sum", "text/plain", `1`, http.StatusUnsupportedMediaType},
+		{http.MethodGet, "/foo/sum", "", ``, http.StatusMethodNotAllowed},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest(c.method, address+c.path, strings.NewReader(c.body))
+		if c.contentType != "" {
+			req.Header.Set("Content-Type", c.contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != c.status {
+			t.Error(c.method, c.path, c.contentType, "expected", c.status, "got", resp.StatusCode)
+		}
+	}
+}
+
+func TestHTTPGateway_ClientAddr(t *testing.T) {
+	transports.RegisterFunction("foo", "client")
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		config  string
+		headers map[string]string
+		client  string
+	}{
+		{name: "untrusted", headers: map[string]string{"X-Forwarded-For": "203.0.113.7"}, client: "127.0.0.1"},
+		{name: "x-forwarded-for", config: "forwarded:\n  trustedProxies: [127.0.0.0/8]\n", headers: map[string]string{"X-Forwarded-For": "203.0.113.7"}, client: "203.0.113.7"},
+		{name: "untrusted hop", config: "forwarded:\n  trustedProxies: [127.0.0.0/8]\n", headers: map[string]string{"X-Forwarded-For": "203.0.113.7, 198.51.100.1"}, client: "198.51.100.1"},
+		{name: "forwarded", config: "forwarded:\n  trustedProxies: [127.0.0.0/8, 198.51.100.1]\n", headers: map[string]string{
+			"Forwarded":       `for="[2001:db8::1]:4711";proto=https, for=198.51.100.1`,
+			"X-Forwarded-For": "203.0.113.7",
+		}, client: "2001:db8::1"},
+		{name: "unknown", config: "forwarded:\n  trustedProxies: [127.0.0.0/8]\n", headers: map[string]string{"Forwarded": "for=unknown"}, client: "127.0.0.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tr, trErr := transports.NewHTTPTransport(ctx, *mustConfig(t, "address: 127.0.0.1:0\n"+test.config))
+			if trErr != nil {
+				t.Fatal(trErr)
+			}
+			if err := tr.Listen(ctx, &clientAddrHandler{}); err != nil {
+				t.Fatal(err)
+			}
+			defer tr.Close()
+			req, _ := http.NewRequest(http.MethodPost, "http://"+tr.(*transports.HTTPTransport).Addr().String()+"/foo/client", nil)
+			for key, value := range test.headers {
+				req.Header.Set(key, value)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+			if client := resp.Header.Get("client"); client != test.client {
+				t.Fatal("unexpected client address", client)
+			}
+		})
+	}
+}
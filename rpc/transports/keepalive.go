@@ -0,0 +1,69 @@
+package transports
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrKeepAliveTimeout = errors.New("keepalive timeout")
+	ErrGoAway           = errors.New("connection is going away")
+)
+
+// keepalive
+// the liveness of a connection, any frame which is read proves the peer is alive.
+type keepalive struct {
+	interval time.Duration
+	timeout  time.Duration
+	lastRead atomic.Int64
+	pinged   int64
+	seq      uint64
+}
+
+// newKeepalive
+// it is nil when keepalive is disabled.
+func newKeepalive(opts Options) (ka *keepalive) {
+	if opts.KeepAliveInterval <= 0 {
+		return
+	}
+	ka = &keepalive{
+		interval: opts.KeepAliveInterval,
+		timeout:  opts.KeepAliveTimeout,
+	}
+	if ka.timeout <= 0 {
+		ka.timeout = DefaultKeepAliveTimeout
+	}
+	ka.touch()
+	return
+}
+
+func (ka *keepalive) touch() {
+	ka.lastRead.Store(time.Now().UnixNano())
+}
+
+// tick
+// the period of check.
+func (ka *keepalive) tick() time.Duration {
+	return min(ka.interval, ka.timeout) / 2
+}
+
+// check
+// ping is true when a ping of the id should be sent, dead is true when the sent ping is not answered in time.
+// it must be called by one goroutine.
+func (ka *keepalive) check(now time.Time) (ping bool, id uint64, dead bool) {
+	last, at := ka.lastRead.Load(), now.UnixNano()
+	if ka.pinged > 0 {
+		if last < ka.pinged {
+			dead = at-ka.pinged >= int64(ka.timeout)
+			return
+		}
+		ka.pinged = 0
+	}
+	if at-last >= int64(ka.interval) {
+		ka.seq++
+		ka.pinged = at
+		ping, id = true, ka.seq
+	}
+	return
+}
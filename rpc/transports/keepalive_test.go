@@ -0,0 +1,135 @@
+package transports_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brickingsoft/brick/rpc/transports"
+	brick "github.com/brickingsoft/brick/transports"
+)
+
+func TestKeepAlive(t *testing.T) {
+	transports.RegisterFunction("foo", "slow")
+	ctx := context.Background()
+
+	// a half-dead peer reads frames but never answers
+	ln, lnErr := net.Listen("tcp", "127.0.0.1:0")
+	if lnErr != nil {
+		t.Fatal(lnErr)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	client, dialErr := transports.Dial(ctx, "tcp", ln.Addr().String(), transports.WithKeepAlive(50*time.Millisecond, 50*time.Millisecond))
+	if dialErr != nil {
+		t.Fatal(dialErr)
+	}
+	defer client.Close()
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "slow"})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, transports.ErrKeepAliveTimeout) {
+			t.Fatal("expected keepalive timeout, got", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("call is not aborted by keepalive")
+	}
+
+	// a live peer answers pings
+	tr, _ := transports.NewTCPTransport(ctx, *mustConfig(t, `
+address: 127.0.0.1:0
+keepAliveInterval: 20ms
+keepAliveTimeout: 50ms
+`))
+	if err := tr.Listen(ctx, &slowHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	live, liveErr := transports.Dial(ctx, "tcp", tr.(*transports.TCPTransport).Addr().String(), transports.WithKeepAlive(20*time.Millisecond, 50*time.Millisecond))
+	if liveErr != nil {
+		t.Fatal(liveErr)
+	}
+	defer live.Close()
+	time.Sleep(200 * time.Millisecond)
+	if _, err := live.Do(ctx, &testRequest{endpoint: "foo", function: "slow"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	transports.RegisterFunction("foo", "slow")
+	ctx := context.Background()
+	tr, _ := transports.NewTCPTransport(ctx, *mustConfig(t, `
+address: 127.0.0.1:0
+idleTimeout: 100ms
+`))
+	if err := tr.Listen(ctx, &slowHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	client, dialErr := transports.Dial(ctx, "tcp", tr.(*transports.TCPTransport).Addr().String())
+	if dialErr != nil {
+		t.Fatal(dialErr)
+	}
+	defer client.Close()
+	if _, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "slow"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if _, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "slow"}); !errors.Is(err, transports.ErrGoAway) {
+		t.Fatal("expected goaway of idle connection, got", err)
+	}
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	transports.RegisterFunction("foo", "slow")
+	ctx := context.Background()
+	tr, _ := transports.NewTCPTransport(ctx, *mustConfig(t, `address: 127.0.0.1:0`))
+	if err := tr.Listen(ctx, &slowHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	client, dialErr := transports.Dial(ctx, "tcp", tr.(*transports.TCPTransport).Addr().String())
+	if dialErr != nil {
+		t.Fatal(dialErr)
+	}
+	defer client.Close()
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "slow"}); err != nil {
+				t.Error("inflight call should be done", err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	timeout, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if err := tr.(brick.GracefulTransport).Shutdown(timeout); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if _, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "slow"}); !errors.Is(err, transports.ErrGoAway) {
+		t.Fatal("expected goaway after shutdown, got", err)
+	}
+}
@@ -0,0 +1,164 @@
+package transports
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultMaxBodySize
+	// the max size of a body on the wire, it is used when the limit is not set.
+	DefaultMaxBodySize = 4 << 20
+)
+
+var (
+	ErrBodyTooLarge = errors.New("body is too large")
+)
+
+// BodyLimit
+// the body limit of a function.
+type BodyLimit struct {
+	// MaxBodySize
+	// the max size of the body, the limit of the transport is used when it is not positive.
+	MaxBodySize int64
+	// Streaming
+	// the body is not buffered, the handler reads it from the connection by BodyReader.
+	// the connection reads no other frame until the body is read or the handling is done, so it is for large uploads.
+	Streaming bool
+}
+
+// LimitsConfig
+// the limits node of a transport config.
+type LimitsConfig struct {
+	// MaxBodySize
+	// the max size of bodies, the default is 4MB.
+	MaxBodySize int64                 `json:"maxBodySize" yaml:"maxBodySize"`
+	Functions   []FunctionLimitConfig `json:"functions" yaml:"functions"`
+}
+
+// FunctionLimitConfig
+// the body limit of a function, the name is `endpoint.function`.
+type FunctionLimitConfig struct {
+	Name        string `json:"name" yaml:"name"`
+	MaxBodySize int64  `json:"maxBodySize" yaml:"maxBodySize"`
+	Streaming   bool   `json:"streaming" yaml:"streaming"`
+}
+
+func (config *LimitsConfig) options() []Option {
+	options := []Option{WithMaxBodySize(config.MaxBodySize)}
+	for _, function := range config.Functions {
+		name := strings.TrimSpace(function.Name)
+		idx := strings.LastIndexByte(name, '.')
+		if idx < 1 || idx == len(name)-1 {
+			options = append(options, func(options *Options) (err error) {
+				err = errors.Join(errors.New("invalid function limit"), errors.New("name must be endpoint.function"))
+				return
+			})
+			continue
+		}
+		options = append(options, WithFunctionBodyLimit(name[:idx], name[idx+1:], BodyLimit{
+			MaxBodySize: function.MaxBodySize,
+			Streaming:   function.Streaming,
+		}))
+	}
+	return options
+}
+
+// bodyLimitOf
+// the limit of the function, it falls back to the limit of the transport.
+func (opts *Options) bodyLimitOf(function uint64) (limit BodyLimit) {
+	limit = opts.FunctionBodyLimits[function]
+	if limit.MaxBodySize < 1 {
+		limit.MaxBodySize = opts.MaxBodySize
+	}
+	if limit.MaxBodySize < 1 {
+		limit.MaxBodySize = DefaultMaxBodySize
+	}
+	return
+}
+
+// streamingBody
+// the body of a streaming function, the handler reads it from the connection.
+// it is finished when it is read to the end or the handling is done, the rest is discarded to keep the framing.
+type streamingBody struct {
+	locker   sync.Mutex
+	r        io.LimitedReader
+	ka       *keepalive
+	finished bool
+	err      error
+	done     chan struct{}
+}
+
+func newStreamingBody(r io.Reader, n int64, ka *keepalive) *streamingBody {
+	return &streamingBody{
+		r:    io.LimitedReader{R: r, N: n},
+		ka:   ka,
+		done: make(chan struct{}),
+	}
+}
+
+func (b *streamingBody) Read(p []byte) (n int, err error) {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	if b.finished {
+		err = b.err
+		if err == nil {
+			err = io.EOF
+		}
+		return
+	}
+	n, err = b.r.Read(p)
+	if n > 0 && b.ka != nil {
+		// a long upload is not a dead peer.
+		b.ka.touch()
+	}
+	if err != nil || b.r.N == 0 {
+		if err == io.EOF && b.r.N > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		b.finish(err)
+	}
+	return
+}
+
+// close
+// discard the unread body and let the connection continue reading frames.
+func (b *streamingBody) close() {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	if b.finished {
+		return
+	}
+	var err error
+	if b.r.N > 0 {
+		_, err = io.Copy(io.Discard, &b.r)
+	}
+	b.finish(err)
+}
+
+func (b *streamingBody) finish(err error) {
+	b.finished = true
+	if err != io.EOF {
+		b.err = err
+	}
+	close(b.done)
+}
+
+func (b *streamingBody) remaining() int64 {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	if b.finished {
+		return 0
+	}
+	return b.r.N
+}
+
+// failed
+// the error of the connection while reading the body, the connection is closed when it is not nil.
+func (b *streamingBody) failed() error {
+	b.locker.Lock()
+	defer b.locker.Unlock()
+	return b.err
+}
@@ -0,0 +1,212 @@
+package transports_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/brickingsoft/brick/rpc/transports"
+	brick "github.com/brickingsoft/brick/transports"
+)
+
+type uploadHandler struct{}
+
+func (h *uploadHandler) Handle(r brick.RequestCtx) {
+	if r.Function() != "upload" {
+		(&rawEchoHandler{}).Handle(r)
+		return
+	}
+	n, err := io.Copy(io.Discard, r.(brick.StreamingRequest).BodyReader())
+	if err != nil {
+		r.Response().Failed(err)
+		return
+	}
+	r.Response().Succeed(transports.RawBody(strconv.FormatInt(n, 10)))
+}
+
+func TestBodyLimit(t *testing.T) {
+	transports.RegisterFunction("foo", "raw")
+	transports.RegisterFunction("foo", "large")
+	transports.RegisterFunction("foo", "upload")
+	ctx := context.Background()
+	tr, _ := transports.NewTCPTransport(ctx, *mustConfig(t, `
+address: 127.0.0.1:0
+limits:
+  maxBodySize: 1024
+  functions:
+    - name: foo.large
+      maxBodySize: 65536
+    - name: foo.upload
+      maxBodySize: 16777216
+      streaming: true
+`))
+	if err := tr.Listen(ctx, &uploadHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	client, dialErr := transports.Dial(ctx, "tcp", tr.(*transports.TCPTransport).Addr().String(), transports.WithMaxBodySize(32<<20))
+	if dialErr != nil {
+		t.Fatal(dialErr)
+	}
+	defer client.Close()
+
+	tests := []struct {
+		name     string
+		function string
+		size     int
+		succeed  bool
+	}{
+		{name: "small", function: "raw", size: 512, succeed: true},
+		{name: "oversized", function: "raw", size: 4096, succeed: false},
+		{name: "function", function: "large", size: 4096, succeed: true},
+		{name: "function oversized", function: "large", size: 128 << 10, succeed: false},
+		{name: "streaming", function: "upload", size: 8 << 20, succeed: true},
+		{name: "streaming oversized", function: "upload", size: 20 << 20, succeed: false},
+		// the connection is still usable after rejections.
+		{name: "after", function: "raw", size: 16, succeed: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := bytes.Repeat([]byte("b"), test.size)
+			response, err := client.Do(ctx, &testRequest{endpoint: "foo", function: test.function, body: body})
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := response.Body()
+			if response.Succeed() != test.succeed {
+				t.Fatal("unexpected result", response.Succeed(), string(b[:min(len(b), 128)]))
+			}
+			if !test.succeed {
+				if !strings.Contains(string(b), transports.ErrBodyTooLarge.Error()) {
+					t.Fatal("unexpected failure", string(b))
+				}
+				return
+			}
+			if test.function == "upload" {
+				if string(b) != strconv.Itoa(test.size) {
+					t.Fatal("unexpected uploaded size", string(b))
+				}
+				return
+			}
+			if !bytes.Equal(b, body) {
+				t.Fatal("unexpected body of", len(b), "bytes")
+			}
+		})
+	}
+}
+
+type parseHandler struct {
+	errs chan error
+}
+
+func (h *parseHandler) Handle(r brick.RequestCtx) {
+	var v any
+	err := r.ParseBody(&v)
+	h.errs <- err
+	if err != nil {
+		r.Response().Failed(err)
+		return
+	}
+	r.Response().Succeed(v)
+}
+
+// truncatingConn
+// the writing side is closed when the written bytes reach the limit, the limit is not set when it is zero.
+type truncatingConn struct {
+	net.Conn
+	limit   atomic.Int64
+	written int64
+}
+
+func (c *truncatingConn) Write(p []byte) (n int, err error) {
+	limit := c.limit.Load()
+	if limit == 0 || c.written+int64(len(p)) < limit {
+		n, err = c.Conn.Write(p)
+		c.written += int64(n)
+		return
+	}
+	if n, err = c.Conn.Write(p[:limit-c.written]); err == nil {
+		err = io.ErrClosedPipe
+	}
+	c.written += int64(n)
+	_ = c.Conn.(*net.TCPConn).CloseWrite()
+	return
+}
+
+func TestBodyLimit_ParseBody(t *testing.T) {
+	transports.RegisterFunction("foo", "parse")
+	ctx := context.Background()
+	tr, _ := transports.NewTCPTransport(ctx, *mustConfig(t, `
+address: 127.0.0.1:0
+limits:
+  functions:
+    - name: foo.parse
+      streaming: true
+`))
+	handler := &parseHandler{errs: make(chan error, 1)}
+	if err := tr.Listen(ctx, handler); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	raw, dialErr := net.Dial("tcp", tr.(*transports.TCPTransport).Addr().String())
+	if dialErr != nil {
+		t.Fatal(dialErr)
+	}
+	conn := &truncatingConn{Conn: raw}
+	client, clientErr := transports.NewClient(conn)
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	defer client.Close()
+
+	// the body is read by the handler, and the peer goes away in the middle of it.
+	conn.limit.Store(conn.written + 8<<10)
+	body := []byte(`"` + strings.Repeat("b", 64<<10) + `"`)
+	_, _ = client.Do(ctx, &testRequest{endpoint: "foo", function: "parse", body: body})
+	select {
+	case err := <-handler.errs:
+		if !errors.Is(err, brick.ParseBodyFailed) || !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatal("unexpected error", err)
+		}
+		break
+	case <-time.After(2 * time.Second):
+		t.Fatal("the request is not handled")
+	}
+}
+
+func TestBodyLimit_Response(t *testing.T) {
+	transports.RegisterFunction("foo", "raw")
+	ctx := context.Background()
+	tr, _ := transports.NewTCPTransport(ctx, *mustConfig(t, `address: 127.0.0.1:0`))
+	if err := tr.Listen(ctx, &rawEchoHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	client, dialErr := transports.Dial(ctx, "tcp", tr.(*transports.TCPTransport).Addr().String(), transports.WithMaxBodySize(1024))
+	if dialErr != nil {
+		t.Fatal(dialErr)
+	}
+	defer client.Close()
+
+	response, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "raw", body: bytes.Repeat([]byte("b"), 4096)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := response.Body(); response.Succeed() || !strings.Contains(string(b), transports.ErrBodyTooLarge.Error()) {
+		t.Fatal("oversized response should be failed", string(b))
+	}
+	response, err = client.Do(ctx, &testRequest{endpoint: "foo", function: "raw", body: []byte("b")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := response.Body(); !response.Succeed() || string(b) != "b" {
+		t.Fatal("unexpected response", string(b))
+	}
+}
@@ -0,0 +1,204 @@
+package transports
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brickingsoft/brick/rpc/configs"
+	"github.com/brickingsoft/brick/transports"
+)
+
+const (
+	MemTransportName = "mem"
+	memScheme        = "mem://"
+)
+
+var (
+	memLocker  = sync.RWMutex{}
+	memServers = make(map[string]*Server)
+)
+
+// MemAddr
+// the address of mem transport, it is `mem://{name}`.
+type MemAddr string
+
+func (addr MemAddr) Network() string {
+	return MemTransportName
+}
+
+func (addr MemAddr) String() string {
+	return memScheme + string(addr)
+}
+
+type MemConfig struct {
+	Name          string         `json:"name" yaml:"name"`
+	MaxHeaderSize int            `json:"maxHeaderSize" yaml:"maxHeaderSize"`
+	Headers       []HeaderValues `json:"headers" yaml:"headers"`
+	// HeaderTableSize
+	// the size of dynamic header tables of a connection, the default is 4KB, negative disables them.
+	HeaderTableSize int `json:"headerTableSize" yaml:"headerTableSize"`
+	// KeepAliveInterval
+	// a ping is sent when nothing is read for the interval, the default is 30s, negative disables keepalive.
+	KeepAliveInterval time.Duration `json:"keepAliveInterval" yaml:"keepAliveInterval"`
+	KeepAliveTimeout  time.Duration `json:"keepAliveTimeout" yaml:"keepAliveTimeout"`
+	// IdleTimeout
+	// the server closes connections which have no request for the timeout, it is disabled by default.
+	IdleTimeout time.Duration     `json:"idleTimeout" yaml:"idleTimeout"`
+	Compression CompressionConfig `json:"compression" yaml:"compression"`
+	// Limits
+	// the body limits, the default max body size is 4MB.
+	Limits LimitsConfig `json:"limits" yaml:"limits"`
+	// Forwarded
+	// the trusted proxies and the max hops of forwarded chains.
+	Forwarded ForwardedConfig `json:"forwarded" yaml:"forwarded"`
+}
+
+func (config *MemConfig) options() []Option {
+	options := []Option{
+		WithMaxHeaderSize(config.MaxHeaderSize),
+		WithHeaderFields(config.Headers...),
+		WithHeaderTableSize(config.HeaderTableSize),
+		WithKeepAlive(config.KeepAliveInterval, config.KeepAliveTimeout),
+		WithIdleTimeout(config.IdleTimeout),
+		WithCompression(config.Compression.Threshold, config.Compression.Encodings...),
+	}
+	options = append(options, config.Limits.options()...)
+	return append(options, config.Forwarded.options()...)
+}
+
+// NewMemTransport
+// a transports.Builder of the in-memory loopback transport, the config is the node of mem transport.
+func NewMemTransport(_ context.Context, config configs.Config) (transport transports.Transport, err error) {
+	mc := MemConfig{}
+	if err = config.As(&mc); err != nil {
+		err = errors.Join(errors.New("new mem transport failed"), err)
+		return
+	}
+	mc.Name = strings.TrimPrefix(strings.TrimSpace(mc.Name), memScheme)
+	if mc.Name == "" {
+		err = errors.Join(errors.New("new mem transport failed"), errors.New("name is missing"))
+		return
+	}
+	transport = &MemTransport{
+		config: mc,
+	}
+	return
+}
+
+// MemTransport
+// the handler is registered in a process-local registry by name, and clients are connected by in-memory pipes,
+// the frames are the same as network transports, so the header, body and stream semantics are the same too.
+type MemTransport struct {
+	config MemConfig
+	locker sync.Mutex
+	server *Server
+}
+
+func (tr *MemTransport) Name() string {
+	return MemTransportName
+}
+
+func (tr *MemTransport) Addr() net.Addr {
+	return MemAddr(tr.config.Name)
+}
+
+// Listen
+// register the handler by the name, the name must be unique in the process.
+func (tr *MemTransport) Listen(ctx context.Context, handler transports.ServeHandler) (err error) {
+	tr.locker.Lock()
+	defer tr.locker.Unlock()
+	if tr.server != nil {
+		err = errors.Join(errors.New("mem transport listen failed"), errors.New("already listened"))
+		return
+	}
+	srv, srvErr := NewServer(ctx, handler, tr.config.options()...)
+	if srvErr != nil {
+		err = errors.Join(errors.New("mem transport listen failed"), srvErr)
+		return
+	}
+	memLocker.Lock()
+	if _, has := memServers[tr.config.Name]; has {
+		memLocker.Unlock()
+		_ = srv.Close()
+		err = errors.Join(errors.New("mem transport listen failed"), errors.New(tr.config.Name+" is already listened"))
+		return
+	}
+	memServers[tr.config.Name] = srv
+	memLocker.Unlock()
+	tr.server = srv
+	return
+}
+
+// Connect
+// connect to the mem transport listened by the name, the address is `mem://{name}` or `{name}`.
+func (tr *MemTransport) Connect(_ context.Context, address string) (client transports.Client, err error) {
+	name := strings.TrimPrefix(strings.TrimSpace(address), memScheme)
+	if name == "" {
+		err = errors.Join(errors.New("mem transport connect failed"), errors.New("address is missing"))
+		return
+	}
+	memLocker.RLock()
+	srv, has := memServers[name]
+	memLocker.RUnlock()
+	if !has {
+		err = errors.Join(errors.New("mem transport connect failed"), errors.New(name+" is not listened"))
+		return
+	}
+	serverSide, clientSide := net.Pipe()
+	// the pipe is synchronous, so serve it before the handshake of the client.
+	if serveErr := srv.serveBackground(serverSide); serveErr != nil {
+		_ = serverSide.Close()
+		_ = clientSide.Close()
+		err = errors.Join(errors.New("mem transport connect failed"), serveErr)
+		return
+	}
+	c, clientErr := NewClient(clientSide, tr.config.options()...)
+	if clientErr != nil {
+		_ = serverSide.Close()
+		_ = clientSide.Close()
+		err = errors.Join(errors.New("mem transport connect failed"), clientErr)
+		return
+	}
+	client = c
+	return
+}
+
+// Shutdown
+// unregister the name, and close the server after the handling requests are done or ctx is done.
+func (tr *MemTransport) Shutdown(ctx context.Context) (err error) {
+	tr.locker.Lock()
+	srv := tr.server
+	tr.server = nil
+	tr.locker.Unlock()
+	if srv == nil {
+		return
+	}
+	memLocker.Lock()
+	if memServers[tr.config.Name] == srv {
+		delete(memServers, tr.config.Name)
+	}
+	memLocker.Unlock()
+	err = srv.Shutdown(ctx)
+	return
+}
+
+func (tr *MemTransport) Close() (err error) {
+	tr.locker.Lock()
+	srv := tr.server
+	tr.server = nil
+	tr.locker.Unlock()
+	if srv == nil {
+		return
+	}
+	memLocker.Lock()
+	if memServers[tr.config.Name] == srv {
+		delete(memServers, tr.config.Name)
+	}
+	memLocker.Unlock()
+	err = srv.Close()
+	return
+}
@@ -0,0 +1,95 @@
+package transports_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/brickingsoft/brick/rpc/configs"
+	"github.com/brickingsoft/brick/rpc/transports"
+	brick "github.com/brickingsoft/brick/transports"
+)
+
+func TestMemTransport(t *testing.T) {
+	transports.RegisterFunction("foo", "bar")
+	transports.RegisterFunction("foo", "stream")
+	config, configErr := configs.NewConfig([]byte(`name: mem://test`))
+	if configErr != nil {
+		t.Fatal(configErr)
+	}
+	ctx := context.Background()
+	tr, trErr := transports.NewMemTransport(ctx, *config)
+	if trErr != nil {
+		t.Fatal(trErr)
+	}
+	if err := tr.Listen(ctx, &echoHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	if err := tr.Listen(ctx, &echoHandler{}); err == nil {
+		t.Fatal("listen twice should be failed")
+	}
+
+	client, clientErr := tr.Connect(ctx, "mem://test")
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	defer client.Close()
+
+	body := []byte("body")
+	resp, doErr := client.Do(ctx, &testRequest{endpoint: "foo", function: "bar", body: body})
+	if doErr != nil {
+		t.Fatal(doErr)
+	}
+	body[0] = 'B'
+	if echo := resp.Header().Get("echo"); echo != "body" {
+		t.Fatal("unexpected echo", echo)
+	}
+
+	stream, streamErr := client.(brick.StreamClient).Stream(ctx, &testRequest{endpoint: "foo", function: "stream"})
+	if streamErr != nil {
+		t.Fatal(streamErr)
+	}
+	for _, s := range []string{"a", "bye"} {
+		if err := stream.Send(&testRequest{endpoint: "foo", function: "stream", body: []byte(s)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if resp, doErr = stream.Receive(); doErr != nil || resp.Header().Get("echo") != "a" {
+		t.Fatal("unexpected stream response", doErr)
+	}
+	if _, doErr = stream.Receive(); !errors.Is(doErr, io.EOF) {
+		t.Fatal("stream should be closed", doErr)
+	}
+	_ = stream.Close()
+
+	if _, err := tr.Connect(ctx, "mem://missing"); err == nil {
+		t.Fatal("connect to missing should be failed")
+	}
+}
+
+func TestMemTransport_ConnectClose(t *testing.T) {
+	ctx := context.Background()
+	for i := 0; i < 50; i++ {
+		tr, trErr := transports.NewMemTransport(ctx, *mustConfig(t, "name: mem://closing"))
+		if trErr != nil {
+			t.Fatal(trErr)
+		}
+		if err := tr.Listen(ctx, &echoHandler{}); err != nil {
+			t.Fatal(err)
+		}
+		connected := make(chan struct{})
+		go func() {
+			defer close(connected)
+			// the client is either connected before the close or failed.
+			if client, err := tr.Connect(ctx, "mem://closing"); err == nil {
+				_ = client.Close()
+			}
+		}()
+		if err := tr.Close(); err != nil {
+			t.Fatal(err)
+		}
+		<-connected
+	}
+}
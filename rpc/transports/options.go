@@ -0,0 +1,247 @@
+package transports
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/brickingsoft/brick/rpc/transports/bpack"
+)
+
+const (
+	DefaultKeepAliveInterval = 30 * time.Second
+	DefaultKeepAliveTimeout  = 20 * time.Second
+	DefaultHandshakeTimeout  = 10 * time.Second
+)
+
+type Options struct {
+	MaxHeaderSize int
+	HeaderFields  []HeaderValues
+	// HeaderTableSize
+	// the size of dynamic header tables of a connection, headers which are not in the dictionary are indexed by them.
+	// it is disabled when it is not positive, the smaller size of peers is used, and none is used when either peer has none.
+	HeaderTableSize int
+	// DisableDictionaryExchange
+	// headers are packed as literals instead of sending the header dictionary when the peer has a different one.
+	DisableDictionaryExchange bool
+	// KeepAliveInterval
+	// a ping is sent when nothing is read for the interval, keepalive is disabled when it is not positive.
+	KeepAliveInterval time.Duration
+	// KeepAliveTimeout
+	// the connection is closed when nothing is read for the timeout after a ping.
+	KeepAliveTimeout time.Duration
+	// IdleTimeout
+	// the server closes connections which have no request for the timeout, it is disabled when it is not positive.
+	IdleTimeout time.Duration
+	// HandshakeTimeout
+	// the server closes connections whose tls handshake is not done in the timeout.
+	HandshakeTimeout time.Duration
+	// ContentEncodings
+	// the body compressions in preference order, compression is disabled when it is empty.
+	ContentEncodings  []string
+	CompressThreshold int
+	// MaxBodySize
+	// the max size of bodies which are read, larger ones are rejected by ErrBodyTooLarge before being buffered.
+	MaxBodySize int64
+	// FunctionBodyLimits
+	// the body limits of functions, they are keyed by FunctionId.
+	FunctionBodyLimits map[uint64]BodyLimit
+	// TrustedProxies
+	// the proxies whose forwarded entries are used to resolve client addresses, it is only used by servers.
+	TrustedProxies TrustedProxies
+	// MaxForwardedHops
+	// the max count of forwarded entries of a request, it is only used by proxies.
+	MaxForwardedHops int
+}
+
+type Option func(options *Options) (err error)
+
+func WithMaxHeaderSize(n int) Option {
+	return func(options *Options) (err error) {
+		if n < 0 {
+			err = errors.New("max header size must be positive")
+			return
+		}
+		options.MaxHeaderSize = n
+		return
+	}
+}
+
+func WithHeaderFields(fields ...HeaderValues) Option {
+	return func(options *Options) (err error) {
+		options.HeaderFields = append(options.HeaderFields, fields...)
+		return
+	}
+}
+
+// WithHeaderTableSize
+// zero keeps the default, a negative size disables dynamic header tables.
+func WithHeaderTableSize(n int) Option {
+	return func(options *Options) (err error) {
+		if n != 0 {
+			options.HeaderTableSize = n
+		}
+		return
+	}
+}
+
+// WithDictionaryExchange
+// send the header dictionary to the peer which has a different one, it is enabled by default.
+func WithDictionaryExchange(enabled bool) Option {
+	return func(options *Options) (err error) {
+		options.DisableDictionaryExchange = !enabled
+		return
+	}
+}
+
+// WithKeepAlive
+// zero keeps the default, a negative interval disables keepalive.
+func WithKeepAlive(interval time.Duration, timeout time.Duration) Option {
+	return func(options *Options) (err error) {
+		if interval != 0 {
+			options.KeepAliveInterval = interval
+		}
+		if timeout < 0 {
+			err = errors.New("keepalive timeout must be positive")
+			return
+		}
+		if timeout > 0 {
+			options.KeepAliveTimeout = timeout
+		}
+		return
+	}
+}
+
+// WithIdleTimeout
+// zero or negative disables the idle timeout.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(options *Options) (err error) {
+		options.IdleTimeout = timeout
+		return
+	}
+}
+
+// WithHandshakeTimeout
+// zero keeps the default.
+func WithHandshakeTimeout(timeout time.Duration) Option {
+	return func(options *Options) (err error) {
+		if timeout < 0 {
+			err = errors.New("handshake timeout must be positive")
+			return
+		}
+		if timeout > 0 {
+			options.HandshakeTimeout = timeout
+		}
+		return
+	}
+}
+
+// WithCompression
+// compress bodies which are not smaller than the threshold, the encodings are snappy and zstd in preference order.
+func WithCompression(threshold int, encodings ...string) Option {
+	return func(options *Options) (err error) {
+		for _, encoding := range encodings {
+			encoding = strings.ToLower(strings.TrimSpace(encoding))
+			if !validContentEncoding(encoding) {
+				err = errors.Join(ErrUnsupportedContentEncoding, errors.New(encoding))
+				return
+			}
+			if !slices.Contains(options.ContentEncodings, encoding) {
+				options.ContentEncodings = append(options.ContentEncodings, encoding)
+			}
+		}
+		options.CompressThreshold = threshold
+		return
+	}
+}
+
+// WithMaxBodySize
+// zero keeps the default.
+func WithMaxBodySize(n int64) Option {
+	return func(options *Options) (err error) {
+		if n < 0 {
+			err = errors.New("max body size must be positive")
+			return
+		}
+		if n > 0 {
+			options.MaxBodySize = n
+		}
+		return
+	}
+}
+
+// WithFunctionBodyLimit
+// set the body limit of the function, it is only used by servers.
+func WithFunctionBodyLimit(endpoint string, function string, limit BodyLimit) Option {
+	return func(options *Options) (err error) {
+		endpoint = strings.TrimSpace(endpoint)
+		function = strings.TrimSpace(function)
+		if endpoint == "" || function == "" {
+			err = errors.New("endpoint and function of body limit are required")
+			return
+		}
+		if limit.MaxBodySize < 0 {
+			err = errors.New("max body size must be positive")
+			return
+		}
+		if options.FunctionBodyLimits == nil {
+			options.FunctionBodyLimits = make(map[uint64]BodyLimit)
+		}
+		options.FunctionBodyLimits[FunctionId(endpoint, function)] = limit
+		return
+	}
+}
+
+// WithTrustedProxies
+// a proxy is an ip, a cidr or an address of non-ip networks, e.g. a unix socket.
+func WithTrustedProxies(proxies ...string) Option {
+	return func(options *Options) (err error) {
+		for _, proxy := range proxies {
+			if err = options.TrustedProxies.add(proxy); err != nil {
+				return
+			}
+		}
+		return
+	}
+}
+
+// WithMaxForwardedHops
+// zero keeps the default.
+func WithMaxForwardedHops(n int) Option {
+	return func(options *Options) (err error) {
+		if n < 0 {
+			err = errors.New("max forwarded hops must be positive")
+			return
+		}
+		if n > 0 {
+			options.MaxForwardedHops = n
+		}
+		return
+	}
+}
+
+func newHeaderTable(opts Options) *bpack.DynamicTable {
+	if opts.HeaderTableSize < 1 {
+		return nil
+	}
+	return bpack.NewDynamicTable(opts.HeaderTableSize)
+}
+
+func newOptions(options ...Option) (opts Options, err error) {
+	opts = Options{
+		MaxHeaderSize:     bpack.DefaultMaxHeaderSize,
+		HeaderTableSize:   bpack.DefaultHeaderTableSize,
+		KeepAliveInterval: DefaultKeepAliveInterval,
+		KeepAliveTimeout:  DefaultKeepAliveTimeout,
+		HandshakeTimeout:  DefaultHandshakeTimeout,
+		MaxBodySize:       DefaultMaxBodySize,
+		MaxForwardedHops:  DefaultMaxForwardedHops,
+	}
+	for _, option := range options {
+		if err = option(&opts); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,88 @@
+package transports
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+)
+
+var (
+	ErrPeerCredentialsUnsupported = errors.New("peer credentials are unsupported")
+)
+
+// PeerCredentials
+// the credentials of the process on the other side of a unix socket.
+type PeerCredentials struct {
+	Pid int32  `json:"pid" yaml:"pid"`
+	Uid uint32 `json:"uid" yaml:"uid"`
+	Gid uint32 `json:"gid" yaml:"gid"`
+}
+
+type peerCredentialsKey struct{}
+
+func withPeerCredentials(ctx context.Context, credentials PeerCredentials) context.Context {
+	return context.WithValue(ctx, peerCredentialsKey{}, credentials)
+}
+
+// PeerCredentialsFrom
+// get the peer credentials from the context of request, ok is false when the request is not from a unix socket.
+func PeerCredentialsFrom(ctx context.Context) (credentials PeerCredentials, ok bool) {
+	if ctx == nil {
+		return
+	}
+	credentials, ok = ctx.Value(peerCredentialsKey{}).(PeerCredentials)
+	return
+}
+
+// PeerIdentity
+// the verified certificate of the peer of mutual tls.
+type PeerIdentity struct {
+	Subject        string   `json:"subject" yaml:"subject"`
+	CommonName     string   `json:"commonName" yaml:"commonName"`
+	DNSNames       []string `json:"dnsNames" yaml:"dnsNames"`
+	IPAddresses    []string `json:"ipAddresses" yaml:"ipAddresses"`
+	URIs           []string `json:"uris" yaml:"uris"`
+	EmailAddresses []string `json:"emailAddresses" yaml:"emailAddresses"`
+}
+
+type peerIdentityKey struct{}
+
+// peerIdentityOf
+// ok is false when the peer certificate is not verified.
+func peerIdentityOf(state *tls.ConnectionState) (identity PeerIdentity, ok bool) {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return
+	}
+	cert := state.VerifiedChains[0][0]
+	identity = PeerIdentity{
+		Subject:        cert.Subject.String(),
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+	}
+	for _, ip := range cert.IPAddresses {
+		identity.IPAddresses = append(identity.IPAddresses, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		identity.URIs = append(identity.URIs, uri.String())
+	}
+	ok = true
+	return
+}
+
+func withPeerIdentity(ctx context.Context, state *tls.ConnectionState) context.Context {
+	if identity, ok := peerIdentityOf(state); ok {
+		return context.WithValue(ctx, peerIdentityKey{}, identity)
+	}
+	return ctx
+}
+
+// PeerIdentityFrom
+// get the verified peer identity from the context of request, ok is false when the peer is not verified by mutual tls.
+func PeerIdentityFrom(ctx context.Context) (identity PeerIdentity, ok bool) {
+	if ctx == nil {
+		return
+	}
+	identity, ok = ctx.Value(peerIdentityKey{}).(PeerIdentity)
+	return
+}
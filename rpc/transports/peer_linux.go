@@ -0,0 +1,29 @@
+package transports
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+func peerCredentialsOf(conn *net.UnixConn) (credentials PeerCredentials, err error) {
+	raw, rawErr := conn.SyscallConn()
+	if rawErr != nil {
+		err = rawErr
+		return
+	}
+	var ucred *syscall.Ucred
+	var credErr error
+	if ctrlErr := raw.Control(func(fd uintptr) {
+		ucred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); ctrlErr != nil {
+		err = ctrlErr
+		return
+	}
+	if credErr != nil {
+		err = errors.Join(errors.New("get peer credentials failed"), credErr)
+		return
+	}
+	credentials = PeerCredentials{Pid: ucred.Pid, Uid: ucred.Uid, Gid: ucred.Gid}
+	return
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package transports
+
+import (
+	"net"
+)
+
+func peerCredentialsOf(_ *net.UnixConn) (credentials PeerCredentials, err error) {
+	err = ErrPeerCredentialsUnsupported
+	return
+}
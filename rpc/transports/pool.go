@@ -0,0 +1,466 @@
+package transports
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brickingsoft/brick/transports"
+)
+
+const (
+	DefaultPoolMaxConns            = 4
+	DefaultPoolMinConns            = 1
+	DefaultPoolMaxStreams          = 256
+	DefaultPoolIdleTimeout         = 90 * time.Second
+	DefaultPoolHealthCheckInterval = 15 * time.Second
+	DefaultPoolHealthCheckTimeout  = 5 * time.Second
+	DefaultPoolBackoffMin          = 100 * time.Millisecond
+	DefaultPoolBackoffMax          = 10 * time.Second
+)
+
+// PoolConfig
+// the pool node of a transport config.
+type PoolConfig struct {
+	// MaxConns
+	// the max connections of an address.
+	MaxConns int `json:"maxConns" yaml:"maxConns"`
+	// MinConns
+	// the connections which are kept even if they are idle.
+	MinConns int `json:"minConns" yaml:"minConns"`
+	// MaxStreams
+	// the max concurrent calls and streams of a connection, a new connection is dialed when all are full.
+	MaxStreams  int64         `json:"maxStreams" yaml:"maxStreams"`
+	IdleTimeout time.Duration `json:"idleTimeout" yaml:"idleTimeout"`
+	// HealthCheckInterval
+	// the interval of health checks, broken and idle connections are evicted, and idle ones are pinged.
+	HealthCheckInterval time.Duration `json:"healthCheckInterval" yaml:"healthCheckInterval"`
+	// HealthCheckTimeout
+	// an idle connection whose ping is not answered in the timeout is evicted.
+	HealthCheckTimeout time.Duration `json:"healthCheckTimeout" yaml:"healthCheckTimeout"`
+	// BackoffMin
+	// the first delay of redialing after a failed dial, it is doubled until BackoffMax.
+	BackoffMin time.Duration `json:"backoffMin" yaml:"backoffMin"`
+	BackoffMax time.Duration `json:"backoffMax" yaml:"backoffMax"`
+}
+
+func (config PoolConfig) normalize() PoolConfig {
+	if config.MaxConns < 1 {
+		config.MaxConns = DefaultPoolMaxConns
+	}
+	if config.MinConns < 0 {
+		config.MinConns = 0
+	} else if config.MinConns == 0 {
+		config.MinConns = DefaultPoolMinConns
+	}
+	if config.MinConns > config.MaxConns {
+		config.MinConns = config.MaxConns
+	}
+	if config.MaxStreams < 1 {
+		config.MaxStreams = DefaultPoolMaxStreams
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = DefaultPoolIdleTimeout
+	}
+	if config.HealthCheckInterval <= 0 {
+		config.HealthCheckInterval = DefaultPoolHealthCheckInterval
+	}
+	if config.HealthCheckTimeout <= 0 {
+		config.HealthCheckTimeout = DefaultPoolHealthCheckTimeout
+	}
+	if config.BackoffMin <= 0 {
+		config.BackoffMin = DefaultPoolBackoffMin
+	}
+	if config.BackoffMax < config.BackoffMin {
+		config.BackoffMax = max(DefaultPoolBackoffMax, config.BackoffMin)
+	}
+	return config
+}
+
+// pooledClient
+// a client of one connection.
+type pooledClient interface {
+	transports.StreamClient
+	alive() bool
+	// ping
+	// send a ping and wait for its pong.
+	ping(ctx context.Context) error
+}
+
+type pooledConn struct {
+	client   pooledClient
+	inflight int64
+	lastUsed time.Time
+	// retired
+	// the connection is broken or going away, it is closed when the inflight calls are done.
+	retired bool
+}
+
+// Pool
+// a client of one address which keeps several connections, calls go to the least loaded connection.
+type Pool struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	dial     func(ctx context.Context) (pooledClient, error)
+	config   PoolConfig
+	locker   sync.Mutex
+	conns    []*pooledConn
+	dialing  int
+	failures int
+	next     time.Time
+	released chan struct{}
+	closed   bool
+	done     chan struct{}
+}
+
+// newPool
+// the dial is used to make new connections, the first connection is dialed at once.
+func newPool(ctx context.Context, config PoolConfig, dial func(ctx context.Context) (pooledClient, error)) (pool *Pool, err error) {
+	pool = &Pool{
+		dial:     dial,
+		config:   config.normalize(),
+		released: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	client, dialErr := dial(ctx)
+	if dialErr != nil {
+		pool = nil
+		err = dialErr
+		return
+	}
+	pool.conns = append(pool.conns, &pooledConn{client: client, lastUsed: time.Now()})
+	pool.ctx, pool.cancel = context.WithCancel(context.Background())
+	go pool.maintain()
+	return
+}
+
+// Conns
+// the number of connections.
+func (pool *Pool) Conns() (n int) {
+	pool.locker.Lock()
+	n = len(pool.conns)
+	pool.locker.Unlock()
+	return
+}
+
+func (pool *Pool) Do(ctx context.Context, request transports.Request) (response transports.Response, err error) {
+	if ctx == nil {
+		err = errors.New("context is missing")
+		return
+	}
+	for retried := false; ; retried = true {
+		pc, acquireErr := pool.acquire(ctx)
+		if acquireErr != nil {
+			err = acquireErr
+			return
+		}
+		response, err = pc.client.Do(ctx, request)
+		pool.release(pc)
+		// the request was not handled by the server which went away, so it is safe to send it again.
+		if retried || !errors.Is(err, ErrGoAway) {
+			return
+		}
+	}
+}
+
+// Stream
+// the stream takes a place of the connection until it is closed or ended.
+func (pool *Pool) Stream(ctx context.Context, request transports.Request) (stream transports.ClientStream, err error) {
+	if ctx == nil {
+		err = errors.New("context is missing")
+		return
+	}
+	pc, acquireErr := pool.acquire(ctx)
+	if acquireErr != nil {
+		err = acquireErr
+		return
+	}
+	s, streamErr := pc.client.Stream(ctx, request)
+	if streamErr != nil {
+		pool.release(pc)
+		err = streamErr
+		return
+	}
+	stream = &pooledStream{ClientStream: s, pool: pool, conn: pc}
+	return
+}
+
+func (pool *Pool) Close() (err error) {
+	pool.locker.Lock()
+	if pool.closed {
+		pool.locker.Unlock()
+		return
+	}
+	pool.closed = true
+	conns := pool.conns
+	pool.conns = nil
+	close(pool.released)
+	pool.locker.Unlock()
+
+	pool.cancel()
+	<-pool.done
+	var errs []error
+	for _, pc := range conns {
+		if closeErr := pc.client.Close(); closeErr != nil {
+			errs = append(errs, closeErr)
+		}
+	}
+	if len(errs) > 0 {
+		err = errors.Join(errors.New("close pool failed"), errors.Join(errs...))
+	}
+	return
+}
+
+func (pool *Pool) acquire(ctx context.Context) (pc *pooledConn, err error) {
+	for {
+		pool.locker.Lock()
+		if pool.closed {
+			pool.locker.Unlock()
+			err = ErrClientClosed
+			return
+		}
+		pc = pool.leastLoaded()
+		if pc != nil && pc.inflight < pool.config.MaxStreams {
+			pc.inflight++
+			pool.locker.Unlock()
+			return
+		}
+		if len(pool.conns)+pool.dialing < pool.config.MaxConns {
+			wait := time.Until(pool.next)
+			if wait <= 0 {
+				pool.dialing++
+				pool.locker.Unlock()
+				if err = pool.redial(ctx); err != nil && pc == nil {
+					return
+				}
+				err = nil
+				continue
+			}
+			if pc == nil {
+				pool.locker.Unlock()
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+					continue
+				case <-ctx.Done():
+					timer.Stop()
+					pc, err = nil, ctx.Err()
+					return
+				}
+			}
+		}
+		released := pool.released
+		pool.locker.Unlock()
+		select {
+		case <-released:
+			break
+		case <-ctx.Done():
+			pc, err = nil, ctx.Err()
+			return
+		}
+	}
+}
+
+// redial
+// the caller must increase the dialing before calling.
+func (pool *Pool) redial(ctx context.Context) (err error) {
+	client, dialErr := pool.dial(ctx)
+	pool.locker.Lock()
+	defer pool.locker.Unlock()
+	pool.dialing--
+	if dialErr != nil {
+		pool.failures++
+		pool.next = time.Now().Add(pool.backoff())
+		err = dialErr
+		// the place of dialing is free, so the waiters may dial.
+		if !pool.closed {
+			pool.notify()
+		}
+		return
+	}
+	pool.failures = 0
+	pool.next = time.Time{}
+	if pool.closed {
+		_ = client.Close()
+		err = ErrClientClosed
+		return
+	}
+	pool.conns = append(pool.conns, &pooledConn{client: client, lastUsed: time.Now()})
+	pool.notify()
+	return
+}
+
+// backoff
+// the delay of next dialing, it has a jitter of 20 percent.
+func (pool *Pool) backoff() time.Duration {
+	d := pool.config.BackoffMin
+	for i := 1; i < pool.failures && d < pool.config.BackoffMax; i++ {
+		d *= 2
+	}
+	d = min(d, pool.config.BackoffMax)
+	return d - time.Duration(rand.Int64N(int64(d)/5+1))
+}
+
+// leastLoaded
+// the broken connections are removed, the ones which have inflight calls are closed when the calls are done.
+func (pool *Pool) leastLoaded() (pc *pooledConn) {
+	n := 0
+	for _, conn := range pool.conns {
+		if !conn.client.alive() {
+			if conn.inflight == 0 {
+				_ = conn.client.Close()
+			} else {
+				conn.retired = true
+			}
+			continue
+		}
+		pool.conns[n] = conn
+		n++
+		if pc == nil || conn.inflight < pc.inflight {
+			pc = conn
+		}
+	}
+	clear(pool.conns[n:])
+	pool.conns = pool.conns[:n]
+	return
+}
+
+func (pool *Pool) release(pc *pooledConn) {
+	pool.locker.Lock()
+	pc.inflight--
+	pc.lastUsed = time.Now()
+	if pc.retired && pc.inflight == 0 {
+		_ = pc.client.Close()
+	}
+	if !pool.closed {
+		pool.notify()
+	}
+	pool.locker.Unlock()
+}
+
+func (pool *Pool) notify() {
+	close(pool.released)
+	pool.released = make(chan struct{})
+}
+
+// evict
+// remove the connection, it is closed when the inflight calls are done.
+func (pool *Pool) evict(pc *pooledConn) {
+	pool.locker.Lock()
+	defer pool.locker.Unlock()
+	i := slices.Index(pool.conns, pc)
+	if i < 0 {
+		return
+	}
+	pool.conns = slices.Delete(pool.conns, i, i+1)
+	if pc.inflight == 0 {
+		_ = pc.client.Close()
+		return
+	}
+	pc.retired = true
+}
+
+// maintain
+// evict broken, unanswered and idle connections, and keep the min connections.
+func (pool *Pool) maintain() {
+	defer close(pool.done)
+	ticker := time.NewTicker(pool.config.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pool.ctx.Done():
+			return
+		case <-ticker.C:
+			pool.check()
+		}
+	}
+}
+
+func (pool *Pool) check() {
+	pool.probe()
+	pool.locker.Lock()
+	if pool.closed {
+		pool.locker.Unlock()
+		return
+	}
+	pool.leastLoaded()
+	now := time.Now()
+	total := len(pool.conns)
+	kept := pool.conns[:0]
+	for _, pc := range pool.conns {
+		if total > pool.config.MinConns && pc.inflight == 0 && now.Sub(pc.lastUsed) > pool.config.IdleTimeout {
+			_ = pc.client.Close()
+			total--
+			continue
+		}
+		kept = append(kept, pc)
+	}
+	clear(pool.conns[len(kept):])
+	pool.conns = kept
+	need := len(pool.conns)+pool.dialing < pool.config.MinConns && !now.Before(pool.next)
+	if need {
+		pool.dialing++
+	}
+	pool.locker.Unlock()
+	if need {
+		_ = pool.redial(pool.ctx)
+	}
+}
+
+// probe
+// ping the idle connections, so half-dead ones are evicted before calls are sent on them.
+func (pool *Pool) probe() {
+	pool.locker.Lock()
+	if pool.closed {
+		pool.locker.Unlock()
+		return
+	}
+	idle := make([]*pooledConn, 0, len(pool.conns))
+	for _, pc := range pool.conns {
+		if pc.inflight == 0 {
+			idle = append(idle, pc)
+		}
+	}
+	pool.locker.Unlock()
+	for _, pc := range idle {
+		ctx, cancel := context.WithTimeout(pool.ctx, pool.config.HealthCheckTimeout)
+		err := pc.client.ping(ctx)
+		cancel()
+		if pool.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			pool.evict(pc)
+		}
+	}
+}
+
+type pooledStream struct {
+	transports.ClientStream
+	pool     *Pool
+	conn     *pooledConn
+	released atomic.Bool
+}
+
+func (s *pooledStream) Receive() (response transports.Response, err error) {
+	if response, err = s.ClientStream.Receive(); err != nil {
+		s.release()
+	}
+	return
+}
+
+func (s *pooledStream) Close() (err error) {
+	err = s.ClientStream.Close()
+	s.release()
+	return
+}
+
+func (s *pooledStream) release() {
+	if s.released.CompareAndSwap(false, true) {
+		s.pool.release(s.conn)
+	}
+}
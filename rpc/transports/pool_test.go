@@ -0,0 +1,211 @@
+package transports_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brickingsoft/brick/rpc/configs"
+	"github.com/brickingsoft/brick/rpc/transports"
+	brick "github.com/brickingsoft/brick/transports"
+)
+
+type slowHandler struct{}
+
+func (h *slowHandler) Handle(r brick.RequestCtx) {
+	time.Sleep(50 * time.Millisecond)
+	r.Response().Succeed(nil)
+}
+
+func TestPool(t *testing.T) {
+	transports.RegisterFunction("foo", "slow")
+	ctx := context.Background()
+	config, _ := configs.NewConfig([]byte(`
+address: 127.0.0.1:0
+pool:
+  maxConns: 3
+  minConns: 1
+  maxStreams: 2
+  idleTimeout: 100ms
+  healthCheckInterval: 50ms
+  backoffMin: 10ms
+  backoffMax: 50ms
+`))
+	tr, _ := transports.NewTCPTransport(ctx, *config)
+	if err := tr.Listen(ctx, &slowHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	address := tr.(*transports.TCPTransport).Addr().String()
+
+	client, clientErr := tr.Connect(ctx, address)
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	defer client.Close()
+	pool := client.(*transports.Pool)
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := pool.Do(ctx, &testRequest{endpoint: "foo", function: "slow"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := pool.Conns(); n != 3 {
+		t.Fatal("expected 3 conns, got", n)
+	}
+
+	// idle eviction
+	time.Sleep(300 * time.Millisecond)
+	if n := pool.Conns(); n != 1 {
+		t.Fatal("expected 1 conn after idle eviction, got", n)
+	}
+
+	// reconnection
+	_ = tr.Close()
+	restarted, _ := transports.NewTCPTransport(ctx, *mustConfig(t, fmt.Sprintf("address: %s", address)))
+	if err := restarted.Listen(ctx, &slowHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Close()
+	time.Sleep(100 * time.Millisecond)
+	timeout, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if _, err := pool.Do(timeout, &testRequest{endpoint: "foo", function: "slow"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPool_HealthCheck(t *testing.T) {
+	// a half-dead peer accepts connections and reads frames, but never answers.
+	ln, lnErr := net.Listen("tcp", "127.0.0.1:0")
+	if lnErr != nil {
+		t.Fatal(lnErr)
+	}
+	defer ln.Close()
+	closed := make(chan struct{}, 8)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				_, _ = io.Copy(io.Discard, conn)
+				_ = conn.Close()
+				closed <- struct{}{}
+			}(conn)
+		}
+	}()
+
+	ctx := context.Background()
+	tr, _ := transports.NewTCPTransport(ctx, *mustConfig(t, `
+address: 127.0.0.1:0
+keepAliveInterval: -1s
+pool:
+  healthCheckInterval: 50ms
+  healthCheckTimeout: 50ms
+`))
+	client, clientErr := tr.Connect(ctx, ln.Addr().String())
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	defer client.Close()
+	select {
+	case <-closed:
+		break
+	case <-time.After(2 * time.Second):
+		t.Fatal("the unanswered connection is not evicted")
+	}
+}
+
+func TestPool_DialFailed(t *testing.T) {
+	transports.RegisterFunction("foo", "bar")
+	ca, server, _ := writeTestCertificates(t)
+	ctx := context.Background()
+	srv, _ := transports.NewTCPTransport(ctx, *mustConfig(t, fmt.Sprintf("address: 127.0.0.1:0\ntls:\n  cert: %s\n  key: %s\n", server[0], server[1])))
+	if err := srv.Listen(ctx, &echoHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	// the first connection is relayed to the server, the later ones are closed before the handshake is done.
+	ln, lnErr := net.Listen("tcp", "127.0.0.1:0")
+	if lnErr != nil {
+		t.Fatal(lnErr)
+	}
+	defer ln.Close()
+	relayed := make(chan net.Conn, 1)
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if i > 0 {
+				time.AfterFunc(200*time.Millisecond, func() { _ = conn.Close() })
+				continue
+			}
+			upstream, dialErr := net.Dial("tcp", srv.(*transports.TCPTransport).Addr().String())
+			if dialErr != nil {
+				_ = conn.Close()
+				return
+			}
+			go func() { _, _ = io.Copy(upstream, conn) }()
+			go func() { _, _ = io.Copy(conn, upstream) }()
+			relayed <- conn
+		}
+	}()
+
+	tr, _ := transports.NewTCPTransport(ctx, *mustConfig(t, fmt.Sprintf(`
+address: 127.0.0.1:0
+tls:
+  ca: %s
+  serverName: localhost
+pool:
+  maxConns: 1
+  backoffMin: 10ms
+  backoffMax: 20ms
+`, ca)))
+	client, clientErr := tr.Connect(ctx, ln.Addr().String())
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	defer client.Close()
+	_ = (<-relayed).Close()
+	time.Sleep(100 * time.Millisecond)
+
+	// one caller dials and the other waits for it, the waiter must not hang when the dialing is failed.
+	timeout, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := client.Do(timeout, &testRequest{endpoint: "foo", function: "bar"})
+			errs <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err == nil || errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("expected the dial error, got", err)
+		}
+	}
+}
+
+func mustConfig(t *testing.T, s string) *configs.Config {
+	t.Helper()
+	config, err := configs.NewConfig([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
@@ -0,0 +1,224 @@
+package transports
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/brickingsoft/brick/transports"
+)
+
+const (
+	// DefaultMaxForwardedHops
+	// the default max count of forwarded entries of a request which is relayed by a proxy.
+	DefaultMaxForwardedHops = 8
+)
+
+var (
+	ErrTooManyForwardedHops = errors.New("too many forwarded hops")
+)
+
+// ForwardedConfig
+// the config of forwarded chains, trusted proxies are ips, cidrs or addresses of non-ip networks, e.g. unix sockets.
+type ForwardedConfig struct {
+	TrustedProxies []string `json:"trustedProxies" yaml:"trustedProxies"`
+	// MaxHops
+	// the max count of forwarded entries which a proxy relays, the default is 8.
+	MaxHops int `json:"maxHops" yaml:"maxHops"`
+}
+
+func (config *ForwardedConfig) options() []Option {
+	return []Option{
+		WithTrustedProxies(config.TrustedProxies...),
+		WithMaxForwardedHops(config.MaxHops),
+	}
+}
+
+// TrustedProxies
+// the proxies whose forwarded entries are trusted.
+type TrustedProxies struct {
+	prefixes []netip.Prefix
+	names    []string
+}
+
+func (proxies *TrustedProxies) add(proxy string) (err error) {
+	proxy = strings.TrimSpace(proxy)
+	if proxy == "" {
+		err = errors.New("trusted proxy is empty")
+		return
+	}
+	if prefix, prefixErr := netip.ParsePrefix(proxy); prefixErr == nil {
+		proxies.prefixes = append(proxies.prefixes, prefix.Masked())
+		return
+	}
+	if addr, addrErr := netip.ParseAddr(proxy); addrErr == nil {
+		proxies.prefixes = append(proxies.prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		return
+	}
+	proxies.names = append(proxies.names, proxy)
+	return
+}
+
+// Contains
+// the host is an ip without port or an address of non-ip networks.
+func (proxies *TrustedProxies) Contains(host string) bool {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addr = addr.Unmap()
+		for _, prefix := range proxies.prefixes {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, name := range proxies.names {
+		if name == host {
+			return true
+		}
+	}
+	return false
+}
+
+// Len
+// the count of trusted proxies.
+func (proxies *TrustedProxies) Len() int {
+	return len(proxies.prefixes) + len(proxies.names)
+}
+
+// AddrHost
+// the host of ip addresses without port, other addresses are kept.
+func AddrHost(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	s := addr.String()
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		return host
+	}
+	return s
+}
+
+// resolveClientAddr
+// the client of the forwarded chain of brick frames, see resolveForwardedHosts.
+func resolveClientAddr(remote net.Addr, forwarded *Forwarded, trusted *TrustedProxies) (client string) {
+	client = AddrHost(remote)
+	if forwarded == nil || trusted.Len() == 0 {
+		return
+	}
+	hosts := make([]string, len(forwarded.entries))
+	for i, entry := range forwarded.entries {
+		hosts[i] = string(entry.host)
+	}
+	client = resolveForwardedHosts(client, hosts, trusted)
+	return
+}
+
+// resolveForwardedHosts
+// walk the hosts of a forwarded chain from the nearest hop, the first host which is not a trusted proxy is the client,
+// the peer is the nearest one, and an unknown host stops the walk.
+func resolveForwardedHosts(peer string, hosts []string, trusted *TrustedProxies) (client string) {
+	client = peer
+	for i := len(hosts) - 1; i >= 0; i-- {
+		if !trusted.Contains(client) {
+			return
+		}
+		if hosts[i] == "" {
+			return
+		}
+		client = hosts[i]
+	}
+	return
+}
+
+// appendForwarded
+// append the entry of this hop, the host is the address which the request is received from.
+func appendForwarded(header Header, name string, remote net.Addr) {
+	proto := ""
+	if remote != nil {
+		proto = remote.Network()
+	}
+	header.AddForwarded([]byte(name), []byte(AddrHost(remote)), []byte(proto))
+}
+
+// forwardHeader
+// copy the header of the previous hop and append the entry of this hop, the bytes of src are shared.
+func forwardHeader(dst Header, src transports.Header, name string, remote net.Addr, maxHops int) (err error) {
+	transports.CopyHeader(dst, src)
+	if forwarded := dst.Forwarded(); forwarded != nil && len(forwarded.entries) >= maxHops {
+		err = ErrTooManyForwardedHops
+		return
+	}
+	appendForwarded(dst, name, remote)
+	return
+}
+
+// ProxyUpstream
+// the upstream of a proxy, e.g. a Client or a Balancer.
+type ProxyUpstream interface {
+	Do(ctx context.Context, request transports.Request) (response transports.Response, err error)
+}
+
+// NewProxy
+// a transports.ServeHandler which relays requests to the upstream, the name is the name of this hop in forwarded entries.
+// hijacked requests (streams) are not relayed.
+func NewProxy(name string, upstream ProxyUpstream, options ...Option) (proxy *Proxy, err error) {
+	if name = strings.TrimSpace(name); name == "" {
+		err = errors.Join(errors.New("new proxy failed"), errors.New("name is missing"))
+		return
+	}
+	if strings.ContainsAny(name, ";,") {
+		err = errors.Join(errors.New("new proxy failed"), errors.New("name must not contain ';' or ','"))
+		return
+	}
+	if upstream == nil {
+		err = errors.Join(errors.New("new proxy failed"), errors.New("upstream is missing"))
+		return
+	}
+	opts, optsErr := newOptions(options...)
+	if optsErr != nil {
+		err = errors.Join(errors.New("new proxy failed"), optsErr)
+		return
+	}
+	proxy = &Proxy{
+		name:     name,
+		upstream: upstream,
+		maxHops:  opts.MaxForwardedHops,
+	}
+	return
+}
+
+// Proxy
+// relay requests to the upstream, each relayed request has the forwarded entry of this hop.
+type Proxy struct {
+	name     string
+	upstream ProxyUpstream
+	maxHops  int
+}
+
+func (proxy *Proxy) Handle(r transports.RequestCtx) {
+	header := AcquireHeader()
+	defer ReleaseHeader(header)
+	if err := forwardHeader(header, r.Header(), proxy.name, r.RemoteAddr(), proxy.maxHops); err != nil {
+		r.Response().Failed(err)
+		return
+	}
+
+	body, bodyErr := r.Body()
+	if bodyErr != nil {
+		r.Response().Failed(errors.Join(transports.ParseBodyFailed, bodyErr))
+		return
+	}
+	response, err := proxy.upstream.Do(r, &forwardedRequest{
+		endpoint: r.Endpoint(),
+		function: r.Function(),
+		header:   header,
+		body:     body,
+	})
+	if err != nil {
+		r.Response().Failed(err)
+		return
+	}
+	relayResponse(&relayWriter{writer: r.Response()}, response)
+}
@@ -0,0 +1,148 @@
+package transports_test
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/brickingsoft/brick/rpc/transports"
+	brick "github.com/brickingsoft/brick/transports"
+)
+
+type clientAddrHandler struct{}
+
+func (h *clientAddrHandler) Handle(r brick.RequestCtx) {
+	r.Response().Header().Set("client", r.ClientAddr())
+	r.Response().Header().Set("hops", strconv.Itoa(len(r.Header().Values("forwarded"))))
+	r.Response().Succeed(nil)
+}
+
+func TestProxy(t *testing.T) {
+	transports.RegisterFunction("foo", "client")
+	ctx := context.Background()
+
+	listen := func(t *testing.T, config string, handler brick.ServeHandler) string {
+		t.Helper()
+		tr, _ := transports.NewTCPTransport(ctx, *mustConfig(t, "address: 127.0.0.1:0\n"+config))
+		if err := tr.Listen(ctx, handler); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = tr.Close() })
+		return tr.(*transports.TCPTransport).Addr().String()
+	}
+	relay := func(t *testing.T, name string, upstream string, options ...transports.Option) string {
+		t.Helper()
+		client, err := transports.Dial(ctx, "tcp", upstream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = client.Close() })
+		proxy, proxyErr := transports.NewProxy(name, client, options...)
+		if proxyErr != nil {
+			t.Fatal(proxyErr)
+		}
+		return listen(t, "", proxy)
+	}
+
+	tests := []struct {
+		name    string
+		origin  string
+		maxHops int
+		forged  []string
+		client  string
+		hops    int
+		failed  bool
+	}{
+		{name: "untrusted", forged: []string{"evil;203.0.113.7;tcp"}, client: "127.0.0.1", hops: 3},
+		{name: "trusted", origin: "forwarded:\n  trustedProxies: [127.0.0.0/8]\n", client: "127.0.0.1", hops: 2},
+		{name: "trusted forged", origin: "forwarded:\n  trustedProxies: [127.0.0.0/8]\n", forged: []string{"evil;203.0.113.7;tcp"}, client: "203.0.113.7", hops: 3},
+		{name: "too many hops", maxHops: 2, forged: []string{"a;203.0.113.7;tcp", "b;203.0.113.8;tcp"}, failed: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			origin := listen(t, test.origin, &clientAddrHandler{})
+			mid := relay(t, "mid", origin)
+			edge := relay(t, "edge", mid, transports.WithMaxForwardedHops(test.maxHops))
+
+			client, err := transports.Dial(ctx, "tcp", edge)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			header := testHeader{}
+			if len(test.forged) > 0 {
+				header["forwarded"] = test.forged
+			}
+			response, doErr := client.Do(ctx, &testRequest{endpoint: "foo", function: "client", header: header})
+			if doErr != nil {
+				t.Fatal(doErr)
+			}
+			if test.failed {
+				if b, _ := response.Body(); response.Succeed() || !strings.Contains(string(b), transports.ErrTooManyForwardedHops.Error()) {
+					t.Fatal("expected too many hops", string(b))
+				}
+				return
+			}
+			if !response.Succeed() {
+				b, _ := response.Body()
+				t.Fatal("unexpected failure", string(b))
+			}
+			if client, hops := response.Header().Get("client"), response.Header().Get("hops"); client != test.client || hops != strconv.Itoa(test.hops) {
+				t.Fatal("unexpected client address", client, hops)
+			}
+		})
+	}
+}
+
+type versionHandler struct {
+	version string
+	next    brick.ServeHandler
+}
+
+func (h *versionHandler) Handle(r brick.RequestCtx) {
+	r.Response().Header().Set("x-version", h.version)
+	if h.next != nil {
+		h.next.Handle(r)
+		return
+	}
+	r.Response().Succeed(nil)
+}
+
+func TestProxy_Header(t *testing.T) {
+	transports.RegisterFunction("foo", "version")
+	ctx := context.Background()
+	origin, _ := transports.NewTCPTransport(ctx, *mustConfig(t, "address: 127.0.0.1:0"))
+	if err := origin.Listen(ctx, &versionHandler{version: "origin"}); err != nil {
+		t.Fatal(err)
+	}
+	defer origin.Close()
+	upstream, dialErr := transports.Dial(ctx, "tcp", origin.(*transports.TCPTransport).Addr().String())
+	if dialErr != nil {
+		t.Fatal(dialErr)
+	}
+	defer upstream.Close()
+	proxy, proxyErr := transports.NewProxy("edge", upstream)
+	if proxyErr != nil {
+		t.Fatal(proxyErr)
+	}
+	// the header which is set before relaying is replaced by the upstream one.
+	edge, _ := transports.NewTCPTransport(ctx, *mustConfig(t, "address: 127.0.0.1:0"))
+	if err := edge.Listen(ctx, &versionHandler{version: "edge", next: proxy}); err != nil {
+		t.Fatal(err)
+	}
+	defer edge.Close()
+
+	client, clientErr := transports.Dial(ctx, "tcp", edge.(*transports.TCPTransport).Addr().String())
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	defer client.Close()
+	response, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "version"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values := response.Header().Values("x-version"); len(values) != 1 || values[0] != "origin" {
+		t.Fatal("unexpected header", values)
+	}
+}
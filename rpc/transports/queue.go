@@ -0,0 +1,87 @@
+package transports
+
+import (
+	"context"
+	"sync"
+)
+
+// queue
+// an unbounded queue of stream messages, the reading loop of connection must never be blocked by a slow stream.
+type queue[T any] struct {
+	locker sync.Mutex
+	items  []T
+	notify chan struct{}
+	ended  bool
+}
+
+func newQueue[T any]() *queue[T] {
+	return &queue[T]{
+		notify: make(chan struct{}, 1),
+	}
+}
+
+func (q *queue[T]) push(v T) (ok bool) {
+	q.locker.Lock()
+	if q.ended {
+		q.locker.Unlock()
+		return
+	}
+	q.items = append(q.items, v)
+	q.locker.Unlock()
+	q.wakeup()
+	ok = true
+	return
+}
+
+func (q *queue[T]) end() {
+	q.locker.Lock()
+	q.ended = true
+	q.locker.Unlock()
+	q.wakeup()
+}
+
+func (q *queue[T]) wakeup() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pop
+// wait for the next item, ok is false when the queue is ended and drained or the ctx is done.
+func (q *queue[T]) pop(ctx context.Context) (v T, ok bool) {
+	for {
+		q.locker.Lock()
+		if len(q.items) > 0 {
+			v = q.items[0]
+			var zero T
+			q.items[0] = zero
+			q.items = q.items[1:]
+			q.locker.Unlock()
+			ok = true
+			return
+		}
+		ended := q.ended
+		q.locker.Unlock()
+		if ended {
+			return
+		}
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// drain
+// end the queue and return the remaining items.
+func (q *queue[T]) drain() (items []T) {
+	q.locker.Lock()
+	q.ended = true
+	items = q.items
+	q.items = nil
+	q.locker.Unlock()
+	q.wakeup()
+	return
+}
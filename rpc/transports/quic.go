@@ -0,0 +1,989 @@
+package transports
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brickingsoft/brick/rpc/configs"
+	"github.com/brickingsoft/brick/rpc/transports/bpack"
+	"github.com/brickingsoft/brick/transports"
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	QUICTransportName = "quic"
+	// QUICProtocol
+	// the alpn of brick over quic.
+	QUICProtocol = "brick"
+)
+
+const (
+	quicNoError quic.ApplicationErrorCode = 0
+	// quicGoAway
+	// the connection is closed by a draining server before any stream of it is handled.
+	quicGoAway         quic.ApplicationErrorCode = 1
+	quicStreamCanceled quic.StreamErrorCode      = 1
+	// quicStreamGoAway
+	// the stream is reset by a draining server without being handled.
+	quicStreamGoAway quic.StreamErrorCode = 2
+)
+
+type QUICConfig struct {
+	Address            string         `json:"address" yaml:"address"`
+	MaxHeaderSize      int            `json:"maxHeaderSize" yaml:"maxHeaderSize"`
+	Headers            []HeaderValues `json:"headers" yaml:"headers"`
+	TLS                TLSConfig      `json:"tls" yaml:"tls"`
+	MaxIdleTimeout     time.Duration  `json:"maxIdleTimeout" yaml:"maxIdleTimeout"`
+	KeepAlivePeriod    time.Duration  `json:"keepAlivePeriod" yaml:"keepAlivePeriod"`
+	MaxIncomingStreams int64          `json:"maxIncomingStreams" yaml:"maxIncomingStreams"`
+	// Allow0RTT
+	// the calls of idempotent functions are handled as 0-RTT data, others wait for the handshake, see RegisterIdempotentFunction.
+	Allow0RTT   bool              `json:"allow0RTT" yaml:"allow0RTT"`
+	Compression CompressionConfig `json:"compression" yaml:"compression"`
+	// Limits
+	// the body limits, the default max body size is 4MB.
+	Limits LimitsConfig `json:"limits" yaml:"limits"`
+	// Forwarded
+	// the trusted proxies and the max hops of forwarded chains.
+	Forwarded ForwardedConfig `json:"forwarded" yaml:"forwarded"`
+}
+
+func (config *QUICConfig) options() []Option {
+	options := []Option{
+		WithMaxHeaderSize(config.MaxHeaderSize),
+		WithHeaderFields(config.Headers...),
+		WithCompression(config.Compression.Threshold, config.Compression.Encodings...),
+	}
+	options = append(options, config.Limits.options()...)
+	return append(options, config.Forwarded.options()...)
+}
+
+func (config *QUICConfig) quic() *quic.Config {
+	return &quic.Config{
+		MaxIdleTimeout:     config.MaxIdleTimeout,
+		KeepAlivePeriod:    config.KeepAlivePeriod,
+		MaxIncomingStreams: config.MaxIncomingStreams,
+		Allow0RTT:          config.Allow0RTT,
+	}
+}
+
+// NewQUICTransport
+// a transports.Builder of quic, the config is the node of quic transport.
+func NewQUICTransport(ctx context.Context, config configs.Config) (transport transports.Transport, err error) {
+	qc := QUICConfig{}
+	if err = config.As(&qc); err != nil {
+		err = errors.Join(errors.New("new quic transport failed"), err)
+		return
+	}
+	qc.Address = strings.TrimSpace(qc.Address)
+	if qc.Address == "" {
+		err = errors.Join(errors.New("new quic transport failed"), errors.New("address is missing"))
+		return
+	}
+	watcher, watchErr := qc.TLS.Watch(ctx)
+	if watchErr != nil {
+		err = errors.Join(errors.New("new quic transport failed"), watchErr)
+		return
+	}
+	clientTLS := watcher.Client(QUICProtocol)
+	// sessions are cached for 0-RTT resumption.
+	clientTLS.ClientSessionCache = tls.NewLRUClientSessionCache(0)
+	transport = &QUICTransport{
+		config:    qc,
+		tls:       watcher,
+		clientTLS: clientTLS,
+		tokens:    quic.NewLRUTokenStore(16, 4),
+	}
+	return
+}
+
+// QUICTransport
+// each rpc is an own bidirectional quic stream, so a slow call does not block others.
+type QUICTransport struct {
+	config    QUICConfig
+	tls       *TLSWatcher
+	clientTLS *tls.Config
+	tokens    quic.TokenStore
+	locker    sync.Mutex
+	server    *Server
+	addr      net.Addr
+}
+
+func (tr *QUICTransport) Name() string {
+	return QUICTransportName
+}
+
+// Addr
+// the listened address, it is nil before Listen.
+func (tr *QUICTransport) Addr() net.Addr {
+	tr.locker.Lock()
+	defer tr.locker.Unlock()
+	return tr.addr
+}
+
+// Listen
+// listen the configured address and serve in background.
+func (tr *QUICTransport) Listen(ctx context.Context, handler transports.ServeHandler) (err error) {
+	tr.locker.Lock()
+	defer tr.locker.Unlock()
+	if tr.server != nil {
+		err = errors.Join(errors.New("quic transport listen failed"), errors.New("already listened"))
+		return
+	}
+	serverTLS, serverTLSErr := tr.tls.Server(QUICProtocol)
+	if serverTLSErr != nil {
+		err = errors.Join(errors.New("quic transport listen failed"), serverTLSErr)
+		return
+	}
+	srv, srvErr := NewServer(ctx, handler, tr.config.options()...)
+	if srvErr != nil {
+		err = errors.Join(errors.New("quic transport listen failed"), srvErr)
+		return
+	}
+	ln, lnErr := quic.ListenAddrEarly(tr.config.Address, serverTLS, tr.config.quic())
+	if lnErr != nil {
+		err = errors.Join(errors.New("quic transport listen failed"), lnErr)
+		return
+	}
+	tr.server = srv
+	tr.addr = ln.Addr()
+	go func(srv *Server, ln *quic.EarlyListener) {
+		_ = srv.ServeQUIC(ln)
+	}(srv, ln)
+	return
+}
+
+func (tr *QUICTransport) Connect(ctx context.Context, address string) (client transports.Client, err error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		err = errors.Join(errors.New("quic transport connect failed"), errors.New("address is missing"))
+		return
+	}
+	config := tr.config.quic()
+	config.TokenStore = tr.tokens
+	c, dialErr := DialQUIC(ctx, address, tr.clientTLS, config, tr.config.options()...)
+	if dialErr != nil {
+		err = errors.Join(errors.New("quic transport connect failed"), dialErr)
+		return
+	}
+	client = c
+	return
+}
+
+// Shutdown
+// stop listening, and close the server after the handling requests are done or ctx is done.
+func (tr *QUICTransport) Shutdown(ctx context.Context) (err error) {
+	tr.locker.Lock()
+	srv := tr.server
+	tr.server = nil
+	tr.locker.Unlock()
+	_ = tr.tls.Close()
+	if srv == nil {
+		return
+	}
+	err = srv.Shutdown(ctx)
+	return
+}
+
+func (tr *QUICTransport) Close() (err error) {
+	tr.locker.Lock()
+	srv := tr.server
+	tr.server = nil
+	tr.locker.Unlock()
+	_ = tr.tls.Close()
+	if srv == nil {
+		return
+	}
+	err = srv.Close()
+	return
+}
+
+// ServeQUIC
+// accept connections of ln until the server is closed, every stream of a connection is served like a connection.
+func (srv *Server) ServeQUIC(ln *quic.EarlyListener) (err error) {
+	srv.locker.Lock()
+	if srv.closed {
+		srv.locker.Unlock()
+		_ = ln.Close()
+		err = ErrServerClosed
+		return
+	}
+	srv.listeners[ln] = struct{}{}
+	srv.locker.Unlock()
+
+	for {
+		conn, acceptErr := ln.Accept(srv.ctx)
+		if acceptErr != nil {
+			srv.locker.Lock()
+			closed := srv.closed || srv.draining
+			delete(srv.listeners, ln)
+			srv.locker.Unlock()
+			if !closed {
+				err = acceptErr
+			}
+			return
+		}
+		srv.wg.Add(1)
+		go func(srv *Server, conn *quic.Conn) {
+			srv.serveQUICConn(conn)
+			srv.wg.Done()
+		}(srv, conn)
+	}
+}
+
+// serveQUICConn
+// the server opens a control stream of the connection, the handshake and the goaway are sent by it.
+func (srv *Server) serveQUICConn(conn *quic.Conn) {
+	control, controlErr := conn.OpenUniStream()
+	if controlErr != nil {
+		_ = conn.CloseWithError(quicNoError, "")
+		return
+	}
+	qc := &quicServerConn{
+		conn:    conn,
+		control: control,
+		// the dictionaries are not exchanged, because streams are not ordered with the control stream.
+		dict: newDictionaries(srv.packer, srv.prefixes.get(0).packer, Options{DisableDictionaryExchange: true}),
+	}
+	n := srv.packer.Len()
+	if writeHandshake(control, n, srv.packer.Fingerprint(n), 0) != nil {
+		_ = conn.CloseWithError(quicNoError, "")
+		return
+	}
+	srv.locker.Lock()
+	if srv.closed || srv.draining {
+		srv.locker.Unlock()
+		// no stream of it is handled, so the client sends them on another connection.
+		_ = conn.CloseWithError(quicGoAway, "")
+		return
+	}
+	srv.quicConns[qc] = struct{}{}
+	srv.locker.Unlock()
+	defer func() {
+		srv.locker.Lock()
+		delete(srv.quicConns, qc)
+		srv.locker.Unlock()
+		srv.notifyIdle()
+	}()
+
+	streams := sync.WaitGroup{}
+	streams.Add(1)
+	go func() {
+		qc.handshake(srv.ctx)
+		streams.Done()
+	}()
+	for {
+		stream, acceptErr := conn.AcceptStream(srv.ctx)
+		if acceptErr != nil {
+			break
+		}
+		if !qc.accept(stream.StreamID()) {
+			stream.CancelRead(quicStreamGoAway)
+			stream.CancelWrite(quicStreamGoAway)
+			continue
+		}
+		streams.Add(1)
+		go func(stream *quic.Stream) {
+			defer streams.Done()
+			// the stream context is canceled when the peer cancels the stream.
+			state := conn.ConnectionState().TLS
+			ctx, cancel := context.WithCancel(withPeerIdentity(stream.Context(), &state))
+			stop := context.AfterFunc(srv.ctx, cancel)
+			srv.serveConn(ctx, &quicStreamConn{Stream: stream, conn: conn}, true)
+			stop()
+			cancel()
+		}(stream)
+	}
+	_ = conn.CloseWithError(quicNoError, "")
+	streams.Wait()
+}
+
+// quicServerConn
+// a quic connection of the server, streams are accepted in the order of their ids.
+type quicServerConn struct {
+	conn     *quic.Conn
+	control  *quic.SendStream
+	dict     *dictionaries
+	wl       sync.Mutex
+	locker   sync.Mutex
+	lastID   quic.StreamID
+	draining bool
+}
+
+// handshake
+// read the handshake of the client by its control stream,
+// and answer it when the dictionary of the client is shorter.
+func (qc *quicServerConn) handshake(ctx context.Context) {
+	stream, acceptErr := qc.conn.AcceptUniStream(ctx)
+	if acceptErr != nil {
+		return
+	}
+	r := bufio.NewReader(stream)
+	if typ, _, err := readFrameHead(r); err != nil || typ != HandshakeFrame {
+		return
+	}
+	_, _, reply, err := qc.dict.handshake(r)
+	if err != nil {
+		return
+	}
+	qc.wl.Lock()
+	_ = qc.dict.answer(qc.control, reply)
+	qc.wl.Unlock()
+}
+
+// accept
+// a stream after the goaway is not accepted, it is reset by quicStreamGoAway.
+func (qc *quicServerConn) accept(id quic.StreamID) (ok bool) {
+	qc.locker.Lock()
+	if ok = !qc.draining; ok {
+		qc.lastID = id
+	}
+	qc.locker.Unlock()
+	return
+}
+
+// goAway
+// tell the client the last stream id which will be handled by the control stream.
+// the client sends new calls on another connection, and closes this one after its calls are done.
+func (qc *quicServerConn) goAway() {
+	qc.locker.Lock()
+	if qc.draining {
+		qc.locker.Unlock()
+		return
+	}
+	qc.draining = true
+	last := qc.lastID
+	qc.locker.Unlock()
+	qc.wl.Lock()
+	_ = writeControl(qc.control, GoAwayFrame, uint64(last))
+	qc.wl.Unlock()
+}
+
+// quicStreamConn
+// a net.Conn of quic stream, Close only closes the write side.
+type quicStreamConn struct {
+	*quic.Stream
+	conn *quic.Conn
+}
+
+func (c *quicStreamConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *quicStreamConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// HandshakeComplete
+// the requests of 0-RTT data are read before the handshake is completed.
+func (c *quicStreamConn) HandshakeComplete() <-chan struct{} {
+	return c.conn.HandshakeComplete()
+}
+
+// QUICClient
+// each call opens a stream of the connection, the calls are canceled by canceling the streams.
+// when the server goes away, the connection is dialed again, and the calls which were not handled are sent again.
+type QUICClient struct {
+	addr        *net.UDPAddr
+	tlsConfig   *tls.Config
+	config      *quic.Config
+	packer      *bpack.Packer
+	literal     *dictionaryPrefix
+	compress    *compression
+	maxBodySize int64
+	locker      sync.Mutex
+	session     *quicSession
+	closed      atomic.Bool
+}
+
+// quicSession
+// a connection of the client, it is closed when it went away and its calls are done.
+// inflight and away are guarded by the locker of the client.
+type quicSession struct {
+	conn       *quic.Conn
+	transports []*quic.Transport
+	// dict and prefix
+	// the dictionaries are settled by the control streams, and each stream declares the prefix which it uses.
+	dict     *dictionaries
+	prefix   atomic.Pointer[dictionaryPrefix]
+	inflight int
+	away     bool
+	once     sync.Once
+}
+
+func (session *quicSession) close() (err error) {
+	session.once.Do(func() {
+		err = session.conn.CloseWithError(quicNoError, "")
+		for _, tr := range session.transports {
+			_ = tr.Close()
+		}
+	})
+	return
+}
+
+// DialQUIC
+// dial the address in early mode, requests are sent as 0-RTT data when tlsConfig has a session of the address,
+// 0-RTT data can be replayed, so only the calls of functions of RegisterIdempotentFunction are sent before the handshake is completed.
+func DialQUIC(ctx context.Context, address string, tlsConfig *tls.Config, config *quic.Config, options ...Option) (client *QUICClient, err error) {
+	if ctx == nil {
+		err = errors.Join(errors.New("dial quic failed"), errors.New("context is missing"))
+		return
+	}
+	opts, optsErr := newOptions(options...)
+	if optsErr != nil {
+		err = errors.Join(errors.New("dial quic failed"), optsErr)
+		return
+	}
+	packer, packerErr := newHeaderPacker(opts.MaxHeaderSize, opts.HeaderFields)
+	if packerErr != nil {
+		err = errors.Join(errors.New("dial quic failed"), packerErr)
+		return
+	}
+	addr, addrErr := net.ResolveUDPAddr("udp", address)
+	if addrErr != nil {
+		err = errors.Join(errors.New("dial quic failed"), addrErr)
+		return
+	}
+	tlsConfig, tlsErr := clientTLSOf(tlsConfig, address)
+	if tlsErr != nil {
+		err = errors.Join(errors.New("dial quic failed"), tlsErr)
+		return
+	}
+	c := &QUICClient{
+		addr:        addr,
+		tlsConfig:   tlsConfig,
+		config:      config,
+		packer:      packer,
+		literal:     newDictionaryPrefix(packer.Prefix(0)),
+		compress:    newCompression(opts),
+		maxBodySize: opts.MaxBodySize,
+	}
+	session, dialErr := c.dial(ctx)
+	if dialErr != nil {
+		err = errors.Join(errors.New("dial quic failed"), dialErr)
+		return
+	}
+	c.session = session
+	client = c
+	return
+}
+
+// dial
+// dial a session in early mode, and read its control stream in background.
+func (client *QUICClient) dial(ctx context.Context) (session *quicSession, err error) {
+	tr, trErr := newQUICTransport()
+	if trErr != nil {
+		err = trErr
+		return
+	}
+	conn, dialErr := tr.DialEarly(ctx, client.addr, client.tlsConfig, client.config)
+	if dialErr != nil {
+		_ = tr.Close()
+		err = dialErr
+		return
+	}
+	session = &quicSession{
+		conn:       conn,
+		transports: []*quic.Transport{tr},
+		// the dictionaries are not exchanged, because streams are not ordered with the control stream.
+		dict: newDictionaries(client.packer, client.literal.packer, Options{DisableDictionaryExchange: true}),
+	}
+	session.prefix.Store(client.literal)
+	go client.control(session)
+	return
+}
+
+// control
+// send the handshake by a control stream, and read the control stream of the server until the session is closed.
+func (client *QUICClient) control(session *quicSession) {
+	ctx := session.conn.Context()
+	// the streams of a rejected 0-RTT are reset, so the control streams are opened after the handshake of quic.
+	if _, err := session.conn.NextConnection(ctx); err != nil {
+		return
+	}
+	local, openErr := session.conn.OpenUniStream()
+	if openErr != nil {
+		return
+	}
+	if writeHandshake(local, client.packer.Len(), client.packer.Fingerprint(client.packer.Len()), 0) != nil {
+		return
+	}
+	stream, acceptErr := session.conn.AcceptUniStream(ctx)
+	if acceptErr != nil {
+		return
+	}
+	r := bufio.NewReader(stream)
+	for {
+		typ, _, err := readFrameHead(r)
+		if err != nil {
+			return
+		}
+		switch typ {
+		case HandshakeFrame:
+			// the server packs the responses of a stream by its declaration, so the handshake is not answered.
+			encoder, _, _, handshakeErr := session.dict.handshake(r)
+			if handshakeErr != nil {
+				return
+			}
+			if encoder != nil {
+				session.prefix.Store(newDictionaryPrefix(encoder))
+			}
+			break
+		case GoAwayFrame:
+			client.goAway(session)
+			break
+		default:
+			return
+		}
+	}
+}
+
+// goAway
+// new calls are sent by a new session, and the session is closed after its calls are done.
+func (client *QUICClient) goAway(session *quicSession) {
+	client.locker.Lock()
+	if session.away {
+		client.locker.Unlock()
+		return
+	}
+	session.away = true
+	closing := session.inflight == 0
+	client.locker.Unlock()
+	if closing {
+		_ = session.close()
+	}
+}
+
+// acquire
+// the session of a call, the caller must release it when the call is done.
+func (client *QUICClient) acquire(ctx context.Context) (session *quicSession, err error) {
+	client.locker.Lock()
+	defer client.locker.Unlock()
+	if client.closed.Load() {
+		err = ErrClientClosed
+		return
+	}
+	if client.session.away {
+		next, dialErr := client.dial(ctx)
+		if dialErr != nil {
+			err = dialErr
+			return
+		}
+		client.session = next
+	}
+	session = client.session
+	session.inflight++
+	return
+}
+
+func (client *QUICClient) release(session *quicSession) {
+	client.locker.Lock()
+	session.inflight--
+	closing := session.away && session.inflight == 0
+	client.locker.Unlock()
+	if closing {
+		_ = session.close()
+	}
+}
+
+func newQUICTransport() (tr *quic.Transport, err error) {
+	udp, udpErr := net.ListenUDP("udp", nil)
+	if udpErr != nil {
+		err = udpErr
+		return
+	}
+	tr = &quic.Transport{Conn: udp}
+	return
+}
+
+// Migrate
+// move the connection to a new local udp socket, e.g. the network of the host is changed.
+func (client *QUICClient) Migrate(ctx context.Context) (err error) {
+	session, acquireErr := client.acquire(ctx)
+	if acquireErr != nil {
+		err = errors.Join(errors.New("migrate failed"), acquireErr)
+		return
+	}
+	defer client.release(session)
+	tr, trErr := newQUICTransport()
+	if trErr != nil {
+		err = errors.Join(errors.New("migrate failed"), trErr)
+		return
+	}
+	path, pathErr := session.conn.AddPath(tr)
+	if pathErr != nil {
+		_ = tr.Close()
+		err = errors.Join(errors.New("migrate failed"), pathErr)
+		return
+	}
+	if err = path.Probe(ctx); err == nil {
+		err = path.Switch()
+	}
+	if err != nil {
+		_ = path.Close()
+		_ = tr.Close()
+		err = errors.Join(errors.New("migrate failed"), err)
+		return
+	}
+	// the previous transports are kept, they are closed with the session.
+	client.locker.Lock()
+	session.transports = append(session.transports, tr)
+	client.locker.Unlock()
+	return
+}
+
+func (client *QUICClient) LocalAddr() net.Addr {
+	client.locker.Lock()
+	defer client.locker.Unlock()
+	return client.session.conn.LocalAddr()
+}
+
+// ConnectionState
+// the state of the current connection.
+func (client *QUICClient) ConnectionState() quic.ConnectionState {
+	client.locker.Lock()
+	defer client.locker.Unlock()
+	return client.session.conn.ConnectionState()
+}
+
+// open
+// open a stream of the call of the function, the stream of a function which is not idempotent is opened after the handshake.
+func (client *QUICClient) open(ctx context.Context, session *quicSession, function uint64) (stream *quic.Stream, err error) {
+	if !IsIdempotentFunction(function) {
+		select {
+		case <-session.conn.HandshakeComplete():
+			break
+		case <-session.conn.Context().Done():
+			// the stream is failed to open by the error of the connection.
+			break
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		}
+	}
+	if stream, err = session.conn.OpenStreamSync(ctx); err != nil {
+		if client.closed.Load() {
+			err = ErrClientClosed
+			return
+		}
+		err = session.failed(ctx, err)
+	}
+	return
+}
+
+// failed
+// the error of a call of the session, the streams of a rejected 0-RTT are opened again after the handshake is completed.
+func (session *quicSession) failed(ctx context.Context, err error) error {
+	if errors.Is(err, quic.Err0RTTRejected) {
+		if _, nextErr := session.conn.NextConnection(ctx); nextErr != nil {
+			return nextErr
+		}
+		return err
+	}
+	return quicError(err)
+}
+
+// retryable
+// the call was not handled by the server, because the server went away or rejected the 0-RTT data.
+func retryable(err error) bool {
+	return errors.Is(err, ErrGoAway) || errors.Is(err, quic.Err0RTTRejected)
+}
+
+// quicError
+// the connection and the streams which are closed by a draining server are ErrGoAway, they were not handled.
+func quicError(err error) error {
+	var appErr *quic.ApplicationError
+	if errors.As(err, &appErr) && appErr.Remote && appErr.ErrorCode == quicGoAway {
+		return ErrGoAway
+	}
+	var streamErr *quic.StreamError
+	if errors.As(err, &streamErr) && streamErr.Remote && streamErr.ErrorCode == quicStreamGoAway {
+		return ErrGoAway
+	}
+	return err
+}
+
+func (client *QUICClient) Do(ctx context.Context, request transports.Request) (response transports.Response, err error) {
+	if ctx == nil {
+		err = errors.New("context is missing")
+		return
+	}
+	req, reqErr := newRequest(request)
+	if reqErr != nil {
+		err = reqErr
+		return
+	}
+	defer ReleaseRequest(req)
+
+	client.compress.encodeRequest(req)
+	for retried := false; ; retried = true {
+		response, err = client.do(ctx, req)
+		// the request was not handled, so it is safe to send it again.
+		if retried || !retryable(err) {
+			return
+		}
+	}
+}
+
+func (client *QUICClient) do(ctx context.Context, req *Request) (response transports.Response, err error) {
+	session, acquireErr := client.acquire(ctx)
+	if acquireErr != nil {
+		err = acquireErr
+		return
+	}
+	defer client.release(session)
+	stream, openErr := client.open(ctx, session, req.function)
+	if openErr != nil {
+		err = openErr
+		return
+	}
+	stop := context.AfterFunc(ctx, func() {
+		stream.CancelWrite(quicStreamCanceled)
+		stream.CancelRead(quicStreamCanceled)
+	})
+	defer stop()
+
+	// each stream is served like a connection, so the dictionary and the function are declared on every stream.
+	prefix := session.prefix.Load()
+	if err = prefix.declare(stream); err == nil {
+		err = writeFunction(stream, req.function)
+	}
+	if err == nil {
+		err = writeRequest(stream, prefix.packer, nil, RequestFrame, 0, req)
+	}
+	if err == nil {
+		err = stream.Close()
+	}
+	if err == nil {
+		var resp *Response
+		if resp, err = readQUICResponse(bufio.NewReader(stream), client.packer, client.compress, client.maxBodySize); err == nil {
+			response = &clientResponse{response: resp}
+		}
+	}
+	if err != nil {
+		cancelQUICStream(stream, err)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		} else {
+			err = session.failed(ctx, err)
+		}
+	}
+	return
+}
+
+// cancelQUICStream
+// the streams of a rejected 0-RTT are reset already, and their ids are used again after the handshake, so they are not canceled.
+func cancelQUICStream(stream *quic.Stream, err error) {
+	if errors.Is(err, quic.Err0RTTRejected) {
+		return
+	}
+	stream.CancelRead(quicStreamCanceled)
+	stream.CancelWrite(quicStreamCanceled)
+}
+
+// Stream
+// open a quic stream by the request, the server side must hijack it, otherwise the stream is closed after the first response.
+func (client *QUICClient) Stream(ctx context.Context, request transports.Request) (stream transports.ClientStream, err error) {
+	if ctx == nil {
+		err = errors.New("context is missing")
+		return
+	}
+	req, reqErr := newRequest(request)
+	if reqErr != nil {
+		err = reqErr
+		return
+	}
+	defer ReleaseRequest(req)
+
+	client.compress.encodeRequest(req)
+	var (
+		session *quicSession
+		prefix  *dictionaryPrefix
+		qs      *quic.Stream
+	)
+	for retried := false; ; retried = true {
+		if session, prefix, qs, err = client.stream(ctx, req); err == nil || retried || !retryable(err) {
+			break
+		}
+	}
+	if err != nil {
+		return
+	}
+	s := &quicClientStream{
+		ctx:     ctx,
+		client:  client,
+		session: session,
+		prefix:  prefix,
+		stream:  qs,
+		reader:  bufio.NewReader(qs),
+	}
+	s.stop = context.AfterFunc(ctx, s.cancel)
+	stream = s
+	return
+}
+
+// stream
+// open a stream and send the first request, the session is released when the stream is failed.
+// the following requests of the stream are packed by the declared prefix.
+func (client *QUICClient) stream(ctx context.Context, req *Request) (session *quicSession, prefix *dictionaryPrefix, qs *quic.Stream, err error) {
+	if session, err = client.acquire(ctx); err != nil {
+		return
+	}
+	if qs, err = client.open(ctx, session, req.function); err != nil {
+		client.release(session)
+		return
+	}
+	prefix = session.prefix.Load()
+	if err = prefix.declare(qs); err == nil {
+		err = writeFunction(qs, req.function)
+	}
+	if err == nil {
+		err = writeRequest(qs, prefix.packer, nil, StreamFrame, 0, req)
+	}
+	if err != nil {
+		cancelQUICStream(qs, err)
+		err = session.failed(ctx, err)
+		client.release(session)
+	}
+	return
+}
+
+// Close
+// close the current session, the sessions which went away are closed after their calls are done.
+func (client *QUICClient) Close() (err error) {
+	if !client.closed.CompareAndSwap(false, true) {
+		return
+	}
+	client.locker.Lock()
+	session := client.session
+	session.away = true
+	client.locker.Unlock()
+	err = session.close()
+	return
+}
+
+// readQUICResponse
+// read the response frame of a quic stream, io.EOF is returned when the stream is closed without responses.
+func readQUICResponse(r frameReader, packer *bpack.Packer, compress *compression, maxBodySize int64) (response *Response, err error) {
+	typ, _, headErr := readFrameHead(r)
+	if headErr != nil {
+		err = headErr
+		return
+	}
+	switch typ {
+	case ResponseFrame:
+		response = AcquireResponse()
+		if err = parseResponse(r, packer, nil, response, maxBodySize); err != nil {
+			if !errors.Is(err, ErrBodyTooLarge) {
+				ReleaseResponse(response)
+				response = nil
+				break
+			}
+			failResponse(response, err)
+			err = nil
+		} else if decompressErr := decompressBody(response.body, response.header, maxBodySize); decompressErr != nil {
+			failResponse(response, decompressErr)
+		}
+		compress.learn(response.header)
+		break
+	case CloseFrame:
+		err = io.EOF
+		break
+	default:
+		err = ErrInvalidFrame
+		break
+	}
+	return
+}
+
+// quicClientStream
+// the stream takes the session until it is closed or ended.
+type quicClientStream struct {
+	ctx      context.Context
+	client   *QUICClient
+	session  *quicSession
+	prefix   *dictionaryPrefix
+	stream   *quic.Stream
+	reader   *bufio.Reader
+	wl       sync.Mutex
+	stop     func() bool
+	closed   atomic.Bool
+	released atomic.Bool
+}
+
+func (s *quicClientStream) Send(request transports.Request) (err error) {
+	if s.closed.Load() {
+		err = io.ErrClosedPipe
+		return
+	}
+	req, reqErr := newRequest(request)
+	if reqErr != nil {
+		err = reqErr
+		return
+	}
+	s.client.compress.encodeRequest(req)
+	s.wl.Lock()
+	if err = writeRequest(s.stream, s.prefix.packer, nil, StreamFrame, 0, req); err != nil {
+		err = quicError(err)
+	}
+	s.wl.Unlock()
+	ReleaseRequest(req)
+	return
+}
+
+// Receive
+// io.EOF is returned when the stream was closed by the server side.
+func (s *quicClientStream) Receive() (response transports.Response, err error) {
+	resp, readErr := readQUICResponse(s.reader, s.client.packer, s.client.compress, s.client.maxBodySize)
+	if readErr != nil {
+		s.release()
+		if ctxErr := s.ctx.Err(); ctxErr != nil {
+			err = ctxErr
+			return
+		}
+		if errors.Is(readErr, io.EOF) {
+			err = io.EOF
+			return
+		}
+		err = quicError(readErr)
+		return
+	}
+	response = &clientResponse{response: resp}
+	return
+}
+
+func (s *quicClientStream) cancel() {
+	s.stream.CancelWrite(quicStreamCanceled)
+	s.stream.CancelRead(quicStreamCanceled)
+}
+
+// Close
+// send the close frame and close the write side, the responses are no longer read.
+func (s *quicClientStream) Close() (err error) {
+	if !s.closed.CompareAndSwap(false, true) {
+		return
+	}
+	s.stop()
+	s.wl.Lock()
+	if err = writeControl(s.stream, CloseFrame, 0); err == nil {
+		err = s.stream.Close()
+	}
+	s.wl.Unlock()
+	s.stream.CancelRead(quicStreamCanceled)
+	s.release()
+	return
+}
+
+func (s *quicClientStream) release() {
+	if s.released.CompareAndSwap(false, true) {
+		s.client.release(s.session)
+	}
+}
@@ -0,0 +1,304 @@
+package transports_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/brickingsoft/brick/rpc/configs"
+	"github.com/brickingsoft/brick/rpc/transports"
+	brick "github.com/brickingsoft/brick/transports"
+)
+
+func writeTestCertificate(t *testing.T) (certFile string, keyFile string) {
+	t.Helper()
+	key, keyErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if keyErr != nil {
+		t.Fatal(keyErr)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "brick"},
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, derErr := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if derErr != nil {
+		t.Fatal(derErr)
+	}
+	keyDER, keyDERErr := x509.MarshalECPrivateKey(key)
+	if keyDERErr != nil {
+		t.Fatal(keyDERErr)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func newQUICTransport(t *testing.T) *transports.QUICTransport {
+	t.Helper()
+	certFile, keyFile := writeTestCertificate(t)
+	return listenQUIC(t, "127.0.0.1:0", certFile, keyFile, &echoHandler{})
+}
+
+func listenQUIC(t *testing.T, address string, certFile string, keyFile string, handler brick.ServeHandler) *transports.QUICTransport {
+	t.Helper()
+	tr, err := tryListenQUIC(address, certFile, keyFile, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tr
+}
+
+func tryListenQUIC(address string, certFile string, keyFile string, handler brick.ServeHandler) (*transports.QUICTransport, error) {
+	tr, trErr := newQUICTransportOf(address, certFile, keyFile, "")
+	if trErr != nil {
+		return nil, trErr
+	}
+	if err := tr.Listen(context.Background(), handler); err != nil {
+		return nil, err
+	}
+	return tr, nil
+}
+
+// newQUICTransportOf
+// extra is appended to the config.
+func newQUICTransportOf(address string, certFile string, keyFile string, extra string) (*transports.QUICTransport, error) {
+	config, configErr := configs.NewConfig([]byte(fmt.Sprintf(`
+address: %s
+allow0RTT: true
+tls:
+  cert: %s
+  key: %s
+  ca: %s
+  serverName: localhost
+`, address, certFile, keyFile, certFile) + extra))
+	if configErr != nil {
+		return nil, configErr
+	}
+	tr, trErr := transports.NewQUICTransport(context.Background(), *config)
+	if trErr != nil {
+		return nil, trErr
+	}
+	return tr.(*transports.QUICTransport), nil
+}
+
+func TestQUICTransport(t *testing.T) {
+	// the calls of it are sent as 0-RTT data by a resumed session.
+	transports.RegisterIdempotentFunction("foo", "bar")
+	tr := newQUICTransport(t)
+	defer tr.Close()
+
+	ctx := context.Background()
+	client, clientErr := tr.Connect(ctx, tr.Addr().String())
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	defer client.Close()
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := []byte{'b', 'o', 'd', 'y', '0' + byte(i)}
+			resp, doErr := client.Do(ctx, &testRequest{endpoint: "foo", function: "bar", body: body})
+			if doErr != nil {
+				t.Error(doErr)
+				return
+			}
+			if echo := resp.Header().Get("echo"); echo != string(body) {
+				t.Error("unexpected echo", echo)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if err := client.(*transports.QUICClient).Migrate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	resp, doErr := client.Do(ctx, &testRequest{endpoint: "foo", function: "fail"})
+	if doErr != nil {
+		t.Fatal(doErr)
+	}
+	if err := resp.ParseBody(nil); err == nil || err.Error() != "boom" {
+		t.Fatal("unexpected failure", err)
+	}
+	t.Log("migrated to", client.(*transports.QUICClient).LocalAddr())
+
+	// resume the session by 0-RTT
+	resumed, resumedErr := tr.Connect(ctx, tr.Addr().String())
+	if resumedErr != nil {
+		t.Fatal(resumedErr)
+	}
+	defer resumed.Close()
+	if resp, doErr = resumed.Do(ctx, &testRequest{endpoint: "foo", function: "bar", body: []byte("0rtt")}); doErr != nil {
+		t.Fatal(doErr)
+	}
+	if echo := resp.Header().Get("echo"); echo != "0rtt" {
+		t.Fatal("unexpected echo", echo)
+	}
+	if !resumed.(*transports.QUICClient).ConnectionState().Used0RTT {
+		t.Fatal("expected the session to be resumed by 0-RTT")
+	}
+	// a call which is not idempotent is sent after the handshake.
+	if resp, doErr = resumed.Do(ctx, &testRequest{endpoint: "foo", function: "fail"}); doErr != nil {
+		t.Fatal(doErr)
+	}
+	if err := resp.ParseBody(nil); err == nil || err.Error() != "boom" {
+		t.Fatal("unexpected failure", err)
+	}
+}
+
+type blockHandler struct {
+	canceled chan struct{}
+}
+
+func (h *blockHandler) Handle(r brick.RequestCtx) {
+	<-r.Done()
+	close(h.canceled)
+}
+
+func TestQUICTransport_Cancel(t *testing.T) {
+	transports.RegisterFunction("foo", "block")
+	certFile, keyFile := writeTestCertificate(t)
+	config, _ := configs.NewConfig([]byte(fmt.Sprintf("address: 127.0.0.1:0\ntls:\n  cert: %s\n  key: %s\n  insecureSkipVerify: true\n", certFile, keyFile)))
+	tr, _ := transports.NewQUICTransport(context.Background(), *config)
+	handler := &blockHandler{canceled: make(chan struct{})}
+	if err := tr.Listen(context.Background(), handler); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	client, clientErr := tr.Connect(context.Background(), tr.(*transports.QUICTransport).Addr().String())
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "block"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("unexpected error", err)
+	}
+	select {
+	case <-handler.canceled:
+	case <-time.After(3 * time.Second):
+		t.Fatal("handler is not canceled")
+	}
+}
+
+func TestQUICTransport_Stream(t *testing.T) {
+	transports.RegisterFunction("foo", "stream")
+	tr := newQUICTransport(t)
+	defer tr.Close()
+
+	ctx := context.Background()
+	client, clientErr := tr.Connect(ctx, tr.Addr().String())
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	defer client.Close()
+
+	stream, streamErr := client.(brick.StreamClient).Stream(ctx, &testRequest{endpoint: "foo", function: "stream"})
+	if streamErr != nil {
+		t.Fatal(streamErr)
+	}
+	for _, s := range []string{"a", "b", "c", "bye"} {
+		if err := stream.Send(&testRequest{endpoint: "foo", function: "stream", body: []byte(s)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n := 0
+	for {
+		resp, err := stream.Receive()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.Fatal(err)
+			}
+			break
+		}
+		t.Log(resp.Header().Get("echo"))
+		n++
+	}
+	if n != 3 {
+		t.Fatal("expected 3 responses, got", n)
+	}
+	_ = stream.Close()
+}
+
+func TestQUICTransport_Shutdown(t *testing.T) {
+	transports.RegisterFunction("foo", "slow")
+	certFile, keyFile := writeTestCertificate(t)
+	tr := listenQUIC(t, "127.0.0.1:0", certFile, keyFile, &slowHandler{})
+	address := tr.Addr().String()
+
+	ctx := context.Background()
+	client, clientErr := tr.Connect(ctx, address)
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	defer client.Close()
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "slow"}); err != nil {
+				t.Error("inflight call should be done", err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	// the client closes the connection after its calls are done, so the shutdown is not timed out.
+	timeout, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	if err := tr.Shutdown(timeout); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	// the client dials again after the server went away,
+	// the socket of the server is released after the closed connection is drained by quic.
+	var restarted *transports.QUICTransport
+	for deadline := time.Now().Add(3 * time.Second); restarted == nil; {
+		var err error
+		if restarted, err = tryListenQUIC(address, certFile, keyFile, &slowHandler{}); err != nil {
+			if time.Now().After(deadline) {
+				t.Fatal(err)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	defer restarted.Close()
+	if _, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "slow"}); err != nil {
+		t.Fatal(err)
+	}
+}
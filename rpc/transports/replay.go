@@ -0,0 +1,128 @@
+package transports
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/brickingsoft/brick/transports"
+)
+
+// ReplayDiff
+// a difference between the captured response and the replayed one.
+// the field is succeed, body, error or the name of a header, and values of a header are joined by ','.
+type ReplayDiff struct {
+	Index    int    `json:"index"`
+	Endpoint string `json:"endpoint"`
+	Function string `json:"function"`
+	Field    string `json:"field"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// Replayer
+// re-send captured requests by the client and diff the responses.
+type Replayer struct {
+	Client transports.Client
+	// IgnoredHeaders
+	// the response headers which are not diffed, masked headers are never diffed.
+	IgnoredHeaders []string
+	// Prepare
+	// change the header of a request before it is sent, e.g. set the authorization which was masked.
+	// masked headers are not sent.
+	Prepare func(record *CaptureRecord, header transports.Header)
+}
+
+// Replay
+// read records of the capture and replay them in order, a failure of sending is a diff of the error field.
+func (r *Replayer) Replay(ctx context.Context, capture io.Reader) (diffs []ReplayDiff, err error) {
+	if r.Client == nil {
+		err = errors.Join(errors.New("replay failed"), errors.New("client is missing"))
+		return
+	}
+	err = ReadCaptureRecords(capture, func(index int, record *CaptureRecord) bool {
+		diffs = append(diffs, r.replay(ctx, index, record)...)
+		return ctx.Err() == nil
+	})
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		err = errors.Join(errors.New("replay failed"), err)
+	}
+	return
+}
+
+func (r *Replayer) replay(ctx context.Context, index int, record *CaptureRecord) (diffs []ReplayDiff) {
+	diff := func(field string, expected string, actual string) {
+		diffs = append(diffs, ReplayDiff{
+			Index:    index,
+			Endpoint: record.Endpoint,
+			Function: record.Function,
+			Field:    field,
+			Expected: expected,
+			Actual:   actual,
+		})
+	}
+
+	header := new(transports.Fields)
+	for _, field := range record.Request.Header {
+		if field.Value == MaskedHeaderValue {
+			continue
+		}
+		header.Add(field.Name, field.Value)
+	}
+	if r.Prepare != nil {
+		r.Prepare(record, header)
+	}
+	response, err := r.Client.Do(ctx, &forwardedRequest{
+		endpoint: record.Endpoint,
+		function: record.Function,
+		header:   header,
+		body:     record.Request.Body,
+	})
+	if err != nil {
+		diff("error", "", err.Error())
+		return
+	}
+	if succeed := response.Succeed(); succeed != record.Response.Succeed {
+		diff("succeed", strconv.FormatBool(record.Response.Succeed), strconv.FormatBool(succeed))
+	}
+	if body, _ := response.Body(); !bytes.Equal(body, record.Response.Body) {
+		diff("body", string(record.Response.Body), string(body))
+	}
+
+	expected := make(map[string][]string)
+	for _, field := range record.Response.Header {
+		expected[field.Name] = append(expected[field.Name], field.Value)
+	}
+	actual := make(map[string][]string)
+	if h := response.Header(); h != nil {
+		for name, value := range h.All() {
+			actual[string(name)] = append(actual[string(name)], string(value))
+		}
+	}
+	names := make([]string, 0, len(expected)+len(actual))
+	for name := range expected {
+		names = append(names, name)
+	}
+	for name := range actual {
+		if _, has := expected[name]; !has {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if slices.ContainsFunc(r.IgnoredHeaders, func(ignored string) bool { return strings.EqualFold(ignored, name) }) || slices.Contains(expected[name], MaskedHeaderValue) {
+			continue
+		}
+		if !slices.Equal(expected[name], actual[name]) {
+			diff(name, strings.Join(expected[name], ","), strings.Join(actual[name], ","))
+		}
+	}
+	return
+}
@@ -6,7 +6,9 @@ import (
 	"sync"
 
 	"github.com/brickingsoft/brick/pkg/avro"
+	"github.com/brickingsoft/brick/pkg/quicvarint"
 	"github.com/brickingsoft/brick/rpc/errors"
+	"github.com/brickingsoft/brick/rpc/transports/bpack"
 	"github.com/brickingsoft/bytebuffers"
 )
 
@@ -56,13 +58,13 @@ func (r *Request) Sign(signer func(b []byte) (signature []byte)) {
 		_, _ = rand.Read(fake)
 		r.body.Return(4)
 		signature := signer(fake)
-		_ = r.header.Set(SignatureHeaderKey, signature)
-		_ = r.header.Set(fakeBodyHeaderKey, fakeBodyHeaderValue)
+		r.header.SetBytes(SignatureHeaderKey, signature)
+		r.header.SetBytes(fakeBodyHeaderKey, fakeBodyHeaderValue)
 		return
 	}
 	p := r.body.Peek(bLen)
 	signature := signer(p)
-	_ = r.header.Set(SignatureHeaderKey, signature)
+	r.header.SetBytes(SignatureHeaderKey, signature)
 	return
 }
 
@@ -98,7 +100,30 @@ func ReleaseRequest(r *Request) {
 	requestPool.Put(r)
 }
 
-func writeRequest(w io.Writer, request *Request) (err error) {
+func writeRequest(w io.Writer, packer *bpack.Packer, table *bpack.DynamicTable, typ FrameType, id uint64, request *Request) (err error) {
+	b := bytebuffers.Acquire()
+	defer bytebuffers.Release(b)
 
+	writeFrameHead(b, typ, id)
+	_, _ = quicvarint.Write(b, request.function)
+	if err = request.header.Flush(b, packer, table); err != nil {
+		return
+	}
+	writeBody(b, request.body)
+
+	if _, err = b.WriteTo(w); err != nil {
+		err = errors.Join(ErrWriteFrameFailed, err)
+	}
+	return
+}
+
+// parseRequestHead
+// parse the function and the header, the body is read by the server by the limit of the function.
+func parseRequestHead(r frameReader, packer *bpack.Packer, table *bpack.DynamicTable, request *Request) (err error) {
+	if request.function, err = quicvarint.Read(r); err != nil {
+		err = errors.Join(ErrReadFrameFailed, err)
+		return
+	}
+	err = request.header.Parse(r, packer, table)
 	return
 }
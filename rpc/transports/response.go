@@ -1,11 +1,13 @@
 package transports
 
 import (
+	"encoding/json"
 	"io"
 	"sync"
 
 	"github.com/brickingsoft/brick/pkg/avro"
 	"github.com/brickingsoft/brick/rpc/errors"
+	"github.com/brickingsoft/brick/rpc/transports/bpack"
 	"github.com/brickingsoft/bytebuffers"
 )
 
@@ -66,7 +68,49 @@ func ReleaseResponse(r *Response) {
 	responsePool.Put(r)
 }
 
-func parseResponse(r io.Reader, response *Response) (err error) {
+func writeResponse(w io.Writer, packer *bpack.Packer, table *bpack.DynamicTable, id uint64, response *Response) (err error) {
+	b := bytebuffers.Acquire()
+	defer bytebuffers.Release(b)
 
+	writeFrameHead(b, ResponseFrame, id)
+	var flags byte
+	if response.succeed {
+		flags |= succeedResponseFlag
+	}
+	_ = b.WriteByte(flags)
+	if err = response.header.Flush(b, packer, table); err != nil {
+		return
+	}
+	writeBody(b, response.body)
+
+	if _, err = b.WriteTo(w); err != nil {
+		err = errors.Join(ErrWriteFrameFailed, err)
+	}
 	return
 }
+
+// parseResponse
+// ErrBodyTooLarge is returned when the body is larger than the limit, the frame is read out, so the connection is still usable.
+func parseResponse(r frameReader, packer *bpack.Packer, table *bpack.DynamicTable, response *Response, limit int64) (err error) {
+	flags, flagsErr := r.ReadByte()
+	if flagsErr != nil {
+		err = errors.Join(ErrReadFrameFailed, flagsErr)
+		return
+	}
+	response.succeed = flags&succeedResponseFlag != 0
+	if err = response.header.Parse(r, packer, table); err != nil {
+		return
+	}
+	if err = readBody(r, response.body, limit); err != nil && !errors.Is(err, ErrBodyTooLarge) {
+		err = errors.Join(ErrReadFrameFailed, err)
+	}
+	return
+}
+
+// failResponse
+// replace the response by a failure of err, e.g. the body can not be decompressed.
+func failResponse(response *Response, err error) {
+	response.Reset()
+	b, _ := json.Marshal(errors.Wrap(err))
+	_, _ = response.body.Write(b)
+}
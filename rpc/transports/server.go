@@ -0,0 +1,748 @@
+package transports
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brickingsoft/brick/rpc/transports/bpack"
+	"github.com/brickingsoft/brick/transports"
+)
+
+var (
+	ErrServerClosed = errors.New("server closed")
+)
+
+// Server
+// serve the brick frames of connections, requests of one connection are handled concurrently.
+type Server struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	handler   transports.ServeHandler
+	packer    *bpack.Packer
+	prefixes  *dictionaryPrefixes
+	options   Options
+	compress  *compression
+	locker    sync.Mutex
+	listeners map[io.Closer]struct{}
+	conns     map[*serverConn]struct{}
+	quicConns map[*quicServerConn]struct{}
+	idle      chan struct{}
+	wg        sync.WaitGroup
+	draining  bool
+	closed    bool
+}
+
+func NewServer(ctx context.Context, handler transports.ServeHandler, options ...Option) (srv *Server, err error) {
+	if ctx == nil {
+		err = errors.Join(errors.New("new server failed"), errors.New("context is missing"))
+		return
+	}
+	if handler == nil {
+		err = errors.Join(errors.New("new server failed"), errors.New("handler is missing"))
+		return
+	}
+	opts, optsErr := newOptions(options...)
+	if optsErr != nil {
+		err = errors.Join(errors.New("new server failed"), optsErr)
+		return
+	}
+	packer, packerErr := newHeaderPacker(opts.MaxHeaderSize, opts.HeaderFields)
+	if packerErr != nil {
+		err = errors.Join(errors.New("new server failed"), packerErr)
+		return
+	}
+	srv = &Server{
+		handler:   handler,
+		packer:    packer,
+		prefixes:  &dictionaryPrefixes{local: packer},
+		options:   opts,
+		compress:  newCompression(opts),
+		listeners: make(map[io.Closer]struct{}),
+		conns:     make(map[*serverConn]struct{}),
+		quicConns: make(map[*quicServerConn]struct{}),
+		idle:      make(chan struct{}, 1),
+	}
+	srv.ctx, srv.cancel = context.WithCancel(ctx)
+	return
+}
+
+// Serve
+// accept connections of ln until the server is closed, it returns nil when the server is closed.
+func (srv *Server) Serve(ln net.Listener) (err error) {
+	srv.locker.Lock()
+	if srv.closed {
+		srv.locker.Unlock()
+		_ = ln.Close()
+		err = ErrServerClosed
+		return
+	}
+	srv.listeners[ln] = struct{}{}
+	srv.locker.Unlock()
+
+	for {
+		conn, acceptErr := ln.Accept()
+		if acceptErr != nil {
+			srv.locker.Lock()
+			closed := srv.closed || srv.draining
+			delete(srv.listeners, ln)
+			srv.locker.Unlock()
+			if !closed {
+				err = acceptErr
+			}
+			return
+		}
+		srv.wg.Add(1)
+		go func(srv *Server, conn net.Conn) {
+			srv.ServeConn(conn)
+			srv.wg.Done()
+		}(srv, conn)
+	}
+}
+
+// ServeConn
+// serve one connection until it is closed.
+// the peer credentials of unix connections and the peer identity of mutual tls are in the context of requests,
+// see PeerCredentialsFrom and PeerIdentityFrom.
+func (srv *Server) ServeConn(conn net.Conn) {
+	ctx := srv.ctx
+	switch c := conn.(type) {
+	case *net.UnixConn:
+		if credentials, credErr := peerCredentialsOf(c); credErr == nil {
+			ctx = withPeerCredentials(ctx, credentials)
+		}
+		break
+	case *tls.Conn:
+		// a peer which never completes the handshake must not hold the connection.
+		handshakeCtx, cancel := context.WithTimeout(ctx, srv.options.HandshakeTimeout)
+		err := c.HandshakeContext(handshakeCtx)
+		cancel()
+		if err != nil {
+			_ = c.Close()
+			return
+		}
+		state := c.ConnectionState()
+		ctx = withPeerIdentity(ctx, &state)
+		break
+	}
+	srv.serveConn(ctx, conn, false)
+}
+
+// serveBackground
+// serve conn in background unless the server is draining or closed, then Close waits for it.
+func (srv *Server) serveBackground(conn net.Conn) (err error) {
+	srv.locker.Lock()
+	if srv.closed || srv.draining {
+		srv.locker.Unlock()
+		err = ErrServerClosed
+		return
+	}
+	// it is added under the lock, so Close never waits while it is being added.
+	srv.wg.Add(1)
+	srv.locker.Unlock()
+	go func(srv *Server, conn net.Conn) {
+		srv.ServeConn(conn)
+		srv.wg.Done()
+	}(srv, conn)
+	return
+}
+
+// serveConn
+// when halfClose is true, the end of reading does not abort the handlers, the conn is closed after all of them are done.
+func (srv *Server) serveConn(ctx context.Context, conn net.Conn, halfClose bool) {
+	c := &serverConn{
+		srv:        srv,
+		raw:        conn,
+		reader:     bufio.NewReader(conn),
+		streams:    make(map[uint64]*serverStream),
+		lastActive: time.Now().UnixNano(),
+		halfClose:  halfClose,
+		dict:       newDictionaries(srv.packer, srv.prefixes.get(0).packer, srv.options),
+	}
+	if early, ok := conn.(interface{ HandshakeComplete() <-chan struct{} }); ok {
+		c.handshake = early.HandshakeComplete()
+	}
+	if halfClose {
+		// a quic stream declares its dictionary before other frames, see dictionaries.declared.
+		c.dict.decoder = c.dict.encoder
+	}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	// the keepalive and idle timeout of quic are done by quic itself.
+	if !halfClose {
+		c.ka = newKeepalive(srv.options)
+		c.idleTimeout = srv.options.IdleTimeout
+		// frames of quic streams are not ordered, so they have no dynamic table.
+		// the encodeTable is settled by the handshake, see dictionaries.handshake.
+		c.decodeTable = newHeaderTable(srv.options)
+	}
+
+	srv.locker.Lock()
+	// the streams of a quic connection are accepted by it, see quicServerConn.accept.
+	if srv.closed || (srv.draining && !halfClose) {
+		srv.locker.Unlock()
+		_ = conn.Close()
+		c.cancel()
+		return
+	}
+	srv.conns[c] = struct{}{}
+	srv.locker.Unlock()
+
+	if c.ka != nil || c.idleTimeout > 0 {
+		go c.watch()
+	}
+	c.serve()
+
+	srv.locker.Lock()
+	delete(srv.conns, c)
+	srv.locker.Unlock()
+}
+
+// Shutdown
+// close listeners, tell clients to go away, and wait for handling requests until ctx is done, then close the server.
+// the requests which are sent after the goaway are not handled, clients send them on other connections.
+// quic clients are told by the control streams, and they close their connections after their calls are done.
+func (srv *Server) Shutdown(ctx context.Context) (err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	srv.locker.Lock()
+	if srv.closed || srv.draining {
+		srv.locker.Unlock()
+		return
+	}
+	srv.draining = true
+	var errs []error
+	for ln := range srv.listeners {
+		if closeErr := ln.Close(); closeErr != nil {
+			errs = append(errs, closeErr)
+		}
+		delete(srv.listeners, ln)
+	}
+	conns := make([]*serverConn, 0, len(srv.conns))
+	for c := range srv.conns {
+		conns = append(conns, c)
+	}
+	quicConns := make([]*quicServerConn, 0, len(srv.quicConns))
+	for qc := range srv.quicConns {
+		quicConns = append(quicConns, qc)
+	}
+	srv.locker.Unlock()
+
+	for _, c := range conns {
+		c.goAway()
+	}
+	for _, qc := range quicConns {
+		qc.goAway()
+	}
+wait:
+	for !srv.drained(conns) {
+		select {
+		case <-ctx.Done():
+			errs = append(errs, ctx.Err())
+			break wait
+		case <-srv.idle:
+			break
+		}
+	}
+	if closeErr := srv.Close(); closeErr != nil {
+		errs = append(errs, closeErr)
+	}
+	if len(errs) > 0 {
+		err = errors.Join(errors.New("shutdown server failed"), errors.Join(errs...))
+	}
+	return
+}
+
+// drained
+// the conns have no handling requests, and the quic connections are closed by their clients.
+func (srv *Server) drained(conns []*serverConn) bool {
+	for _, c := range conns {
+		if !c.idle() {
+			return false
+		}
+	}
+	srv.locker.Lock()
+	n := len(srv.quicConns)
+	srv.locker.Unlock()
+	return n == 0
+}
+
+// notifyIdle
+// a connection became idle or closed, wake up the Shutdown which waits for the drain.
+// a pending notification is enough, so it never blocks.
+func (srv *Server) notifyIdle() {
+	select {
+	case srv.idle <- struct{}{}:
+		break
+	default:
+		break
+	}
+}
+
+func (srv *Server) Close() (err error) {
+	srv.locker.Lock()
+	if srv.closed {
+		srv.locker.Unlock()
+		return
+	}
+	srv.closed = true
+	var errs []error
+	for ln := range srv.listeners {
+		if closeErr := ln.Close(); closeErr != nil {
+			errs = append(errs, closeErr)
+		}
+	}
+	for c := range srv.conns {
+		_ = c.raw.Close()
+	}
+	srv.locker.Unlock()
+
+	srv.cancel()
+	srv.wg.Wait()
+	if len(errs) > 0 {
+		err = errors.Join(errors.New("close server failed"), errors.Join(errs...))
+	}
+	return
+}
+
+type serverConn struct {
+	ctx         context.Context
+	cancel      context.CancelFunc
+	srv         *Server
+	raw         net.Conn
+	reader      *bufio.Reader
+	wl          sync.Mutex
+	locker      sync.Mutex
+	streams     map[uint64]*serverStream
+	handling    sync.WaitGroup
+	halfClose   bool
+	ka          *keepalive
+	idleTimeout time.Duration
+	dict        *dictionaries
+	functions   functionTable
+	// handshake
+	// it is closed when the handshake of the connection is completed, it is nil when there is no early data.
+	handshake <-chan struct{}
+	// advertised
+	// the encodings of the server are sent by the first response.
+	advertised atomic.Bool
+	// encodeTable and decodeTable
+	// the dynamic header tables of responses and requests, they are nil when disabled.
+	encodeTable *bpack.DynamicTable
+	decodeTable *bpack.DynamicTable
+	// active, lastActive, lastID and draining are guarded by locker.
+	active     int
+	lastActive int64
+	lastID     uint64
+	draining   bool
+}
+
+func (c *serverConn) serve() {
+	defer c.close()
+	if !c.halfClose {
+		if err := c.writeHandshake(); err != nil {
+			return
+		}
+	}
+	for {
+		typ, id, err := readFrameHead(c.reader)
+		if err != nil {
+			return
+		}
+		if c.ka != nil {
+			c.ka.touch()
+		}
+		switch typ {
+		case RequestFrame, StreamFrame:
+			request := AcquireRequest(0)
+			if err = parseRequestHead(c.reader, c.dict.decoder, c.decodeTable, request); err != nil {
+				ReleaseRequest(request)
+				return
+			}
+			limit := c.srv.options.bodyLimitOf(request.function)
+			bLen, lenErr := readBodyLength(c.reader)
+			if lenErr != nil {
+				ReleaseRequest(request)
+				return
+			}
+			if bLen > limit.MaxBodySize {
+				// reject it before buffering, and skip it to keep the framing.
+				ReleaseRequest(request)
+				if err = discardBody(c.reader, bLen); err != nil {
+					return
+				}
+				c.reject(id, ErrBodyTooLarge)
+				continue
+			}
+			if typ == RequestFrame && limit.Streaming && bLen > 0 && len(request.header.ContentEncoding()) == 0 {
+				if err = c.serveStreaming(id, request, bLen); err != nil {
+					return
+				}
+				continue
+			}
+			if err = readBodyN(c.reader, request.body, bLen); err != nil {
+				ReleaseRequest(request)
+				return
+			}
+			if err = decompressBody(request.body, request.header, limit.MaxBodySize); err != nil {
+				ReleaseRequest(request)
+				c.reject(id, err)
+				continue
+			}
+			var stream *serverStream
+			if typ == StreamFrame {
+				c.locker.Lock()
+				opened, has := c.streams[id]
+				c.locker.Unlock()
+				if has {
+					if !opened.requests.push(request) {
+						ReleaseRequest(request)
+					}
+					continue
+				}
+			}
+			if !c.accept(id) {
+				ReleaseRequest(request)
+				continue
+			}
+			encoding := c.srv.compress.negotiate(request.header.Peek(AcceptEncodingHeaderKey))
+			if typ == StreamFrame {
+				// open it before handling, the following requests of the stream may come before the handling.
+				stream = c.openStream(id, encoding)
+			}
+			c.handling.Add(1)
+			go c.handle(id, request, stream, encoding, nil)
+			break
+		case CloseFrame:
+			c.locker.Lock()
+			stream, has := c.streams[id]
+			c.locker.Unlock()
+			if has {
+				stream.requests.end()
+			}
+			break
+		case HandshakeFrame:
+			if c.halfClose {
+				if err = c.dict.declared(c.reader, c.srv.prefixes); err != nil {
+					c.reject(id, err)
+					return
+				}
+				break
+			}
+			encoder, table, reply, handshakeErr := c.dict.handshake(c.reader)
+			if handshakeErr != nil {
+				return
+			}
+			c.wl.Lock()
+			err = c.dict.answer(c.raw, reply)
+			if encoder != nil {
+				c.dict.encoder = encoder
+			}
+			if table != nil {
+				c.encodeTable = table
+			}
+			c.wl.Unlock()
+			if err != nil {
+				return
+			}
+			break
+		case DictionaryFrame:
+			if err = c.dict.load(c.reader); err != nil {
+				return
+			}
+			break
+		case FunctionFrame:
+			if err = c.functions.read(c.reader, id); err != nil {
+				return
+			}
+			break
+		case PingFrame:
+			_ = c.writeControl(PongFrame, id)
+			break
+		case PongFrame:
+			break
+		default:
+			return
+		}
+	}
+}
+
+// serveStreaming
+// the handler reads the body from the connection, so the next frame is read after the body is done.
+func (c *serverConn) serveStreaming(id uint64, request *Request, bLen int64) (err error) {
+	if !c.accept(id) {
+		ReleaseRequest(request)
+		err = discardBody(c.reader, bLen)
+		return
+	}
+	body := newStreamingBody(c.reader, bLen, c.ka)
+	encoding := c.srv.compress.negotiate(request.header.Peek(AcceptEncodingHeaderKey))
+	c.handling.Add(1)
+	go c.handle(id, request, nil, encoding, body)
+	select {
+	case <-body.done:
+		err = body.failed()
+		break
+	case <-c.ctx.Done():
+		err = c.ctx.Err()
+		break
+	}
+	return
+}
+
+func (c *serverConn) handle(id uint64, request *Request, stream *serverStream, encoding string, body *streamingBody) {
+	defer c.handling.Done()
+	defer c.release()
+	if body != nil {
+		defer body.close()
+	}
+
+	endpoint, function, found := c.functions.lookup(request.function)
+	if found && c.handshake != nil && !IsIdempotentFunction(request.function) {
+		// a replayed 0-RTT request never completes the handshake, so only idempotent functions are handled before it.
+		select {
+		case <-c.handshake:
+			break
+		case <-c.ctx.Done():
+			ReleaseRequest(request)
+			if stream != nil {
+				_ = stream.Close()
+			}
+			return
+		}
+	}
+	if !found {
+		ReleaseRequest(request)
+		if stream != nil {
+			stream.writer.Failed(ErrFunctionNotFound)
+			_ = stream.Close()
+			return
+		}
+		response := AcquireResponse()
+		w := &responseWriter{conn: c, id: id, response: response}
+		w.Failed(ErrFunctionNotFound)
+		ReleaseResponse(response)
+		return
+	}
+
+	ctx := &requestCtx{
+		Context:  c.ctx,
+		endpoint: endpoint,
+		function: function,
+		conn:     c,
+		request:  request,
+		stream:   stream,
+		body:     body,
+	}
+	if stream != nil {
+		stream.endpoint, stream.function = endpoint, function
+		ctx.writer = stream.writer
+	} else {
+		ctx.writer = &responseWriter{conn: c, id: id, response: AcquireResponse(), encoding: encoding}
+	}
+
+	if err := ctx.writer.negotiate(request.header); err != nil {
+		ctx.writer.Failed(err)
+	} else {
+		c.srv.handler.Handle(ctx)
+	}
+
+	if ctx.hijacker != nil {
+		ctx.hijacker.Handle(stream.ctx, stream)
+	} else if !ctx.writer.responded {
+		ctx.writer.Succeed(nil)
+	}
+	ReleaseRequest(request)
+	if stream != nil {
+		_ = stream.Close()
+		return
+	}
+	ReleaseResponse(ctx.writer.response)
+}
+
+func (c *serverConn) openStream(id uint64, encoding string) (stream *serverStream) {
+	stream = &serverStream{
+		conn:     c,
+		id:       id,
+		requests: newQueue[*Request](),
+		writer: &responseWriter{
+			conn:     c,
+			id:       id,
+			response: AcquireResponse(),
+			multiple: true,
+			encoding: encoding,
+		},
+	}
+	stream.ctx, stream.cancel = context.WithCancel(c.ctx)
+	c.locker.Lock()
+	c.streams[id] = stream
+	c.locker.Unlock()
+	return
+}
+
+func (c *serverConn) removeStream(id uint64) (removed bool) {
+	c.locker.Lock()
+	if _, removed = c.streams[id]; removed {
+		delete(c.streams, id)
+	}
+	c.locker.Unlock()
+	return
+}
+
+// reject
+// respond a failure of the request which can not be handled, e.g. its body can not be decompressed.
+func (c *serverConn) reject(id uint64, err error) {
+	response := AcquireResponse()
+	w := &responseWriter{conn: c, id: id, response: response}
+	w.Failed(err)
+	ReleaseResponse(response)
+}
+
+func (c *serverConn) writeResponse(id uint64, response *Response) (err error) {
+	c.wl.Lock()
+	err = writeResponse(c.raw, c.dict.encoder, c.encodeTable, id, response)
+	c.wl.Unlock()
+	return
+}
+
+func (c *serverConn) writeControl(typ FrameType, id uint64) (err error) {
+	c.wl.Lock()
+	err = writeControl(c.raw, typ, id)
+	c.wl.Unlock()
+	return
+}
+
+func (c *serverConn) writeHandshake() (err error) {
+	c.wl.Lock()
+	n := c.dict.local.Len()
+	err = writeHandshake(c.raw, n, c.dict.local.Fingerprint(n), c.dict.tableSize)
+	c.wl.Unlock()
+	return
+}
+
+// accept
+// a request after the goaway is not accepted.
+func (c *serverConn) accept(id uint64) (ok bool) {
+	c.locker.Lock()
+	if ok = !c.draining || id <= c.lastID; ok {
+		c.lastID = max(c.lastID, id)
+		c.active++
+		c.lastActive = time.Now().UnixNano()
+	}
+	c.locker.Unlock()
+	return
+}
+
+func (c *serverConn) release() {
+	c.locker.Lock()
+	c.active--
+	c.lastActive = time.Now().UnixNano()
+	drained := c.draining && c.active == 0
+	c.locker.Unlock()
+	if drained {
+		c.srv.notifyIdle()
+	}
+}
+
+func (c *serverConn) idle() (ok bool) {
+	c.locker.Lock()
+	ok = c.active == 0
+	c.locker.Unlock()
+	return
+}
+
+// advertise
+// tell the client the encodings of the server by the first response, so the client knows how to compress requests.
+func (c *serverConn) advertise(h *header) {
+	if c.srv.compress == nil || c.advertised.Load() || !c.advertised.CompareAndSwap(false, true) {
+		return
+	}
+	h.SetBytes(AcceptEncodingHeaderKey, c.srv.compress.accept)
+}
+
+// goAway
+// tell the client the last request id which will be handled.
+func (c *serverConn) goAway() {
+	c.locker.Lock()
+	if c.draining {
+		c.locker.Unlock()
+		return
+	}
+	c.draining = true
+	last := c.lastID
+	c.locker.Unlock()
+	if !c.halfClose {
+		_ = c.writeControl(GoAwayFrame, last)
+	}
+}
+
+// watch
+// ping the client by the keepalive, and go away when there is no request for the idle timeout.
+func (c *serverConn) watch() {
+	tick := time.Duration(0)
+	if c.ka != nil {
+		tick = c.ka.tick()
+	}
+	if c.idleTimeout > 0 && (tick == 0 || c.idleTimeout/2 < tick) {
+		tick = c.idleTimeout / 2
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case now := <-ticker.C:
+			if c.ka != nil {
+				ping, id, dead := c.ka.check(now)
+				if dead {
+					_ = c.raw.Close()
+					return
+				}
+				if ping {
+					// a blocked write must not block the check.
+					go func(c *serverConn, id uint64) {
+						_ = c.writeControl(PingFrame, id)
+					}(c, id)
+				}
+			}
+			if c.idleTimeout <= 0 {
+				break
+			}
+			c.locker.Lock()
+			active, draining := c.active, c.draining
+			idle := active == 0 && now.UnixNano()-c.lastActive >= int64(c.idleTimeout)
+			c.locker.Unlock()
+			if draining && active == 0 {
+				_ = c.raw.Close()
+				return
+			}
+			if idle {
+				c.goAway()
+			}
+		}
+	}
+}
+
+func (c *serverConn) close() {
+	if !c.halfClose {
+		c.cancel()
+		_ = c.raw.Close()
+	}
+	c.locker.Lock()
+	for _, stream := range c.streams {
+		stream.requests.end()
+	}
+	c.locker.Unlock()
+	c.handling.Wait()
+	if c.halfClose {
+		c.cancel()
+		_ = c.raw.Close()
+	}
+}
@@ -0,0 +1,205 @@
+package transports
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/brickingsoft/brick/rpc/configs"
+	"github.com/brickingsoft/brick/transports"
+)
+
+const (
+	TCPTransportName = "tcp"
+	// TCPProtocol
+	// the alpn of brick over tls.
+	TCPProtocol = "brick"
+)
+
+type TCPConfig struct {
+	Address       string         `json:"address" yaml:"address"`
+	MaxHeaderSize int            `json:"maxHeaderSize" yaml:"maxHeaderSize"`
+	Headers       []HeaderValues `json:"headers" yaml:"headers"`
+	// HeaderTableSize
+	// the size of dynamic header tables of a connection, the default is 4KB, negative disables them.
+	HeaderTableSize int `json:"headerTableSize" yaml:"headerTableSize"`
+	// TLS
+	// the connections are plain when it is not set.
+	TLS  *TLSConfig `json:"tls" yaml:"tls"`
+	Pool PoolConfig `json:"pool" yaml:"pool"`
+	// KeepAliveInterval
+	// a ping is sent when nothing is read for the interval, the default is 30s, negative disables keepalive.
+	KeepAliveInterval time.Duration `json:"keepAliveInterval" yaml:"keepAliveInterval"`
+	KeepAliveTimeout  time.Duration `json:"keepAliveTimeout" yaml:"keepAliveTimeout"`
+	// IdleTimeout
+	// the server closes connections which have no request for the timeout, it is disabled by default.
+	IdleTimeout time.Duration     `json:"idleTimeout" yaml:"idleTimeout"`
+	Compression CompressionConfig `json:"compression" yaml:"compression"`
+	// Limits
+	// the body limits, the default max body size is 4MB.
+	Limits LimitsConfig `json:"limits" yaml:"limits"`
+	// Forwarded
+	// the trusted proxies and the max hops of forwarded chains.
+	Forwarded ForwardedConfig `json:"forwarded" yaml:"forwarded"`
+}
+
+func (config *TCPConfig) options() []Option {
+	options := []Option{
+		WithMaxHeaderSize(config.MaxHeaderSize),
+		WithHeaderFields(config.Headers...),
+		WithHeaderTableSize(config.HeaderTableSize),
+		WithKeepAlive(config.KeepAliveInterval, config.KeepAliveTimeout),
+		WithIdleTimeout(config.IdleTimeout),
+		WithCompression(config.Compression.Threshold, config.Compression.Encodings...),
+	}
+	if config.TLS != nil {
+		options = append(options, WithHandshakeTimeout(config.TLS.HandshakeTimeout))
+	}
+	options = append(options, config.Limits.options()...)
+	return append(options, config.Forwarded.options()...)
+}
+
+// NewTCPTransport
+// a transports.Builder of tcp, the config is the node of tcp transport.
+func NewTCPTransport(ctx context.Context, config configs.Config) (transport transports.Transport, err error) {
+	tc := TCPConfig{}
+	if err = config.As(&tc); err != nil {
+		err = errors.Join(errors.New("new tcp transport failed"), err)
+		return
+	}
+	tc.Address = strings.TrimSpace(tc.Address)
+	if tc.Address == "" {
+		err = errors.Join(errors.New("new tcp transport failed"), errors.New("address is missing"))
+		return
+	}
+	tr := &TCPTransport{
+		config: tc,
+	}
+	if tc.TLS != nil {
+		if tr.tls, err = tc.TLS.Watch(ctx); err != nil {
+			err = errors.Join(errors.New("new tcp transport failed"), err)
+			return
+		}
+		tr.clientTLS = tr.tls.Client(TCPProtocol)
+	}
+	transport = tr
+	return
+}
+
+type TCPTransport struct {
+	config    TCPConfig
+	tls       *TLSWatcher
+	clientTLS *tls.Config
+	locker    sync.Mutex
+	server    *Server
+	addr      net.Addr
+}
+
+func (tr *TCPTransport) Name() string {
+	return TCPTransportName
+}
+
+// Addr
+// the listened address, it is nil before Listen.
+func (tr *TCPTransport) Addr() net.Addr {
+	tr.locker.Lock()
+	defer tr.locker.Unlock()
+	return tr.addr
+}
+
+// Listen
+// listen the configured address and serve in background.
+func (tr *TCPTransport) Listen(ctx context.Context, handler transports.ServeHandler) (err error) {
+	tr.locker.Lock()
+	defer tr.locker.Unlock()
+	if tr.server != nil {
+		err = errors.Join(errors.New("tcp transport listen failed"), errors.New("already listened"))
+		return
+	}
+	srv, srvErr := NewServer(ctx, handler, tr.config.options()...)
+	if srvErr != nil {
+		err = errors.Join(errors.New("tcp transport listen failed"), srvErr)
+		return
+	}
+	var serverTLS *tls.Config
+	if tr.tls != nil {
+		var serverTLSErr error
+		if serverTLS, serverTLSErr = tr.tls.Server(TCPProtocol); serverTLSErr != nil {
+			err = errors.Join(errors.New("tcp transport listen failed"), serverTLSErr)
+			return
+		}
+	}
+	config := net.ListenConfig{}
+	ln, lnErr := config.Listen(ctx, "tcp", tr.config.Address)
+	if lnErr != nil {
+		err = errors.Join(errors.New("tcp transport listen failed"), lnErr)
+		return
+	}
+	if serverTLS != nil {
+		ln = tls.NewListener(ln, serverTLS)
+	}
+	tr.server = srv
+	tr.addr = ln.Addr()
+	go func(srv *Server, ln net.Listener) {
+		_ = srv.Serve(ln)
+	}(srv, ln)
+	return
+}
+
+// Connect
+// the client is a pool of connections to the address.
+func (tr *TCPTransport) Connect(ctx context.Context, address string) (client transports.Client, err error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		err = errors.Join(errors.New("tcp transport connect failed"), errors.New("address is missing"))
+		return
+	}
+	pool, poolErr := newPool(ctx, tr.config.Pool, func(ctx context.Context) (pooledClient, error) {
+		if tr.clientTLS != nil {
+			return DialTLS(ctx, "tcp", address, tr.clientTLS, tr.config.options()...)
+		}
+		return Dial(ctx, "tcp", address, tr.config.options()...)
+	})
+	if poolErr != nil {
+		err = errors.Join(errors.New("tcp transport connect failed"), poolErr)
+		return
+	}
+	client = pool
+	return
+}
+
+// Shutdown
+// stop listening, and close the server after the handling requests are done or ctx is done.
+func (tr *TCPTransport) Shutdown(ctx context.Context) (err error) {
+	tr.locker.Lock()
+	srv := tr.server
+	tr.server = nil
+	tr.locker.Unlock()
+	if tr.tls != nil {
+		_ = tr.tls.Close()
+	}
+	if srv == nil {
+		return
+	}
+	err = srv.Shutdown(ctx)
+	return
+}
+
+func (tr *TCPTransport) Close() (err error) {
+	tr.locker.Lock()
+	srv := tr.server
+	tr.server = nil
+	tr.locker.Unlock()
+	if tr.tls != nil {
+		_ = tr.tls.Close()
+	}
+	if srv == nil {
+		return
+	}
+	err = srv.Close()
+	return
+}
@@ -0,0 +1,297 @@
+package transports_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/brickingsoft/brick/rpc/configs"
+	"github.com/brickingsoft/brick/rpc/transports"
+	brick "github.com/brickingsoft/brick/transports"
+)
+
+type testHeader map[string][]string
+
+func (h testHeader) Get(key string) string {
+	if vs := h[key]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+func (h testHeader) Keys() (keys []string) {
+	for k := range h {
+		keys = append(keys, k)
+	}
+	return
+}
+
+func (h testHeader) Values(key string) []string { return h[key] }
+
+func (h testHeader) Set(key string, value string) { h[key] = []string{value} }
+
+func (h testHeader) Add(key string, values ...string) { h[key] = append(h[key], values...) }
+
+func (h testHeader) Remove(key string) { delete(h, key) }
+
+func (h testHeader) Authorization() string { return h.Get("authorization") }
+
+func (h testHeader) Peek(key []byte) []byte { return []byte(h.Get(string(key))) }
+
+func (h testHeader) PeekValues(key []byte) (values [][]byte) {
+	for _, v := range h[string(key)] {
+		values = append(values, []byte(v))
+	}
+	return
+}
+
+func (h testHeader) SetBytes(key []byte, value []byte) { h.Set(string(key), string(value)) }
+
+func (h testHeader) AddBytes(key []byte, value []byte) { h.Add(string(key), string(value)) }
+
+func (h testHeader) RemoveBytes(key []byte) { h.Remove(string(key)) }
+
+func (h testHeader) All() iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		for k, vs := range h {
+			for _, v := range vs {
+				if !yield([]byte(k), []byte(v)) {
+					return
+				}
+			}
+		}
+	}
+}
+
+type testRequest struct {
+	endpoint string
+	function string
+	header   testHeader
+	body     []byte
+}
+
+func (r *testRequest) Endpoint() string { return r.endpoint }
+
+func (r *testRequest) Function() string { return r.function }
+
+func (r *testRequest) Header() brick.Header { return r.header }
+
+func (r *testRequest) Body() ([]byte, error) { return r.body, nil }
+
+type echoHandler struct{}
+
+func (h *echoHandler) Handle(r brick.RequestCtx) {
+	if r.Function() == "fail" {
+		r.Response().Failed(errors.New("boom"))
+		return
+	}
+	if r.Function() == "stream" {
+		if err := r.Hijack(&echoStreamHandler{}); err != nil {
+			r.Response().Failed(err)
+		}
+		return
+	}
+	body, _ := r.Body()
+	r.Response().Header().Set("echo", string(body))
+	r.Response().Header().Set("function", r.Endpoint()+"."+r.Function())
+	r.Response().Succeed(nil)
+}
+
+type echoStreamHandler struct{}
+
+func (h *echoStreamHandler) Handle(_ context.Context, stream brick.Stream) {
+	for {
+		r, ok := stream.Next()
+		if !ok {
+			return
+		}
+		body, _ := r.Body()
+		if string(body) == "bye" {
+			return
+		}
+		stream.Response().Header().Set("echo", string(body))
+		stream.Response().Succeed(nil)
+	}
+}
+
+func newTCPTransport(t *testing.T) *transports.TCPTransport {
+	t.Helper()
+	config, configErr := configs.NewConfig([]byte(`address: 127.0.0.1:0`))
+	if configErr != nil {
+		t.Fatal(configErr)
+	}
+	tr, trErr := transports.NewTCPTransport(context.Background(), *config)
+	if trErr != nil {
+		t.Fatal(trErr)
+	}
+	if err := tr.Listen(context.Background(), &echoHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	return tr.(*transports.TCPTransport)
+}
+
+func TestTCPTransport(t *testing.T) {
+	transports.RegisterFunction("foo", "bar")
+	tr := newTCPTransport(t)
+	defer tr.Close()
+
+	ctx := context.Background()
+	client, clientErr := tr.Connect(ctx, tr.Addr().String())
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	defer client.Close()
+
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := []byte{'b', 'o', 'd', 'y', '0' + byte(i)}
+			resp, doErr := client.Do(ctx, &testRequest{
+				endpoint: "foo",
+				function: "bar",
+				header:   testHeader{"x-id": {string(body)}},
+				body:     body,
+			})
+			if doErr != nil {
+				t.Error(doErr)
+				return
+			}
+			if !resp.Succeed() {
+				t.Error("response failed")
+				return
+			}
+			if echo := resp.Header().Get("echo"); echo != string(body) {
+				t.Error("unexpected echo", echo)
+			}
+			if fn := resp.Header().Get("function"); fn != "foo.bar" {
+				t.Error("unexpected function", fn)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	resp, doErr := client.Do(ctx, &testRequest{endpoint: "foo", function: "fail"})
+	if doErr != nil {
+		t.Fatal(doErr)
+	}
+	if resp.Succeed() {
+		t.Fatal("response should be failed")
+	}
+	if err := resp.ParseBody(nil); err == nil || err.Error() != "boom" {
+		t.Fatal("unexpected failure", err)
+	}
+}
+
+func TestTCPTransport_Stream(t *testing.T) {
+	transports.RegisterFunction("foo", "stream")
+	tr := newTCPTransport(t)
+	defer tr.Close()
+
+	ctx := context.Background()
+	client, clientErr := tr.Connect(ctx, tr.Addr().String())
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	defer client.Close()
+
+	stream, streamErr := client.(brick.StreamClient).Stream(ctx, &testRequest{endpoint: "foo", function: "stream"})
+	if streamErr != nil {
+		t.Fatal(streamErr)
+	}
+	for _, s := range []string{"a", "b", "c", "bye"} {
+		if err := stream.Send(&testRequest{endpoint: "foo", function: "stream", body: []byte(s)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n := 0
+	for {
+		resp, err := stream.Receive()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				t.Fatal(err)
+			}
+			break
+		}
+		t.Log(resp.Header().Get("echo"))
+		n++
+	}
+	if n != 3 {
+		t.Fatal("expected 3 responses, got", n)
+	}
+	_ = stream.Close()
+}
+
+// TestTCPTransport_RemoteServer
+// the server of TestTCPTransport_Remote, it runs in a child process, so no function is registered in it.
+func TestTCPTransport_RemoteServer(t *testing.T) {
+	if os.Getenv("BRICK_REMOTE_SERVER") == "" {
+		t.Skip("it is run by TestTCPTransport_Remote")
+	}
+	tr := newTCPTransport(t)
+	defer tr.Close()
+	fmt.Println(tr.Addr().String())
+	// serve until the parent closes stdin.
+	_, _ = io.Copy(io.Discard, os.Stdin)
+}
+
+func TestTCPTransport_Remote(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestTCPTransport_RemoteServer$")
+	cmd.Env = append(os.Environ(), "BRICK_REMOTE_SERVER=1")
+	stdin, stdinErr := cmd.StdinPipe()
+	if stdinErr != nil {
+		t.Fatal(stdinErr)
+	}
+	stdout, stdoutErr := cmd.StdoutPipe()
+	if stdoutErr != nil {
+		t.Fatal(stdoutErr)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = stdin.Close()
+		_ = cmd.Wait()
+	}()
+	address := ""
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "127.0.0.1:") {
+			address = line
+			break
+		}
+	}
+	if address == "" {
+		t.Fatal("remote server is not started")
+	}
+
+	ctx := context.Background()
+	client, clientErr := transports.Dial(ctx, "tcp", address)
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	defer client.Close()
+	// names are sent once per connection, the second call is resolved by the names of the first one.
+	for _, function := range []string{"remote", "remote", "other"} {
+		resp, doErr := client.Do(ctx, &testRequest{endpoint: "foo", function: function})
+		if doErr != nil {
+			t.Fatal(doErr)
+		}
+		if !resp.Succeed() {
+			body, _ := resp.Body()
+			t.Fatal("response failed", string(body))
+		}
+		if fn := resp.Header().Get("function"); fn != "foo."+function {
+			t.Fatal("unexpected function", fn)
+		}
+	}
+}
@@ -0,0 +1,412 @@
+package transports
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/brickingsoft/brick/pkg/mosses"
+	"github.com/brickingsoft/brick/rpc/logs"
+)
+
+const (
+	ClientAuthNone             = "none"
+	ClientAuthRequest          = "request"
+	ClientAuthRequire          = "require"
+	ClientAuthVerifyIfGiven    = "verify-if-given"
+	ClientAuthRequireAndVerify = "require-and-verify"
+)
+
+const (
+	// DefaultTLSWatchInterval
+	// the default interval of checking the cert, key and ca files.
+	DefaultTLSWatchInterval = 10 * time.Second
+	// DefaultTLSExpiryWarning
+	// the default duration before the expiry of cert to warn.
+	DefaultTLSExpiryWarning = 7 * 24 * time.Hour
+	tlsExpiryWarningPeriod  = time.Hour
+)
+
+// TLSConfig
+// the tls node of a transport config, files are pem encoded, e.g. the files made by `brickman cert`.
+type TLSConfig struct {
+	Cert string `json:"cert" yaml:"cert"`
+	Key  string `json:"key" yaml:"key"`
+	// CA
+	// the ca bundle, it verifies servers in client side and clients in server side.
+	CA string `json:"ca" yaml:"ca"`
+	// ClientAuth
+	// none, request, require, verify-if-given and require-and-verify, use require-and-verify for mutual tls.
+	ClientAuth string `json:"clientAuth" yaml:"clientAuth"`
+	// MinVersion
+	// 1.2 or 1.3, the default is 1.2.
+	MinVersion string `json:"minVersion" yaml:"minVersion"`
+	// ALPN
+	// the application protocols, the default of the transport is used when it is empty.
+	ALPN               []string `json:"alpn" yaml:"alpn"`
+	ServerName         string   `json:"serverName" yaml:"serverName"`
+	InsecureSkipVerify bool     `json:"insecureSkipVerify" yaml:"insecureSkipVerify"`
+	// WatchInterval
+	// the interval of checking the files, changed files are reloaded without restart, negative disables watching.
+	WatchInterval time.Duration `json:"watchInterval" yaml:"watchInterval"`
+	// ExpiryWarning
+	// warn when the cert expires within it.
+	ExpiryWarning time.Duration `json:"expiryWarning" yaml:"expiryWarning"`
+	// HandshakeTimeout
+	// accepted connections are closed when their handshakes are not done in it, the default is 10s.
+	HandshakeTimeout time.Duration `json:"handshakeTimeout" yaml:"handshakeTimeout"`
+}
+
+// Watch
+// load the files and watch them until ctx is done or the watcher is closed.
+// the logger of ctx is used to report reloads, invalid files and coming expiry.
+func (config *TLSConfig) Watch(ctx context.Context) (watcher *TLSWatcher, err error) {
+	if ctx == nil {
+		err = errors.Join(errors.New("watch tls failed"), errors.New("context is missing"))
+		return
+	}
+	watcher = &TLSWatcher{
+		config: *config,
+		done:   make(chan struct{}),
+	}
+	watcher.config.Cert = strings.TrimSpace(config.Cert)
+	watcher.config.Key = strings.TrimSpace(config.Key)
+	watcher.config.CA = strings.TrimSpace(config.CA)
+	if watcher.config.ExpiryWarning <= 0 {
+		watcher.config.ExpiryWarning = DefaultTLSExpiryWarning
+	}
+	if watcher.config.WatchInterval == 0 {
+		watcher.config.WatchInterval = DefaultTLSWatchInterval
+	}
+	if watcher.clientAuth, err = parseClientAuth(config.ClientAuth); err != nil {
+		watcher = nil
+		err = errors.Join(errors.New("watch tls failed"), err)
+		return
+	}
+	if watcher.minVersion, err = parseTLSVersion(config.MinVersion); err != nil {
+		watcher = nil
+		err = errors.Join(errors.New("watch tls failed"), err)
+		return
+	}
+	if logger, has := logs.Lookup(ctx); has {
+		watcher.logger = logger.Group("tls")
+	}
+	watcher.ctx, watcher.cancel = context.WithCancel(ctx)
+	if err = watcher.load(); err != nil {
+		watcher.cancel()
+		watcher = nil
+		err = errors.Join(errors.New("watch tls failed"), err)
+		return
+	}
+	if watcher.config.WatchInterval > 0 {
+		go watcher.watch()
+	} else {
+		close(watcher.done)
+	}
+	return
+}
+
+func parseClientAuth(s string) (auth tls.ClientAuthType, err error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", ClientAuthNone:
+		auth = tls.NoClientCert
+		break
+	case ClientAuthRequest:
+		auth = tls.RequestClientCert
+		break
+	case ClientAuthRequire:
+		auth = tls.RequireAnyClientCert
+		break
+	case ClientAuthVerifyIfGiven:
+		auth = tls.VerifyClientCertIfGiven
+		break
+	case ClientAuthRequireAndVerify:
+		auth = tls.RequireAndVerifyClientCert
+		break
+	default:
+		err = errors.New("invalid client auth " + s)
+		break
+	}
+	return
+}
+
+func parseTLSVersion(s string) (version uint16, err error) {
+	switch strings.TrimSpace(s) {
+	case "", "1.2":
+		version = tls.VersionTLS12
+		break
+	case "1.3":
+		version = tls.VersionTLS13
+		break
+	default:
+		err = errors.New("invalid min version " + s)
+		break
+	}
+	return
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func stampOf(name string) (stamp fileStamp) {
+	if name == "" {
+		return
+	}
+	if info, err := os.Stat(name); err == nil {
+		stamp = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+	return
+}
+
+// TLSWatcher
+// the certificate and ca of tls configs built by the watcher are swapped atomically when the files are changed,
+// new files are validated before switching, the invalid ones are ignored and the previous are kept.
+type TLSWatcher struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	config     TLSConfig
+	clientAuth tls.ClientAuthType
+	minVersion uint16
+	logger     mosses.Logger
+	cert       atomic.Pointer[tls.Certificate]
+	pool       atomic.Pointer[x509.CertPool]
+	locker     sync.Mutex
+	stamps     [3]fileStamp
+	warned     time.Time
+	done       chan struct{}
+}
+
+// Certificate
+// the current certificate, it is nil when cert and key are not set.
+func (watcher *TLSWatcher) Certificate() *tls.Certificate {
+	return watcher.cert.Load()
+}
+
+// Server
+// build the tls config of server side, the cert and key are required.
+func (watcher *TLSWatcher) Server(protocols ...string) (c *tls.Config, err error) {
+	if watcher.cert.Load() == nil {
+		err = errors.Join(errors.New("build server tls config failed"), errors.New("cert or key is missing"))
+		return
+	}
+	if watcher.clientAuth >= tls.VerifyClientCertIfGiven && watcher.pool.Load() == nil {
+		err = errors.Join(errors.New("build server tls config failed"), errors.New("ca is missing for verifying clients"))
+		return
+	}
+	c = watcher.base(protocols)
+	c.ClientAuth = watcher.clientAuth
+	c.GetCertificate = func(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return watcher.cert.Load(), nil
+	}
+	base := c
+	c.GetConfigForClient = func(_ *tls.ClientHelloInfo) (*tls.Config, error) {
+		current := base.Clone()
+		current.GetConfigForClient = nil
+		current.ClientCAs = watcher.pool.Load()
+		return current, nil
+	}
+	return
+}
+
+// Client
+// build the tls config of client side, the system roots are used when ca is not set,
+// the cert and key are used as the client certificate of mutual tls.
+// the config is bound to the dialed address by clientTLSOf, which the dials of transports do.
+func (watcher *TLSWatcher) Client(protocols ...string) (c *tls.Config) {
+	c = watcher.base(protocols)
+	c.ServerName = strings.TrimSpace(watcher.config.ServerName)
+	c.GetClientCertificate = func(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		if cert := watcher.cert.Load(); cert != nil {
+			return cert, nil
+		}
+		return &tls.Certificate{}, nil
+	}
+	// the servers are verified by the current ca in VerifyConnection, so the ca can be swapped.
+	c.InsecureSkipVerify = true
+	if !watcher.config.InsecureSkipVerify {
+		c.VerifyConnection = watcher.verifyServer
+	}
+	return
+}
+
+func (watcher *TLSWatcher) base(protocols []string) *tls.Config {
+	c := &tls.Config{
+		NextProtos: protocols,
+		MinVersion: watcher.minVersion,
+	}
+	if len(watcher.config.ALPN) > 0 {
+		c.NextProtos = watcher.config.ALPN
+	}
+	return c
+}
+
+// verifyServer
+// verify the server certificate by the current ca against the server name, it fails when the name is unknown.
+func (watcher *TLSWatcher) verifyServer(state tls.ConnectionState) (err error) {
+	if len(state.PeerCertificates) == 0 {
+		err = errors.New("tls: server did not provide a certificate")
+		return
+	}
+	if state.ServerName == "" {
+		err = errors.New("tls: server name is unknown")
+		return
+	}
+	options := x509.VerifyOptions{
+		Roots:         watcher.pool.Load(),
+		DNSName:       state.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range state.PeerCertificates[1:] {
+		options.Intermediates.AddCert(cert)
+	}
+	_, err = state.PeerCertificates[0].Verify(options)
+	return
+}
+
+// clientTLSOf
+// clone the client tls config for the dialed address, the server name is the configured one or the host of address,
+// ips included, so the server is verified against it even when no sni is sent.
+func clientTLSOf(config *tls.Config, address string) (c *tls.Config, err error) {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	c = config.Clone()
+	if c.ServerName == "" {
+		host, _, splitErr := net.SplitHostPort(address)
+		if splitErr != nil {
+			host = address
+		}
+		c.ServerName = strings.Trim(host, "[]")
+	}
+	if c.ServerName == "" && (!c.InsecureSkipVerify || c.VerifyConnection != nil) {
+		err = errors.New("tls: server name is unknown")
+		return
+	}
+	if verify := config.VerifyConnection; verify != nil {
+		// the sni of ip is not sent, so the name of state is empty for ip hosts.
+		name := c.ServerName
+		c.VerifyConnection = func(state tls.ConnectionState) error {
+			state.ServerName = name
+			return verify(state)
+		}
+	}
+	return
+}
+
+func (watcher *TLSWatcher) Close() (err error) {
+	watcher.cancel()
+	<-watcher.done
+	return
+}
+
+func (watcher *TLSWatcher) watch() {
+	defer close(watcher.done)
+	ticker := time.NewTicker(watcher.config.WatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-watcher.ctx.Done():
+			return
+		case <-ticker.C:
+			watcher.Check()
+		}
+	}
+}
+
+// Check
+// reload the changed files and warn the coming expiry, it is called by the watching.
+func (watcher *TLSWatcher) Check() {
+	watcher.locker.Lock()
+	defer watcher.locker.Unlock()
+	stamps := [3]fileStamp{stampOf(watcher.config.Cert), stampOf(watcher.config.Key), stampOf(watcher.config.CA)}
+	if stamps != watcher.stamps {
+		if err := watcher.reload(stamps); err != nil {
+			if watcher.logger != nil {
+				watcher.logger.Attr(mosses.Err(err)).Error(watcher.ctx, "reload tls files failed, the previous are kept")
+			}
+		} else if watcher.logger != nil {
+			watcher.logger.Attr(mosses.String("cert", watcher.config.Cert), mosses.String("ca", watcher.config.CA)).Info(watcher.ctx, "tls files reloaded")
+		}
+	}
+	watcher.warnExpiry()
+}
+
+func (watcher *TLSWatcher) load() (err error) {
+	watcher.locker.Lock()
+	defer watcher.locker.Unlock()
+	stamps := [3]fileStamp{stampOf(watcher.config.Cert), stampOf(watcher.config.Key), stampOf(watcher.config.CA)}
+	if err = watcher.reload(stamps); err != nil {
+		return
+	}
+	watcher.warnExpiry()
+	return
+}
+
+// reload
+// the stamps are updated even if the files are invalid, so a broken file is reported once until it is changed again.
+func (watcher *TLSWatcher) reload(stamps [3]fileStamp) (err error) {
+	watcher.stamps = stamps
+	var cert *tls.Certificate
+	if watcher.config.Cert != "" || watcher.config.Key != "" {
+		pair, pairErr := tls.LoadX509KeyPair(watcher.config.Cert, watcher.config.Key)
+		if pairErr != nil {
+			err = pairErr
+			return
+		}
+		now := time.Now()
+		if pair.Leaf == nil {
+			if pair.Leaf, err = x509.ParseCertificate(pair.Certificate[0]); err != nil {
+				return
+			}
+		}
+		if now.Before(pair.Leaf.NotBefore) || now.After(pair.Leaf.NotAfter) {
+			err = errors.New("cert is not valid at now, it is valid from " + pair.Leaf.NotBefore.String() + " to " + pair.Leaf.NotAfter.String())
+			return
+		}
+		cert = &pair
+	}
+	var pool *x509.CertPool
+	if watcher.config.CA != "" {
+		pem, readErr := os.ReadFile(watcher.config.CA)
+		if readErr != nil {
+			err = readErr
+			return
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			err = errors.New("no certificate in ca")
+			return
+		}
+	}
+	watcher.cert.Store(cert)
+	watcher.pool.Store(pool)
+	watcher.warned = time.Time{}
+	return
+}
+
+func (watcher *TLSWatcher) warnExpiry() {
+	cert := watcher.cert.Load()
+	if cert == nil || cert.Leaf == nil || watcher.logger == nil {
+		return
+	}
+	now := time.Now()
+	remain := cert.Leaf.NotAfter.Sub(now)
+	if remain > watcher.config.ExpiryWarning || now.Sub(watcher.warned) < tlsExpiryWarningPeriod {
+		return
+	}
+	watcher.warned = now
+	watcher.logger.Attr(
+		mosses.String("cert", watcher.config.Cert),
+		mosses.Time("notAfter", cert.Leaf.NotAfter),
+		mosses.Duration("remain", remain),
+	).Warn(watcher.ctx, "tls cert expires soon")
+}
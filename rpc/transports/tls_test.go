@@ -0,0 +1,354 @@
+package transports_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brickingsoft/brick/pkg/mosses"
+	"github.com/brickingsoft/brick/rpc/configs"
+	"github.com/brickingsoft/brick/rpc/logs"
+	"github.com/brickingsoft/brick/rpc/transports"
+	brick "github.com/brickingsoft/brick/transports"
+)
+
+type identityHandler struct{}
+
+func (h *identityHandler) Handle(r brick.RequestCtx) {
+	identity, ok := transports.PeerIdentityFrom(r)
+	if !ok {
+		r.Response().Failed(fmt.Errorf("peer is not verified"))
+		return
+	}
+	r.Response().Header().Set("cn", identity.CommonName)
+}
+
+// testCA
+// the ca of test certificates, its certificates are written into a temp dir of the test.
+type testCA struct {
+	dir  string
+	file string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, keyErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if keyErr != nil {
+		t.Fatal(keyErr)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: "brick ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, derErr := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if derErr != nil {
+		t.Fatal(derErr)
+	}
+	cert, certErr := x509.ParseCertificate(der)
+	if certErr != nil {
+		t.Fatal(certErr)
+	}
+	ca := &testCA{dir: t.TempDir(), cert: cert, key: key}
+	ca.file, _ = ca.write(t, "ca", der, key)
+	return ca
+}
+
+// issue
+// a certificate of the cn for the hosts, it is for both server and client auth.
+func (ca *testCA) issue(t *testing.T, cn string, expire time.Duration, hosts ...string) (certFile string, keyFile string) {
+	t.Helper()
+	key, keyErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if keyErr != nil {
+		t.Fatal(keyErr)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(expire),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, derErr := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if derErr != nil {
+		t.Fatal(derErr)
+	}
+	return ca.write(t, cn, der, key)
+}
+
+func (ca *testCA) write(t *testing.T, name string, der []byte, key *ecdsa.PrivateKey) (certFile string, keyFile string) {
+	t.Helper()
+	keyDER, keyDERErr := x509.MarshalECPrivateKey(key)
+	if keyDERErr != nil {
+		t.Fatal(keyDERErr)
+	}
+	certFile, keyFile = filepath.Join(ca.dir, name+".crt"), filepath.Join(ca.dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// writeTestCertificates
+// make the ca, server and client certificates.
+func writeTestCertificates(t *testing.T) (ca string, server [2]string, client [2]string) {
+	t.Helper()
+	authority := newTestCA(t)
+	server[0], server[1] = authority.issue(t, "localhost", 24*time.Hour, "localhost", "127.0.0.1")
+	client[0], client[1] = authority.issue(t, "sidecar", 24*time.Hour)
+	ca = authority.file
+	return
+}
+
+func TestTLSConfig_Mutual(t *testing.T) {
+	transports.RegisterFunction("foo", "identity")
+	ca, server, client := writeTestCertificates(t)
+	ctx := context.Background()
+
+	builders := map[string]brick.Builder{
+		transports.TCPTransportName:  transports.NewTCPTransport,
+		transports.QUICTransportName: transports.NewQUICTransport,
+		transports.HTTPTransportName: transports.NewHTTPTransport,
+	}
+	for name, builder := range builders {
+		t.Run(name, func(t *testing.T) {
+			serverConfig, _ := configs.NewConfig([]byte(fmt.Sprintf(`
+address: 127.0.0.1:0
+tls:
+  cert: %s
+  key: %s
+  ca: %s
+  clientAuth: require-and-verify
+  minVersion: "1.3"
+`, server[0], server[1], ca)))
+			srv, srvErr := builder(ctx, *serverConfig)
+			if srvErr != nil {
+				t.Fatal(srvErr)
+			}
+			if err := srv.Listen(ctx, &identityHandler{}); err != nil {
+				t.Fatal(err)
+			}
+			defer srv.Close()
+			address := srv.(interface{ Addr() net.Addr }).Addr().String()
+
+			clientConfig, _ := configs.NewConfig([]byte(fmt.Sprintf(`
+address: 127.0.0.1:0
+tls:
+  cert: %s
+  key: %s
+  ca: %s
+  serverName: localhost
+`, client[0], client[1], ca)))
+			tr, trErr := builder(ctx, *clientConfig)
+			if trErr != nil {
+				t.Fatal(trErr)
+			}
+			c, connectErr := tr.Connect(ctx, address)
+			if connectErr != nil {
+				t.Fatal(connectErr)
+			}
+			defer c.Close()
+			resp, doErr := c.Do(ctx, &testRequest{endpoint: "foo", function: "identity"})
+			if doErr != nil {
+				t.Fatal(doErr)
+			}
+			if !resp.Succeed() {
+				t.Fatal(resp.ParseBody(nil))
+			}
+			if cn := resp.Header().Get("cn"); cn != "sidecar" {
+				t.Fatal("unexpected cn", cn)
+			}
+
+			// without client certificate
+			anonymousConfig, _ := configs.NewConfig([]byte(fmt.Sprintf("address: 127.0.0.1:0\ntls:\n  ca: %s\n  serverName: localhost\n", ca)))
+			anonymous, _ := builder(ctx, *anonymousConfig)
+			if ac, acErr := anonymous.Connect(ctx, address); acErr == nil {
+				defer ac.Close()
+				if resp, doErr = ac.Do(ctx, &testRequest{endpoint: "foo", function: "identity"}); doErr == nil && resp.Succeed() {
+					t.Fatal("anonymous client should be refused")
+				}
+			}
+		})
+	}
+}
+
+func TestTLSConfig_ServerName(t *testing.T) {
+	transports.RegisterFunction("foo", "bar")
+	authority := newTestCA(t)
+	ctx := context.Background()
+
+	builders := map[string]brick.Builder{
+		transports.TCPTransportName:  transports.NewTCPTransport,
+		transports.QUICTransportName: transports.NewQUICTransport,
+		transports.HTTPTransportName: transports.NewHTTPTransport,
+	}
+	tests := []struct {
+		name    string
+		hosts   []string
+		succeed bool
+	}{
+		{name: "ip", hosts: []string{"127.0.0.1"}, succeed: true},
+		// the certificate is valid but it is not of the dialed host.
+		{name: "mismatch", hosts: []string{"evil.example"}, succeed: false},
+	}
+	for name, builder := range builders {
+		for _, test := range tests {
+			t.Run(name+"/"+test.name, func(t *testing.T) {
+				certFile, keyFile := authority.issue(t, test.name, 24*time.Hour, test.hosts...)
+				serverConfig, _ := configs.NewConfig([]byte(fmt.Sprintf("address: 127.0.0.1:0\ntls:\n  cert: %s\n  key: %s\n", certFile, keyFile)))
+				srv, srvErr := builder(ctx, *serverConfig)
+				if srvErr != nil {
+					t.Fatal(srvErr)
+				}
+				if err := srv.Listen(ctx, &echoHandler{}); err != nil {
+					t.Fatal(err)
+				}
+				defer srv.Close()
+				address := srv.(interface{ Addr() net.Addr }).Addr().String()
+
+				// the server name is not configured, so the server is verified against the dialed ip.
+				clientConfig, _ := configs.NewConfig([]byte(fmt.Sprintf("address: 127.0.0.1:0\ntls:\n  ca: %s\n", authority.file)))
+				tr, trErr := builder(ctx, *clientConfig)
+				if trErr != nil {
+					t.Fatal(trErr)
+				}
+				succeed := false
+				if c, connectErr := tr.Connect(ctx, address); connectErr == nil {
+					defer c.Close()
+					resp, doErr := c.Do(ctx, &testRequest{endpoint: "foo", function: "bar"})
+					succeed = doErr == nil && resp.Succeed()
+				}
+				if succeed != test.succeed {
+					t.Fatal("unexpected result", succeed)
+				}
+			})
+		}
+	}
+}
+
+func TestTLSConfig_HandshakeTimeout(t *testing.T) {
+	ca, server, _ := writeTestCertificates(t)
+	ctx := context.Background()
+	config, _ := configs.NewConfig([]byte(fmt.Sprintf("address: 127.0.0.1:0\ntls:\n  cert: %s\n  key: %s\n  ca: %s\n  handshakeTimeout: 100ms\n", server[0], server[1], ca)))
+	tr, trErr := transports.NewTCPTransport(ctx, *config)
+	if trErr != nil {
+		t.Fatal(trErr)
+	}
+	if err := tr.Listen(ctx, &identityHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	// the peer never sends its client hello.
+	conn, dialErr := net.Dial("tcp", tr.(*transports.TCPTransport).Addr().String())
+	if dialErr != nil {
+		t.Fatal(dialErr)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("expected the connection is closed")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("the connection is not closed by the handshake timeout")
+	}
+}
+
+func TestTLSWatcher(t *testing.T) {
+	authority := newTestCA(t)
+	ca := authority.file
+	generate := func(cn string, expire int) (string, string) {
+		return authority.issue(t, cn, time.Duration(expire)*24*time.Hour, "localhost")
+	}
+	replace := func(dst string, src string) {
+		b, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(dst, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	certFile, keyFile := generate("first", 1)
+	nextCert, nextKey := generate("second", 30)
+
+	logger, _ := mosses.New()
+	ctx := logs.With(context.Background(), logger)
+	config := transports.TLSConfig{Cert: certFile, Key: keyFile, CA: ca, ServerName: "localhost", WatchInterval: -1}
+	watcher, watchErr := config.Watch(ctx)
+	if watchErr != nil {
+		t.Fatal(watchErr)
+	}
+	defer watcher.Close()
+	serverTLS, serverTLSErr := watcher.Server()
+	if serverTLSErr != nil {
+		t.Fatal(serverTLSErr)
+	}
+	ln, lnErr := tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	if lnErr != nil {
+		t.Fatal(lnErr)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.(*tls.Conn).Handshake()
+			_ = conn.Close()
+		}
+	}()
+	served := func() string {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), watcher.Client())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+	if cn := served(); cn != "first" {
+		t.Fatal("unexpected cn", cn)
+	}
+
+	// invalid pair is ignored
+	replace(certFile, nextCert)
+	watcher.Check()
+	if cn := served(); cn != "first" {
+		t.Fatal("unexpected cn", cn)
+	}
+
+	replace(keyFile, nextKey)
+	watcher.Check()
+	if cn := served(); cn != "second" {
+		t.Fatal("unexpected cn", cn)
+	}
+}
@@ -0,0 +1,350 @@
+package transports
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/brickingsoft/brick/rpc/configs"
+	"github.com/brickingsoft/brick/transports"
+)
+
+const (
+	UnixTransportName = "unix"
+	unixScheme        = "unix://"
+)
+
+type UnixConfig struct {
+	Path string `json:"path" yaml:"path"`
+	// Mode
+	// the octal file permission of socket file, e.g. 0660.
+	Mode string `json:"mode" yaml:"mode"`
+	// Owner
+	// the user name or uid of socket file.
+	Owner string `json:"owner" yaml:"owner"`
+	// Group
+	// the group name or gid of socket file.
+	Group string `json:"group" yaml:"group"`
+	// AllowedUIDs
+	// the uids of peers which are allowed to connect, all are allowed when it is empty.
+	AllowedUIDs   []uint32       `json:"allowedUids" yaml:"allowedUids"`
+	MaxHeaderSize int            `json:"maxHeaderSize" yaml:"maxHeaderSize"`
+	Headers       []HeaderValues `json:"headers" yaml:"headers"`
+	// HeaderTableSize
+	// the size of dynamic header tables of a connection, the default is 4KB, negative disables them.
+	HeaderTableSize int        `json:"headerTableSize" yaml:"headerTableSize"`
+	Pool            PoolConfig `json:"pool" yaml:"pool"`
+	// KeepAliveInterval
+	// a ping is sent when nothing is read for the interval, the default is 30s, negative disables keepalive.
+	KeepAliveInterval time.Duration `json:"keepAliveInterval" yaml:"keepAliveInterval"`
+	KeepAliveTimeout  time.Duration `json:"keepAliveTimeout" yaml:"keepAliveTimeout"`
+	// IdleTimeout
+	// the server closes connections which have no request for the timeout, it is disabled by default.
+	IdleTimeout time.Duration     `json:"idleTimeout" yaml:"idleTimeout"`
+	Compression CompressionConfig `json:"compression" yaml:"compression"`
+	// Limits
+	// the body limits, the default max body size is 4MB.
+	Limits LimitsConfig `json:"limits" yaml:"limits"`
+	// Forwarded
+	// the trusted proxies and the max hops of forwarded chains.
+	Forwarded ForwardedConfig `json:"forwarded" yaml:"forwarded"`
+}
+
+func (config *UnixConfig) options() []Option {
+	options := []Option{
+		WithMaxHeaderSize(config.MaxHeaderSize),
+		WithHeaderFields(config.Headers...),
+		WithHeaderTableSize(config.HeaderTableSize),
+		WithKeepAlive(config.KeepAliveInterval, config.KeepAliveTimeout),
+		WithIdleTimeout(config.IdleTimeout),
+		WithCompression(config.Compression.Threshold, config.Compression.Encodings...),
+	}
+	options = append(options, config.Limits.options()...)
+	return append(options, config.Forwarded.options()...)
+}
+
+// NewUnixTransport
+// a transports.Builder of unix socket, the config is the node of unix transport.
+func NewUnixTransport(_ context.Context, config configs.Config) (transport transports.Transport, err error) {
+	uc := UnixConfig{}
+	if err = config.As(&uc); err != nil {
+		err = errors.Join(errors.New("new unix transport failed"), err)
+		return
+	}
+	uc.Path = strings.TrimPrefix(strings.TrimSpace(uc.Path), unixScheme)
+	if uc.Path == "" {
+		err = errors.Join(errors.New("new unix transport failed"), errors.New("path is missing"))
+		return
+	}
+	if uc.Mode = strings.TrimSpace(uc.Mode); uc.Mode != "" {
+		if _, modeErr := strconv.ParseUint(uc.Mode, 8, 32); modeErr != nil {
+			err = errors.Join(errors.New("new unix transport failed"), errors.New("invalid mode"), modeErr)
+			return
+		}
+	}
+	transport = &UnixTransport{
+		config: uc,
+	}
+	return
+}
+
+type UnixTransport struct {
+	config UnixConfig
+	locker sync.Mutex
+	server *Server
+	addr   net.Addr
+}
+
+func (tr *UnixTransport) Name() string {
+	return UnixTransportName
+}
+
+// Addr
+// the listened address, it is nil before Listen.
+func (tr *UnixTransport) Addr() net.Addr {
+	tr.locker.Lock()
+	defer tr.locker.Unlock()
+	return tr.addr
+}
+
+// Listen
+// listen the configured path and serve in background, a stale socket file of the path is removed,
+// and the path which is listened by a live server or is not a socket is refused.
+func (tr *UnixTransport) Listen(ctx context.Context, handler transports.ServeHandler) (err error) {
+	tr.locker.Lock()
+	defer tr.locker.Unlock()
+	if tr.server != nil {
+		err = errors.Join(errors.New("unix transport listen failed"), errors.New("already listened"))
+		return
+	}
+	srv, srvErr := NewServer(ctx, handler, tr.config.options()...)
+	if srvErr != nil {
+		err = errors.Join(errors.New("unix transport listen failed"), srvErr)
+		return
+	}
+	if err = tr.removeStale(ctx); err != nil {
+		err = errors.Join(errors.New("unix transport listen failed"), err)
+		return
+	}
+	ln, info, lnErr := tr.listen(ctx)
+	if lnErr != nil {
+		err = errors.Join(errors.New("unix transport listen failed"), lnErr)
+		return
+	}
+	tr.server = srv
+	tr.addr = &net.UnixAddr{Name: tr.config.Path, Net: "unix"}
+	go func(srv *Server, ln net.Listener) {
+		_ = srv.Serve(ln)
+	}(srv, &unixListener{Listener: ln, allowed: tr.config.AllowedUIDs, path: tr.config.Path, info: info})
+	return
+}
+
+// removeStale
+// remove the socket file of the path which no server listens, a server listens it when it accepts a dial.
+func (tr *UnixTransport) removeStale(ctx context.Context) (err error) {
+	info, statErr := os.Lstat(tr.config.Path)
+	if statErr != nil {
+		if !os.IsNotExist(statErr) {
+			err = statErr
+		}
+		return
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		err = errors.New(tr.config.Path + " is not a socket")
+		return
+	}
+	dialer := net.Dialer{Timeout: time.Second}
+	conn, dialErr := dialer.DialContext(ctx, "unix", tr.config.Path)
+	if dialErr == nil {
+		_ = conn.Close()
+		err = errors.New(tr.config.Path + " is listened by another server")
+		return
+	}
+	if !errors.Is(dialErr, syscall.ECONNREFUSED) {
+		err = errors.Join(errors.New(tr.config.Path+" may be listened by another server"), dialErr)
+		return
+	}
+	if err = os.Remove(tr.config.Path); os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
+
+// listen
+// the socket is bound in a private directory beside the path, its mode and owner are set there,
+// then it is linked to the path, so peers can not connect it by the permission of the umask.
+// a link never replaces an existing file, so a file which is created at the path meanwhile is kept.
+func (tr *UnixTransport) listen(ctx context.Context) (ln *net.UnixListener, info os.FileInfo, err error) {
+	dir, dirErr := os.MkdirTemp(filepath.Dir(tr.config.Path), ".brick")
+	if dirErr != nil {
+		err = dirErr
+		return
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "s")
+	config := net.ListenConfig{}
+	listener, lnErr := config.Listen(ctx, "unix", name)
+	if lnErr != nil {
+		err = lnErr
+		return
+	}
+	ln = listener.(*net.UnixListener)
+	// the file is linked, so it is removed by unixListener.
+	ln.SetUnlinkOnClose(false)
+	if err = tr.chmod(name); err == nil {
+		err = os.Link(name, tr.config.Path)
+	}
+	if err == nil {
+		info, err = os.Lstat(tr.config.Path)
+	}
+	if err != nil {
+		_ = ln.Close()
+		ln = nil
+	}
+	return
+}
+
+func (tr *UnixTransport) chmod(name string) (err error) {
+	if tr.config.Mode != "" {
+		mode, _ := strconv.ParseUint(tr.config.Mode, 8, 32)
+		if err = os.Chmod(name, os.FileMode(mode)); err != nil {
+			return
+		}
+	}
+	if tr.config.Owner == "" && tr.config.Group == "" {
+		return
+	}
+	uid, gid := -1, -1
+	if owner := strings.TrimSpace(tr.config.Owner); owner != "" {
+		if uid, err = lookupId(owner, func(name string) (string, error) {
+			u, lookupErr := user.Lookup(name)
+			if lookupErr != nil {
+				return "", lookupErr
+			}
+			return u.Uid, nil
+		}); err != nil {
+			return
+		}
+	}
+	if group := strings.TrimSpace(tr.config.Group); group != "" {
+		if gid, err = lookupId(group, func(name string) (string, error) {
+			g, lookupErr := user.LookupGroup(name)
+			if lookupErr != nil {
+				return "", lookupErr
+			}
+			return g.Gid, nil
+		}); err != nil {
+			return
+		}
+	}
+	err = os.Chown(name, uid, gid)
+	return
+}
+
+// lookupId
+// the name is used as id when it is numeric.
+func lookupId(name string, lookup func(name string) (string, error)) (id int, err error) {
+	if n, parseErr := strconv.Atoi(name); parseErr == nil {
+		id = n
+		return
+	}
+	s, lookupErr := lookup(name)
+	if lookupErr != nil {
+		err = lookupErr
+		return
+	}
+	id, err = strconv.Atoi(s)
+	return
+}
+
+// Connect
+// connect to the unix socket, the address is `unix://{path}` or `{path}`, the client is a pool of connections.
+func (tr *UnixTransport) Connect(ctx context.Context, address string) (client transports.Client, err error) {
+	address = strings.TrimPrefix(strings.TrimSpace(address), unixScheme)
+	if address == "" {
+		err = errors.Join(errors.New("unix transport connect failed"), errors.New("address is missing"))
+		return
+	}
+	pool, poolErr := newPool(ctx, tr.config.Pool, func(ctx context.Context) (pooledClient, error) {
+		return Dial(ctx, "unix", address, tr.config.options()...)
+	})
+	if poolErr != nil {
+		err = errors.Join(errors.New("unix transport connect failed"), poolErr)
+		return
+	}
+	client = pool
+	return
+}
+
+// Shutdown
+// stop listening, and close the server after the handling requests are done or ctx is done.
+func (tr *UnixTransport) Shutdown(ctx context.Context) (err error) {
+	tr.locker.Lock()
+	srv := tr.server
+	tr.server = nil
+	tr.locker.Unlock()
+	if srv == nil {
+		return
+	}
+	err = srv.Shutdown(ctx)
+	return
+}
+
+func (tr *UnixTransport) Close() (err error) {
+	tr.locker.Lock()
+	srv := tr.server
+	tr.server = nil
+	tr.locker.Unlock()
+	if srv == nil {
+		return
+	}
+	err = srv.Close()
+	return
+}
+
+// unixListener
+// connections of peers whose uid is not allowed are closed at once,
+// the socket file of path is removed by Close when it is still the listened one.
+type unixListener struct {
+	net.Listener
+	allowed []uint32
+	path    string
+	info    os.FileInfo
+	once    sync.Once
+}
+
+func (ln *unixListener) Close() (err error) {
+	err = ln.Listener.Close()
+	ln.once.Do(func() {
+		if current, statErr := os.Lstat(ln.path); statErr == nil && os.SameFile(current, ln.info) {
+			_ = os.Remove(ln.path)
+		}
+	})
+	return
+}
+
+func (ln *unixListener) Accept() (conn net.Conn, err error) {
+	for {
+		if conn, err = ln.Listener.Accept(); err != nil || len(ln.allowed) == 0 {
+			return
+		}
+		uc, ok := conn.(*net.UnixConn)
+		if !ok {
+			return
+		}
+		credentials, credErr := peerCredentialsOf(uc)
+		if credErr == nil && slices.Contains(ln.allowed, credentials.Uid) {
+			return
+		}
+		_ = conn.Close()
+	}
+}
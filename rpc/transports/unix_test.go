@@ -0,0 +1,153 @@
+package transports_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/brickingsoft/brick/rpc/configs"
+	"github.com/brickingsoft/brick/rpc/transports"
+	brick "github.com/brickingsoft/brick/transports"
+)
+
+type peerHandler struct{}
+
+func (h *peerHandler) Handle(r brick.RequestCtx) {
+	credentials, ok := transports.PeerCredentialsFrom(r)
+	if !ok {
+		r.Response().Failed(transports.ErrPeerCredentialsUnsupported)
+		return
+	}
+	r.Response().Header().Set("pid", strconv.Itoa(int(credentials.Pid)))
+	r.Response().Header().Set("uid", strconv.Itoa(int(credentials.Uid)))
+}
+
+func TestUnixTransport(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only supported on linux")
+	}
+	transports.RegisterFunction("foo", "peer")
+	path := filepath.Join(t.TempDir(), "brick.sock")
+	config, configErr := configs.NewConfig([]byte(fmt.Sprintf("path: unix://%s\nmode: \"0600\"\nallowedUids: [%d]\n", path, os.Getuid())))
+	if configErr != nil {
+		t.Fatal(configErr)
+	}
+	ctx := context.Background()
+	tr, trErr := transports.NewUnixTransport(ctx, *config)
+	if trErr != nil {
+		t.Fatal(trErr)
+	}
+	if err := tr.Listen(ctx, &peerHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	info, statErr := os.Stat(path)
+	if statErr != nil {
+		t.Fatal(statErr)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatal("unexpected mode", info.Mode().Perm())
+	}
+	// the socket is bound in a private directory which is removed after the socket is in place.
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Fatal("unexpected files beside the socket", entries)
+	}
+
+	client, clientErr := tr.Connect(ctx, path)
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	defer client.Close()
+
+	resp, doErr := client.Do(ctx, &testRequest{endpoint: "foo", function: "peer"})
+	if doErr != nil {
+		t.Fatal(doErr)
+	}
+	if !resp.Succeed() {
+		t.Fatal(resp.ParseBody(nil))
+	}
+	if pid := resp.Header().Get("pid"); pid != strconv.Itoa(os.Getpid()) {
+		t.Fatal("unexpected pid", pid)
+	}
+	if uid := resp.Header().Get("uid"); uid != strconv.Itoa(os.Getuid()) {
+		t.Fatal("unexpected uid", uid)
+	}
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, statErr = os.Stat(path); !os.IsNotExist(statErr) {
+		t.Fatal("expected the socket file is removed", statErr)
+	}
+}
+
+func TestUnixTransport_Path(t *testing.T) {
+	transports.RegisterFunction("foo", "bar")
+	ctx := context.Background()
+	dir := t.TempDir()
+	newTransport := func(path string) brick.Transport {
+		tr, err := transports.NewUnixTransport(ctx, *mustConfig(t, "path: "+path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tr
+	}
+
+	// a file which is not a socket is never replaced.
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := newTransport(file).Listen(ctx, &echoHandler{}); err == nil {
+		t.Fatal("expected listen on a regular file to be failed")
+	}
+	if b, _ := os.ReadFile(file); string(b) != "data" {
+		t.Fatal("the regular file is replaced", string(b))
+	}
+
+	// a stale socket is removed.
+	path := filepath.Join(dir, "brick.sock")
+	stale, staleErr := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if staleErr != nil {
+		t.Fatal(staleErr)
+	}
+	stale.SetUnlinkOnClose(false)
+	_ = stale.Close()
+	tr := newTransport(path)
+	if err := tr.Listen(ctx, &echoHandler{}); err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	// a live socket is not taken over.
+	if err := newTransport(path).Listen(ctx, &echoHandler{}); err == nil {
+		t.Fatal("expected listen on a live socket to be failed")
+	}
+	client, clientErr := tr.Connect(ctx, path)
+	if clientErr != nil {
+		t.Fatal(clientErr)
+	}
+	defer client.Close()
+	if _, err := client.Do(ctx, &testRequest{endpoint: "foo", function: "bar"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the path which is replaced by others is kept by Close.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("other"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "other" {
+		t.Fatal("the replaced path is removed", string(b))
+	}
+}
@@ -0,0 +1,95 @@
+package encoding
+
+import (
+	"mime"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ContentType
+// the media type of the encoder name, e.g. `application/json`.
+func ContentType(name string) string {
+	return "application/" + name
+}
+
+// NameOfContentType
+// the encoder name is the subtype of the content type or its suffix,
+// e.g. `application/json` and `application/problem+json` are json, and `application/x-msgpack` is msgpack.
+func NameOfContentType(contentType string) (name string, ok bool) {
+	mediaType, _, parseErr := mime.ParseMediaType(contentType)
+	if parseErr != nil {
+		return
+	}
+	_, subtype, _ := strings.Cut(mediaType, "/")
+	if _, suffix, has := strings.Cut(subtype, "+"); has {
+		subtype = suffix
+	}
+	name = strings.TrimPrefix(subtype, "x-")
+	ok = name != ""
+	return
+}
+
+// RetrieveByContentType
+// ok is false when the encoder of the content type is not registered.
+func RetrieveByContentType(contentType string) (encoder Encoder, ok bool) {
+	name, has := NameOfContentType(contentType)
+	if !has || !Registered(name) {
+		return
+	}
+	encoder, ok = Retrieve(name), true
+	return
+}
+
+// Negotiate
+// the registered encoder name which is preferred by the accept header, e.g. `application/json;q=0.9, application/avro`.
+// the fallback is used when the accept is empty or accepts any, and ok is false when nothing is acceptable.
+func Negotiate(accept string, fallback string) (name string, ok bool) {
+	accept = strings.TrimSpace(accept)
+	if accept == "" {
+		name, ok = fallback, true
+		return
+	}
+	type candidate struct {
+		mediaType string
+		quality   float64
+	}
+	candidates := make([]candidate, 0, 1)
+	for _, item := range strings.Split(accept, ",") {
+		mediaType, params, parseErr := mime.ParseMediaType(strings.TrimSpace(item))
+		if parseErr != nil {
+			continue
+		}
+		quality := 1.0
+		if q, has := params["q"]; has {
+			if quality, parseErr = strconv.ParseFloat(q, 64); parseErr != nil {
+				continue
+			}
+		}
+		if quality <= 0 {
+			continue
+		}
+		candidates = append(candidates, candidate{mediaType: mediaType, quality: quality})
+	}
+	slices.SortStableFunc(candidates, func(a, b candidate) int {
+		switch {
+		case a.quality > b.quality:
+			return -1
+		case a.quality < b.quality:
+			return 1
+		default:
+			return 0
+		}
+	})
+	for _, c := range candidates {
+		if c.mediaType == "*/*" || c.mediaType == "application/*" {
+			name, ok = fallback, true
+			return
+		}
+		if candidateName, has := NameOfContentType(c.mediaType); has && Registered(candidateName) {
+			name, ok = candidateName, true
+			return
+		}
+	}
+	return
+}
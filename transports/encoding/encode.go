@@ -1,9 +1,14 @@
 package encoding
 
 import (
+	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
 
 	"github.com/brickingsoft/brick/pkg/avro"
+	"github.com/brickingsoft/brick/pkg/cbor"
+	"github.com/brickingsoft/brick/pkg/msgpack"
 )
 
 type Encoder interface {
@@ -24,11 +29,35 @@ func (encoder *AvroEncoder) Unmarshal(b []byte, v any) error {
 type JsonEncoder struct{}
 
 func (encoder *JsonEncoder) Marshal(v any) ([]byte, error) {
-	return avro.Marshal(v)
+	return json.Marshal(v)
 }
 
 func (encoder *JsonEncoder) Unmarshal(b []byte, v any) error {
-	return avro.Unmarshal(b, v)
+	return json.Unmarshal(b, v)
+}
+
+// MsgpackEncoder
+// the message pack encoder, it is registered as msgpack.
+type MsgpackEncoder struct{}
+
+func (encoder *MsgpackEncoder) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (encoder *MsgpackEncoder) Unmarshal(b []byte, v any) error {
+	return msgpack.Unmarshal(b, v)
+}
+
+// CborEncoder
+// the cbor encoder, it is registered as cbor.
+type CborEncoder struct{}
+
+func (encoder *CborEncoder) Marshal(v any) ([]byte, error) {
+	return cbor.Marshal(v)
+}
+
+func (encoder *CborEncoder) Unmarshal(b []byte, v any) error {
+	return cbor.Unmarshal(b, v)
 }
 
 type InvalidEncoder struct {
@@ -46,39 +75,53 @@ func (encoder InvalidEncoder) Unmarshal(_ []byte, _ any) (err error) {
 }
 
 const (
-	JsonEncoderType = "json"
-	AvroEncoderType = "avro"
+	JsonEncoderType    = "json"
+	AvroEncoderType    = "avro"
+	MsgpackEncoderType = "msgpack"
+	CborEncoderType    = "cbor"
 )
 
 var (
-	encoders            = make(map[string]Encoder)
-	avroEncoder Encoder = new(AvroEncoder)
-	jsonEncoder Encoder = new(JsonEncoder)
+	// encoders
+	// the registry of encoders, it is guarded by locker.
+	encoders = map[string]Encoder{
+		AvroEncoderType:    new(AvroEncoder),
+		JsonEncoderType:    new(JsonEncoder),
+		MsgpackEncoderType: new(MsgpackEncoder),
+		CborEncoderType:    new(CborEncoder),
+	}
+	locker sync.RWMutex
 )
 
+// Register
+// the name is the subtype of its content type, e.g. json of `application/json`, it is safe for concurrent use.
 func Register(name string, encoder Encoder) {
-	switch name {
-	case AvroEncoderType:
-		avroEncoder = encoder
-	case JsonEncoderType:
-		jsonEncoder = encoder
-		break
-	default:
-		encoders[name] = encoder
-		break
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || encoder == nil {
+		return
 	}
+	locker.Lock()
+	encoders[name] = encoder
+	locker.Unlock()
 }
 
+// Retrieve
+// an InvalidEncoder is returned when the name is not registered.
 func Retrieve(name string) Encoder {
-	switch name {
-	case AvroEncoderType:
-		return avroEncoder
-	case JsonEncoderType:
-		return jsonEncoder
-	default:
-		if encoder, ok := encoders[name]; ok {
-			return encoder
-		}
-		return &InvalidEncoder{name: name}
+	locker.RLock()
+	encoder, ok := encoders[name]
+	locker.RUnlock()
+	if ok {
+		return encoder
 	}
+	return &InvalidEncoder{name: name}
+}
+
+// Registered
+// whether the name is registered.
+func Registered(name string) bool {
+	locker.RLock()
+	_, ok := encoders[name]
+	locker.RUnlock()
+	return ok
 }
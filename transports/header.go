@@ -0,0 +1,212 @@
+package transports
+
+import (
+	"bytes"
+	"iter"
+	"unsafe"
+)
+
+// Header
+// the header model of all transports, keys are lower case and a key may have multiple values.
+//
+// the string methods are for convenience, the bytes methods do not copy,
+// so the bytes which are peeked are only valid until the header is changed or released, and the bytes which are set must not be changed.
+type Header interface {
+	Get(key string) (value string)
+	Keys() (keys []string)
+	Values(key string) (values []string)
+	Set(key string, value string)
+	Add(key string, values ...string)
+	Remove(key string)
+	Authorization() string
+	// Peek
+	// the first value of the key.
+	Peek(key []byte) (value []byte)
+	PeekValues(key []byte) (values [][]byte)
+	SetBytes(key []byte, value []byte)
+	AddBytes(key []byte, value []byte)
+	RemoveBytes(key []byte)
+	// All
+	// all fields in the order of adding, a key of multiple values is yielded for each value.
+	All() iter.Seq2[[]byte, []byte]
+}
+
+var (
+	authorizationKey = []byte("authorization")
+)
+
+// HeaderField
+// a field of Fields.
+type HeaderField struct {
+	Key   []byte
+	Value []byte
+}
+
+// Fields
+// the Header of fields, it is used by transports which have no header model of their own.
+// empty values are not kept, setting an empty value removes the key.
+type Fields struct {
+	fields []HeaderField
+}
+
+// NewFields
+// copy the header into fields, the bytes are cloned.
+func NewFields(h Header) (fields *Fields) {
+	fields = new(Fields)
+	if h == nil {
+		return
+	}
+	for key, value := range h.All() {
+		fields.AddBytes(bytes.Clone(key), bytes.Clone(value))
+	}
+	return
+}
+
+func (f *Fields) Get(key string) (value string) {
+	v := f.Peek(unsafe.Slice(unsafe.StringData(key), len(key)))
+	if len(v) > 0 {
+		value = string(v)
+	}
+	return
+}
+
+func (f *Fields) Keys() (keys []string) {
+	for _, field := range f.fields {
+		key := unsafe.String(unsafe.SliceData(field.Key), len(field.Key))
+		exist := false
+		for _, k := range keys {
+			if k == key {
+				exist = true
+				break
+			}
+		}
+		if !exist {
+			keys = append(keys, string(field.Key))
+		}
+	}
+	return
+}
+
+func (f *Fields) Values(key string) (values []string) {
+	for _, value := range f.PeekValues(unsafe.Slice(unsafe.StringData(key), len(key))) {
+		values = append(values, string(value))
+	}
+	return
+}
+
+func (f *Fields) Set(key string, value string) {
+	f.SetBytes([]byte(key), []byte(value))
+}
+
+func (f *Fields) Add(key string, values ...string) {
+	for _, value := range values {
+		f.AddBytes([]byte(key), []byte(value))
+	}
+}
+
+func (f *Fields) Remove(key string) {
+	f.RemoveBytes(unsafe.Slice(unsafe.StringData(key), len(key)))
+}
+
+func (f *Fields) Authorization() string {
+	return string(f.Peek(authorizationKey))
+}
+
+func (f *Fields) Peek(key []byte) (value []byte) {
+	key = lowerKey(key)
+	for _, field := range f.fields {
+		if bytes.Equal(field.Key, key) {
+			value = field.Value
+			return
+		}
+	}
+	return
+}
+
+func (f *Fields) PeekValues(key []byte) (values [][]byte) {
+	key = lowerKey(key)
+	for _, field := range f.fields {
+		if bytes.Equal(field.Key, key) {
+			values = append(values, field.Value)
+		}
+	}
+	return
+}
+
+func (f *Fields) SetBytes(key []byte, value []byte) {
+	f.RemoveBytes(key)
+	f.AddBytes(key, value)
+}
+
+func (f *Fields) AddBytes(key []byte, value []byte) {
+	key = bytes.TrimSpace(key)
+	if len(key) == 0 || len(value) == 0 {
+		return
+	}
+	f.fields = append(f.fields, HeaderField{Key: lowerKey(key), Value: value})
+}
+
+func (f *Fields) RemoveBytes(key []byte) {
+	key = lowerKey(bytes.TrimSpace(key))
+	f.RemoveFunc(func(field HeaderField) bool {
+		return bytes.Equal(field.Key, key)
+	})
+}
+
+// RemoveFunc
+// remove the fields which del returns true.
+func (f *Fields) RemoveFunc(del func(field HeaderField) bool) {
+	n := 0
+	for _, field := range f.fields {
+		if del(field) {
+			continue
+		}
+		f.fields[n] = field
+		n++
+	}
+	clear(f.fields[n:])
+	f.fields = f.fields[:n]
+}
+
+func (f *Fields) All() iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		for _, field := range f.fields {
+			if !yield(field.Key, field.Value) {
+				return
+			}
+		}
+	}
+}
+
+// Len
+// the count of fields.
+func (f *Fields) Len() int {
+	return len(f.fields)
+}
+
+func (f *Fields) Reset() {
+	clear(f.fields)
+	f.fields = f.fields[:0]
+}
+
+// lowerKey
+// the key is copied only when it has upper case letters.
+func lowerKey(key []byte) []byte {
+	for _, c := range key {
+		if 'A' <= c && c <= 'Z' {
+			return bytes.ToLower(key)
+		}
+	}
+	return key
+}
+
+// CopyHeader
+// add all fields of src into dst, the bytes are shared.
+func CopyHeader(dst Header, src Header) {
+	if dst == nil || src == nil {
+		return
+	}
+	for key, value := range src.All() {
+		dst.AddBytes(key, value)
+	}
+}
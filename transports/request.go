@@ -3,6 +3,8 @@ package transports
 import (
 	"context"
 	"errors"
+	"io"
+	"net"
 )
 
 var (
@@ -10,16 +12,6 @@ var (
 	WriteBodyFailed = errors.New("failed to write body")
 )
 
-type Header interface {
-	Get(key string) (value string)
-	Keys() (keys []string)
-	Values(key string) (values []string)
-	Set(key string, value string)
-	Add(key string, values ...string)
-	Remove(key string)
-	Authorization() string
-}
-
 type Request interface {
 	Endpoint() string
 	Function() string
@@ -27,6 +19,13 @@ type Request interface {
 	Body() (body []byte, err error)
 }
 
+// StreamingRequest
+// a request which can be read without buffering the body, e.g. a large upload.
+type StreamingRequest interface {
+	Request
+	BodyReader() io.Reader
+}
+
 type Response interface {
 	Succeed() bool
 	Header() Header
@@ -58,4 +57,10 @@ type RequestCtx interface {
 	Response() (response ResponseWriter)
 	Hijacked() bool
 	Hijack(handler HijackHandler) (err error)
+	// RemoteAddr
+	// the address of the peer, it may be a proxy.
+	RemoteAddr() net.Addr
+	// ClientAddr
+	// the address of the client which is resolved by the forwarded chain and the trusted proxies.
+	ClientAddr() string
 }
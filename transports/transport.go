@@ -3,7 +3,7 @@ package transports
 import (
 	"context"
 
-	"github.com/brickingsoft/brick/configs"
+	"github.com/brickingsoft/brick/rpc/configs"
 )
 
 type ServeHandler interface {
@@ -15,6 +15,17 @@ type Client interface {
 	Close() (err error)
 }
 
+type ClientStream interface {
+	Send(request Request) (err error)
+	Receive() (response Response, err error)
+	Close() (err error)
+}
+
+type StreamClient interface {
+	Client
+	Stream(ctx context.Context, request Request) (stream ClientStream, err error)
+}
+
 type Transport interface {
 	Name() string
 	Listen(ctx context.Context, handler ServeHandler) (err error)
@@ -22,4 +33,11 @@ type Transport interface {
 	Close() (err error)
 }
 
+// GracefulTransport
+// a transport which drains requests before closing, Shutdown returns when all are done or ctx is done.
+type GracefulTransport interface {
+	Transport
+	Shutdown(ctx context.Context) (err error)
+}
+
 type Builder func(ctx context.Context, config configs.Config) (transport Transport, err error)